@@ -1,55 +1,254 @@
 package main
 
 import (
-	"log"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 
+	"github.com/ddomeke/rpc_proxy/internal/admin"
+	"github.com/ddomeke/rpc_proxy/internal/cache"
 	"github.com/ddomeke/rpc_proxy/internal/config"
 	"github.com/ddomeke/rpc_proxy/internal/eth"
+	"github.com/ddomeke/rpc_proxy/internal/health"
+	"github.com/ddomeke/rpc_proxy/internal/logging"
 	"github.com/ddomeke/rpc_proxy/internal/metrics"
 	"github.com/ddomeke/rpc_proxy/internal/monitor"
 	"github.com/ddomeke/rpc_proxy/internal/proxy"
-	"github.com/ddomeke/rpc_proxy/pkg/utils"
+	"github.com/ddomeke/rpc_proxy/internal/reload"
+	"github.com/ddomeke/rpc_proxy/internal/store"
+)
+
+// Process exit codes
+const (
+	exitOK       = 0 // Clean shutdown after SIGINT or SIGTERM
+	exitFailure  = 1 // Startup failed or a server stopped with an error
+	exitConfig   = 2 // Invalid configuration
+	exitDeadline = 3 // Shutdown did not finish within the shutdown timeout
 )
 
 func main() {
-	// Initialize logging system
-	logFile := utils.InitLogger()
-	defer logFile.Close()
+	os.Exit(run())
+}
 
-	// Load environment variables
-	envPath := "../.env"
-	err := utils.LoadEnvFile(envPath)
+// run starts all components, waits for a shutdown signal or a server failure
+// and stops everything again. It returns the process exit code.
+func run() int {
+	// Load the configuration from the config file, .env file, environment and flags
+	cfg, err := config.Load(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return exitOK
+	}
 	if err != nil {
-		log.Fatalf("Could not load .env file: %v", err)
+		// Printed as is so that every configuration error is on its own line
+		fmt.Fprintln(os.Stderr, err)
+		return exitConfig
 	}
 
-	// Initialize configuration
-	cfg, err := config.LoadConfig()
+	// Initialize logging system
+	logFile, err := logging.Init(cfg.Log)
 	if err != nil {
-		log.Fatalf("Failed to load configuration: %v", err)
+		fmt.Fprintln(os.Stderr, "Could not initialize logger:", err)
+		return exitFailure
 	}
+	if logFile != nil {
+		defer logFile.Close()
+	}
+
+	// Everything runs until SIGINT or SIGTERM cancels the context
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
 	// Initialize Ethereum clients
 	ethClients, err := eth.InitClients(cfg)
 	if err != nil {
-		log.Fatalf("[ERROR] Could not initialize clients: %v", err)
+		return fatal("Could not initialize clients", err)
 	}
 
 	// Initialize metrics
-	metricsCollector := metrics.NewCollector()
+	metricsCollector := metrics.NewCollector(cfg.Metrics)
+
+	// The frozen accounts set is loaded in the background by its worker
+	frozenSet, err := eth.NewFrozenSet(cfg, ethClients, metricsCollector)
+	if err != nil {
+		return fatal("Could not initialize frozen set", err)
+	}
 
-	// Start Prometheus metrics server
-	go metrics.StartServer(cfg.MetricsPort)
+	// Stores are only closed once every writer stopped. After a shutdown
+	// timeout they are left to the operating system instead.
+	closeStores := true
+
+	// Open the deposit store. It is closed last, after every writer stopped.
+	depositStore, err := store.Open(cfg.Store)
+	if err != nil {
+		return fatal("Could not open deposit store", err)
+	}
+	defer func() {
+		if !closeStores {
+			return
+		}
+		if err := depositStore.Close(); err != nil {
+			slog.Error("Could not close deposit store", "error", err)
+		}
+	}()
+
+	l1Monitor := monitor.NewL1Monitor(ethClients, frozenSet, depositStore, cfg, metricsCollector)
+
+	// Open the response cache, closed after the proxy server stopped
+	responseCache, err := cache.New(cfg.Cache, metricsCollector)
+	if err != nil {
+		return fatal("Could not open response cache", err)
+	}
+	if responseCache != nil {
+		defer func() {
+			if !closeStores {
+				return
+			}
+			if err := responseCache.Close(); err != nil {
+				slog.Error("Could not close response cache", "error", err)
+			}
+		}()
+	}
+
+	proxyServer, err := proxy.NewServer(cfg, ethClients, frozenSet, depositStore, responseCache, metricsCollector)
+	if err != nil {
+		return fatal("Could not initialize proxy server", err)
+	}
+	defer func() {
+		if err := proxyServer.Close(); err != nil {
+			slog.Error("Could not close rate limiter", "error", err)
+		}
+	}()
+
+	adminAPI := admin.NewAPI(cfg, depositStore, proxyServer.ReloadKeys)
+
+	// Reload the configuration on SIGHUP and POST /admin/reload
+	reloader := reload.NewReloader(cfg, func() (*config.Config, error) {
+		return config.Load(os.Args[1:])
+	}, metricsCollector, ethClients, frozenSet, l1Monitor, proxyServer, adminAPI)
+
+	// Health, readiness and status endpoints and the admin API are served
+	// next to the metrics
+	adminHandlers := health.NewService(ethClients, frozenSet, l1Monitor, depositStore).Handlers()
+	for pattern, handler := range adminAPI.Handlers() {
+		adminHandlers[pattern] = handler
+	}
+	adminHandlers["/admin/reload"] = adminAPI.RequireToken(reloader)
+
+	// Background workers stop when the context is cancelled
+	var workers sync.WaitGroup
+	start := func(fn func(ctx context.Context)) {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			fn(ctx)
+		}()
+	}
+
+	// Keep probing upstream health, load the frozen set and keep it current
+	start(ethClients.L1Pool.Run)
+	start(ethClients.L2Pool.Run)
+	start(frozenSet.Run)
+	start(func(ctx context.Context) { reloadOnSignal(ctx, reloader) })
+
+	// Drop cached results of recent blocks on reorgs
+	start(proxyServer.TrackHeads)
 
 	// Start listening for L1 deposit events
-	go monitor.ListenL1DepositEvents(ethClients, cfg, metricsCollector)
+	start(l1Monitor.Run)
 
 	// Monitor L2 deposit confirmations
-	go monitor.MonitorL2Deposits(ethClients, cfg, metricsCollector)
+	start(func(ctx context.Context) {
+		monitor.MonitorL2Deposits(ctx, ethClients, depositStore, cfg, metricsCollector)
+	})
+
+	// Servers drain their in-flight requests before they return. A failing
+	// server shuts down the whole process.
+	serverCtx, stopServers := context.WithCancel(ctx)
+	defer stopServers()
+	serverErrs := make(chan error, 2)
+	go func() {
+		serverErrs <- metrics.StartServer(serverCtx, cfg.MetricsPort, metricsCollector, adminHandlers, cfg.ShutdownTimeout)
+	}()
+	go func() {
+		serverErrs <- proxyServer.Start(serverCtx)
+	}()
 
-	// Start JSON-RPC Proxy
-	proxyServer := proxy.NewServer(cfg, ethClients, metricsCollector)
-	if err := proxyServer.Start(); err != nil {
-		log.Fatalf("[ERROR] Failed to start proxy server: %v", err)
+	code := exitOK
+	var firstErr error
+	select {
+	case <-ctx.Done():
+		slog.Info("Shutdown signal received, draining requests", "timeout", cfg.ShutdownTimeout)
+	case firstErr = <-serverErrs:
+		slog.Error("Server failed, shutting down", "error", firstErr)
+		code = exitFailure
 	}
+	stop()
+	stopServers()
+
+	// Wait for the servers and workers, but not beyond the shutdown timeout.
+	// Once shutdown started, servers only fail if they could not drain in time.
+	remaining := 2
+	if firstErr != nil {
+		remaining--
+	}
+	done := make(chan struct{})
+	drained := true
+	go func() {
+		defer close(done)
+		for i := 0; i < remaining; i++ {
+			if err := <-serverErrs; err != nil {
+				slog.Error("Server did not shut down cleanly", "error", err)
+				drained = false
+			}
+		}
+		workers.Wait()
+	}()
+
+	select {
+	case <-done:
+		if !drained && code == exitOK {
+			code = exitDeadline
+		}
+		slog.Info("Shutdown complete", "exit_code", code)
+	case <-time.After(cfg.ShutdownTimeout + time.Second):
+		if code == exitOK {
+			code = exitDeadline
+		}
+		// Handlers and monitors may still write, closing the stores under
+		// them could lose writes that would otherwise be committed
+		closeStores = false
+		slog.Error("Shutdown timed out, exiting with work still running and stores open", "timeout", cfg.ShutdownTimeout, "exit_code", code)
+	}
+	return code
+}
+
+// reloadOnSignal reloads the configuration every time SIGHUP is received
+// until the context is cancelled
+func reloadOnSignal(ctx context.Context, reloader *reload.Reloader) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			slog.Info("SIGHUP received, reloading configuration")
+			reloader.Reload()
+		}
+	}
+}
+
+// fatal logs an unrecoverable startup error and returns the exit code
+func fatal(msg string, err error) int {
+	slog.Error(msg, "error", err)
+	return exitFailure
 }
@@ -0,0 +1,99 @@
+package admin
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"github.com/ddomeke/rpc_proxy/internal/config"
+	"github.com/ddomeke/rpc_proxy/internal/store"
+)
+
+// API serves the authenticated admin endpoints on the metrics port
+type API struct {
+	depositStore store.DepositStore
+	keyStore     store.KeyStore
+	keysChanged  func() error // Applies changed API keys to the proxy
+	token        atomic.Pointer[string]
+}
+
+// NewAPI creates the admin API. keysChanged is called after an API key was
+// added or deleted.
+func NewAPI(cfg *config.Config, st store.Store, keysChanged func() error) *API {
+	a := &API{
+		depositStore: st,
+		keyStore:     st,
+		keysChanged:  keysChanged,
+	}
+	a.token.Store(&cfg.Admin.Token)
+	if cfg.Admin.Token == "" {
+		slog.Warn("ADMIN_TOKEN not set, the admin API is disabled")
+	}
+	return a
+}
+
+// PrepareReload implements reload.Reloadable. A new token is required from
+// the next request on.
+func (a *API) PrepareReload(cfg *config.Config) (func(), error) {
+	token := cfg.Admin.Token
+	return func() {
+		a.token.Store(&token)
+	}, nil
+}
+
+// Handlers returns the deposit and API key endpoints by pattern
+func (a *API) Handlers() map[string]http.Handler {
+	deposits := a.RequireToken(http.HandlerFunc(a.serveDeposits))
+	keys := a.RequireToken(http.HandlerFunc(a.serveKeys))
+	return map[string]http.Handler{
+		"/admin/deposits":  deposits,
+		"/admin/deposits/": deposits,
+		"/admin/keys":      keys,
+		"/admin/keys/":     keys,
+	}
+}
+
+// RequireToken requires the admin token for a handler and disables it while
+// no token is configured
+func (a *API) RequireToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := *a.token.Load()
+		if token == "" {
+			http.Error(w, "Admin API disabled, admin.token is not set", http.StatusForbidden)
+			return
+		}
+		if !authorized(r, token) {
+			unauthorized(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// authorized reports whether the request carries the token as a bearer token
+func authorized(r *http.Request, token string) bool {
+	given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(given), []byte(token)) == 1
+}
+
+// unauthorized rejects a request without a valid token
+func unauthorized(w http.ResponseWriter, r *http.Request) {
+	slog.WarnContext(r.Context(), "Unauthorized admin request", "path", r.URL.Path, "remote_addr", r.RemoteAddr)
+	w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+	http.Error(w, "Unauthorized", http.StatusUnauthorized)
+}
+
+// writeJSON encodes a value as the JSON response body
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	writeJSONStatus(w, http.StatusOK, v)
+}
+
+// writeJSONStatus encodes a value as the JSON response body with a status code
+func writeJSONStatus(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
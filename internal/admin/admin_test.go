@@ -0,0 +1,44 @@
+package admin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ddomeke/rpc_proxy/internal/config"
+	"github.com/ddomeke/rpc_proxy/internal/store"
+)
+
+func TestRequireToken(t *testing.T) {
+	tests := []struct {
+		name       string
+		token      string // Configured admin token
+		header     string
+		wantStatus int
+	}{
+		// A reload endpoint must not be open while no token is set
+		{name: "no_token_configured", header: "Bearer " + testToken, wantStatus: http.StatusForbidden},
+		{name: "missing_token", token: testToken, wantStatus: http.StatusUnauthorized},
+		{name: "wrong_token", token: testToken, header: "Bearer wrong-token-0123456789", wantStatus: http.StatusUnauthorized},
+		{name: "valid_token", token: testToken, header: "Bearer " + testToken, wantStatus: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.Default()
+			cfg.Admin.Token = tt.token
+			api := NewAPI(cfg, store.NewMemoryStore(), func() error { return nil })
+			handler := api.RequireToken(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+			req := httptest.NewRequest(http.MethodPost, "/admin/reload", nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+		})
+	}
+}
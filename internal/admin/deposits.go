@@ -0,0 +1,332 @@
+package admin
+
+import (
+	"fmt"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ddomeke/rpc_proxy/internal/store"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Page sizes of the deposit list endpoints
+const (
+	defaultDepositsLimit = 100
+	maxDepositsLimit     = 1000
+)
+
+// Deposit is the admin view of a stored deposit
+type Deposit struct {
+	SourceHash     common.Hash         `json:"sourceHash"`
+	Status         store.DepositStatus `json:"status"`
+	Frozen         bool                `json:"frozen"`
+	From           common.Address      `json:"from"`
+	To             common.Address      `json:"to"`
+	IsCreation     bool                `json:"isCreation"`
+	MintWei        string              `json:"mintWei"`
+	ValueWei       string              `json:"valueWei"`
+	GasLimit       uint64              `json:"gasLimit"`
+	L1TxHash       common.Hash         `json:"l1TxHash"`
+	L1BlockHash    common.Hash         `json:"l1BlockHash"`
+	L1Block        uint64              `json:"l1Block"`
+	L1LogIndex     uint                `json:"l1LogIndex"`
+	L1Time         time.Time           `json:"l1Time"`
+	L2TxHash       common.Hash         `json:"l2TxHash"`
+	L2Block        uint64              `json:"l2Block,omitempty"`
+	L2Status       string              `json:"l2Status,omitempty"`
+	AgeSeconds     float64             `json:"ageSeconds"`               // Time since the L1 block
+	LatencySeconds float64             `json:"latencySeconds,omitempty"` // Time between the L1 block and L2 inclusion
+	Lifecycle      []LifecycleEvent    `json:"lifecycle"`
+}
+
+// LifecycleEvent is a state a deposit went through
+type LifecycleEvent struct {
+	State store.DepositStatus `json:"state"`
+	Time  time.Time           `json:"time"`
+	Block uint64              `json:"block,omitempty"` // L1 block when observed, L2 block when included
+}
+
+// serveDeposits serves /admin/deposits (history), /admin/deposits/pending
+// and /admin/deposits/{hash}
+func (a *API) serveDeposits(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	switch path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/admin/deposits"), "/"); path {
+	case "":
+		a.listDeposits(w, r, false)
+	case "pending":
+		a.listDeposits(w, r, true)
+	default:
+		a.lookupDeposit(w, path)
+	}
+}
+
+// listDeposits returns one page of the deposits matching the query
+// parameters. Pending deposits are listed oldest first, history newest first.
+func (a *API) listDeposits(w http.ResponseWriter, r *http.Request, pending bool) {
+	filter, err := parseFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if pending {
+		filter.Statuses = []store.DepositStatus{store.StatusObserved}
+		filter.OldestFirst = r.URL.Query().Get("order") != "desc"
+	}
+
+	deposits, total, err := a.depositStore.QueryDeposits(filter)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	now := time.Now()
+	views := make([]Deposit, len(deposits))
+	for i, deposit := range deposits {
+		views[i] = depositView(deposit, now)
+	}
+	writeJSON(w, struct {
+		Total    int       `json:"total"`
+		Limit    int       `json:"limit"`
+		Offset   int       `json:"offset"`
+		Deposits []Deposit `json:"deposits"`
+	}{total, filter.Limit, filter.Offset, views})
+}
+
+// lookupDeposit returns the deposits with the given source hash, L2
+// transaction hash or L1 transaction hash. Only an L1 transaction can match
+// more than one deposit.
+func (a *API) lookupDeposit(w http.ResponseWriter, hash string) {
+	if !isHash(hash) {
+		http.Error(w, "Invalid hash", http.StatusBadRequest)
+		return
+	}
+	h := common.HexToHash(hash)
+
+	deposits, err := a.findDeposits(h)
+	if err == store.ErrNotFound {
+		http.Error(w, "Deposit not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	now := time.Now()
+	views := make([]Deposit, len(deposits))
+	for i, deposit := range deposits {
+		views[i] = depositView(deposit, now)
+	}
+	writeJSON(w, struct {
+		Deposits []Deposit `json:"deposits"`
+	}{views})
+}
+
+// findDeposits tries the hash as source hash, L2 transaction hash and L1
+// transaction hash in that order
+func (a *API) findDeposits(h common.Hash) ([]*store.Deposit, error) {
+	deposit, err := a.depositStore.GetDeposit(h)
+	if err == nil {
+		return []*store.Deposit{deposit}, nil
+	}
+	if err != store.ErrNotFound {
+		return nil, err
+	}
+
+	deposit, err = a.depositStore.GetDepositByL2TxHash(h)
+	if err == nil {
+		return []*store.Deposit{deposit}, nil
+	}
+	if err != store.ErrNotFound {
+		return nil, err
+	}
+
+	return a.depositStore.GetDepositsByL1TxHash(h)
+}
+
+// parseFilter reads the filter and page query parameters of the list endpoints
+func parseFilter(r *http.Request) (store.DepositFilter, error) {
+	query := r.URL.Query()
+	filter := store.DepositFilter{
+		Limit:       defaultDepositsLimit,
+		OldestFirst: query.Get("order") == "asc",
+	}
+
+	if order := query.Get("order"); order != "" && order != "asc" && order != "desc" {
+		return filter, fmt.Errorf("invalid order: %q, must be asc or desc", order)
+	}
+	for _, status := range strings.Split(query.Get("status"), ",") {
+		switch s := store.DepositStatus(strings.TrimSpace(status)); s {
+		case "":
+		case store.StatusObserved, store.StatusBlocked, store.StatusConfirmed, store.StatusFailed, store.StatusReorged:
+			filter.Statuses = append(filter.Statuses, s)
+		default:
+			return filter, fmt.Errorf("invalid status: %q", status)
+		}
+	}
+
+	var err error
+	if filter.From, err = queryAddress(r, "from"); err != nil {
+		return filter, err
+	}
+	if filter.To, err = queryAddress(r, "to"); err != nil {
+		return filter, err
+	}
+	if filter.MinValue, err = queryWei(r, "min_value"); err != nil {
+		return filter, err
+	}
+	if filter.MaxValue, err = queryWei(r, "max_value"); err != nil {
+		return filter, err
+	}
+
+	// Ages are measured from the L1 block time
+	now := time.Now()
+	minAge, err := queryDuration(r, "min_age")
+	if err != nil {
+		return filter, err
+	}
+	if minAge > 0 {
+		filter.L1Before = now.Add(-minAge)
+	}
+	maxAge, err := queryDuration(r, "max_age")
+	if err != nil {
+		return filter, err
+	}
+	if maxAge > 0 {
+		filter.L1After = now.Add(-maxAge)
+	}
+
+	if filter.Limit, err = queryInt(r, "limit", defaultDepositsLimit); err != nil {
+		return filter, err
+	}
+	if filter.Limit == 0 || filter.Limit > maxDepositsLimit {
+		return filter, fmt.Errorf("invalid limit: must be between 1 and %d", maxDepositsLimit)
+	}
+	if filter.Offset, err = queryInt(r, "offset", 0); err != nil {
+		return filter, err
+	}
+	return filter, nil
+}
+
+// depositView builds the admin view of a deposit
+func depositView(d *store.Deposit, now time.Time) Deposit {
+	view := Deposit{
+		SourceHash:     d.SourceHash,
+		Status:         d.Status,
+		Frozen:         d.Frozen,
+		From:           d.From,
+		To:             d.To,
+		IsCreation:     d.IsCreation,
+		MintWei:        weiString(d.Mint),
+		ValueWei:       weiString(d.Value),
+		GasLimit:       d.GasLimit,
+		L1TxHash:       d.L1TxHash,
+		L1BlockHash:    d.L1BlockHash,
+		L1Block:        d.BlockNum,
+		L1LogIndex:     d.LogIndex,
+		L1Time:         d.Timestamp,
+		L2TxHash:       d.L2TxHash,
+		L2Block:        d.L2BlockNumber,
+		L2Status:       d.L2Status,
+		AgeSeconds:     now.Sub(d.Timestamp).Seconds(),
+		LatencySeconds: d.Latency.Seconds(),
+	}
+
+	// The store keeps the L2 inclusion when a deposit is reorged out of L1
+	// afterwards, so every step can be rebuilt from the stored fields
+	view.Lifecycle = []LifecycleEvent{{State: store.StatusObserved, Time: d.ObservedAt, Block: d.BlockNum}}
+	if d.Frozen {
+		view.Lifecycle = append(view.Lifecycle, LifecycleEvent{State: store.StatusBlocked, Time: d.ObservedAt})
+	}
+	if d.L2BlockNumber > 0 {
+		state := store.StatusConfirmed
+		if d.L2Status == "failed" {
+			state = store.StatusFailed
+		}
+		view.Lifecycle = append(view.Lifecycle, LifecycleEvent{State: state, Time: d.Timestamp.Add(d.Latency), Block: d.L2BlockNumber})
+	}
+	if d.Status == store.StatusReorged {
+		view.Lifecycle = append(view.Lifecycle, LifecycleEvent{State: store.StatusReorged, Time: d.UpdatedAt})
+	}
+	return view
+}
+
+// queryAddress parses an optional address query parameter
+func queryAddress(r *http.Request, name string) (*common.Address, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return nil, nil
+	}
+	if !common.IsHexAddress(value) {
+		return nil, fmt.Errorf("invalid %s: %q is not an address", name, value)
+	}
+	address := common.HexToAddress(value)
+	return &address, nil
+}
+
+// queryWei parses an optional amount in wei
+func queryWei(r *http.Request, name string) (*big.Int, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return nil, nil
+	}
+	amount, ok := new(big.Int).SetString(value, 10)
+	if !ok || amount.Sign() < 0 {
+		return nil, fmt.Errorf("invalid %s: %q, must be an amount in wei", name, value)
+	}
+	return amount, nil
+}
+
+// queryDuration parses an optional duration such as "10m"
+func queryDuration(r *http.Request, name string) (time.Duration, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid %s: %q, must be a duration such as 10m", name, value)
+	}
+	return d, nil
+}
+
+// queryInt parses a non-negative integer query parameter
+func queryInt(r *http.Request, name string, def int) (int, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid %s: %q", name, value)
+	}
+	return n, nil
+}
+
+// isHash reports whether s is a 32-byte hex hash with 0x prefix
+func isHash(s string) bool {
+	if len(s) != 66 || !strings.HasPrefix(s, "0x") {
+		return false
+	}
+	for _, c := range s[2:] {
+		if !strings.ContainsRune("0123456789abcdefABCDEF", c) {
+			return false
+		}
+	}
+	return true
+}
+
+// weiString returns the decimal form of an amount, treating nil as zero
+func weiString(v *big.Int) string {
+	if v == nil {
+		return "0"
+	}
+	return v.String()
+}
@@ -0,0 +1,182 @@
+package admin
+
+import (
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ddomeke/rpc_proxy/internal/config"
+	"github.com/ddomeke/rpc_proxy/internal/eth"
+	"github.com/ddomeke/rpc_proxy/internal/store"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+const testToken = "admin-secret"
+
+var (
+	senderA = common.HexToAddress("0x00000000000000000000000000000000000000a1")
+	senderB = common.HexToAddress("0x00000000000000000000000000000000000000a2")
+)
+
+// testDeposit builds a deposit emitted in an L1 block some time ago
+func testDeposit(block uint64, from common.Address, value int64, age time.Duration) *store.Deposit {
+	blockHash := common.BigToHash(new(big.Int).SetUint64(block))
+	sourceHash := eth.DepositSourceHash(blockHash, 0)
+	return &store.Deposit{
+		DepositEvent: eth.DepositEvent{
+			SourceHash:  sourceHash,
+			L1TxHash:    common.BigToHash(new(big.Int).SetUint64(block * 1000)),
+			L1BlockHash: blockHash,
+			BlockNum:    block,
+			Timestamp:   time.Now().Add(-age),
+			From:        from,
+			Value:       big.NewInt(value),
+			L2TxHash:    common.BytesToHash(append([]byte{0x7e}, sourceHash[1:]...)),
+		},
+		Status: store.StatusObserved,
+	}
+}
+
+// get sends an admin request with a bearer token, none if empty
+func get(api *API, path, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	mux := http.NewServeMux()
+	for pattern, handler := range api.Handlers() {
+		mux.Handle(pattern, handler)
+	}
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestDeposits(t *testing.T) {
+	st := store.NewMemoryStore()
+	pending := testDeposit(10, senderA, 1e15, 2*time.Hour)
+	blocked := testDeposit(11, senderB, 5e15, time.Hour)
+	blocked.Status, blocked.Frozen = store.StatusBlocked, true
+	confirmed := testDeposit(12, senderA, 2e15, 30*time.Minute)
+	failed := testDeposit(13, senderA, 3e15, 10*time.Minute)
+	recent := testDeposit(14, senderB, 1e15, time.Minute)
+	reorged := testDeposit(15, senderB, 1e15, 30*time.Second)
+	for _, deposit := range []*store.Deposit{pending, blocked, confirmed, failed, recent, reorged} {
+		if _, err := st.SaveDeposit(deposit); err != nil {
+			t.Fatal(err)
+		}
+	}
+	st.ConfirmDeposit(confirmed.L2TxHash, 100, "success", 2*time.Minute)
+	st.ConfirmDeposit(failed.L2TxHash, 101, "failed", time.Minute)
+	st.RevertDeposit(reorged.SourceHash)
+
+	cfg := config.Default()
+	cfg.Admin.Token = testToken
+	api := NewAPI(cfg, st, func() error { return nil })
+
+	tests := []struct {
+		name          string
+		path          string
+		token         string
+		wantStatus    int
+		wantBlocks    []uint64 // L1 blocks of the listed deposits in order
+		wantTotal     int      // Total matches of list requests
+		wantLifecycle []store.DepositStatus
+	}{
+		{name: "no_token", path: "/admin/deposits", wantStatus: http.StatusUnauthorized},
+		{name: "wrong_token", path: "/admin/deposits", token: "wrong", wantStatus: http.StatusUnauthorized},
+		{name: "pending", path: "/admin/deposits/pending", token: testToken, wantStatus: http.StatusOK, wantBlocks: []uint64{10, 14}, wantTotal: 2},
+		{name: "pending_newest_first", path: "/admin/deposits/pending?order=desc", token: testToken, wantStatus: http.StatusOK, wantBlocks: []uint64{14, 10}, wantTotal: 2},
+		{name: "pending_by_sender", path: "/admin/deposits/pending?from=" + senderB.Hex(), token: testToken, wantStatus: http.StatusOK, wantBlocks: []uint64{14}, wantTotal: 1},
+		{name: "pending_by_age", path: "/admin/deposits/pending?min_age=1h", token: testToken, wantStatus: http.StatusOK, wantBlocks: []uint64{10}, wantTotal: 1},
+		{name: "history", path: "/admin/deposits", token: testToken, wantStatus: http.StatusOK, wantBlocks: []uint64{15, 14, 13, 12, 11, 10}, wantTotal: 6},
+		{name: "history_by_sender", path: "/admin/deposits?from=" + senderA.Hex(), token: testToken, wantStatus: http.StatusOK, wantBlocks: []uint64{13, 12, 10}, wantTotal: 3},
+		{name: "history_by_status", path: "/admin/deposits?status=confirmed,failed", token: testToken, wantStatus: http.StatusOK, wantBlocks: []uint64{13, 12}, wantTotal: 2},
+		{name: "history_by_value", path: "/admin/deposits?min_value=2000000000000000&max_value=3000000000000000", token: testToken, wantStatus: http.StatusOK, wantBlocks: []uint64{13, 12}, wantTotal: 2},
+		{name: "history_by_age", path: "/admin/deposits?max_age=45m", token: testToken, wantStatus: http.StatusOK, wantBlocks: []uint64{15, 14, 13, 12}, wantTotal: 4},
+		{name: "history_page", path: "/admin/deposits?limit=2&offset=1", token: testToken, wantStatus: http.StatusOK, wantBlocks: []uint64{14, 13}, wantTotal: 6},
+		{name: "invalid_limit", path: "/admin/deposits?limit=0", token: testToken, wantStatus: http.StatusBadRequest},
+		{name: "invalid_sender", path: "/admin/deposits?from=0x12", token: testToken, wantStatus: http.StatusBadRequest},
+		{name: "invalid_status", path: "/admin/deposits?status=lost", token: testToken, wantStatus: http.StatusBadRequest},
+		{
+			name: "by_source_hash", path: "/admin/deposits/" + confirmed.SourceHash.Hex(), token: testToken, wantStatus: http.StatusOK,
+			wantBlocks: []uint64{12}, wantLifecycle: []store.DepositStatus{store.StatusObserved, store.StatusConfirmed},
+		},
+		{
+			name: "by_l2_tx_hash", path: "/admin/deposits/" + failed.L2TxHash.Hex(), token: testToken, wantStatus: http.StatusOK,
+			wantBlocks: []uint64{13}, wantLifecycle: []store.DepositStatus{store.StatusObserved, store.StatusFailed},
+		},
+		{
+			name: "by_l1_tx_hash", path: "/admin/deposits/" + blocked.L1TxHash.Hex(), token: testToken, wantStatus: http.StatusOK,
+			wantBlocks: []uint64{11}, wantLifecycle: []store.DepositStatus{store.StatusObserved, store.StatusBlocked},
+		},
+		{
+			name: "reorged", path: "/admin/deposits/" + reorged.SourceHash.Hex(), token: testToken, wantStatus: http.StatusOK,
+			wantBlocks: []uint64{15}, wantLifecycle: []store.DepositStatus{store.StatusObserved, store.StatusReorged},
+		},
+		{name: "unknown_hash", path: "/admin/deposits/" + common.HexToHash("0x1234").Hex(), token: testToken, wantStatus: http.StatusNotFound},
+		{name: "invalid_hash", path: "/admin/deposits/0x1234", token: testToken, wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := get(api, tt.path, tt.token)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			var resp struct {
+				Total    int       `json:"total"`
+				Deposits []Deposit `json:"deposits"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			blocks := make([]uint64, len(resp.Deposits))
+			for i, deposit := range resp.Deposits {
+				blocks[i] = deposit.L1Block
+			}
+			if !equal(blocks, tt.wantBlocks) {
+				t.Errorf("blocks = %v, want %v", blocks, tt.wantBlocks)
+			}
+			if tt.wantTotal > 0 && resp.Total != tt.wantTotal {
+				t.Errorf("total = %d, want %d", resp.Total, tt.wantTotal)
+			}
+			if tt.wantLifecycle != nil && len(resp.Deposits) == 1 {
+				states := make([]store.DepositStatus, len(resp.Deposits[0].Lifecycle))
+				for i, event := range resp.Deposits[0].Lifecycle {
+					states[i] = event.State
+				}
+				if !equal(states, tt.wantLifecycle) {
+					t.Errorf("lifecycle = %v, want %v", states, tt.wantLifecycle)
+				}
+			}
+		})
+	}
+}
+
+func TestDepositsDisabled(t *testing.T) {
+	api := NewAPI(config.Default(), store.NewMemoryStore(), func() error { return nil })
+	if rec := get(api, "/admin/deposits", testToken); rec.Code != http.StatusForbidden {
+		t.Errorf("status = %d, want %d without an admin token", rec.Code, http.StatusForbidden)
+	}
+}
+
+// equal reports whether two slices have the same elements in order
+func equal[T comparable](a, b []T) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,136 @@
+package admin
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/ddomeke/rpc_proxy/internal/auth"
+	"github.com/ddomeke/rpc_proxy/internal/store"
+)
+
+// apiKeyBytes is the number of random bytes of a generated API key
+const apiKeyBytes = 24
+
+// keyName restricts API key names, which are used as metric labels
+var keyName = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,64}$`)
+
+// serveKeys serves /admin/keys (list and create) and /admin/keys/{name} (delete)
+func (a *API) serveKeys(w http.ResponseWriter, r *http.Request) {
+	name := strings.Trim(strings.TrimPrefix(r.URL.Path, "/admin/keys"), "/")
+	switch {
+	case name == "" && r.Method == http.MethodGet:
+		a.listKeys(w)
+	case name == "" && r.Method == http.MethodPost:
+		a.createKey(w, r)
+	case name != "" && r.Method == http.MethodDelete:
+		a.deleteKey(w, r, name)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// listKeys returns the stored API keys without the keys themselves. Keys of
+// the config file are not listed.
+func (a *API) listKeys(w http.ResponseWriter) {
+	keys, err := a.keyStore.APIKeys()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if keys == nil {
+		keys = []*store.APIKey{}
+	}
+	writeJSON(w, struct {
+		Keys []*store.APIKey `json:"keys"`
+	}{keys})
+}
+
+// createKey generates a new API key with the posted permissions and quotas.
+// The key is only returned in this response, the store keeps its hash.
+func (a *API) createKey(w http.ResponseWriter, r *http.Request) {
+	var key store.APIKey
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&key); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid API key: %v", err), http.StatusBadRequest)
+		return
+	}
+	if err := validateKey(&key); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	random := make([]byte, apiKeyBytes)
+	if _, err := rand.Read(random); err != nil {
+		http.Error(w, "Could not generate API key", http.StatusInternalServerError)
+		return
+	}
+	secret := hex.EncodeToString(random)
+	key.KeyHash = auth.HashKey(secret)
+
+	err := a.keyStore.SaveAPIKey(&key)
+	if err == store.ErrExists {
+		http.Error(w, fmt.Sprintf("API key %s already exists", key.Name), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := a.keysChanged(); err != nil {
+		slog.Error("Could not apply API keys", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	slog.InfoContext(r.Context(), "API key created", "key", key.Name, "remote_addr", r.RemoteAddr)
+	writeJSONStatus(w, http.StatusCreated, struct {
+		Name string `json:"name"`
+		Key  string `json:"key"`
+	}{key.Name, secret})
+}
+
+// deleteKey revokes a stored API key
+func (a *API) deleteKey(w http.ResponseWriter, r *http.Request, name string) {
+	err := a.keyStore.DeleteAPIKey(name)
+	if err == store.ErrNotFound {
+		http.Error(w, "API key not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := a.keysChanged(); err != nil {
+		slog.Error("Could not apply API keys", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	slog.InfoContext(r.Context(), "API key deleted", "key", name, "remote_addr", r.RemoteAddr)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// validateKey checks the name and the quotas of a new API key
+func validateKey(key *store.APIKey) error {
+	switch {
+	case !keyName.MatchString(key.Name):
+		return fmt.Errorf("name must be 1 to 64 letters, digits, '_', '.' or '-'")
+	case key.RPS < 0, key.Burst < 0, key.DailyRequests < 0, key.DailyComputeUnits < 0:
+		return fmt.Errorf("quotas must not be negative")
+	}
+	for _, list := range [][]string{key.Routes, key.Methods} {
+		for _, item := range list {
+			if item == "" || strings.Contains(item, ",") {
+				return fmt.Errorf("routes and methods must be non-empty and must not contain ','")
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ddomeke/rpc_proxy/internal/auth"
+	"github.com/ddomeke/rpc_proxy/internal/config"
+	"github.com/ddomeke/rpc_proxy/internal/store"
+)
+
+func TestKeys(t *testing.T) {
+	st := store.NewMemoryStore()
+	cfg := config.Default()
+	cfg.Admin.Token = testToken
+	changes := 0
+	api := NewAPI(cfg, st, func() error {
+		changes++
+		return nil
+	})
+
+	// Cases run in order against the same store
+	tests := []struct {
+		name        string
+		method      string
+		path        string
+		body        string
+		wantStatus  int
+		wantChanges int // Keys applied to the proxy so far
+		wantKeys    []string
+	}{
+		{name: "empty", method: http.MethodGet, path: "/admin/keys", wantStatus: http.StatusOK, wantKeys: []string{}},
+		{name: "create", method: http.MethodPost, path: "/admin/keys", body: `{"name":"indexer","routes":["l1"],"dailyRequests":1000}`, wantStatus: http.StatusCreated, wantChanges: 1},
+		{name: "duplicate", method: http.MethodPost, path: "/admin/keys", body: `{"name":"indexer"}`, wantStatus: http.StatusConflict, wantChanges: 1},
+		{name: "invalid_name", method: http.MethodPost, path: "/admin/keys", body: `{"name":"a b"}`, wantStatus: http.StatusBadRequest, wantChanges: 1},
+		{name: "negative_quota", method: http.MethodPost, path: "/admin/keys", body: `{"name":"bot","rps":-1}`, wantStatus: http.StatusBadRequest, wantChanges: 1},
+		{name: "unknown_field", method: http.MethodPost, path: "/admin/keys", body: `{"name":"bot","key":"chosen"}`, wantStatus: http.StatusBadRequest, wantChanges: 1},
+		{name: "list", method: http.MethodGet, path: "/admin/keys", wantStatus: http.StatusOK, wantChanges: 1, wantKeys: []string{"indexer"}},
+		{name: "delete", method: http.MethodDelete, path: "/admin/keys/indexer", wantStatus: http.StatusNoContent, wantChanges: 2},
+		{name: "delete_unknown", method: http.MethodDelete, path: "/admin/keys/indexer", wantStatus: http.StatusNotFound, wantChanges: 2},
+		{name: "list_after_delete", method: http.MethodGet, path: "/admin/keys", wantStatus: http.StatusOK, wantChanges: 2, wantKeys: []string{}},
+	}
+
+	mux := http.NewServeMux()
+	for pattern, handler := range api.Handlers() {
+		mux.Handle(pattern, handler)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			req.Header.Set("Authorization", "Bearer "+testToken)
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if changes != tt.wantChanges {
+				t.Errorf("keys applied %d times, want %d", changes, tt.wantChanges)
+			}
+
+			switch {
+			case tt.wantStatus == http.StatusCreated:
+				// The key is returned once, the store only keeps its hash
+				var created struct {
+					Name string `json:"name"`
+					Key  string `json:"key"`
+				}
+				json.Unmarshal(rec.Body.Bytes(), &created)
+				stored, err := st.APIKeys()
+				if err != nil || len(stored) != 1 || stored[0].KeyHash != auth.HashKey(created.Key) {
+					t.Errorf("stored keys = %v (%v), want the hash of the returned key", stored, err)
+				}
+			case tt.wantKeys != nil:
+				if strings.Contains(rec.Body.String(), "Hash") {
+					t.Errorf("key list %s contains key hashes", rec.Body.String())
+				}
+				var list struct {
+					Keys []store.APIKey `json:"keys"`
+				}
+				json.Unmarshal(rec.Body.Bytes(), &list)
+				names := make([]string, len(list.Keys))
+				for i, key := range list.Keys {
+					names[i] = key.Name
+				}
+				if !equal(names, tt.wantKeys) {
+					t.Errorf("keys = %v, want %v", names, tt.wantKeys)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,38 @@
+package auth
+
+// defaultComputeUnits is the cost of common methods, roughly in proportion
+// to the load they put on the upstream node. auth.compute_units overrides
+// single entries, auth.default_compute_units applies to all other methods.
+var defaultComputeUnits = map[string]int{
+	"eth_chainId":               0,
+	"net_version":               0,
+	"web3_clientVersion":        0,
+	"eth_blockNumber":           10,
+	"eth_gasPrice":              19,
+	"eth_maxPriorityFeePerGas":  19,
+	"eth_feeHistory":            10,
+	"eth_syncing":               0,
+	"eth_getBalance":            19,
+	"eth_getCode":               19,
+	"eth_getStorageAt":          17,
+	"eth_getTransactionCount":   26,
+	"eth_getBlockByNumber":      16,
+	"eth_getBlockByHash":        16,
+	"eth_getTransactionByHash":  17,
+	"eth_getTransactionReceipt": 15,
+	"eth_getBlockReceipts":      500,
+	"eth_getLogs":               75,
+	"eth_call":                  26,
+	"eth_estimateGas":           87,
+	"eth_createAccessList":      87,
+	"eth_sendRawTransaction":    250,
+	"eth_subscribe":             10,
+	"eth_unsubscribe":           10,
+	"eth_newFilter":             20,
+	"eth_getFilterChanges":      20,
+	"eth_uninstallFilter":       10,
+	"debug_traceTransaction":    300,
+	"debug_traceCall":           300,
+	"debug_traceBlockByNumber":  500,
+	"debug_traceBlockByHash":    500,
+}
@@ -0,0 +1,144 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"log/slog"
+	"math"
+
+	"github.com/ddomeke/rpc_proxy/internal/config"
+	"github.com/ddomeke/rpc_proxy/internal/ratelimit"
+	"github.com/ddomeke/rpc_proxy/internal/store"
+)
+
+// Key is an API key with its permissions and quotas
+type Key struct {
+	Name    string
+	routes  map[string]bool // nil allows every route
+	methods map[string]bool // nil allows every method of the route
+	quota   Quota
+}
+
+// Quota holds the limits of a key. Zero values are unlimited.
+type Quota struct {
+	RPS               float64
+	Burst             int
+	DailyRequests     int64
+	DailyComputeUnits int64
+}
+
+// Keyring holds the API keys of a configuration and the compute unit costs
+type Keyring struct {
+	required    bool
+	keys        map[string]*Key // By SHA-256 hash of the key
+	costs       map[string]int
+	defaultCost int
+}
+
+// HashKey returns the hex SHA-256 hash under which a key is stored
+func HashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// NewKeyring builds the keyring from the configured and the stored keys.
+// Stored keys whose name is also configured are skipped.
+func NewKeyring(cfg config.AuthConfig, stored []*store.APIKey) *Keyring {
+	k := &Keyring{
+		required:    cfg.Required,
+		keys:        make(map[string]*Key),
+		costs:       make(map[string]int, len(defaultComputeUnits)+len(cfg.ComputeUnits)),
+		defaultCost: cfg.DefaultComputeUnits,
+	}
+	for method, cost := range defaultComputeUnits {
+		k.costs[method] = cost
+	}
+	for method, cost := range cfg.ComputeUnits {
+		k.costs[method] = cost
+	}
+
+	names := make(map[string]bool)
+	for _, kc := range cfg.Keys {
+		k.keys[HashKey(kc.Key)] = newKey(kc.Name, kc.Routes, kc.Methods, Quota{
+			RPS:               kc.RPS,
+			Burst:             kc.Burst,
+			DailyRequests:     kc.DailyRequests,
+			DailyComputeUnits: kc.DailyComputeUnits,
+		})
+		names[kc.Name] = true
+	}
+	for _, sk := range stored {
+		if names[sk.Name] || k.keys[sk.KeyHash] != nil {
+			slog.Warn("Stored API key skipped, the name or key is configured", "key", sk.Name)
+			continue
+		}
+		k.keys[sk.KeyHash] = newKey(sk.Name, sk.Routes, sk.Methods, Quota{
+			RPS:               sk.RPS,
+			Burst:             sk.Burst,
+			DailyRequests:     sk.DailyRequests,
+			DailyComputeUnits: sk.DailyComputeUnits,
+		})
+	}
+	return k
+}
+
+// newKey creates a key with its route and method permissions
+func newKey(name string, routes, methods []string, quota Quota) *Key {
+	key := &Key{Name: name, quota: quota}
+	if key.quota.RPS > 0 && key.quota.Burst == 0 {
+		key.quota.Burst = int(math.Ceil(key.quota.RPS))
+	}
+	if len(routes) > 0 {
+		key.routes = make(map[string]bool)
+		for _, route := range routes {
+			key.routes[route] = true
+		}
+	}
+	if len(methods) > 0 {
+		key.methods = make(map[string]bool)
+		for _, method := range methods {
+			key.methods[method] = true
+		}
+	}
+	return key
+}
+
+// RateLimit returns the rps quota of a key. It is enforced by the rate
+// limiter, so all replicas share it with the redis backend.
+func (k *Key) RateLimit() ratelimit.Limit {
+	return ratelimit.Limit{RPS: k.quota.RPS, Burst: k.quota.Burst}
+}
+
+// Required reports whether requests without a key are rejected
+func (k *Keyring) Required() bool {
+	return k.required
+}
+
+// Len returns the number of keys
+func (k *Keyring) Len() int {
+	return len(k.keys)
+}
+
+// Lookup returns the settings of an API key
+func (k *Keyring) Lookup(key string) (*Key, bool) {
+	found, ok := k.keys[HashKey(key)]
+	return found, ok
+}
+
+// Cost returns the compute units of a method
+func (k *Keyring) Cost(method string) int {
+	if cost, ok := k.costs[method]; ok {
+		return cost
+	}
+	return k.defaultCost
+}
+
+// RouteAllowed reports whether the key may use a route
+func (key *Key) RouteAllowed(route string) bool {
+	return key.routes == nil || key.routes[route]
+}
+
+// MethodAllowed reports whether the key may call a method
+func (key *Key) MethodAllowed(method string) bool {
+	return key.methods == nil || key.methods[method]
+}
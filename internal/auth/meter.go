@@ -0,0 +1,89 @@
+package auth
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ddomeke/rpc_proxy/internal/metrics"
+)
+
+// Quotas a call can exceed
+const (
+	QuotaDailyRequests     = "daily_requests"
+	QuotaDailyComputeUnits = "daily_compute_units"
+)
+
+// QuotaError reports a call that was rejected because its key is over a quota
+type QuotaError struct {
+	Key        string
+	Quota      string
+	RetryAfter time.Duration // Time until the call would be allowed
+}
+
+func (e *QuotaError) Error() string {
+	return fmt.Sprintf("%s quota of API key %s exceeded", e.Quota, e.Key)
+}
+
+// Meter enforces the daily quotas of API keys and meters their usage. Usage
+// is kept in memory by key name, so it survives reloads but not restarts. The
+// rps quota is enforced by the rate limiter.
+type Meter struct {
+	collector *metrics.Collector
+
+	mu    sync.Mutex
+	usage map[string]*usage
+}
+
+// usage is the daily counters of a key
+type usage struct {
+	day          time.Time // Start of the UTC day the counters belong to
+	requests     int64
+	computeUnits int64
+}
+
+// NewMeter creates a meter without usage
+func NewMeter(collector *metrics.Collector) *Meter {
+	return &Meter{
+		collector: collector,
+		usage:     make(map[string]*usage),
+	}
+}
+
+// Charge charges a call of the given compute units to a key. A call over a
+// quota is not charged and returns a *QuotaError.
+func (m *Meter) Charge(key *Key, cost int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	today := now.UTC().Truncate(24 * time.Hour)
+	u, ok := m.usage[key.Name]
+	if !ok {
+		u = &usage{day: today}
+		m.usage[key.Name] = u
+	}
+	if !u.day.Equal(today) {
+		u.day, u.requests, u.computeUnits = today, 0, 0
+	}
+
+	var err *QuotaError
+	switch {
+	case key.quota.DailyRequests > 0 && u.requests+1 > key.quota.DailyRequests:
+		err = &QuotaError{Key: key.Name, Quota: QuotaDailyRequests, RetryAfter: today.Add(24 * time.Hour).Sub(now)}
+	case key.quota.DailyComputeUnits > 0 && u.computeUnits+int64(cost) > key.quota.DailyComputeUnits:
+		err = &QuotaError{Key: key.Name, Quota: QuotaDailyComputeUnits, RetryAfter: today.Add(24 * time.Hour).Sub(now)}
+	}
+	if err != nil {
+		m.collector.APIKeyRequests.WithLabelValues(key.Name, "quota_exceeded").Inc()
+		return err
+	}
+
+	u.requests++
+	u.computeUnits += int64(cost)
+	m.collector.APIKeyRequests.WithLabelValues(key.Name, "allowed").Inc()
+	m.collector.APIKeyComputeUnits.WithLabelValues(key.Name).Add(float64(cost))
+	m.collector.APIKeyDailyUsage.WithLabelValues(key.Name, "requests").Set(float64(u.requests))
+	m.collector.APIKeyDailyUsage.WithLabelValues(key.Name, "compute_units").Set(float64(u.computeUnits))
+	return nil
+}
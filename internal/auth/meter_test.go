@@ -0,0 +1,60 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/ddomeke/rpc_proxy/internal/config"
+	"github.com/ddomeke/rpc_proxy/internal/metrics"
+)
+
+func TestCharge(t *testing.T) {
+	keyring := NewKeyring(config.AuthConfig{
+		DefaultComputeUnits: 5,
+		ComputeUnits:        map[string]int{"eth_call": 40},
+		Keys: []config.APIKeyConfig{
+			{Name: "requests", Key: "a", DailyRequests: 2},
+			{Name: "units", Key: "b", DailyComputeUnits: 50},
+		},
+	}, nil)
+	meter := NewMeter(metrics.NewCollector(config.MetricsConfig{}))
+
+	// Calls are charged in order, a rejected call is not charged
+	tests := []struct {
+		name      string
+		key       string
+		method    string
+		wantQuota string // Quota exceeded, none if empty
+	}{
+		{name: "first_request", key: "a", method: "eth_call"},
+		{name: "second_request", key: "a", method: "eth_call"},
+		{name: "requests_exceeded", key: "a", method: "eth_chainId", wantQuota: QuotaDailyRequests},
+		{name: "configured_cost", key: "b", method: "eth_call"},
+		{name: "units_exceeded", key: "b", method: "eth_getLogs", wantQuota: QuotaDailyComputeUnits},
+		{name: "free_method", key: "b", method: "eth_chainId"},
+		{name: "default_cost", key: "b", method: "eth_madeUpMethod"},
+		{name: "units_reached", key: "b", method: "eth_madeUpMethod"},
+		{name: "units_used_up", key: "b", method: "eth_madeUpMethod", wantQuota: QuotaDailyComputeUnits},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, ok := keyring.Lookup(tt.key)
+			if !ok {
+				t.Fatalf("key %s not found", tt.key)
+			}
+			err := meter.Charge(key, keyring.Cost(tt.method))
+			var quotaErr *QuotaError
+			switch {
+			case tt.wantQuota == "" && err != nil:
+				t.Errorf("Charge() = %v, want no error", err)
+			case tt.wantQuota != "" && !errors.As(err, &quotaErr):
+				t.Errorf("Charge() = %v, want %s exceeded", err, tt.wantQuota)
+			case tt.wantQuota != "" && quotaErr.Quota != tt.wantQuota:
+				t.Errorf("quota = %s, want %s", quotaErr.Quota, tt.wantQuota)
+			case quotaErr != nil && quotaErr.RetryAfter <= 0:
+				t.Errorf("RetryAfter = %v, want the time until the next day", quotaErr.RetryAfter)
+			}
+		})
+	}
+}
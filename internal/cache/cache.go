@@ -0,0 +1,232 @@
+package cache
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log/slog"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ddomeke/rpc_proxy/internal/config"
+	"github.com/ddomeke/rpc_proxy/internal/metrics"
+)
+
+// Forever is the TTL of results that never change. They are only evicted
+// when the cache is full and are also kept on disk.
+const Forever = time.Duration(math.MaxInt64)
+
+// HeadBlock is the block of results that follow the head, such as those of
+// block tags. They are dropped on every reorg.
+const HeadBlock = uint64(math.MaxUint64)
+
+// entryOverhead approximates the memory of an entry besides its result
+const entryOverhead = 200
+
+// Cache keeps upstream JSON-RPC results in an in-memory LRU and, if a path
+// is configured, the results that never change in a SQLite file as well
+type Cache struct {
+	collector *metrics.Collector
+	maxBytes  int
+	disk      *disk // Nil without a path
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List // Most recently used first
+	bytes   int
+	hits    uint64
+	misses  uint64
+}
+
+// entry is a cached result
+type entry struct {
+	key     string
+	chain   string
+	block   uint64 // Highest block the result depends on
+	result  json.RawMessage
+	expires time.Time // Zero for results that never change
+}
+
+// New creates the cache of a configuration, nil if caching is disabled
+func New(cfg config.CacheConfig, collector *metrics.Collector) (*Cache, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+
+	c := &Cache{
+		collector: collector,
+		maxBytes:  cfg.MaxMemoryMB << 20,
+		entries:   make(map[string]*list.Element),
+		lru:       list.New(),
+	}
+	if cfg.Path != "" {
+		d, err := openDisk(cfg.Path, cfg.DiskMaxEntries)
+		if err != nil {
+			return nil, err
+		}
+		c.disk = d
+	}
+	slog.Info("Response cache enabled", "max_memory_mb", cfg.MaxMemoryMB, "path", cfg.Path)
+	return c, nil
+}
+
+// Key returns the cache key of a call on a chain. Parameters are normalized,
+// so that calls differing only in whitespace, object key order or the case of
+// hex values share an entry.
+func Key(chain, method string, params json.RawMessage) string {
+	var decoded interface{}
+	normalized := params
+	if err := json.Unmarshal(params, &decoded); err == nil {
+		normalized, _ = json.Marshal(lowerHex(decoded))
+	}
+	sum := sha256.Sum256([]byte(chain + "\x00" + method + "\x00" + string(normalized)))
+	return hex.EncodeToString(sum[:])
+}
+
+// lowerHex lower-cases every 0x-prefixed string of a decoded JSON value
+func lowerHex(v interface{}) interface{} {
+	switch v := v.(type) {
+	case string:
+		if strings.HasPrefix(v, "0x") || strings.HasPrefix(v, "0X") {
+			return strings.ToLower(v)
+		}
+	case []interface{}:
+		for i := range v {
+			v[i] = lowerHex(v[i])
+		}
+	case map[string]interface{}:
+		for k := range v {
+			v[k] = lowerHex(v[k])
+		}
+	}
+	return v
+}
+
+// Get returns the cached result of a key. Results that are not in memory
+// are looked up on disk.
+func (c *Cache) Get(key, method string) (json.RawMessage, bool) {
+	result, ok := c.get(key)
+	if !ok && c.disk != nil {
+		if result, ok = c.disk.get(key); ok {
+			c.set(&entry{key: key, result: result})
+		}
+	}
+
+	c.mu.Lock()
+	outcome := "miss"
+	if ok {
+		c.hits++
+		outcome = "hit"
+	} else {
+		c.misses++
+	}
+	ratio := float64(c.hits) / float64(c.hits+c.misses)
+	c.mu.Unlock()
+
+	c.collector.CacheLookups.WithLabelValues(method, outcome).Inc()
+	c.collector.CacheHitRatio.Set(ratio)
+	return result, ok
+}
+
+// Set caches the result of a key on a chain for a TTL. block is the highest
+// block the result depends on. Results cached Forever are written to disk as
+// well.
+func (c *Cache) Set(chain, key string, block uint64, result json.RawMessage, ttl time.Duration) {
+	e := &entry{key: key, chain: chain, block: block, result: result}
+	if ttl != Forever {
+		e.expires = time.Now().Add(ttl)
+	} else if c.disk != nil {
+		c.disk.put(key, result)
+	}
+	c.set(e)
+}
+
+// Invalidate drops the results of a chain that expire and depend on a block
+// from the given one on, which are those of reorged blocks that are not
+// finalized and of block tags. It is called on reorgs and returns the number
+// of dropped results.
+func (c *Cache) Invalidate(chain string, from uint64) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	dropped := 0
+	for elem := c.lru.Front(); elem != nil; {
+		next := elem.Next()
+		if e := elem.Value.(*entry); e.chain == chain && !e.expires.IsZero() && e.block >= from {
+			c.remove(elem)
+			dropped++
+		}
+		elem = next
+	}
+	c.updateSize()
+	c.collector.CacheInvalidations.WithLabelValues(chain).Add(float64(dropped))
+	return dropped
+}
+
+// Close writes the pending results to disk and closes it
+func (c *Cache) Close() error {
+	if c.disk == nil {
+		return nil
+	}
+	return c.disk.close()
+}
+
+// get returns an unexpired result from memory and marks it as recently used
+func (c *Cache) get(key string) (json.RawMessage, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	e := elem.Value.(*entry)
+	if !e.expires.IsZero() && time.Now().After(e.expires) {
+		c.remove(elem)
+		c.updateSize()
+		return nil, false
+	}
+	c.lru.MoveToFront(elem)
+	return e.result, true
+}
+
+// set stores an entry in memory and evicts the least recently used entries
+// until the cache fits its size again
+func (c *Cache) set(e *entry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[e.key]; ok {
+		c.remove(elem)
+	}
+	if size(e) > c.maxBytes {
+		return
+	}
+	c.entries[e.key] = c.lru.PushFront(e)
+	c.bytes += size(e)
+	for c.bytes > c.maxBytes {
+		c.remove(c.lru.Back())
+	}
+	c.updateSize()
+}
+
+// remove drops an entry from memory, the caller holds the lock
+func (c *Cache) remove(elem *list.Element) {
+	e := c.lru.Remove(elem).(*entry)
+	delete(c.entries, e.key)
+	c.bytes -= size(e)
+}
+
+// updateSize exports the size of the cache, the caller holds the lock
+func (c *Cache) updateSize() {
+	c.collector.CacheEntries.Set(float64(len(c.entries)))
+	c.collector.CacheSizeBytes.Set(float64(c.bytes))
+}
+
+// size approximates the memory an entry takes
+func size(e *entry) int {
+	return len(e.key) + len(e.chain) + len(e.result) + entryOverhead
+}
@@ -0,0 +1,98 @@
+package cache
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ddomeke/rpc_proxy/internal/config"
+	"github.com/ddomeke/rpc_proxy/internal/metrics"
+)
+
+var collector = metrics.NewCollector(config.MetricsConfig{})
+
+// testCache creates an enabled cache, kept on disk if path is set
+func testCache(t *testing.T, path string) *Cache {
+	t.Helper()
+	c, err := New(config.CacheConfig{Enabled: true, MaxMemoryMB: 1, Path: path, DiskMaxEntries: 100}, collector)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestKey(t *testing.T) {
+	base := Key("L1", "eth_getBalance", json.RawMessage(`["0xAbC",{"blockHash":"0xDEF"}]`))
+	tests := []struct {
+		name   string
+		chain  string
+		method string
+		params string
+		same   bool
+	}{
+		{name: "whitespace", chain: "L1", method: "eth_getBalance", params: `[ "0xAbC", { "blockHash": "0xDEF" } ]`, same: true},
+		{name: "hex_case", chain: "L1", method: "eth_getBalance", params: `["0xabc",{"blockHash":"0xdef"}]`, same: true},
+		{name: "other_chain", chain: "L2", method: "eth_getBalance", params: `["0xAbC",{"blockHash":"0xDEF"}]`},
+		{name: "other_method", chain: "L1", method: "eth_getCode", params: `["0xAbC",{"blockHash":"0xDEF"}]`},
+		{name: "other_params", chain: "L1", method: "eth_getBalance", params: `["0xAbD",{"blockHash":"0xDEF"}]`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if same := Key(tt.chain, tt.method, json.RawMessage(tt.params)) == base; same != tt.same {
+				t.Errorf("same key = %v, want %v", same, tt.same)
+			}
+		})
+	}
+}
+
+func TestInvalidate(t *testing.T) {
+	c := testCache(t, "")
+	entries := []struct {
+		key   string
+		chain string
+		block uint64
+		ttl   time.Duration
+		kept  bool // Still cached after a reorg of L1 from block 100
+	}{
+		{key: "finalized", chain: "L1", block: 90, ttl: Forever, kept: true},
+		{key: "before_reorg", chain: "L1", block: 99, ttl: time.Hour, kept: true},
+		{key: "reorged", chain: "L1", block: 100, ttl: time.Hour},
+		{key: "latest", chain: "L1", block: HeadBlock, ttl: time.Hour},
+		{key: "other_chain", chain: "L2", block: 100, ttl: time.Hour, kept: true},
+	}
+	for _, e := range entries {
+		c.Set(e.chain, e.key, e.block, json.RawMessage(`"`+e.key+`"`), e.ttl)
+	}
+
+	if dropped := c.Invalidate("L1", 100); dropped != 2 {
+		t.Errorf("Invalidate() dropped %d results, want 2", dropped)
+	}
+	for _, e := range entries {
+		t.Run(e.key, func(t *testing.T) {
+			if _, ok := c.Get(e.key, "eth_call"); ok != e.kept {
+				t.Errorf("cached = %v, want %v", ok, e.kept)
+			}
+		})
+	}
+}
+
+func TestDisk(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.db")
+	c := testCache(t, path)
+	c.Set("L1", "finalized", 90, json.RawMessage(`"0x1"`), Forever)
+	c.Set("L1", "recent", 120, json.RawMessage(`"0x2"`), time.Hour)
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only results that never expire survive a restart
+	c = testCache(t, path)
+	defer c.Close()
+	if result, ok := c.Get("finalized", "eth_getBlockByHash"); !ok || string(result) != `"0x1"` {
+		t.Errorf("finalized result = %s, %v, want \"0x1\" from disk", result, ok)
+	}
+	if _, ok := c.Get("recent", "eth_getBlockByNumber"); ok {
+		t.Error("recent result was kept across a restart")
+	}
+}
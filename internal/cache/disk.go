@@ -0,0 +1,129 @@
+package cache
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"sync"
+
+	_ "modernc.org/sqlite" // Pure-Go SQLite driver
+)
+
+// diskSchema keeps results by key. Rewritten results get a new rowid, so the
+// lowest rowids are the results written longest ago.
+const diskSchema = `
+CREATE TABLE IF NOT EXISTS results (
+	key    TEXT PRIMARY KEY,
+	result BLOB NOT NULL
+);
+`
+
+// Disk writes are queued so that they do not delay responses. Writes beyond
+// the queue are dropped, the result is fetched again on the next miss.
+const (
+	diskQueueSize     = 1024
+	diskPruneInterval = 1000 // Writes between prunes of the oldest results
+)
+
+// disk keeps the results that never change in a SQLite file
+type disk struct {
+	db         *sql.DB
+	maxEntries int
+	writes     chan diskWrite
+	done       chan struct{}
+
+	mu     sync.RWMutex // Keeps writes from being queued after close
+	closed bool
+}
+
+// diskWrite is a queued result
+type diskWrite struct {
+	key    string
+	result json.RawMessage
+}
+
+// openDisk opens (or creates) the cache database and starts its writer
+func openDisk(path string, maxEntries int) (*disk, error) {
+	dsn := fmt.Sprintf("file:%s?_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)", url.PathEscape(path))
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("could not open cache database: %v", err)
+	}
+	if _, err := db.Exec(diskSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("could not create cache schema: %v", err)
+	}
+
+	d := &disk{
+		db:         db,
+		maxEntries: maxEntries,
+		writes:     make(chan diskWrite, diskQueueSize),
+		done:       make(chan struct{}),
+	}
+	go d.writeLoop()
+	return d, nil
+}
+
+// get reads a result, a failed read is a miss
+func (d *disk) get(key string) (json.RawMessage, bool) {
+	var result []byte
+	err := d.db.QueryRow(`SELECT result FROM results WHERE key = ?`, key).Scan(&result)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			slog.Warn("Could not read cached result", "error", err)
+		}
+		return nil, false
+	}
+	return result, true
+}
+
+// put queues a result for writing
+func (d *disk) put(key string, result json.RawMessage) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if d.closed {
+		return
+	}
+	select {
+	case d.writes <- diskWrite{key, result}:
+	default:
+	}
+}
+
+// writeLoop writes the queued results until the queue is closed and prunes
+// the oldest results beyond the maximum
+func (d *disk) writeLoop() {
+	defer close(d.done)
+	written := 0
+	for w := range d.writes {
+		if _, err := d.db.Exec(`INSERT OR REPLACE INTO results (key, result) VALUES (?, ?)`, w.key, []byte(w.result)); err != nil {
+			slog.Warn("Could not write cached result", "error", err)
+			continue
+		}
+		if written++; written%diskPruneInterval == 0 {
+			d.prune()
+		}
+	}
+}
+
+// prune deletes the oldest results beyond the maximum
+func (d *disk) prune() {
+	_, err := d.db.Exec(`DELETE FROM results WHERE rowid <= (SELECT MAX(rowid) FROM results) - ?`, d.maxEntries)
+	if err != nil {
+		slog.Warn("Could not prune cached results", "error", err)
+	}
+}
+
+// close writes the queued results and closes the database
+func (d *disk) close() error {
+	d.mu.Lock()
+	d.closed = true
+	close(d.writes)
+	d.mu.Unlock()
+
+	<-d.done
+	d.prune()
+	return d.db.Close()
+}
@@ -1,70 +1,404 @@
 package config
 
 import (
+	"errors"
+	"flag"
 	"fmt"
+	"log/slog"
 	"os"
+	"strings"
+	"time"
+
+	"github.com/ddomeke/rpc_proxy/pkg/utils"
 )
 
 // Config holds all the configuration settings for the application
 type Config struct {
 	// Ethereum RPC URLs
-	L1RPCURL    string
-	L1RPCURLWs  string
-	L2RPCURL    string
-	ProxyPort   string
-	MetricsPort string
+	L1RPCURL    string `yaml:"l1_rpc_url" toml:"l1_rpc_url"`
+	L1RPCURLWs  string `yaml:"l1_rpc_url_ws" toml:"l1_rpc_url_ws"`
+	L2RPCURL    string `yaml:"l2_rpc_url" toml:"l2_rpc_url"`
+	ProxyPort   string `yaml:"proxy_port" toml:"proxy_port"`
+	ProxyWsPort string `yaml:"proxy_ws_port" toml:"proxy_ws_port"`
+	MetricsPort string `yaml:"metrics_port" toml:"metrics_port"`
+
+	// Time to drain in-flight requests and stop the monitors on shutdown
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" toml:"shutdown_timeout"`
+
+	// Upstream node lists per chain, in priority order. After loading, the
+	// first entry always equals L1RPCURL / L2RPCURL.
+	L1RPCURLs []string       `yaml:"l1_rpc_urls" toml:"l1_rpc_urls"`
+	L2RPCURLs []string       `yaml:"l2_rpc_urls" toml:"l2_rpc_urls"`
+	Upstream  UpstreamConfig `yaml:"upstream" toml:"upstream"`
+
+	// Proxy routes (/l1, /l2 and /chain/{chainId})
+	Routes []RouteConfig `yaml:"routes" toml:"routes"`
+
+	// Handling of proxy requests
+	Requests RequestConfig `yaml:"requests" toml:"requests"`
+
+	// API keys and quotas of proxy clients
+	Auth AuthConfig `yaml:"auth" toml:"auth"`
+
+	// Rate limits of proxy clients
+	RateLimit RateLimitConfig `yaml:"rate_limit" toml:"rate_limit"`
+
+	// Cache of upstream responses
+	Cache CacheConfig `yaml:"cache" toml:"cache"`
 
 	// Contract addresses
-	FrozenContractAddress string
-	OptimismPortalAddress string
+	FrozenContractAddress string `yaml:"frozen_contract_address" toml:"frozen_contract_address"`
+	OptimismPortalAddress string `yaml:"optimism_portal_address" toml:"optimism_portal_address"`
+
+	// Frozen accounts cache
+	Frozen FrozenConfig `yaml:"frozen" toml:"frozen"`
+
+	// Deposit store
+	Store StoreConfig `yaml:"store" toml:"store"`
+
+	// L1 deposit monitor
+	Monitor MonitorConfig `yaml:"monitor" toml:"monitor"`
+
+	// Logging
+	Log LogConfig `yaml:"log" toml:"log"`
+
+	// Prometheus metrics
+	Metrics MetricsConfig `yaml:"metrics" toml:"metrics"`
+
+	// Admin API on the metrics port
+	Admin AdminConfig `yaml:"admin" toml:"admin"`
 }
 
-// LoadConfig loads configuration from environment variables
-func LoadConfig() (*Config, error) {
-	l1RPC := os.Getenv("L1_RPC_URL")
-	if l1RPC == "" {
-		return nil, fmt.Errorf("L1_RPC_URL is not set")
-	}
+// AdminConfig holds the admin API settings
+type AdminConfig struct {
+	Token string `yaml:"token" toml:"token" secret:"true"` // Bearer token required by /admin endpoints, empty disables the deposit API
+}
+
+// MetricsConfig holds the Prometheus metrics settings
+type MetricsConfig struct {
+	AccountLabels    string   `yaml:"account_labels" toml:"account_labels"`       // full (one label per account) or bounded (watchlist, top-N and "other")
+	AccountWatchlist []string `yaml:"account_watchlist" toml:"account_watchlist"` // Accounts that always get their own label in bounded mode
+	AccountTopN      int      `yaml:"account_top_n" toml:"account_top_n"`         // Accounts with the highest deposit volume labeled in bounded mode
+}
+
+// LogConfig holds the logger settings
+type LogConfig struct {
+	Level  slog.Level `yaml:"level" toml:"level"`   // Minimum level that is logged
+	Format string     `yaml:"format" toml:"format"` // text or json
+	File   string     `yaml:"file" toml:"file"`     // Log file written in addition to stdout, empty for stdout only
+}
+
+// MonitorConfig holds the L1 deposit monitor settings
+type MonitorConfig struct {
+	ConfirmationDepth uint64 `yaml:"confirmation_depth" toml:"confirmation_depth"`   // Blocks a deposit must be buried under before it is processed
+	ReorgWindow       uint64 `yaml:"reorg_window" toml:"reorg_window"`               // Recent L1 blocks re-checked for reorgs when the listener (re)starts
+	StartBlock        uint64 `yaml:"start_block" toml:"start_block"`                 // First L1 block to scan when there is no checkpoint yet, 0 for the current head
+	BackfillChunkSize uint64 `yaml:"backfill_chunk_size" toml:"backfill_chunk_size"` // Maximum block range of a single eth_getLogs call during backfill
+
+	IngestMode    string        `yaml:"ingest_mode" toml:"ingest_mode"`         // auto, ws, poll (eth_getLogs) or filter (eth_newFilter/eth_getFilterChanges)
+	PollInterval  time.Duration `yaml:"poll_interval" toml:"poll_interval"`     // Interval between L1 polls when not subscribed over websocket
+	WSMaxFailures int           `yaml:"ws_max_failures" toml:"ws_max_failures"` // Consecutive websocket failures before auto mode falls back to polling
+}
 
-	l1RPCWs := os.Getenv("L1_RPC_URL_WS")
-	if l1RPCWs == "" {
-		return nil, fmt.Errorf("L1_RPC_URL_WS is not set")
+// StoreConfig holds the deposit store settings
+type StoreConfig struct {
+	Backend string `yaml:"backend" toml:"backend"` // sqlite or memory
+	Path    string `yaml:"path" toml:"path"`       // SQLite database file
+}
+
+// FrozenConfig holds the settings of the in-memory frozen accounts set
+type FrozenConfig struct {
+	StartBlock   uint64        `yaml:"start_block" toml:"start_block"`     // L1 block from which AccountFrozen events are replayed
+	SyncInterval time.Duration `yaml:"sync_interval" toml:"sync_interval"` // Interval between event catch-ups
+}
+
+// UpstreamConfig holds the upstream pool settings
+type UpstreamConfig struct {
+	Strategy       string        `yaml:"strategy" toml:"strategy"`               // round-robin, least-latency or priority
+	HealthInterval time.Duration `yaml:"health_interval" toml:"health_interval"` // Interval between health probes
+	MaxBlockLag    uint64        `yaml:"max_block_lag" toml:"max_block_lag"`     // Maximum number of blocks a node may lag behind the head
+	MaxRetries     int           `yaml:"max_retries" toml:"max_retries"`         // Number of retries on another node for idempotent methods
+	Coalesce       bool          `yaml:"coalesce" toml:"coalesce"`               // Send identical concurrent idempotent requests upstream once
+}
+
+// RequestConfig holds the limits of proxy requests
+type RequestConfig struct {
+	BatchConcurrency int `yaml:"batch_concurrency" toml:"batch_concurrency"` // Calls of a batch request processed at the same time
+}
+
+// AuthConfig holds the API key settings of the proxy
+type AuthConfig struct {
+	Required            bool           `yaml:"required" toml:"required"`                           // Reject proxy requests without a valid API key
+	Keys                []APIKeyConfig `yaml:"keys" toml:"keys"`                                   // Keys in addition to the ones in the store
+	ComputeUnits        map[string]int `yaml:"compute_units" toml:"compute_units"`                 // Compute units per method, overriding the built-in costs
+	DefaultComputeUnits int            `yaml:"default_compute_units" toml:"default_compute_units"` // Compute units of methods without a cost
+}
+
+// APIKeyConfig holds an API key with its permissions and quotas. Zero quotas are unlimited.
+type APIKeyConfig struct {
+	Name              string   `yaml:"name" toml:"name"` // Key name in logs and metrics
+	Key               string   `yaml:"key" toml:"key" secret:"true"`
+	Routes            []string `yaml:"routes" toml:"routes"`                           // Routes the key may use, all if empty
+	Methods           []string `yaml:"methods" toml:"methods"`                         // Methods the key may call, all the route allows if empty
+	RPS               float64  `yaml:"rps" toml:"rps"`                                 // Sustained JSON-RPC calls per second
+	Burst             int      `yaml:"burst" toml:"burst"`                             // Calls allowed at once, default: rps rounded up
+	DailyRequests     int64    `yaml:"daily_requests" toml:"daily_requests"`           // JSON-RPC calls per UTC day
+	DailyComputeUnits int64    `yaml:"daily_compute_units" toml:"daily_compute_units"` // Compute units per UTC day
+}
+
+// RateLimitConfig holds the token-bucket rate limits of the proxy. Calls are
+// limited per client IP and per API key, with separate budgets for cheap
+// calls and heavy scans.
+type RateLimitConfig struct {
+	Backend        string        `yaml:"backend" toml:"backend"`                 // memory or redis (shared between replicas)
+	RedisURL       string        `yaml:"redis_url" toml:"redis_url"`             // redis://[user:password@]host:port/db
+	RedisTimeout   time.Duration `yaml:"redis_timeout" toml:"redis_timeout"`     // Time after which a call is limited in memory instead
+	HeavyMethods   []string      `yaml:"heavy_methods" toml:"heavy_methods"`     // Methods limited with the heavy budget
+	TrustedProxies []string      `yaml:"trusted_proxies" toml:"trusted_proxies"` // CIDRs whose X-Forwarded-For header gives the client IP
+	PerIP          ClassLimits   `yaml:"per_ip" toml:"per_ip"`
+	PerKey         ClassLimits   `yaml:"per_key" toml:"per_key"`
+}
+
+// ClassLimits holds the rate limits of the method classes
+type ClassLimits struct {
+	Cheap RateLimit `yaml:"cheap" toml:"cheap"` // All methods that are not heavy
+	Heavy RateLimit `yaml:"heavy" toml:"heavy"`
+}
+
+// RateLimit is a token bucket. A zero rate is unlimited.
+type RateLimit struct {
+	RPS   float64 `yaml:"rps" toml:"rps"`     // Sustained calls per second
+	Burst int     `yaml:"burst" toml:"burst"` // Calls allowed at once, default: rps rounded up
+}
+
+// CacheConfig holds the cache of upstream JSON-RPC results. Results of
+// finalized blocks and of block hashes never change and are kept until they
+// are evicted, results of recent blocks until they expire or are reorged.
+type CacheConfig struct {
+	Enabled        bool          `yaml:"enabled" toml:"enabled"`
+	MaxMemoryMB    int           `yaml:"max_memory_mb" toml:"max_memory_mb"`       // Size of the in-memory LRU
+	LatestTTL      time.Duration `yaml:"latest_ttl" toml:"latest_ttl"`             // Results of the latest, safe and finalized tags, 0 does not cache them
+	RecentTTL      time.Duration `yaml:"recent_ttl" toml:"recent_ttl"`             // Results of blocks that are not finalized yet, 0 does not cache them
+	HeadInterval   time.Duration `yaml:"head_interval" toml:"head_interval"`       // Interval between head checks that detect reorgs
+	Path           string        `yaml:"path" toml:"path"`                         // SQLite file that keeps immutable results across restarts, optional
+	DiskMaxEntries int           `yaml:"disk_max_entries" toml:"disk_max_entries"` // Results kept on disk, the oldest are pruned
+}
+
+// RouteConfig holds the method policies and frozen-account filtering rules of a proxy route
+type RouteConfig struct {
+	Name           string      `yaml:"name" toml:"name"`                               // Route name, also the URL path (/l1, /l2)
+	Upstream       string      `yaml:"upstream" toml:"upstream"`                       // Upstream pool the route forwards to: L1 or L2
+	AllowedMethods []string    `yaml:"allowed_methods" toml:"allowed_methods"`         // If set, only these methods are forwarded, names or globs such as eth_*
+	DeniedMethods  []string    `yaml:"denied_methods" toml:"denied_methods"`           // Methods that are always rejected, names or globs such as debug_*
+	FilterDeposits bool        `yaml:"filter_deposits" toml:"filter_deposits"`         // Drop TransactionDeposited logs sent by frozen accounts
+	ScreenTxs      bool        `yaml:"screen_transactions" toml:"screen_transactions"` // Reject raw transactions from or to frozen accounts
+	Guards         GuardConfig `yaml:"guards" toml:"guards"`                           // Parameter limits of expensive calls
+}
+
+// GuardConfig limits the parameters of expensive calls on a route. A limit of
+// 0 is unlimited.
+type GuardConfig struct {
+	MaxLogsBlockRange uint64 `yaml:"max_logs_block_range" toml:"max_logs_block_range"` // Blocks an eth_getLogs or eth_newFilter call may span
+	MaxLogsAddresses  int    `yaml:"max_logs_addresses" toml:"max_logs_addresses"`     // Addresses of a log filter
+	MaxLogsTopics     int    `yaml:"max_logs_topics" toml:"max_logs_topics"`           // Topic values of a log filter, across all positions
+	MaxCallGas        uint64 `yaml:"max_call_gas" toml:"max_call_gas"`                 // Gas an eth_call or eth_estimateGas call may request
+}
+
+// DefaultDeniedMethods are the node administration, debugging and signing
+// methods the default routes reject. Public nodes usually do not expose them,
+// but a proxy in front of a private node must not forward them. debug_*
+// includes the traces, which can also change node state (debug_setHead) or
+// take the node down with expensive calls.
+var DefaultDeniedMethods = []string{
+	"admin_*",
+	"debug_*",
+	"personal_*",
+	"miner_*",
+	"eth_sendTransaction",
+	"eth_sign",
+	"eth_signTransaction",
+	"eth_signTypedData*",
+}
+
+// Default returns the configuration used for every setting that is not
+// given in the config file, the environment or on the command line
+func Default() *Config {
+	return &Config{
+		ProxyPort:   "8545",
+		ProxyWsPort: "8546",
+		MetricsPort: "9100",
+		// Below the default Kubernetes termination grace period of 30s
+		ShutdownTimeout: 20 * time.Second,
+		Upstream: UpstreamConfig{
+			Strategy:       "priority",
+			HealthInterval: 10 * time.Second,
+			MaxBlockLag:    10,
+			MaxRetries:     2,
+			Coalesce:       true,
+		},
+		// TransactionDeposited is an L1 event, so only L1 filters deposits by default.
+		// Frozen accounts are kept from transacting on L2 by default.
+		Routes: []RouteConfig{
+			{Name: "l1", Upstream: "L1", DeniedMethods: DefaultDeniedMethods, FilterDeposits: true},
+			{Name: "l2", Upstream: "L2", DeniedMethods: DefaultDeniedMethods, ScreenTxs: true},
+		},
+		Requests: RequestConfig{
+			BatchConcurrency: 16,
+		},
+		Auth: AuthConfig{
+			DefaultComputeUnits: 20,
+		},
+		RateLimit: RateLimitConfig{
+			Backend:      "memory",
+			RedisTimeout: 50 * time.Millisecond,
+			HeavyMethods: []string{
+				"eth_getLogs",
+				"eth_getFilterLogs",
+				"eth_getBlockReceipts",
+				"eth_newFilter",
+				"debug_traceTransaction",
+				"debug_traceCall",
+				"debug_traceBlockByNumber",
+				"debug_traceBlockByHash",
+				"trace_block",
+				"trace_filter",
+				"trace_replayBlockTransactions",
+			},
+		},
+		Cache: CacheConfig{
+			MaxMemoryMB:    64,
+			RecentTTL:      time.Minute,
+			HeadInterval:   2 * time.Second,
+			DiskMaxEntries: 1000000,
+		},
+		Frozen: FrozenConfig{
+			SyncInterval: 15 * time.Second,
+		},
+		Store: StoreConfig{
+			Backend: "sqlite",
+			Path:    "deposits.db",
+		},
+		Monitor: MonitorConfig{
+			ReorgWindow:       64,
+			BackfillChunkSize: 1000,
+			IngestMode:        "auto",
+			PollInterval:      12 * time.Second,
+			WSMaxFailures:     3,
+		},
+		Log: LogConfig{
+			Level:  slog.LevelInfo,
+			Format: "text",
+			File:   "proxy.log",
+		},
+		Metrics: MetricsConfig{
+			AccountLabels: "full",
+			AccountTopN:   20,
+		},
 	}
+}
 
-	l2RPC := os.Getenv("L2_RPC_URL")
-	if l2RPC == "" {
-		return nil, fmt.Errorf("L2_RPC_URL is not set")
+// Load builds the configuration from, in increasing order of precedence, the
+// defaults, the config file, environment variables and command-line flags.
+// All invalid settings are reported together in the returned error.
+func Load(args []string) (*Config, error) {
+	flags := flag.NewFlagSet("rpc_proxy", flag.ContinueOnError)
+	configFile := flags.String("config", os.Getenv("CONFIG_FILE"), "YAML (.yaml, .yml) or TOML (.toml) config file")
+	envFile := flags.String("env-file", "", "`.env` file to load into the environment (default: .env if it exists)")
+	l1RPCURL := flags.String("l1-rpc-url", "", "Ethereum L1 RPC URL")
+	l1RPCURLWs := flags.String("l1-rpc-url-ws", "", "Ethereum L1 websocket URL")
+	l2RPCURL := flags.String("l2-rpc-url", "", "OP Stack L2 RPC URL")
+	proxyPort := flags.String("proxy-port", "", "Port of the RPC proxy")
+	proxyWsPort := flags.String("proxy-ws-port", "", "Port of the websocket RPC proxy")
+	metricsPort := flags.String("metrics-port", "", "Port of the Prometheus metrics server")
+	storePath := flags.String("store-path", "", "SQLite database file of the deposit store")
+	logLevel := flags.String("log-level", "", "Minimum log level: debug, info, warn or error")
+	logFormat := flags.String("log-format", "", "Log output format: text or json")
+	if err := flags.Parse(args); err != nil {
+		return nil, err
 	}
 
-	frozenContract := os.Getenv("FROZEN_CONTRACT_ADDRESS")
-	if frozenContract == "" {
-		return nil, fmt.Errorf("FROZEN_CONTRACT_ADDRESS is not set")
+	// Unlike the default .env, an explicitly given file must exist
+	if *envFile != "" {
+		if err := utils.LoadEnvFile(*envFile); err != nil {
+			return nil, err
+		}
+	} else if _, err := os.Stat(".env"); err == nil {
+		if err := utils.LoadEnvFile(".env"); err != nil {
+			return nil, err
+		}
 	}
 
-	portalAddress := os.Getenv("OPTIMISM_PORTAL_ADDRESS")
-	if portalAddress == "" {
-		return nil, fmt.Errorf("OPTIMISM_PORTAL_ADDRESS is not set")
+	cfg := Default()
+	if *configFile != "" {
+		if err := loadFile(*configFile, cfg); err != nil {
+			return nil, err
+		}
 	}
 
-	proxyPort := os.Getenv("PROXY_PORT")
-	if proxyPort == "" {
-		proxyPort = "8545" // Default port
-		fmt.Println("[WARN] PROXY_PORT not set, using default: 8545")
+	errs := applyEnv(cfg)
+
+	// Only flags given on the command line override the other sources
+	flags.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "l1-rpc-url":
+			cfg.L1RPCURL = *l1RPCURL
+		case "l1-rpc-url-ws":
+			cfg.L1RPCURLWs = *l1RPCURLWs
+		case "l2-rpc-url":
+			cfg.L2RPCURL = *l2RPCURL
+		case "proxy-port":
+			cfg.ProxyPort = *proxyPort
+		case "proxy-ws-port":
+			cfg.ProxyWsPort = *proxyWsPort
+		case "metrics-port":
+			cfg.MetricsPort = *metricsPort
+		case "store-path":
+			cfg.Store.Path = *storePath
+		case "log-level":
+			if err := cfg.Log.Level.UnmarshalText([]byte(*logLevel)); err != nil {
+				errs = append(errs, fmt.Errorf("invalid -log-level: %q", *logLevel))
+			}
+		case "log-format":
+			cfg.Log.Format = *logFormat
+		}
+	})
+
+	// The primary URL always leads the upstream list
+	cfg.L1RPCURLs = urlList(cfg.L1RPCURL, cfg.L1RPCURLs)
+	cfg.L2RPCURLs = urlList(cfg.L2RPCURL, cfg.L2RPCURLs)
+
+	errs = append(errs, cfg.Validate()...)
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("invalid configuration:\n%w", errors.Join(errs...))
 	}
+	return cfg, nil
+}
 
-	metricsPort := os.Getenv("METRICS_PORT")
-	if metricsPort == "" {
-		metricsPort = "9100" // Default port
-		fmt.Println("[WARN] METRICS_PORT not set, using default: 9100")
+// splitList splits a comma-separated list, dropping empty entries
+func splitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
 	}
+	return items
+}
 
-	return &Config{
-		L1RPCURL:             l1RPC,
-		L1RPCURLWs:           l1RPCWs,
-		L2RPCURL:             l2RPC,
-		ProxyPort:            proxyPort,
-		MetricsPort:          metricsPort,
-		FrozenContractAddress: frozenContract,
-		OptimismPortalAddress: portalAddress,
-	}, nil
+// urlList builds an upstream list from the primary URL and a list of
+// additional URLs, dropping duplicates
+func urlList(primary string, extra []string) []string {
+	var urls []string
+	if primary != "" {
+		urls = append(urls, primary)
+	}
+	seen := map[string]bool{primary: true}
+	for _, u := range extra {
+		if seen[u] {
+			continue
+		}
+		seen[u] = true
+		urls = append(urls, u)
+	}
+	return urls
 }
@@ -0,0 +1,143 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testYAML = `
+l1_rpc_url: ${L1_NODE}
+l2_rpc_url: ${L2_NODE:-http://l2.example:8545}
+l1_rpc_urls: [http://l1-backup.example:8545]
+proxy_port: "8000"
+frozen_contract_address: "0x00000000000000000000000000000000000000f0"
+optimism_portal_address: "0xbEb5Fc579115071764c7423A4f12eDde41f106Ed"
+upstream:
+  strategy: priority
+routes:
+  - name: l1
+    upstream: L1
+    denied_methods: ["admin_*"]
+`
+
+const testTOML = `
+l1_rpc_url = "${L1_NODE}"
+l2_rpc_url = "${L2_NODE:-http://l2.example:8545}"
+l1_rpc_urls = ["http://l1-backup.example:8545"]
+proxy_port = "8000"
+frozen_contract_address = "0x00000000000000000000000000000000000000f0"
+optimism_portal_address = "0xbEb5Fc579115071764c7423A4f12eDde41f106Ed"
+
+[upstream]
+strategy = "priority"
+
+[[routes]]
+name = "l1"
+upstream = "L1"
+denied_methods = ["admin_*"]
+`
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name     string
+		file     string // Config file name
+		content  string
+		env      map[string]string
+		args     []string
+		wantPort string
+		wantErrs []string // Parts of the error, which must list every problem
+	}{
+		{name: "yaml", file: "config.yaml", content: testYAML, wantPort: "8000"},
+		{name: "toml", file: "config.toml", content: testTOML, wantPort: "8000"},
+		{name: "env_over_file", file: "config.yaml", content: testYAML, env: map[string]string{"PROXY_PORT": "9000"}, wantPort: "9000"},
+		{name: "flag_over_env", file: "config.yaml", content: testYAML, env: map[string]string{"PROXY_PORT": "9000"}, args: []string{"-proxy-port", "9100"}, wantPort: "9100"},
+		{
+			name: "unknown_key", file: "config.yaml", content: testYAML + "proxy_prot: \"8000\"\n",
+			wantErrs: []string{"proxy_prot"},
+		},
+		{
+			name: "unknown_toml_key", file: "config.toml", content: "proxy_prot = \"8000\"\n" + testTOML,
+			wantErrs: []string{"unknown keys: proxy_prot"},
+		},
+		{
+			name: "unset_variable", file: "config.yaml", content: testYAML, env: map[string]string{"L1_NODE": ""},
+			wantErrs: []string{"environment variable L1_NODE is not set"},
+		},
+		{
+			name: "unsupported_format", file: "config.json", content: "{}",
+			wantErrs: []string{"unsupported format"},
+		},
+		{
+			name: "every_error", file: "config.yaml", content: testYAML,
+			env: map[string]string{
+				"FROZEN_CONTRACT_ADDRESS": "0x00000000000000000000000000000000000000F0aB",
+				"OPTIMISM_PORTAL_ADDRESS": "0xbeB5Fc579115071764c7423A4f12eDde41f106Ed",
+			},
+			args: []string{"-l2-rpc-url", "ftp://l2.example", "-metrics-port", "70000"},
+			wantErrs: []string{
+				`frozen_contract_address: "0x00000000000000000000000000000000000000F0aB" is not an address`,
+				"optimism_portal_address: \"0xbeB5Fc579115071764c7423A4f12eDde41f106Ed\" has an invalid checksum",
+				`l2_rpc_urls: URL "ftp://l2.example" must use http or https`,
+				`metrics_port must be a port between 1 and 65535, got "70000"`,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			// Load reads .env from the working directory
+			wd, _ := os.Getwd()
+			os.Chdir(dir)
+			defer os.Chdir(wd)
+
+			t.Setenv("L1_NODE", "http://l1.example:8545")
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+			path := filepath.Join(dir, tt.file)
+			if err := os.WriteFile(path, []byte(tt.content), 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			cfg, err := Load(append([]string{"-config", path}, tt.args...))
+			if len(tt.wantErrs) > 0 {
+				if err == nil {
+					t.Fatal("Load() succeeded, want an error")
+				}
+				for _, want := range tt.wantErrs {
+					if !strings.Contains(err.Error(), want) {
+						t.Errorf("error %q does not contain %q", err, want)
+					}
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Load() = %v", err)
+			}
+
+			if cfg.ProxyPort != tt.wantPort {
+				t.Errorf("proxy port = %s, want %s", cfg.ProxyPort, tt.wantPort)
+			}
+			wantURLs := []string{"http://l1.example:8545", "http://l1-backup.example:8545"}
+			if strings.Join(cfg.L1RPCURLs, ",") != strings.Join(wantURLs, ",") {
+				t.Errorf("L1 URLs = %v, want %v", cfg.L1RPCURLs, wantURLs)
+			}
+			if cfg.L2RPCURL != "http://l2.example:8545" {
+				t.Errorf("L2 URL = %s, want the default of the reference", cfg.L2RPCURL)
+			}
+			if cfg.Upstream.Strategy != "priority" {
+				t.Errorf("strategy = %s, want priority", cfg.Upstream.Strategy)
+			}
+			if len(cfg.Routes) != 1 || cfg.Routes[0].DeniedMethods[0] != "admin_*" {
+				t.Errorf("routes = %+v, want the l1 route of the file", cfg.Routes)
+			}
+			// Settings missing from the file keep their default
+			if cfg.MetricsPort != Default().MetricsPort {
+				t.Errorf("metrics port = %s, want the default %s", cfg.MetricsPort, Default().MetricsPort)
+			}
+		})
+	}
+}
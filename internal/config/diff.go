@@ -0,0 +1,97 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"strings"
+)
+
+// Change is a setting that differs between two configurations
+type Change struct {
+	Key string `json:"key"` // Config file key, e.g. upstream.strategy or routes[1].denied_methods
+	Old string `json:"old"`
+	New string `json:"new"`
+}
+
+// Diff returns the settings that differ between two configurations. URLs are
+// reduced to scheme and host so that API keys in them are not logged, and
+// fields tagged secret:"true" are only reported as changed.
+func Diff(old, new *Config) []Change {
+	var changes []Change
+	diffValue("", reflect.ValueOf(*old), reflect.ValueOf(*new), &changes)
+	return changes
+}
+
+// redacted replaces the value of secret settings in changes
+const redacted = "<redacted>"
+
+// diffValue compares two values of the same type, descending into structs and
+// into slices of structs of equal length
+func diffValue(key string, old, new reflect.Value, changes *[]Change) {
+	switch {
+	case old.Kind() == reflect.Struct:
+		for i := 0; i < old.NumField(); i++ {
+			field := old.Type().Field(i)
+			name := strings.Split(field.Tag.Get("yaml"), ",")[0]
+			if key != "" {
+				name = key + "." + name
+			}
+			if field.Tag.Get("secret") == "true" {
+				if !old.Field(i).Equal(new.Field(i)) {
+					*changes = append(*changes, Change{Key: name, Old: redacted, New: redacted})
+				}
+				continue
+			}
+			diffValue(name, old.Field(i), new.Field(i), changes)
+		}
+	case old.Kind() == reflect.Slice && old.Len() == 0 && new.Len() == 0:
+		// A missing and an empty list are the same setting
+	case old.Kind() == reflect.Slice && old.Type().Elem().Kind() == reflect.Struct && old.Len() == new.Len():
+		for i := 0; i < old.Len(); i++ {
+			diffValue(fmt.Sprintf("%s[%d]", key, i), old.Index(i), new.Index(i), changes)
+		}
+	case old.Kind() == reflect.Slice && old.Type().Elem().Kind() == reflect.Struct:
+		// Entries may hold secrets, only the number of entries is reported
+		*changes = append(*changes, Change{Key: key, Old: entries(old.Len()), New: entries(new.Len())})
+	case !reflect.DeepEqual(old.Interface(), new.Interface()):
+		*changes = append(*changes, Change{Key: key, Old: formatValue(old), New: formatValue(new)})
+	}
+}
+
+// formatValue formats a setting for logging
+func formatValue(v reflect.Value) string {
+	switch value := v.Interface().(type) {
+	case string:
+		return redactURL(value)
+	case []string:
+		redacted := make([]string, len(value))
+		for i, s := range value {
+			redacted[i] = redactURL(s)
+		}
+		return "[" + strings.Join(redacted, ", ") + "]"
+	default:
+		return fmt.Sprint(value)
+	}
+}
+
+// entries describes the length of a list
+func entries(n int) string {
+	if n == 1 {
+		return "1 entry"
+	}
+	return fmt.Sprintf("%d entries", n)
+}
+
+// redactURL reduces a URL to its scheme and host, provider URLs often carry
+// API keys in the path or query. Other strings are returned as is.
+func redactURL(s string) string {
+	if !strings.Contains(s, "://") {
+		return s
+	}
+	u, err := url.Parse(s)
+	if err != nil || u.Host == "" {
+		return s
+	}
+	return u.Scheme + "://" + u.Host
+}
@@ -0,0 +1,68 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(cfg *Config)
+		want   []Change
+	}{
+		{name: "unchanged", change: func(cfg *Config) {}},
+		{
+			name:   "setting",
+			change: func(cfg *Config) { cfg.Upstream.Strategy = "priority" },
+			want:   []Change{{Key: "upstream.strategy", Old: "round-robin", New: "priority"}},
+		},
+		{
+			// Provider URLs carry API keys in the path
+			name:   "url_redacted",
+			change: func(cfg *Config) { cfg.L1RPCURL = "https://mainnet.example/v3/secret-key" },
+			want:   []Change{{Key: "l1_rpc_url", Old: "https://l1.example", New: "https://mainnet.example"}},
+		},
+		{
+			name:   "secret",
+			change: func(cfg *Config) { cfg.Admin.Token = "another-admin-token" },
+			want:   []Change{{Key: "admin.token", Old: redacted, New: redacted}},
+		},
+		{
+			name:   "route_setting",
+			change: func(cfg *Config) { cfg.Routes[0].DeniedMethods = []string{"debug_*"} },
+			want:   []Change{{Key: "routes[0].denied_methods", Old: "[admin_*]", New: "[debug_*]"}},
+		},
+		{
+			// Keys are secret, only their number is reported
+			name:   "keys_added",
+			change: func(cfg *Config) { cfg.Auth.Keys = append(cfg.Auth.Keys, APIKeyConfig{Name: "bot", Key: "bot-secret-key-123"}) },
+			want:   []Change{{Key: "auth.keys", Old: "1 entry", New: "2 entries"}},
+		},
+		{
+			name:   "empty_list",
+			change: func(cfg *Config) { cfg.Metrics.AccountWatchlist = []string{} },
+		},
+	}
+
+	// base is the configuration every case starts from
+	base := func() *Config {
+		cfg := Default()
+		cfg.L1RPCURL = "https://l1.example/v3/old-key"
+		cfg.Upstream.Strategy = "round-robin"
+		cfg.Routes = []RouteConfig{{Name: "l1", Upstream: "L1", DeniedMethods: []string{"admin_*"}}}
+		cfg.Auth.Keys = []APIKeyConfig{{Name: "indexer", Key: "indexer-secret-key"}}
+		cfg.Admin.Token = "first-admin-token"
+		return cfg
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			old, new := base(), base()
+			tt.change(new)
+			if got := Diff(old, new); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Diff() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,199 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// envLoader applies environment variables to the configuration and collects
+// the values that could not be parsed
+type envLoader struct {
+	errs []error
+}
+
+// applyEnv overrides the configuration with the environment variables that
+// are set and returns every value that could not be parsed
+func applyEnv(cfg *Config) []error {
+	e := &envLoader{}
+
+	e.str("L1_RPC_URL", &cfg.L1RPCURL)
+	e.str("L1_RPC_URL_WS", &cfg.L1RPCURLWs)
+	e.str("L2_RPC_URL", &cfg.L2RPCURL)
+	e.list("L1_RPC_URLS", &cfg.L1RPCURLs)
+	e.list("L2_RPC_URLS", &cfg.L2RPCURLs)
+	e.str("PROXY_PORT", &cfg.ProxyPort)
+	e.str("PROXY_WS_PORT", &cfg.ProxyWsPort)
+	e.str("METRICS_PORT", &cfg.MetricsPort)
+	e.duration("SHUTDOWN_TIMEOUT", &cfg.ShutdownTimeout)
+	e.str("FROZEN_CONTRACT_ADDRESS", &cfg.FrozenContractAddress)
+	e.str("OPTIMISM_PORTAL_ADDRESS", &cfg.OptimismPortalAddress)
+
+	e.str("UPSTREAM_STRATEGY", &cfg.Upstream.Strategy)
+	e.duration("UPSTREAM_HEALTH_INTERVAL", &cfg.Upstream.HealthInterval)
+	e.uint64("UPSTREAM_MAX_BLOCK_LAG", &cfg.Upstream.MaxBlockLag)
+	e.int("UPSTREAM_MAX_RETRIES", &cfg.Upstream.MaxRetries)
+	e.bool("UPSTREAM_COALESCE", &cfg.Upstream.Coalesce)
+
+	// Route settings are prefixed with the upper-case route name (e.g. L1_DENIED_METHODS)
+	for i := range cfg.Routes {
+		route := &cfg.Routes[i]
+		prefix := strings.ToUpper(route.Name)
+		e.list(prefix+"_ALLOWED_METHODS", &route.AllowedMethods)
+		e.list(prefix+"_DENIED_METHODS", &route.DeniedMethods)
+		e.bool(prefix+"_FILTER_FROZEN_DEPOSITS", &route.FilterDeposits)
+		e.bool(prefix+"_SCREEN_TRANSACTIONS", &route.ScreenTxs)
+		e.uint64(prefix+"_MAX_LOGS_BLOCK_RANGE", &route.Guards.MaxLogsBlockRange)
+		e.int(prefix+"_MAX_LOGS_ADDRESSES", &route.Guards.MaxLogsAddresses)
+		e.int(prefix+"_MAX_LOGS_TOPICS", &route.Guards.MaxLogsTopics)
+		e.uint64(prefix+"_MAX_CALL_GAS", &route.Guards.MaxCallGas)
+	}
+
+	e.int("BATCH_CONCURRENCY", &cfg.Requests.BatchConcurrency)
+
+	e.bool("AUTH_REQUIRED", &cfg.Auth.Required)
+	e.apiKeys("API_KEYS", &cfg.Auth.Keys)
+
+	e.str("RATE_LIMIT_BACKEND", &cfg.RateLimit.Backend)
+	e.str("RATE_LIMIT_REDIS_URL", &cfg.RateLimit.RedisURL)
+	e.list("RATE_LIMIT_HEAVY_METHODS", &cfg.RateLimit.HeavyMethods)
+	e.list("RATE_LIMIT_TRUSTED_PROXIES", &cfg.RateLimit.TrustedProxies)
+	e.float64("RATE_LIMIT_IP_RPS", &cfg.RateLimit.PerIP.Cheap.RPS)
+	e.float64("RATE_LIMIT_IP_HEAVY_RPS", &cfg.RateLimit.PerIP.Heavy.RPS)
+	e.float64("RATE_LIMIT_KEY_RPS", &cfg.RateLimit.PerKey.Cheap.RPS)
+	e.float64("RATE_LIMIT_KEY_HEAVY_RPS", &cfg.RateLimit.PerKey.Heavy.RPS)
+
+	e.bool("CACHE_ENABLED", &cfg.Cache.Enabled)
+	e.int("CACHE_MAX_MEMORY_MB", &cfg.Cache.MaxMemoryMB)
+	e.duration("CACHE_LATEST_TTL", &cfg.Cache.LatestTTL)
+	e.duration("CACHE_RECENT_TTL", &cfg.Cache.RecentTTL)
+	e.str("CACHE_PATH", &cfg.Cache.Path)
+
+	e.uint64("FROZEN_START_BLOCK", &cfg.Frozen.StartBlock)
+	e.duration("FROZEN_SYNC_INTERVAL", &cfg.Frozen.SyncInterval)
+
+	e.str("DEPOSIT_STORE", &cfg.Store.Backend)
+	e.str("DEPOSIT_DB_PATH", &cfg.Store.Path)
+
+	e.uint64("L1_CONFIRMATION_DEPTH", &cfg.Monitor.ConfirmationDepth)
+	e.uint64("L1_REORG_WINDOW", &cfg.Monitor.ReorgWindow)
+	e.uint64("L1_START_BLOCK", &cfg.Monitor.StartBlock)
+	e.uint64("L1_BACKFILL_CHUNK_SIZE", &cfg.Monitor.BackfillChunkSize)
+	e.str("L1_INGEST_MODE", &cfg.Monitor.IngestMode)
+	e.duration("L1_POLL_INTERVAL", &cfg.Monitor.PollInterval)
+	e.int("L1_WS_MAX_FAILURES", &cfg.Monitor.WSMaxFailures)
+
+	if level := os.Getenv("LOG_LEVEL"); level != "" {
+		if err := cfg.Log.Level.UnmarshalText([]byte(level)); err != nil {
+			e.errs = append(e.errs, fmt.Errorf("invalid LOG_LEVEL: %q", level))
+		}
+	}
+	e.str("LOG_FORMAT", &cfg.Log.Format)
+	// An explicitly empty LOG_FILE disables the log file
+	if file, ok := os.LookupEnv("LOG_FILE"); ok {
+		cfg.Log.File = file
+	}
+
+	e.str("METRICS_ACCOUNT_LABELS", &cfg.Metrics.AccountLabels)
+	e.list("METRICS_ACCOUNT_WATCHLIST", &cfg.Metrics.AccountWatchlist)
+	e.int("METRICS_ACCOUNT_TOP_N", &cfg.Metrics.AccountTopN)
+
+	e.str("ADMIN_TOKEN", &cfg.Admin.Token)
+
+	return e.errs
+}
+
+// str sets a string from a non-empty environment variable
+func (e *envLoader) str(name string, dst *string) {
+	if value := os.Getenv(name); value != "" {
+		*dst = value
+	}
+}
+
+// list sets a list from a non-empty comma-separated environment variable
+func (e *envLoader) list(name string, dst *[]string) {
+	if value := os.Getenv(name); value != "" {
+		*dst = splitList(value)
+	}
+}
+
+// apiKeys sets unlimited API keys from a comma-separated list of name:key
+// pairs, replacing the keys of the config file
+func (e *envLoader) apiKeys(name string, dst *[]APIKeyConfig) {
+	value := os.Getenv(name)
+	if value == "" {
+		return
+	}
+	var keys []APIKeyConfig
+	for _, item := range splitList(value) {
+		keyName, key, ok := strings.Cut(item, ":")
+		if !ok {
+			e.errs = append(e.errs, fmt.Errorf("invalid %s: entries must be name:key", name))
+			return
+		}
+		keys = append(keys, APIKeyConfig{Name: keyName, Key: key})
+	}
+	*dst = keys
+}
+
+// uint64 sets an unsigned integer from an environment variable
+func (e *envLoader) uint64(name string, dst *uint64) {
+	if value := os.Getenv(name); value != "" {
+		n, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			e.errs = append(e.errs, fmt.Errorf("invalid %s: %q", name, value))
+			return
+		}
+		*dst = n
+	}
+}
+
+// float64 sets a floating-point number from an environment variable
+func (e *envLoader) float64(name string, dst *float64) {
+	if value := os.Getenv(name); value != "" {
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			e.errs = append(e.errs, fmt.Errorf("invalid %s: %q", name, value))
+			return
+		}
+		*dst = f
+	}
+}
+
+// int sets an integer from an environment variable
+func (e *envLoader) int(name string, dst *int) {
+	if value := os.Getenv(name); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			e.errs = append(e.errs, fmt.Errorf("invalid %s: %q", name, value))
+			return
+		}
+		*dst = n
+	}
+}
+
+// duration sets a duration such as "15s" from an environment variable
+func (e *envLoader) duration(name string, dst *time.Duration) {
+	if value := os.Getenv(name); value != "" {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			e.errs = append(e.errs, fmt.Errorf("invalid %s: %q", name, value))
+			return
+		}
+		*dst = d
+	}
+}
+
+// bool sets a boolean from an environment variable
+func (e *envLoader) bool(name string, dst *bool) {
+	if value := os.Getenv(name); value != "" {
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			e.errs = append(e.errs, fmt.Errorf("invalid %s: %q", name, value))
+			return
+		}
+		*dst = b
+	}
+}
@@ -0,0 +1,77 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// envReference matches ${VAR} and ${VAR:-default} in config files
+var envReference = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+// loadFile reads a YAML or TOML config file over the given configuration.
+// Settings missing from the file keep their current value and unknown keys
+// are rejected.
+func loadFile(path string, cfg *Config) error {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("could not read config file: %v", err)
+	}
+
+	data, err := substituteEnv(raw)
+	if err != nil {
+		return fmt.Errorf("config file %s: %w", path, err)
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		if err := dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("config file %s: %v", path, err)
+		}
+	case ".toml":
+		md, err := toml.Decode(string(data), cfg)
+		if err != nil {
+			return fmt.Errorf("config file %s: %v", path, err)
+		}
+		if undecoded := md.Undecoded(); len(undecoded) > 0 {
+			keys := make([]string, len(undecoded))
+			for i, key := range undecoded {
+				keys[i] = key.String()
+			}
+			return fmt.Errorf("config file %s: unknown keys: %s", path, strings.Join(keys, ", "))
+		}
+	default:
+		return fmt.Errorf("config file %s: unsupported format, use .yaml, .yml or .toml", path)
+	}
+	return nil
+}
+
+// substituteEnv replaces ${VAR} references with the value of the environment
+// variable and ${VAR:-default} with the default if the variable is unset or
+// empty. All references to unset variables without a default are reported.
+func substituteEnv(data []byte) ([]byte, error) {
+	var errs []error
+	result := envReference.ReplaceAllFunc(data, func(ref []byte) []byte {
+		match := envReference.FindSubmatch(ref)
+		name, hasDefault := string(match[1]), len(match[2]) > 0
+		if value := os.Getenv(name); value != "" {
+			return []byte(value)
+		}
+		if hasDefault {
+			return match[3]
+		}
+		errs = append(errs, fmt.Errorf("environment variable %s is not set", name))
+		return ref
+	})
+	return result, errors.Join(errs...)
+}
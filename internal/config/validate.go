@@ -0,0 +1,217 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Minimum lengths of secrets
+const (
+	minAdminTokenLength = 16
+	minAPIKeyLength     = 16
+)
+
+// Validate checks the configuration and returns every problem it finds.
+// Settings are named by their config file key.
+func (c *Config) Validate() []error {
+	var errs []error
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	// Upstreams
+	check(c.L1RPCURL != "", "l1_rpc_url (L1_RPC_URL) is not set")
+	check(c.L2RPCURL != "", "l2_rpc_url (L2_RPC_URL) is not set")
+	for _, u := range c.L1RPCURLs {
+		errs = appendErr(errs, validateURL("l1_rpc_urls", u, "http", "https"))
+	}
+	for _, u := range c.L2RPCURLs {
+		errs = appendErr(errs, validateURL("l2_rpc_urls", u, "http", "https"))
+	}
+	if c.L1RPCURLWs != "" {
+		errs = appendErr(errs, validateURL("l1_rpc_url_ws", c.L1RPCURLWs, "ws", "wss"))
+	}
+	check(oneOf(c.Upstream.Strategy, "round-robin", "least-latency", "priority"),
+		"upstream.strategy must be round-robin, least-latency or priority, got %q", c.Upstream.Strategy)
+	check(c.Upstream.HealthInterval > 0, "upstream.health_interval must be positive")
+	check(c.Upstream.MaxRetries >= 0, "upstream.max_retries must not be negative")
+
+	// Ports
+	errs = appendErr(errs, validatePort("proxy_port", c.ProxyPort))
+	errs = appendErr(errs, validatePort("proxy_ws_port", c.ProxyWsPort))
+	errs = appendErr(errs, validatePort("metrics_port", c.MetricsPort))
+	check(c.ShutdownTimeout > 0, "shutdown_timeout must be positive")
+
+	// Contracts
+	check(c.FrozenContractAddress != "", "frozen_contract_address (FROZEN_CONTRACT_ADDRESS) is not set")
+	check(c.OptimismPortalAddress != "", "optimism_portal_address (OPTIMISM_PORTAL_ADDRESS) is not set")
+	if c.FrozenContractAddress != "" {
+		errs = appendErr(errs, validateAddress("frozen_contract_address", c.FrozenContractAddress))
+	}
+	if c.OptimismPortalAddress != "" {
+		errs = appendErr(errs, validateAddress("optimism_portal_address", c.OptimismPortalAddress))
+	}
+
+	// Requests
+	check(c.Requests.BatchConcurrency > 0, "requests.batch_concurrency must be positive")
+
+	// Routes and their policies
+	names := make(map[string]bool)
+	for i, route := range c.Routes {
+		key := fmt.Sprintf("routes[%d]", i)
+		check(route.Name != "" && !strings.Contains(route.Name, "/"), "%s.name must be a non-empty path segment, got %q", key, route.Name)
+		check(!names[route.Name], "%s.name %q is used by more than one route", key, route.Name)
+		check(route.Name != "chain", "%s.name \"chain\" is reserved for /chain/{chainId}", key)
+		check(oneOf(strings.ToUpper(route.Upstream), "L1", "L2"), "%s.upstream must be L1 or L2, got %q", key, route.Upstream)
+		for _, patterns := range [][]string{route.AllowedMethods, route.DeniedMethods} {
+			for _, pattern := range patterns {
+				_, err := path.Match(pattern, "")
+				check(err == nil && pattern != "", "%s: invalid method pattern %q", key, pattern)
+			}
+		}
+		check(route.Guards.MaxLogsAddresses >= 0, "%s.guards.max_logs_addresses must not be negative", key)
+		check(route.Guards.MaxLogsTopics >= 0, "%s.guards.max_logs_topics must not be negative", key)
+		names[route.Name] = true
+	}
+	check(names["l1"], "routes must contain an l1 route")
+
+	// API keys
+	keyNames, keys := make(map[string]bool), make(map[string]bool)
+	for i, key := range c.Auth.Keys {
+		prefix := fmt.Sprintf("auth.keys[%d]", i)
+		check(key.Name != "", "%s.name must be set", prefix)
+		check(!keyNames[key.Name], "%s.name %q is used by more than one key", prefix, key.Name)
+		check(len(key.Key) >= minAPIKeyLength, "%s.key must be at least %d characters", prefix, minAPIKeyLength)
+		check(!keys[key.Key], "%s.key is used by more than one key", prefix)
+		for _, route := range key.Routes {
+			check(names[route], "%s.routes: unknown route %q", prefix, route)
+		}
+		check(key.RPS >= 0, "%s.rps must not be negative", prefix)
+		check(key.Burst >= 0, "%s.burst must not be negative", prefix)
+		check(key.DailyRequests >= 0, "%s.daily_requests must not be negative", prefix)
+		check(key.DailyComputeUnits >= 0, "%s.daily_compute_units must not be negative", prefix)
+		keyNames[key.Name], keys[key.Key] = true, true
+	}
+	for method, cost := range c.Auth.ComputeUnits {
+		check(cost >= 0, "auth.compute_units.%s must not be negative", method)
+	}
+	check(c.Auth.DefaultComputeUnits >= 0, "auth.default_compute_units must not be negative")
+
+	// Response cache
+	check(c.Cache.MaxMemoryMB > 0, "cache.max_memory_mb must be positive")
+	check(c.Cache.LatestTTL >= 0, "cache.latest_ttl must not be negative")
+	check(c.Cache.RecentTTL >= 0, "cache.recent_ttl must not be negative")
+	check(c.Cache.HeadInterval > 0, "cache.head_interval must be positive")
+	check(c.Cache.DiskMaxEntries > 0, "cache.disk_max_entries must be positive")
+
+	// Rate limits
+	check(oneOf(c.RateLimit.Backend, "memory", "redis"), "rate_limit.backend must be memory or redis, got %q", c.RateLimit.Backend)
+	if c.RateLimit.Backend == "redis" {
+		check(c.RateLimit.RedisURL != "", "rate_limit.redis_url must be set for the redis backend")
+	}
+	if c.RateLimit.RedisURL != "" {
+		errs = appendErr(errs, validateURL("rate_limit.redis_url", c.RateLimit.RedisURL, "redis", "rediss"))
+	}
+	check(c.RateLimit.RedisTimeout > 0, "rate_limit.redis_timeout must be positive")
+	for _, cidr := range c.RateLimit.TrustedProxies {
+		_, _, err := net.ParseCIDR(cidr)
+		check(err == nil, "rate_limit.trusted_proxies: %q is not a CIDR", cidr)
+	}
+	for scope, limits := range map[string]ClassLimits{"per_ip": c.RateLimit.PerIP, "per_key": c.RateLimit.PerKey} {
+		for class, limit := range map[string]RateLimit{"cheap": limits.Cheap, "heavy": limits.Heavy} {
+			check(limit.RPS >= 0, "rate_limit.%s.%s.rps must not be negative", scope, class)
+			check(limit.Burst >= 0, "rate_limit.%s.%s.burst must not be negative", scope, class)
+		}
+	}
+
+	// Frozen accounts cache, deposit store and monitor
+	check(c.Frozen.SyncInterval > 0, "frozen.sync_interval must be positive")
+	check(oneOf(c.Store.Backend, "sqlite", "memory"), "store.backend must be sqlite or memory, got %q", c.Store.Backend)
+	check(c.Store.Backend != "sqlite" || c.Store.Path != "", "store.path must be set for the sqlite backend")
+	check(c.Monitor.BackfillChunkSize > 0, "monitor.backfill_chunk_size must be positive")
+	check(oneOf(c.Monitor.IngestMode, "auto", "ws", "poll", "filter"),
+		"monitor.ingest_mode must be auto, ws, poll or filter, got %q", c.Monitor.IngestMode)
+	check(c.Monitor.IngestMode != "ws" || c.L1RPCURLWs != "", "monitor.ingest_mode ws requires l1_rpc_url_ws")
+	check(c.Monitor.PollInterval > 0, "monitor.poll_interval must be positive")
+	check(c.Monitor.WSMaxFailures > 0, "monitor.ws_max_failures must be positive")
+
+	// Logging and metrics
+	check(oneOf(c.Log.Format, "text", "json"), "log.format must be text or json, got %q", c.Log.Format)
+	check(oneOf(c.Metrics.AccountLabels, "full", "bounded"),
+		"metrics.account_labels must be full or bounded, got %q", c.Metrics.AccountLabels)
+	for _, account := range c.Metrics.AccountWatchlist {
+		errs = appendErr(errs, validateAddress("metrics.account_watchlist", account))
+	}
+	check(c.Metrics.AccountTopN >= 0, "metrics.account_top_n must not be negative")
+
+	// Admin API
+	check(c.Admin.Token == "" || len(c.Admin.Token) >= minAdminTokenLength,
+		"admin.token must be at least %d characters", minAdminTokenLength)
+
+	return errs
+}
+
+// validateAddress checks that an address is 20 bytes of hex and, if it uses
+// mixed case, that it has a valid EIP-55 checksum
+func validateAddress(key, address string) error {
+	if !common.IsHexAddress(address) {
+		return fmt.Errorf("%s: %q is not an address", key, address)
+	}
+	hex := strings.TrimPrefix(strings.TrimPrefix(address, "0x"), "0X")
+	if hex != strings.ToLower(hex) && hex != strings.ToUpper(hex) {
+		if checksummed := common.HexToAddress(address).Hex(); checksummed[2:] != hex {
+			return fmt.Errorf("%s: %q has an invalid checksum, expected %s", key, address, checksummed)
+		}
+	}
+	return nil
+}
+
+// validateURL checks that a URL has a host and one of the given schemes
+func validateURL(key, rawURL string, schemes ...string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("%s: invalid URL %q: %v", key, rawURL, err)
+	}
+	if !oneOf(u.Scheme, schemes...) {
+		return fmt.Errorf("%s: URL %q must use %s", key, rawURL, strings.Join(schemes, " or "))
+	}
+	if u.Host == "" {
+		return fmt.Errorf("%s: URL %q has no host", key, rawURL)
+	}
+	return nil
+}
+
+// validatePort checks that a port is a number between 1 and 65535
+func validatePort(key, port string) error {
+	n, err := strconv.Atoi(port)
+	if err != nil || n < 1 || n > 65535 {
+		return fmt.Errorf("%s must be a port between 1 and 65535, got %q", key, port)
+	}
+	return nil
+}
+
+// oneOf reports whether the value is one of the options
+func oneOf(value string, options ...string) bool {
+	for _, option := range options {
+		if value == option {
+			return true
+		}
+	}
+	return false
+}
+
+// appendErr appends an error if it is not nil
+func appendErr(errs []error, err error) []error {
+	if err != nil {
+		return append(errs, err)
+	}
+	return errs
+}
@@ -1,12 +1,14 @@
 package eth
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"strings"
 	"time"
 
 	"github.com/ddomeke/rpc_proxy/internal/config"
+	"github.com/ddomeke/rpc_proxy/internal/upstream"
 	"github.com/ethereum/go-ethereum/accounts/abi"
 	"github.com/ethereum/go-ethereum/ethclient"
 )
@@ -15,20 +17,37 @@ import (
 type Clients struct {
 	L1Client   *ethclient.Client
 	L2Client   *ethclient.Client
+	L1Pool     *upstream.Pool
+	L2Pool     *upstream.Pool
 	HTTPClient *http.Client
 	PortalABI  abi.ABI
 }
 
 // InitClients initializes Ethereum L1 and Optimism L2 clients
 func InitClients(cfg *config.Config) (*Clients, error) {
+	// Upstream pools
+	l1Pool, err := upstream.NewPool("L1", cfg.L1RPCURLs, cfg.Upstream)
+	if err != nil {
+		return nil, err
+	}
+	l2Pool, err := upstream.NewPool("L2", cfg.L2RPCURLs, cfg.Upstream)
+	if err != nil {
+		return nil, err
+	}
+
+	// Probe the nodes once so the first requests avoid dead upstreams
+	ctx := context.Background()
+	l1Pool.CheckHealth(ctx)
+	l2Pool.CheckHealth(ctx)
+
 	// L1 Client
-	l1Client, err := ethclient.Dial(cfg.L1RPCURL)
+	l1Client, err := l1Pool.Dial(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("could not connect to L1 client: %v", err)
 	}
 
 	// L2 Client
-	l2Client, err := ethclient.Dial(cfg.L2RPCURL)
+	l2Client, err := l2Pool.Dial(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("could not connect to L2 client: %v", err)
 	}
@@ -47,7 +66,22 @@ func InitClients(cfg *config.Config) (*Clients, error) {
 	return &Clients{
 		L1Client:   l1Client,
 		L2Client:   l2Client,
+		L1Pool:     l1Pool,
+		L2Pool:     l2Pool,
 		HTTPClient: httpClient,
 		PortalABI:  portalABI,
 	}, nil
 }
+
+// PrepareReload checks the upstream lists of a new configuration and returns
+// the function that applies them to the pools. The clients keep working, as
+// they send their requests through the pools.
+func (c *Clients) PrepareReload(cfg *config.Config) (func(), error) {
+	if len(cfg.L1RPCURLs) == 0 || len(cfg.L2RPCURLs) == 0 {
+		return nil, fmt.Errorf("L1 and L2 upstreams must be configured")
+	}
+	return func() {
+		c.L1Pool.Reconfigure(cfg.L1RPCURLs, cfg.Upstream)
+		c.L2Pool.Reconfigure(cfg.L2RPCURLs, cfg.Upstream)
+	}, nil
+}
@@ -0,0 +1,63 @@
+package eth
+
+import (
+	"encoding/binary"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// DepositTxType is the EIP-2718 type of L2 deposit transactions
+const DepositTxType = 0x7E
+
+// userDepositSourceDomain is the source hash domain of user deposits
+const userDepositSourceDomain = 0
+
+// depositTx is the RLP payload of an L2 deposit transaction
+type depositTx struct {
+	SourceHash          common.Hash
+	From                common.Address
+	To                  *common.Address `rlp:"nil"`
+	Mint                *big.Int
+	Value               *big.Int
+	Gas                 uint64
+	IsSystemTransaction bool
+	Data                []byte
+}
+
+// DepositSourceHash computes the source hash of a user deposit from the L1
+// block hash and the index of the TransactionDeposited log in that block:
+// keccak256(bytes32(0) ++ keccak256(l1BlockHash ++ bytes32(logIndex)))
+func DepositSourceHash(l1BlockHash common.Hash, logIndex uint) common.Hash {
+	var logIndexBytes [32]byte
+	binary.BigEndian.PutUint64(logIndexBytes[24:], uint64(logIndex))
+	depositID := crypto.Keccak256Hash(l1BlockHash.Bytes(), logIndexBytes[:])
+
+	var domain [32]byte
+	binary.BigEndian.PutUint64(domain[24:], userDepositSourceDomain)
+	return crypto.Keccak256Hash(domain[:], depositID.Bytes())
+}
+
+// L2TransactionHash builds the L2 deposit transaction of the event and returns its hash
+func (e *DepositEvent) L2TransactionHash() (common.Hash, error) {
+	tx := depositTx{
+		SourceHash: e.SourceHash,
+		From:       e.From,
+		Mint:       e.Mint,
+		Value:      e.Value,
+		Gas:        e.GasLimit,
+		Data:       e.Data,
+	}
+	if !e.IsCreation {
+		to := e.To
+		tx.To = &to
+	}
+
+	payload, err := rlp.EncodeToBytes(&tx)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	return crypto.Keccak256Hash([]byte{DepositTxType}, payload), nil
+}
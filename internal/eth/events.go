@@ -2,12 +2,15 @@ package eth
 
 import (
 	"context"
+	"encoding/binary"
 	"fmt"
 	"math/big"
 	"time"
 
+	"github.com/ethereum/go-ethereum/accounts/abi"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
 )
 
 // ABI Definitions
@@ -32,6 +35,19 @@ const (
 			"name": "AccountFrozen",
 			"type": "event"
 		},
+		{
+			"anonymous": false,
+			"inputs": [
+				{
+					"indexed": true,
+					"internalType": "address",
+					"name": "account",
+					"type": "address"
+				}
+			],
+			"name": "AccountUnfrozen",
+			"type": "event"
+		},
 		{
 			"inputs": [
 				{
@@ -129,34 +145,16 @@ const (
 					"type": "address"
 				},
 				{
-					"indexed": false,
+					"indexed": true,
 					"internalType": "uint256",
-					"name": "value",
+					"name": "version",
 					"type": "uint256"
 				},
-				{
-					"indexed": false,
-					"internalType": "uint64",
-					"name": "gasLimit",
-					"type": "uint64"
-				},
-				{
-					"indexed": false,
-					"internalType": "bool",
-					"name": "isCreation",
-					"type": "bool"
-				},
 				{
 					"indexed": false,
 					"internalType": "bytes",
-					"name": "data",
+					"name": "opaqueData",
 					"type": "bytes"
-				},
-				{
-					"indexed": true,
-					"internalType": "bytes32",
-					"name": "depositHash",
-					"type": "bytes32"
 				}
 			],
 			"name": "TransactionDeposited",
@@ -165,66 +163,133 @@ const (
 	]`
 )
 
+// DepositVersion0 is the opaqueData encoding of the OptimismPortal:
+// abi.encodePacked(uint256 mint, uint256 value, uint64 gasLimit, bool isCreation, bytes data)
+const DepositVersion0 = 0
+
+// depositV0MinLength is the length of version 0 opaqueData without call data
+const depositV0MinLength = 32 + 32 + 8 + 1
+
+// DepositEventTopic is the TransactionDeposited event signature (0xb3813568...).
+// The previously used 0x35d79ab8... is L1StandardBridge's ETHDepositInitiated.
+var DepositEventTopic = crypto.Keccak256Hash([]byte("TransactionDeposited(address,address,uint256,bytes)"))
+
 // DepositEvent - Data structure for the deposit event
 type DepositEvent struct {
 	From       common.Address
 	To         common.Address
+	Version    uint64
+	Mint       *big.Int
 	Value      *big.Int
 	GasLimit   uint64
 	IsCreation bool
 	Data       []byte
-	Hash       common.Hash
-	BlockNum   uint64
-	TxIndex    uint
-	LogIndex   uint
-	Timestamp  time.Time
+
+	// L1 location of the event
+	L1TxHash    common.Hash
+	L1BlockHash common.Hash
+	BlockNum    uint64
+	TxIndex     uint
+	LogIndex    uint
+	Timestamp   time.Time
+
+	// Derived L2 deposit transaction
+	SourceHash common.Hash
+	L2TxHash   common.Hash
 }
 
-// DecodeDepositEvent decodes the TransactionDeposited event
+// DecodeDepositEvent decodes the TransactionDeposited event and adds the L1 block timestamp
 func DecodeDepositEvent(clients *Clients, log types.Log) (*DepositEvent, error) {
-	// Expect at least 3 topics (event signature, from, to)
-	if len(log.Topics) < 3 {
-		return nil, fmt.Errorf("insufficient number of topics")
+	event, err := DecodeDepositLog(clients.PortalABI, log)
+	if err != nil {
+		return nil, err
+	}
+
+	event.Timestamp = BlockTimestamp(clients, log.BlockNumber)
+	return event, nil
+}
+
+// BlockTimestamp returns the timestamp of an L1 block, or the current time if
+// the block cannot be fetched
+func BlockTimestamp(clients *Clients, blockNumber uint64) time.Time {
+	header, err := clients.L1Client.HeaderByNumber(context.Background(), new(big.Int).SetUint64(blockNumber))
+	if err == nil { // If no error, add timestamp
+		return time.Unix(int64(header.Time), 0)
+	}
+	return time.Now() // Use current time as fallback
+}
+
+// DecodeDepositLog decodes a TransactionDeposited log without contacting a node
+func DecodeDepositLog(portalABI abi.ABI, log types.Log) (*DepositEvent, error) {
+	// Expect 4 topics (event signature, from, to, version)
+	if len(log.Topics) != 4 {
+		return nil, fmt.Errorf("unexpected number of topics: %d", len(log.Topics))
+	}
+	if log.Topics[0] != DepositEventTopic {
+		return nil, fmt.Errorf("not a TransactionDeposited event: %s", log.Topics[0].Hex())
 	}
 
 	var event DepositEvent
 
 	// Get indexed fields from topics
-	event.From = common.HexToAddress(log.Topics[1].Hex())
-	event.To = common.HexToAddress(log.Topics[2].Hex())
-
-	// Set hash to default value or calculate (if available)
-	if len(log.Topics) > 3 {
-		event.Hash = common.HexToHash(log.Topics[3].Hex())
-	} else {
-		// If no hash, use tx hash
-		event.Hash = log.TxHash
+	event.From = common.BytesToAddress(log.Topics[1].Bytes())
+	event.To = common.BytesToAddress(log.Topics[2].Bytes())
+
+	version := log.Topics[3].Big()
+	if !version.IsUint64() || version.Uint64() != DepositVersion0 {
+		return nil, fmt.Errorf("unsupported deposit version: %s", version)
 	}
+	event.Version = version.Uint64()
 
-	// Try to decode other fields from data
-	if len(log.Data) > 0 {
-		// Data format may vary, use simplified approach
-		if len(log.Data) >= 96 { // At least 3 32-byte parameters
-			// First 32 bytes: value
-			event.Value = new(big.Int).SetBytes(log.Data[0:32])
-			// Second 32 bytes: gasLimit
-			gasLimitBytes := log.Data[32:64]
-			event.GasLimit = new(big.Int).SetBytes(gasLimitBytes).Uint64()
-		}
+	// The only non-indexed field is the ABI encoded opaqueData
+	values, err := portalABI.Unpack("TransactionDeposited", log.Data)
+	if err != nil {
+		return nil, fmt.Errorf("could not unpack opaqueData: %v", err)
+	}
+	opaqueData, ok := values[0].([]byte)
+	if !ok {
+		return nil, fmt.Errorf("unexpected opaqueData type %T", values[0])
+	}
+	if err := event.decodeOpaqueDataV0(opaqueData); err != nil {
+		return nil, err
 	}
 
 	// Add block information
+	event.L1TxHash = log.TxHash
+	event.L1BlockHash = log.BlockHash
 	event.BlockNum = log.BlockNumber
 	event.TxIndex = log.TxIndex
 	event.LogIndex = log.Index
 
-	// Get block timestamp
-	header, err := clients.L1Client.HeaderByNumber(context.Background(), big.NewInt(int64(log.BlockNumber)))
-	if err == nil { // If no error, add timestamp
-		event.Timestamp = time.Unix(int64(header.Time), 0)
-	} else {
-		event.Timestamp = time.Now() // Use current time as fallback
+	// Derive the L2 deposit transaction the sequencer will include
+	event.SourceHash = DepositSourceHash(log.BlockHash, log.Index)
+	event.L2TxHash, err = event.L2TransactionHash()
+	if err != nil {
+		return nil, fmt.Errorf("could not compute L2 deposit transaction hash: %v", err)
 	}
 
 	return &event, nil
 }
+
+// decodeOpaqueDataV0 decodes the packed version 0 deposit fields
+func (e *DepositEvent) decodeOpaqueDataV0(opaqueData []byte) error {
+	if len(opaqueData) < depositV0MinLength {
+		return fmt.Errorf("opaqueData too short: %d bytes", len(opaqueData))
+	}
+
+	e.Mint = new(big.Int).SetBytes(opaqueData[0:32])
+	e.Value = new(big.Int).SetBytes(opaqueData[32:64])
+	e.GasLimit = binary.BigEndian.Uint64(opaqueData[64:72])
+
+	switch opaqueData[72] {
+	case 0:
+		e.IsCreation = false
+	case 1:
+		e.IsCreation = true
+	default:
+		return fmt.Errorf("invalid isCreation flag: %d", opaqueData[72])
+	}
+
+	e.Data = common.CopyBytes(opaqueData[73:])
+	return nil
+}
@@ -0,0 +1,342 @@
+package eth
+
+import (
+	"bytes"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// portalAddress is the OptimismPortal proxy on mainnet
+var portalAddress = common.HexToAddress("0xbEb5Fc579115071764c7423A4f12eDde41f106Ed")
+
+// depositFixture is a TransactionDeposited log in the portal's encoding with
+// the fields and L2 transaction the rollup derives from it. The logs are
+// synthetic, not taken from a chain: topics and opaqueData were assembled by
+// hand following the OptimismPortal source, and the bridge case uses the
+// mainnet messenger and bridge addresses. The expected source and L2
+// transaction hashes are recomputed from the specification's formulas in the
+// tests below, independently of DecodeDepositLog, and pin the derivation
+// against regressions.
+type depositFixture struct {
+	name        string
+	topics      []string
+	data        string
+	blockHash   string
+	blockNumber uint64
+	txHash      string
+	txIndex     uint
+	logIndex    uint
+
+	mint       string
+	value      string
+	gasLimit   uint64
+	isCreation bool
+	callData   string
+	sourceHash string
+	l2TxHash   string
+}
+
+var depositFixtures = []depositFixture{
+	{
+		// ETH bridged through L1StandardBridge: the sender is the aliased
+		// L1CrossDomainMessenger and the call relays the message on L2
+		name: "bridge_eth",
+		topics: []string{
+			"0xb3813568d9991fc951961fcb4c784893574240a28925604d09fc577c55bb7c32",
+			"0x00000000000000000000000036bde71c97b33cc4729cf772ae268934f7ab70b2",
+			"0x0000000000000000000000004200000000000000000000000000000000000007",
+			"0x0000000000000000000000000000000000000000000000000000000000000000",
+		},
+		data:        "0x000000000000000000000000000000000000000000000000000000000000002000000000000000000000000000000000000000000000000000000000000001ed00000000000000000000000000000000000000000000000000b1a2bc2ec5000000000000000000000000000000000000000000000000000000b1a2bc2ec50000000000000004638800d764ad0b000100000000000000000000000000000000000000000000000000000002f8a100000000000000000000000099c9fc46f92e8a1c0dec1b1747d010903e884be1000000000000000000000000420000000000000000000000000000000000001000000000000000000000000000000000000000000000000000b1a2bc2ec500000000000000000000000000000000000000000000000000000000000000030d4000000000000000000000000000000000000000000000000000000000000000c000000000000000000000000000000000000000000000000000000000000000a41635f5fd0000000000000000000000008eb9a4ba0b2cd7e1e4bf7b5e2e5b0a0c83f6d2a10000000000000000000000008eb9a4ba0b2cd7e1e4bf7b5e2e5b0a0c83f6d2a100000000000000000000000000000000000000000000000000b1a2bc2ec50000000000000000000000000000000000000000000000000000000000000000008000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000",
+		blockHash:   "0x2d12037ba5326515a620258ae1c5d92b56e1bb0f40c24748607cf275f6442a59",
+		blockNumber: 19000000,
+		txHash:      "0x1f0b3e6ad2c84a0f6e8b2f0c0d5b1d7e6a9c4b3f2e1d0c9b8a7f6e5d4c3b2a19",
+		txIndex:     42,
+		logIndex:    312,
+
+		mint:       "50000000000000000",
+		value:      "50000000000000000",
+		gasLimit:   287624,
+		callData:   "0xd764ad0b000100000000000000000000000000000000000000000000000000000002f8a100000000000000000000000099c9fc46f92e8a1c0dec1b1747d010903e884be1000000000000000000000000420000000000000000000000000000000000001000000000000000000000000000000000000000000000000000b1a2bc2ec500000000000000000000000000000000000000000000000000000000000000030d4000000000000000000000000000000000000000000000000000000000000000c000000000000000000000000000000000000000000000000000000000000000a41635f5fd0000000000000000000000008eb9a4ba0b2cd7e1e4bf7b5e2e5b0a0c83f6d2a10000000000000000000000008eb9a4ba0b2cd7e1e4bf7b5e2e5b0a0c83f6d2a100000000000000000000000000000000000000000000000000b1a2bc2ec500000000000000000000000000000000000000000000000000000000000000000080000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000",
+		sourceHash: "0x06815df08dc3ab703b020c5612f144630058fc2ba9c3af97d91bbf76054a9001",
+		l2TxHash:   "0xb1bc38b5e841cbd78600a4950d22a6ca0bf06a0b5a34677cbed991a397a0765c",
+	},
+	{
+		// Contract creation through depositTransaction without ETH
+		name: "contract_creation",
+		topics: []string{
+			"0xb3813568d9991fc951961fcb4c784893574240a28925604d09fc577c55bb7c32",
+			"0x0000000000000000000000005c2f7e4b8a1d6e93f0b4a27c61d85e0fa3b9c4d7",
+			"0x0000000000000000000000000000000000000000000000000000000000000000",
+			"0x0000000000000000000000000000000000000000000000000000000000000000",
+		},
+		data:        "0x000000000000000000000000000000000000000000000000000000000000002000000000000000000000000000000000000000000000000000000000000000780000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000f4240016080604052348015600e575f80fd5b50603e80601a5f395ff3fe60806040525f80fdfea164736f6c634300081a000a0000000000000000",
+		blockHash:   "0x8c8041375e150a4a263e7a0481d3508de3250948b026363309a102e6de72931d",
+		blockNumber: 19000517,
+		txHash:      "0x6d4e0c2b8f3a1e5d7c9b0a2f4e6d8c1b3a5f7e9d0c2b4a6f8e1d3c5b7a9f0e2d",
+		txIndex:     3,
+		logIndex:    7,
+
+		mint:       "0",
+		value:      "0",
+		gasLimit:   1000000,
+		isCreation: true,
+		callData:   "0x6080604052348015600e575f80fd5b50603e80601a5f395ff3fe60806040525f80fdfea164736f6c634300081a000a",
+		sourceHash: "0x9525778d6e2d52b79b76f0a6b2b6e320d0b2a14879435cd16c9c44adfca17d74",
+		l2TxHash:   "0x86ce9441bd562834e4007a2075b64f6d182e2befc5a37330737d01edf54a699e",
+	},
+	{
+		// Plain ETH deposit of an account to itself, first log of the block
+		name: "eth_transfer",
+		topics: []string{
+			"0xb3813568d9991fc951961fcb4c784893574240a28925604d09fc577c55bb7c32",
+			"0x000000000000000000000000d1a06f2e4c9b7385e0f1a2b3c4d5e6f708192a3b",
+			"0x000000000000000000000000d1a06f2e4c9b7385e0f1a2b3c4d5e6f708192a3b",
+			"0x0000000000000000000000000000000000000000000000000000000000000000",
+		},
+		data:        "0x000000000000000000000000000000000000000000000000000000000000002000000000000000000000000000000000000000000000000000000000000000490000000000000000000000000000000000000000000000000de0b6b3a76400000000000000000000000000000000000000000000000000000de0b6b3a76400000000000000005208000000000000000000000000000000000000000000000000",
+		blockHash:   "0x9561c1d3280cf2aa7ca85e1358da566cec9fe430d0b3e028a882ff2776b339e1",
+		blockNumber: 19001234,
+		txHash:      "0xc3a5e7f9b1d3f5a7c9e1b3d5f7a9c1e3b5d7f9a1c3e5b7d9f1a3c5e7b9d1f3a5",
+		txIndex:     0,
+		logIndex:    0,
+
+		mint:       "1000000000000000000",
+		value:      "1000000000000000000",
+		gasLimit:   21000,
+		callData:   "0x",
+		sourceHash: "0xa4e6f1e692c238fa8a561dd1e5f7f698324f3d55cdba6032f91cb61191089669",
+		l2TxHash:   "0x3557fc131f719ffd721b5ecfbbb020c9cb4efc8d0cd100076642fd708898a15c",
+	},
+}
+
+// log builds the types.Log of a fixture
+func (f depositFixture) log() types.Log {
+	log := types.Log{
+		Address:     portalAddress,
+		Data:        hexutil.MustDecode(f.data),
+		BlockNumber: f.blockNumber,
+		TxHash:      common.HexToHash(f.txHash),
+		TxIndex:     f.txIndex,
+		BlockHash:   common.HexToHash(f.blockHash),
+		Index:       f.logIndex,
+	}
+	for _, topic := range f.topics {
+		log.Topics = append(log.Topics, common.HexToHash(topic))
+	}
+	return log
+}
+
+func portalABI(t *testing.T) abi.ABI {
+	t.Helper()
+	parsed, err := abi.JSON(strings.NewReader(OptimismPortalABI))
+	if err != nil {
+		t.Fatalf("could not parse portal ABI: %v", err)
+	}
+	return parsed
+}
+
+func TestDecodeDepositLog(t *testing.T) {
+	parsed := portalABI(t)
+	for _, f := range depositFixtures {
+		t.Run(f.name, func(t *testing.T) {
+			log := f.log()
+			event, err := DecodeDepositLog(parsed, log)
+			if err != nil {
+				t.Fatalf("DecodeDepositLog: %v", err)
+			}
+
+			if want := common.BytesToAddress(log.Topics[1].Bytes()); event.From != want {
+				t.Errorf("From = %s, want %s", event.From, want)
+			}
+			if want := common.BytesToAddress(log.Topics[2].Bytes()); event.To != want {
+				t.Errorf("To = %s, want %s", event.To, want)
+			}
+			if event.Version != DepositVersion0 {
+				t.Errorf("Version = %d, want %d", event.Version, DepositVersion0)
+			}
+			if want, _ := new(big.Int).SetString(f.mint, 10); event.Mint.Cmp(want) != 0 {
+				t.Errorf("Mint = %s, want %s", event.Mint, want)
+			}
+			if want, _ := new(big.Int).SetString(f.value, 10); event.Value.Cmp(want) != 0 {
+				t.Errorf("Value = %s, want %s", event.Value, want)
+			}
+			if event.GasLimit != f.gasLimit {
+				t.Errorf("GasLimit = %d, want %d", event.GasLimit, f.gasLimit)
+			}
+			if event.IsCreation != f.isCreation {
+				t.Errorf("IsCreation = %t, want %t", event.IsCreation, f.isCreation)
+			}
+			if want := hexutil.MustDecode(f.callData); !bytes.Equal(event.Data, want) {
+				t.Errorf("Data = %x, want %x", event.Data, want)
+			}
+			if want := common.HexToHash(f.sourceHash); event.SourceHash != want {
+				t.Errorf("SourceHash = %s, want %s", event.SourceHash, want)
+			}
+			if want := common.HexToHash(f.l2TxHash); event.L2TxHash != want {
+				t.Errorf("L2TxHash = %s, want %s", event.L2TxHash, want)
+			}
+
+			if event.L1TxHash != log.TxHash || event.L1BlockHash != log.BlockHash ||
+				event.BlockNum != log.BlockNumber || event.TxIndex != log.TxIndex || event.LogIndex != log.Index {
+				t.Errorf("L1 location = %s/%s/%d/%d/%d, want %s/%s/%d/%d/%d",
+					event.L1TxHash, event.L1BlockHash, event.BlockNum, event.TxIndex, event.LogIndex,
+					log.TxHash, log.BlockHash, log.BlockNumber, log.TxIndex, log.Index)
+			}
+		})
+	}
+}
+
+func TestDecodeDepositLogRejects(t *testing.T) {
+	parsed := portalABI(t)
+	valid := depositFixtures[2]
+
+	// withOpaqueData encodes opaqueData as the only non-indexed event field
+	withOpaqueData := func(opaqueData []byte) func(*types.Log) {
+		return func(log *types.Log) {
+			data, err := parsed.Events["TransactionDeposited"].Inputs.NonIndexed().Pack(opaqueData)
+			if err != nil {
+				t.Fatalf("could not pack opaqueData: %v", err)
+			}
+			log.Data = data
+		}
+	}
+	opaqueData := hexutil.MustDecode(valid.data)[64 : 64+depositV0MinLength]
+
+	tests := []struct {
+		name   string
+		modify func(*types.Log)
+		err    string
+	}{
+		{
+			name:   "unknown_version",
+			modify: func(log *types.Log) { log.Topics[3] = common.BigToHash(big.NewInt(1)) },
+			err:    "unsupported deposit version: 1",
+		},
+		{
+			name:   "oversized_version",
+			modify: func(log *types.Log) { log.Topics[3] = common.MaxHash },
+			err:    "unsupported deposit version",
+		},
+		{
+			name: "wrong_event",
+			modify: func(log *types.Log) {
+				log.Topics[0] = common.HexToHash("0x35d79ab81f2b2017e19afb5c5571778877782d7a8786f5907f93b0f4702f4f23")
+			},
+			err: "not a TransactionDeposited event",
+		},
+		{
+			name:   "missing_topic",
+			modify: func(log *types.Log) { log.Topics = log.Topics[:3] },
+			err:    "unexpected number of topics: 3",
+		},
+		{
+			name:   "truncated_log_data",
+			modify: func(log *types.Log) { log.Data = log.Data[:80] },
+			err:    "could not unpack opaqueData",
+		},
+		{
+			name:   "truncated_opaque_data",
+			modify: withOpaqueData(opaqueData[:depositV0MinLength-1]),
+			err:    "opaqueData too short: 72 bytes",
+		},
+		{
+			name:   "empty_opaque_data",
+			modify: withOpaqueData(nil),
+			err:    "opaqueData too short: 0 bytes",
+		},
+		{
+			name: "invalid_creation_flag",
+			modify: withOpaqueData(func() []byte {
+				invalid := common.CopyBytes(opaqueData)
+				invalid[72] = 2
+				return invalid
+			}()),
+			err: "invalid isCreation flag: 2",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			log := valid.log()
+			tt.modify(&log)
+			event, err := DecodeDepositLog(parsed, log)
+			if err == nil {
+				t.Fatalf("DecodeDepositLog = %+v, want error containing %q", event, tt.err)
+			}
+			if !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("DecodeDepositLog error = %q, want it to contain %q", err, tt.err)
+			}
+		})
+	}
+}
+
+// specSourceHash is the user deposit source hash of the specification:
+// keccak256(bytes32(0) ++ keccak256(l1BlockHash ++ bytes32(l1LogIndex)))
+func specSourceHash(blockHash common.Hash, logIndex uint) common.Hash {
+	depositIDHash := crypto.Keccak256(blockHash.Bytes(), common.BigToHash(new(big.Int).SetUint64(uint64(logIndex))).Bytes())
+	return crypto.Keccak256Hash(make([]byte, 32), depositIDHash)
+}
+
+func TestDepositSourceHash(t *testing.T) {
+	for _, f := range depositFixtures {
+		t.Run(f.name, func(t *testing.T) {
+			blockHash := common.HexToHash(f.blockHash)
+			want := specSourceHash(blockHash, f.logIndex)
+			if want != common.HexToHash(f.sourceHash) {
+				t.Errorf("specification hash = %s, fixture has %s", want, f.sourceHash)
+			}
+			if got := DepositSourceHash(blockHash, f.logIndex); got != want {
+				t.Errorf("DepositSourceHash = %s, want %s", got, want)
+			}
+		})
+	}
+}
+
+// TestL2TransactionHashEncoding checks the fixtures' L2 transaction hashes
+// against the deposit transaction encoding of the specification, built
+// field by field from the log and the fixture's expected fields rather than
+// the decoder's output: 0x7E ++ rlp([sourceHash, from, to, mint, value, gas,
+// isSystemTx, data]) with an empty string as the recipient of a creation
+func TestL2TransactionHashEncoding(t *testing.T) {
+	parsed := portalABI(t)
+	for _, f := range depositFixtures {
+		t.Run(f.name, func(t *testing.T) {
+			mint, _ := new(big.Int).SetString(f.mint, 10)
+			value, _ := new(big.Int).SetString(f.value, 10)
+			var to interface{} = common.HexToAddress(f.topics[2])
+			if f.isCreation {
+				to = []byte{}
+			}
+			payload, err := rlp.EncodeToBytes([]interface{}{
+				specSourceHash(common.HexToHash(f.blockHash), f.logIndex),
+				common.HexToAddress(f.topics[1]),
+				to, mint, value, f.gasLimit, false,
+				hexutil.MustDecode(f.callData),
+			})
+			if err != nil {
+				t.Fatalf("could not encode deposit transaction: %v", err)
+			}
+			want := crypto.Keccak256Hash([]byte{DepositTxType}, payload)
+			if want != common.HexToHash(f.l2TxHash) {
+				t.Errorf("specification hash = %s, fixture has %s", want, f.l2TxHash)
+			}
+
+			event, err := DecodeDepositLog(parsed, f.log())
+			if err != nil {
+				t.Fatalf("DecodeDepositLog: %v", err)
+			}
+			if event.L2TxHash != want {
+				t.Errorf("L2TxHash = %s, want %s", event.L2TxHash, want)
+			}
+		})
+	}
+}
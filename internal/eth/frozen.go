@@ -3,84 +3,419 @@ package eth
 import (
 	"context"
 	"fmt"
+	"log/slog"
+	"math/big"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/ddomeke/rpc_proxy/internal/config"
+	"github.com/ddomeke/rpc_proxy/internal/metrics"
+	"github.com/ddomeke/rpc_proxy/pkg/utils"
 	"github.com/ethereum/go-ethereum"
 	"github.com/ethereum/go-ethereum/accounts/abi"
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/ethclient"
 )
 
-// CheckIfAddressIsFrozen checks if an address is on the frozen accounts list
-func CheckIfAddressIsFrozen(cfg *config.Config, addressToCheck string) (bool, error) {
-	// Connect to the Optimism devnet
-	client, err := ethclient.Dial(cfg.L1RPCURL)
+// frozenSyncChunk is the maximum block range of a single eth_getLogs call
+const frozenSyncChunk = 10000
+
+// FrozenSet is an in-memory view of the FrozenAccounts contract. It is loaded
+// by replaying AccountFrozen/AccountUnfrozen events and kept current from a
+// live subscription plus periodic catch-ups, so checks are answered in-process.
+// Catch-ups and the subscription may deliver events out of order, so every
+// account only takes events after the last one applied to it.
+type FrozenSet struct {
+	clients   *Clients
+	collector *metrics.Collector
+	abi       abi.ABI
+	reloaded  chan struct{} // Signals Run that the configuration changed
+
+	mu        sync.RWMutex
+	cfg       *config.Config
+	contract  common.Address
+	frozen    map[common.Address]bool
+	applied   map[common.Address]logPosition // Last event applied per account
+	nextBlock uint64                         // First L1 block whose events are not applied yet
+	lastSync  time.Time                      // Last time the set was confirmed current
+	emptied   time.Time                      // Creation or last reset, counts as stale until the first sync
+	ready     bool                           // Initial load finished
+}
+
+// logPosition is the position of a log in the chain
+type logPosition struct {
+	block uint64
+	index uint
+}
+
+// before reports whether the position comes before another one
+func (p logPosition) before(other logPosition) bool {
+	return p.block < other.block || (p.block == other.block && p.index < other.index)
+}
+
+// NewFrozenSet creates an empty frozen set; Run loads it in the background
+func NewFrozenSet(cfg *config.Config, clients *Clients, collector *metrics.Collector) (*FrozenSet, error) {
+	parsedABI, err := abi.JSON(strings.NewReader(FrozenAccountsABI))
 	if err != nil {
-		return false, fmt.Errorf("could not connect to ethereum client: %v", err)
+		return nil, fmt.Errorf("could not parse FrozenAccounts ABI: %v", err)
 	}
-	defer client.Close()
 
-	// Check connection
-	chainID, err := client.ChainID(context.Background())
-	if err != nil {
-		return false, fmt.Errorf("could not get chain ID: %v", err)
+	return &FrozenSet{
+		clients:   clients,
+		collector: collector,
+		abi:       parsedABI,
+		reloaded:  make(chan struct{}, 1),
+		cfg:       cfg,
+		contract:  common.HexToAddress(cfg.FrozenContractAddress),
+		frozen:    make(map[common.Address]bool),
+		applied:   make(map[common.Address]logPosition),
+		nextBlock: cfg.Frozen.StartBlock,
+		emptied:   time.Now(),
+	}, nil
+}
+
+// PrepareReload returns the function that applies a new configuration. A new
+// contract address or start block empties the set, which is then reloaded
+// while checks go to the new contract directly.
+func (f *FrozenSet) PrepareReload(cfg *config.Config) (func(), error) {
+	contract := common.HexToAddress(cfg.FrozenContractAddress)
+	return func() {
+		f.mu.Lock()
+		reset := contract != f.contract || cfg.Frozen.StartBlock != f.cfg.Frozen.StartBlock
+		f.cfg = cfg
+		if reset {
+			f.contract = contract
+			f.frozen = make(map[common.Address]bool)
+			f.applied = make(map[common.Address]logPosition)
+			f.nextBlock = cfg.Frozen.StartBlock
+			f.lastSync = time.Time{}
+			f.emptied = time.Now()
+			f.ready = false
+		}
+		f.mu.Unlock()
+
+		if reset {
+			slog.Info("Frozen contract changed, reloading the frozen set", "contract", contract.Hex(), "start_block", cfg.Frozen.StartBlock)
+			f.collector.FrozenAccounts.Set(0)
+		}
+		select {
+		case f.reloaded <- struct{}{}:
+		default:
+		}
+	}, nil
+}
+
+// IsFrozen reports whether an address is frozen. Until the initial load has
+// finished, the contract is queried directly.
+func (f *FrozenSet) IsFrozen(ctx context.Context, address common.Address) (bool, error) {
+	f.mu.RLock()
+	ready, frozen := f.ready, f.frozen[address]
+	f.mu.RUnlock()
+
+	if !ready {
+		frozen, err := f.checkOnChain(ctx, address)
+		if err != nil {
+			slog.ErrorContext(ctx, "Frozen check failed", "address", address.Hex(), "error", err)
+			return false, err
+		}
+		slog.DebugContext(ctx, "Frozen check", "address", address.Hex(), "frozen", frozen, "source", "contract")
+		return frozen, nil
 	}
-	fmt.Printf("Connection established, Chain ID: %s\n", chainID.String())
+	slog.DebugContext(ctx, "Frozen check", "address", address.Hex(), "frozen", frozen, "source", "cache")
+	return frozen, nil
+}
+
+// Ready reports whether the initial load has finished
+func (f *FrozenSet) Ready() bool {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.ready
+}
 
-	frozenContractAddress := common.HexToAddress(cfg.FrozenContractAddress)
-	fmt.Printf("Frozen Contract Address: %s\n", frozenContractAddress)
+// Size returns the number of frozen accounts
+func (f *FrozenSet) Size() int {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return len(f.frozen)
+}
 
-	checkAddress := common.HexToAddress(addressToCheck)
-	fmt.Printf("Address to check: %s\n", checkAddress.Hex())
+// Staleness returns the time since the set was last confirmed current, or
+// since it was created or reset if it was never synced
+func (f *FrozenSet) Staleness() time.Duration {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	if f.lastSync.IsZero() {
+		return time.Since(f.emptied)
+	}
+	return time.Since(f.lastSync)
+}
 
-	// Check if address has contract code
-	code, err := client.CodeAt(context.Background(), frozenContractAddress, nil)
-	if err != nil {
-		return false, fmt.Errorf("could not get contract code: %v", err)
+// Load replays all freeze events up to the current L1 head
+func (f *FrozenSet) Load(ctx context.Context) error {
+	_, contract := f.current()
+	if err := f.sync(ctx); err != nil {
+		return err
 	}
-	if len(code) == 0 {
-		return false, fmt.Errorf("no contract found at the specified address")
+
+	// Not ready if a reload switched to another contract in the meantime
+	f.mu.Lock()
+	if f.contract != contract {
+		f.mu.Unlock()
+		return fmt.Errorf("frozen contract changed during load")
 	}
-	fmt.Printf("Contract code found, code length: %d bytes\n", len(code))
+	f.ready = true
+	f.mu.Unlock()
 
-	// Parse ABI
-	parsedABI, err := abi.JSON(strings.NewReader(FrozenAccountsABI))
-	if err != nil {
-		return false, fmt.Errorf("could not parse ABI: %v", err)
+	slog.Info("Frozen set loaded", "frozen_accounts", f.Size())
+	return nil
+}
+
+// Run loads the set and keeps it current until the context is cancelled.
+// Live events are applied as they arrive and a periodic catch-up closes any
+// gaps. Checks go to the contract until the load finished.
+func (f *FrozenSet) Run(ctx context.Context) {
+	cfg, contract := f.current()
+	stopSub := f.startSubscription(ctx, cfg.L1RPCURLWs)
+	defer func() { stopSub() }()
+
+	// Replaying from an early start block can take long, the readiness probe
+	// reports the set as not loaded until it finished
+	if err := f.Load(ctx); err != nil && ctx.Err() == nil {
+		slog.Warn("Frozen set not loaded, checking the contract directly until it is", "error", err)
 	}
 
-	// Prepare input data for isFrozen
-	input, err := parsedABI.Pack("isFrozen", checkAddress)
+	ticker := time.NewTicker(cfg.Frozen.SyncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-f.reloaded:
+			newCfg, newContract := f.current()
+			// The subscription filters on the contract, so it is restarted when either changes
+			if newCfg.L1RPCURLWs != cfg.L1RPCURLWs || newContract != contract {
+				stopSub()
+				stopSub = f.startSubscription(ctx, newCfg.L1RPCURLWs)
+			}
+			if newCfg.Frozen.SyncInterval != cfg.Frozen.SyncInterval {
+				ticker.Reset(newCfg.Frozen.SyncInterval)
+			}
+			cfg, contract = newCfg, newContract
+		case <-ticker.C:
+			if !f.Ready() {
+				if err := f.Load(ctx); err != nil {
+					slog.Error("Frozen set load failed", "error", err)
+				}
+			} else if err := f.sync(ctx); err != nil {
+				slog.Error("Frozen set sync failed", "error", err)
+			}
+			f.collector.FrozenSetStaleness.Set(f.Staleness().Seconds())
+		}
+	}
+}
+
+// sync applies all freeze events between the last synced block and the L1 head
+func (f *FrozenSet) sync(ctx context.Context) error {
+	head, err := f.clients.L1Client.BlockNumber(ctx)
 	if err != nil {
-		return false, fmt.Errorf("could not pack input parameters: %v", err)
+		return fmt.Errorf("could not get L1 block number: %v", err)
 	}
-	fmt.Printf("Request data created, length: %d bytes\n", len(input))
 
-	// Call the contract
-	ctx := context.Background()
-	msg := ethereum.CallMsg{
-		To:   &frozenContractAddress,
-		Data: input,
+	f.mu.RLock()
+	from, contract := f.nextBlock, f.contract
+	f.mu.RUnlock()
+
+	for from <= head {
+		to := from + frozenSyncChunk - 1
+		if to > head {
+			to = head
+		}
+
+		logs, err := f.clients.L1Client.FilterLogs(ctx, f.query(contract, new(big.Int).SetUint64(from), new(big.Int).SetUint64(to)))
+		if err != nil {
+			return fmt.Errorf("could not fetch freeze events in blocks %d-%d: %v", from, to, err)
+		}
+		sort.Slice(logs, func(i, j int) bool {
+			return logPosition{logs[i].BlockNumber, logs[i].Index}.before(logPosition{logs[j].BlockNumber, logs[j].Index})
+		})
+		for _, logEntry := range logs {
+			f.apply(logEntry)
+		}
+
+		// A reload may have reset the set for another contract in the meantime
+		f.mu.Lock()
+		if f.contract != contract {
+			f.mu.Unlock()
+			return fmt.Errorf("frozen contract changed during sync")
+		}
+		f.nextBlock = to + 1
+		f.mu.Unlock()
+		from = to + 1
 	}
 
-	fmt.Println("Calling contract...")
-	output, err := client.CallContract(ctx, msg, nil)
+	f.mu.Lock()
+	if f.contract != contract {
+		f.mu.Unlock()
+		return fmt.Errorf("frozen contract changed during sync")
+	}
+	f.lastSync = time.Now()
+	f.mu.Unlock()
+
+	f.collector.FrozenAccounts.Set(float64(f.Size()))
+	return nil
+}
+
+// startSubscription follows live freeze events over the websocket, if one is
+// configured, and returns the function that stops it
+func (f *FrozenSet) startSubscription(ctx context.Context, wsURL string) context.CancelFunc {
+	subCtx, cancel := context.WithCancel(ctx)
+	if wsURL != "" {
+		go f.subscribe(subCtx, wsURL)
+	}
+	return cancel
+}
+
+// subscribe applies live freeze events from the L1 websocket until the
+// context is cancelled
+func (f *FrozenSet) subscribe(ctx context.Context, wsURL string) {
+	for ctx.Err() == nil {
+		cfg, contract := f.current()
+		client, err := ethclient.DialContext(ctx, wsURL)
+		if err != nil {
+			slog.Error("Frozen set could not connect to L1 websocket", "error", err)
+			utils.SleepContext(ctx, cfg.Frozen.SyncInterval)
+			continue
+		}
+
+		logs := make(chan types.Log)
+		sub, err := client.SubscribeFilterLogs(ctx, f.query(contract, nil, nil), logs)
+		if err != nil {
+			slog.Error("Frozen set subscription failed", "error", err)
+			client.Close()
+			utils.SleepContext(ctx, cfg.Frozen.SyncInterval)
+			continue
+		}
+
+	loop:
+		for {
+			select {
+			case <-ctx.Done():
+				break loop
+			case err := <-sub.Err():
+				slog.Error("Frozen set subscription error", "error", err)
+				break loop
+			case logEntry := <-logs:
+				f.apply(logEntry)
+				f.collector.FrozenAccounts.Set(float64(f.Size()))
+			}
+		}
+		sub.Unsubscribe()
+		client.Close()
+	}
+}
+
+// apply updates the set from a single AccountFrozen or AccountUnfrozen log
+func (f *FrozenSet) apply(logEntry types.Log) {
+	if len(logEntry.Topics) < 2 {
+		return
+	}
+	// Events of a contract replaced by a reload are ignored
+	if _, contract := f.current(); logEntry.Address != contract {
+		return
+	}
+	account := common.BytesToAddress(logEntry.Topics[1].Bytes())
+
+	// A removed log was reorged out, so the contract is the source of truth
+	if logEntry.Removed {
+		frozen, err := f.checkOnChain(context.Background(), account)
+		if err != nil {
+			slog.Error("Could not re-check account after reorg", "account", account.Hex(), "error", err)
+			return
+		}
+		f.mu.Lock()
+		f.setLocked(account, frozen)
+		f.mu.Unlock()
+		return
+	}
+
+	var frozen bool
+	switch logEntry.Topics[0] {
+	case f.abi.Events["AccountFrozen"].ID:
+		frozen = true
+	case f.abi.Events["AccountUnfrozen"].ID:
+		frozen = false
+	default:
+		return
+	}
+
+	// An event older than the last one applied to the account is stale
+	position := logPosition{logEntry.BlockNumber, logEntry.Index}
+	f.mu.Lock()
+	if last, ok := f.applied[account]; ok && !last.before(position) {
+		f.mu.Unlock()
+		slog.Debug("Stale freeze event ignored", "account", account.Hex(), "block", logEntry.BlockNumber, "log_index", logEntry.Index)
+		return
+	}
+	f.applied[account] = position
+	f.setLocked(account, frozen)
+	f.mu.Unlock()
+
+	if frozen {
+		slog.Info("Account frozen", "account", account.Hex())
+	} else {
+		slog.Info("Account unfrozen", "account", account.Hex())
+	}
+}
+
+// setLocked records the frozen state of an account, f.mu must be held
+func (f *FrozenSet) setLocked(account common.Address, frozen bool) {
+	if frozen {
+		f.frozen[account] = true
+	} else {
+		delete(f.frozen, account)
+	}
+}
+
+// current returns the configuration and the contract the set follows
+func (f *FrozenSet) current() (*config.Config, common.Address) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.cfg, f.contract
+}
+
+// query builds the log filter for freeze events of a contract in a block range
+func (f *FrozenSet) query(contract common.Address, from, to *big.Int) ethereum.FilterQuery {
+	return ethereum.FilterQuery{
+		FromBlock: from,
+		ToBlock:   to,
+		Addresses: []common.Address{contract},
+		Topics: [][]common.Hash{{
+			f.abi.Events["AccountFrozen"].ID,
+			f.abi.Events["AccountUnfrozen"].ID,
+		}},
+	}
+}
+
+// checkOnChain queries isFrozen on the contract through the shared L1 client
+func (f *FrozenSet) checkOnChain(ctx context.Context, account common.Address) (bool, error) {
+	input, err := f.abi.Pack("isFrozen", account)
 	if err != nil {
-		return false, fmt.Errorf("contract call failed: %v", err)
+		return false, fmt.Errorf("could not pack input parameters: %v", err)
 	}
 
-	fmt.Printf("Call successful, output data length: %d bytes\n", len(output))
-	if len(output) == 0 {
-		return false, fmt.Errorf("contract returned empty output")
+	_, contract := f.current()
+	output, err := f.clients.L1Client.CallContract(ctx, ethereum.CallMsg{To: &contract, Data: input}, nil)
+	if err != nil {
+		return false, fmt.Errorf("contract call failed: %v", err)
 	}
 
-	// Process output
 	var result bool
-	err = parsedABI.UnpackIntoInterface(&result, "isFrozen", output)
-	if err != nil {
+	if err := f.abi.UnpackIntoInterface(&result, "isFrozen", output); err != nil {
 		return false, fmt.Errorf("could not unpack output: %v", err)
 	}
-
 	return result, nil
 }
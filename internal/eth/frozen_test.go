@@ -0,0 +1,91 @@
+package eth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ddomeke/rpc_proxy/internal/config"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+func TestFrozenSetApplyOrder(t *testing.T) {
+	cfg := config.Default()
+	cfg.FrozenContractAddress = "0x00000000000000000000000000000000000000f0"
+	contract := common.HexToAddress(cfg.FrozenContractAddress)
+	account := common.HexToAddress("0x00000000000000000000000000000000000000a1")
+
+	type event struct {
+		frozen bool
+		block  uint64
+		index  uint
+	}
+	tests := []struct {
+		name       string
+		events     []event
+		wantFrozen bool
+	}{
+		{name: "in_order", events: []event{{true, 10, 0}, {false, 20, 0}}, wantFrozen: false},
+		// A catch-up delivers the freeze after the subscription delivered the unfreeze
+		{name: "catch_up_after_live", events: []event{{false, 20, 0}, {true, 10, 0}}, wantFrozen: false},
+		{name: "same_block", events: []event{{true, 10, 5}, {false, 10, 2}}, wantFrozen: true},
+		{name: "duplicate", events: []event{{true, 10, 0}, {false, 11, 0}, {true, 10, 0}}, wantFrozen: false},
+		{name: "refrozen", events: []event{{true, 10, 0}, {false, 11, 0}, {true, 12, 0}}, wantFrozen: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := NewFrozenSet(cfg, &Clients{}, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, e := range tt.events {
+				topic := f.abi.Events["AccountUnfrozen"].ID
+				if e.frozen {
+					topic = f.abi.Events["AccountFrozen"].ID
+				}
+				f.apply(types.Log{
+					Address:     contract,
+					Topics:      []common.Hash{topic, common.BytesToHash(account.Bytes())},
+					BlockNumber: e.block,
+					Index:       e.index,
+				})
+			}
+			if got := f.frozen[account]; got != tt.wantFrozen {
+				t.Errorf("frozen = %v, want %v", got, tt.wantFrozen)
+			}
+		})
+	}
+}
+
+func TestFrozenSetStaleness(t *testing.T) {
+	cfg := config.Default()
+	cfg.FrozenContractAddress = "0x00000000000000000000000000000000000000f0"
+
+	tests := []struct {
+		name     string
+		emptied  time.Duration // Time since the set was created or reset
+		lastSync time.Duration // Time since the last sync, zero if never synced
+		want     time.Duration
+	}{
+		// Not loaded yet, stale since the service started
+		{name: "never_synced", emptied: time.Minute, want: time.Minute},
+		{name: "synced", emptied: time.Hour, lastSync: 5 * time.Second, want: 5 * time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := NewFrozenSet(cfg, &Clients{}, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			f.emptied = time.Now().Add(-tt.emptied)
+			if tt.lastSync > 0 {
+				f.lastSync = time.Now().Add(-tt.lastSync)
+			}
+			if got := f.Staleness(); got < tt.want || got > tt.want+time.Second {
+				t.Errorf("staleness = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
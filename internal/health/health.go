@@ -0,0 +1,196 @@
+package health
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/ddomeke/rpc_proxy/internal/eth"
+	"github.com/ddomeke/rpc_proxy/internal/monitor"
+	"github.com/ddomeke/rpc_proxy/internal/store"
+	"github.com/ddomeke/rpc_proxy/internal/upstream"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// oldestPendingLimit is the number of oldest pending deposits in /status
+const oldestPendingLimit = 5
+
+// Service answers health, readiness and status requests from the state the
+// upstream pools, the frozen set, the L1 monitor and the deposit store hold
+type Service struct {
+	clients      *eth.Clients
+	frozenSet    *eth.FrozenSet
+	l1Monitor    *monitor.L1Monitor
+	depositStore store.DepositStore
+}
+
+// ChainStatus is the head and the upstream health of one chain
+type ChainStatus struct {
+	Head      uint64                `json:"head"`
+	Upstreams []upstream.NodeStatus `json:"upstreams"`
+}
+
+// PendingDeposit is a deposit waiting for L2 inclusion
+type PendingDeposit struct {
+	SourceHash common.Hash    `json:"sourceHash"`
+	L2TxHash   common.Hash    `json:"l2TxHash"`
+	From       common.Address `json:"from"`
+	L1Block    uint64         `json:"l1Block"`
+	AgeSeconds float64        `json:"ageSeconds"` // Time since the L1 block
+}
+
+// DepositStatus summarizes the deposit pipeline
+type DepositStatus struct {
+	LastProcessedL1Block uint64           `json:"lastProcessedL1Block"`
+	Pending              int              `json:"pending"`
+	OldestPending        []PendingDeposit `json:"oldestPending"`
+	Error                string           `json:"error,omitempty"`
+}
+
+// FrozenSetStatus describes the in-memory frozen accounts set
+type FrozenSetStatus struct {
+	Ready            bool    `json:"ready"`
+	Accounts         int     `json:"accounts"`
+	StalenessSeconds float64 `json:"stalenessSeconds"`
+}
+
+// Status is the response of /status
+type Status struct {
+	L1        ChainStatus      `json:"l1"`
+	L2        ChainStatus      `json:"l2"`
+	L1Events  monitor.L1Status `json:"l1Events"`
+	Deposits  DepositStatus    `json:"deposits"`
+	FrozenSet FrozenSetStatus  `json:"frozenSet"`
+}
+
+// NewService creates the health service
+func NewService(clients *eth.Clients, frozenSet *eth.FrozenSet, l1Monitor *monitor.L1Monitor, depositStore store.DepositStore) *Service {
+	return &Service{
+		clients:      clients,
+		frozenSet:    frozenSet,
+		l1Monitor:    l1Monitor,
+		depositStore: depositStore,
+	}
+}
+
+// Handlers returns the /healthz, /readyz and /status handlers by pattern
+func (s *Service) Handlers() map[string]http.Handler {
+	return map[string]http.Handler{
+		"/healthz": http.HandlerFunc(s.healthz),
+		"/readyz":  http.HandlerFunc(s.readyz),
+		"/status":  http.HandlerFunc(s.status),
+	}
+}
+
+// healthz reports that the process is alive and serving HTTP
+func (s *Service) healthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write([]byte("ok\n"))
+}
+
+// readyz reports whether the proxy can serve traffic: both chains have a
+// healthy upstream, L1 deposit events are received and the frozen set is
+// loaded. Failed checks are listed with the reason and answered with 503.
+func (s *Service) readyz(w http.ResponseWriter, r *http.Request) {
+	checks := map[string]string{
+		"l1_upstream": "ok",
+		"l2_upstream": "ok",
+		"l1_events":   "ok",
+		"frozen_set":  "ok",
+	}
+	if !s.clients.L1Pool.Healthy() {
+		checks["l1_upstream"] = "no healthy L1 upstream"
+	}
+	if !s.clients.L2Pool.Healthy() {
+		checks["l2_upstream"] = "no healthy L2 upstream"
+	}
+	if !s.l1Monitor.Active() {
+		checks["l1_events"] = "L1 deposit listener is not receiving events"
+	}
+	if !s.frozenSet.Ready() {
+		checks["frozen_set"] = "frozen set is not loaded yet"
+	}
+
+	ready := true
+	for _, result := range checks {
+		if result != "ok" {
+			ready = false
+		}
+	}
+
+	code := http.StatusOK
+	if !ready {
+		code = http.StatusServiceUnavailable
+	}
+	writeJSON(w, code, struct {
+		Ready  bool              `json:"ready"`
+		Checks map[string]string `json:"checks"`
+	}{ready, checks})
+}
+
+// status returns the state of the chains, the deposit pipeline and the frozen set
+func (s *Service) status(w http.ResponseWriter, r *http.Request) {
+	status := Status{
+		L1: ChainStatus{
+			Head:      s.clients.L1Pool.Head(),
+			Upstreams: s.clients.L1Pool.Status(),
+		},
+		L2: ChainStatus{
+			Head:      s.clients.L2Pool.Head(),
+			Upstreams: s.clients.L2Pool.Status(),
+		},
+		L1Events: s.l1Monitor.Status(),
+		Deposits: s.depositStatus(),
+		FrozenSet: FrozenSetStatus{
+			Ready:            s.frozenSet.Ready(),
+			Accounts:         s.frozenSet.Size(),
+			StalenessSeconds: s.frozenSet.Staleness().Seconds(),
+		},
+	}
+	writeJSON(w, http.StatusOK, status)
+}
+
+// depositStatus summarizes the checkpoint and the pending deposits
+func (s *Service) depositStatus() DepositStatus {
+	status := DepositStatus{OldestPending: []PendingDeposit{}}
+
+	checkpoint, err := s.depositStore.GetCheckpoint(monitor.L1CheckpointName)
+	if err != nil && err != store.ErrNotFound {
+		status.Error = err.Error()
+		return status
+	}
+	status.LastProcessedL1Block = checkpoint
+
+	pending, err := s.depositStore.PendingDeposits()
+	if err != nil {
+		status.Error = err.Error()
+		return status
+	}
+	status.Pending = len(pending)
+
+	// Pending deposits are returned oldest first
+	for i, deposit := range pending {
+		if i >= oldestPendingLimit {
+			break
+		}
+		since := deposit.Timestamp
+		if since.IsZero() {
+			since = deposit.ObservedAt
+		}
+		status.OldestPending = append(status.OldestPending, PendingDeposit{
+			SourceHash: deposit.SourceHash,
+			L2TxHash:   deposit.L2TxHash,
+			From:       deposit.From,
+			L1Block:    deposit.BlockNum,
+			AgeSeconds: time.Since(since).Seconds(),
+		})
+	}
+	return status
+}
+
+// writeJSON encodes a value as the JSON response body
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,201 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ddomeke/rpc_proxy/internal/config"
+	"github.com/ddomeke/rpc_proxy/internal/eth"
+	"github.com/ddomeke/rpc_proxy/internal/metrics"
+	"github.com/ddomeke/rpc_proxy/internal/monitor"
+	"github.com/ddomeke/rpc_proxy/internal/store"
+	"github.com/ddomeke/rpc_proxy/internal/upstream"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethclient"
+)
+
+// testNode is an upstream node at block 0x20 without any logs. A down node
+// answers every request with 502.
+type testNode struct {
+	down bool
+}
+
+func (n *testNode) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if n.down {
+		w.WriteHeader(http.StatusBadGateway)
+		return
+	}
+	var req struct {
+		ID     json.RawMessage `json:"id"`
+		Method string          `json:"method"`
+	}
+	json.NewDecoder(r.Body).Decode(&req)
+
+	var result interface{}
+	switch req.Method {
+	case "eth_blockNumber":
+		result = "0x20"
+	case "eth_syncing":
+		result = false
+	case "eth_getLogs":
+		result = []interface{}{}
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"jsonrpc": "2.0", "id": req.ID, "result": result})
+}
+
+// testService creates a health service for an L1 and an L2 node
+func testService(t *testing.T, l1, l2 *testNode, depositStore store.DepositStore) (*Service, *eth.Clients, *eth.FrozenSet) {
+	t.Helper()
+	l1Srv, l2Srv := httptest.NewServer(l1), httptest.NewServer(l2)
+	t.Cleanup(l1Srv.Close)
+	t.Cleanup(l2Srv.Close)
+
+	cfg := config.Default()
+	cfg.L1RPCURLs, cfg.L2RPCURLs = []string{l1Srv.URL}, []string{l2Srv.URL}
+	cfg.FrozenContractAddress = "0x00000000000000000000000000000000000000f0"
+	cfg.OptimismPortalAddress = "0xbEb5Fc579115071764c7423A4f12eDde41f106Ed"
+
+	clients := &eth.Clients{HTTPClient: http.DefaultClient}
+	var err error
+	if clients.L1Pool, err = upstream.NewPool("L1", cfg.L1RPCURLs, cfg.Upstream); err != nil {
+		t.Fatal(err)
+	}
+	if clients.L2Pool, err = upstream.NewPool("L2", cfg.L2RPCURLs, cfg.Upstream); err != nil {
+		t.Fatal(err)
+	}
+	if clients.L1Client, err = ethclient.Dial(l1Srv.URL); err != nil {
+		t.Fatal(err)
+	}
+	collector := metrics.NewCollector(cfg.Metrics)
+	frozenSet, err := eth.NewFrozenSet(cfg, clients, collector)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l1Monitor := monitor.NewL1Monitor(clients, frozenSet, depositStore, cfg, collector)
+	return NewService(clients, frozenSet, l1Monitor, depositStore), clients, frozenSet
+}
+
+// get sends a GET request to a handler of the service
+func get(s *Service, path string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	s.Handlers()[path].ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	return rec
+}
+
+func TestHealth(t *testing.T) {
+	l2 := &testNode{}
+	depositStore := store.NewMemoryStore()
+	s, clients, frozenSet := testService(t, &testNode{}, l2, depositStore)
+
+	// Checkpoint and six pending deposits, of which /status lists the five oldest
+	depositStore.SetCheckpoint(monitor.L1CheckpointName, 0x1e)
+	for i := 0; i < 6; i++ {
+		block := uint64(0x10 + i)
+		depositStore.SaveDeposit(&store.Deposit{
+			DepositEvent: eth.DepositEvent{
+				SourceHash: eth.DepositSourceHash(common.BigToHash(new(big.Int).SetUint64(block)), 0),
+				BlockNum:   block,
+				Timestamp:  time.Now().Add(-time.Duration(6-i) * time.Minute),
+			},
+			Status: store.StatusObserved,
+		})
+	}
+
+	// Cases run in order, each one changes the state of the service first
+	tests := []struct {
+		name       string
+		setup      func()
+		wantStatus int
+		wantFailed []string // Failed readiness checks
+	}{
+		{
+			// Nodes are healthy until probed, the L1 listener has not started
+			name:       "starting",
+			setup:      func() {},
+			wantStatus: http.StatusServiceUnavailable,
+			wantFailed: []string{"l1_events", "frozen_set"},
+		},
+		{
+			name: "frozen_set_loaded",
+			setup: func() {
+				if err := frozenSet.Load(context.Background()); err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantStatus: http.StatusServiceUnavailable,
+			wantFailed: []string{"l1_events"},
+		},
+		{
+			name: "l2_down",
+			setup: func() {
+				l2.down = true
+				clients.L2Pool.CheckHealth(context.Background())
+			},
+			wantStatus: http.StatusServiceUnavailable,
+			wantFailed: []string{"l1_events", "l2_upstream"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.setup()
+
+			if rec := get(s, "/healthz"); rec.Code != http.StatusOK {
+				t.Errorf("/healthz status = %d, want %d", rec.Code, http.StatusOK)
+			}
+
+			rec := get(s, "/readyz")
+			if rec.Code != tt.wantStatus {
+				t.Errorf("/readyz status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			var ready struct {
+				Ready  bool              `json:"ready"`
+				Checks map[string]string `json:"checks"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &ready); err != nil {
+				t.Fatal(err)
+			}
+			failed := make(map[string]bool)
+			for _, check := range tt.wantFailed {
+				failed[check] = true
+			}
+			for check, result := range ready.Checks {
+				if (result != "ok") != failed[check] {
+					t.Errorf("check %s = %q, want failed: %v", check, result, failed[check])
+				}
+			}
+		})
+	}
+
+	t.Run("status", func(t *testing.T) {
+		clients.L1Pool.CheckHealth(context.Background())
+		rec := get(s, "/status")
+		var status Status
+		if err := json.Unmarshal(rec.Body.Bytes(), &status); err != nil {
+			t.Fatal(err)
+		}
+		if status.L1.Head != 0x20 {
+			t.Errorf("L1 head = %d, want %d", status.L1.Head, 0x20)
+		}
+		if len(status.L2.Upstreams) != 1 || status.L2.Upstreams[0].Healthy {
+			t.Errorf("L2 upstreams = %+v, want one unhealthy node", status.L2.Upstreams)
+		}
+		if status.Deposits.LastProcessedL1Block != 0x1e {
+			t.Errorf("last processed block = %d, want %d", status.Deposits.LastProcessedL1Block, 0x1e)
+		}
+		if status.Deposits.Pending != 6 || len(status.Deposits.OldestPending) != oldestPendingLimit {
+			t.Errorf("pending = %d with %d listed, want 6 with %d listed", status.Deposits.Pending, len(status.Deposits.OldestPending), oldestPendingLimit)
+		}
+		if oldest := status.Deposits.OldestPending; len(oldest) > 0 && (oldest[0].L1Block != 0x10 || oldest[0].AgeSeconds < 300) {
+			t.Errorf("oldest pending = %+v, want block 16 about 6 minutes old", oldest[0])
+		}
+		if !status.FrozenSet.Ready {
+			t.Error("frozen set not ready")
+		}
+	})
+}
@@ -0,0 +1,93 @@
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+
+	"github.com/ddomeke/rpc_proxy/internal/config"
+)
+
+// RequestIDHeader is the HTTP header carrying the request ID
+const RequestIDHeader = "X-Request-ID"
+
+type requestIDKey struct{}
+
+// level is the minimum level of the default logger, changed on reload
+var level = new(slog.LevelVar)
+
+// Init installs the default structured logger. The returned log file is nil
+// when logging to stdout only and must otherwise be closed on shutdown.
+func Init(cfg config.LogConfig) (*os.File, error) {
+	var out io.Writer = os.Stdout
+	var logFile *os.File
+	if cfg.File != "" {
+		var err error
+		logFile, err = os.OpenFile(cfg.File, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("could not open log file: %v", err)
+		}
+		out = io.MultiWriter(logFile, os.Stdout) // Write to console and file
+	}
+
+	level.Set(cfg.Level)
+	opts := &slog.HandlerOptions{Level: level}
+	var handler slog.Handler
+	if cfg.Format == "json" {
+		handler = slog.NewJSONHandler(out, opts)
+	} else {
+		handler = slog.NewTextHandler(out, opts)
+	}
+	slog.SetDefault(slog.New(&contextHandler{Handler: handler}))
+	return logFile, nil
+}
+
+// SetLevel changes the minimum level of the default logger
+func SetLevel(l slog.Level) {
+	level.Set(l)
+}
+
+// NewRequestID returns a random request ID
+func NewRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// WithRequestID returns a context whose log lines carry the request ID
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the request ID of the context, if any
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// contextHandler adds the request ID of the context to every record
+type contextHandler struct {
+	slog.Handler
+}
+
+// Handle adds the request ID attribute and passes the record on
+func (h *contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := RequestID(ctx); id != "" {
+		r.AddAttrs(slog.String("request_id", id))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+// WithAttrs keeps the wrapper around derived handlers
+func (h *contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &contextHandler{Handler: h.Handler.WithAttrs(attrs)}
+}
+
+// WithGroup keeps the wrapper around derived handlers
+func (h *contextHandler) WithGroup(name string) slog.Handler {
+	return &contextHandler{Handler: h.Handler.WithGroup(name)}
+}
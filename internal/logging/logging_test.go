@@ -0,0 +1,97 @@
+package logging
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ddomeke/rpc_proxy/internal/config"
+)
+
+// readLines decodes the JSON lines of a log file
+func readLines(t *testing.T, path string) []map[string]interface{} {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var lines []map[string]interface{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var line map[string]interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			t.Fatalf("log line %s is not JSON: %v", scanner.Bytes(), err)
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func TestInit(t *testing.T) {
+	defaultLogger := slog.Default()
+	t.Cleanup(func() { slog.SetDefault(defaultLogger) })
+
+	path := filepath.Join(t.TempDir(), "proxy.log")
+	logFile, err := Init(config.LogConfig{Level: slog.LevelInfo, Format: "json", File: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logFile.Close()
+
+	ctx := WithRequestID(context.Background(), "req-1")
+	tests := []struct {
+		name          string
+		log           func()
+		wantLogged    bool
+		wantRequestID string
+	}{
+		{name: "info", log: func() { slog.Info("info") }, wantLogged: true},
+		{name: "below_level", log: func() { slog.Debug("below_level") }},
+		{name: "request", log: func() { slog.InfoContext(ctx, "request") }, wantLogged: true, wantRequestID: "req-1"},
+		{name: "derived_logger", log: func() { slog.With("component", "monitor").WarnContext(ctx, "derived_logger") }, wantLogged: true, wantRequestID: "req-1"},
+		{name: "group", log: func() { slog.Default().WithGroup("frozen").InfoContext(ctx, "group") }, wantLogged: true, wantRequestID: "req-1"},
+		{
+			// The level changes on reload
+			name: "debug_after_reload",
+			log: func() {
+				SetLevel(slog.LevelDebug)
+				slog.DebugContext(ctx, "debug_after_reload")
+			},
+			wantLogged:    true,
+			wantRequestID: "req-1",
+		},
+	}
+	for _, tt := range tests {
+		tt.log()
+	}
+
+	logged := make(map[string]map[string]interface{})
+	for _, line := range readLines(t, path) {
+		msg, _ := line["msg"].(string)
+		logged[msg] = line
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			line, ok := logged[tt.name]
+			if ok != tt.wantLogged {
+				t.Fatalf("logged = %v, want %v", ok, tt.wantLogged)
+			}
+			if !ok {
+				return
+			}
+			// Grouped attributes are nested, the request ID is in the group
+			if group, ok := line["frozen"].(map[string]interface{}); ok {
+				line = group
+			}
+			id, _ := line["request_id"].(string)
+			if id != tt.wantRequestID {
+				t.Errorf("request_id = %q, want %q", id, tt.wantRequestID)
+			}
+		})
+	}
+}
@@ -0,0 +1,274 @@
+package metrics
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Account metric names and help texts, shared by the labeled counters and the
+// bounded account tracker
+const (
+	depositsByAccountName = "opstack_deposits_by_account"
+	depositsByAccountHelp = "Number of deposits grouped by sender account, without deposits reorged out of L1"
+	blockedDepositsName   = "opstack_blocked_deposits"
+	blockedDepositsHelp   = "Total number of blocked deposits from frozen accounts, without deposits reorged out of L1"
+)
+
+// otherAccount is the account label of deposits from accounts without a label of their own
+const otherAccount = "other"
+
+// defaultAccountsLimit is the page size of the /accounts API
+const defaultAccountsLimit = 100
+
+// AccountStats holds the deposit totals of a single sender account
+type AccountStats struct {
+	Account   common.Address `json:"account"`
+	Deposits  uint64         `json:"deposits"`  // Deposits that were not blocked
+	Blocked   uint64         `json:"blocked"`   // Deposits blocked because the sender is frozen
+	VolumeWei string         `json:"volumeWei"` // Value of all deposits in wei, blocked ones included
+	Watched   bool           `json:"watched"`
+}
+
+// accountTotals are the running totals of an account
+type accountTotals struct {
+	deposits uint64
+	blocked  uint64
+	volume   *big.Int
+}
+
+// AccountTracker keeps per-account deposit totals in memory. In bounded mode
+// it exports them to Prometheus with labels for the watchlist and the top-N
+// accounts by volume only, and folds all other accounts into "other".
+type AccountTracker struct {
+	mu        sync.RWMutex
+	totals    map[common.Address]*accountTotals
+	watchlist map[common.Address]bool
+	topN      int
+
+	depositsDesc *prometheus.Desc
+	blockedDesc  *prometheus.Desc
+}
+
+// NewAccountTracker creates an account tracker
+func NewAccountTracker(watchlist []common.Address, topN int) *AccountTracker {
+	watched := make(map[common.Address]bool, len(watchlist))
+	for _, account := range watchlist {
+		watched[account] = true
+	}
+	return &AccountTracker{
+		totals:       make(map[common.Address]*accountTotals),
+		watchlist:    watched,
+		topN:         topN,
+		depositsDesc: prometheus.NewDesc(depositsByAccountName, depositsByAccountHelp, []string{"account"}, nil),
+		blockedDesc:  prometheus.NewDesc(blockedDepositsName, blockedDepositsHelp, []string{"account"}, nil),
+	}
+}
+
+// Observe adds a deposit to the totals of its sender
+func (t *AccountTracker) Observe(account common.Address, value *big.Int, blocked bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	totals, ok := t.totals[account]
+	if !ok {
+		totals = &accountTotals{volume: new(big.Int)}
+		t.totals[account] = totals
+	}
+	if blocked {
+		totals.blocked++
+	} else {
+		totals.deposits++
+	}
+	if value != nil {
+		totals.volume.Add(totals.volume, value)
+	}
+}
+
+// Revert takes a deposit that was reorged out of L1 off the totals of its sender
+func (t *AccountTracker) Revert(account common.Address, value *big.Int, blocked bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	totals, ok := t.totals[account]
+	if !ok {
+		return
+	}
+	if blocked && totals.blocked > 0 {
+		totals.blocked--
+	} else if !blocked && totals.deposits > 0 {
+		totals.deposits--
+	}
+	if value != nil {
+		totals.volume.Sub(totals.volume, value)
+	}
+}
+
+// Accounts returns the totals of all accounts, highest volume first
+func (t *AccountTracker) Accounts() []AccountStats {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	stats := make([]AccountStats, 0, len(t.totals))
+	for _, account := range t.byVolume() {
+		stats = append(stats, t.stats(account))
+	}
+	return stats
+}
+
+// Account returns the totals of a single account
+func (t *AccountTracker) Account(account common.Address) (AccountStats, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	if _, ok := t.totals[account]; !ok {
+		return AccountStats{}, false
+	}
+	return t.stats(account), true
+}
+
+// Describe implements prometheus.Collector
+func (t *AccountTracker) Describe(ch chan<- *prometheus.Desc) {
+	ch <- t.depositsDesc
+	ch <- t.blockedDesc
+}
+
+// Collect implements prometheus.Collector. The top-N is recomputed on every
+// scrape, so the "other" series drops when an account gets its own label.
+func (t *AccountTracker) Collect(ch chan<- prometheus.Metric) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	labeled := make(map[common.Address]bool, len(t.watchlist)+t.topN)
+	for account := range t.watchlist {
+		labeled[account] = true
+	}
+	for i, account := range t.byVolume() {
+		if i >= t.topN {
+			break
+		}
+		labeled[account] = true
+	}
+
+	var otherDeposits, otherBlocked uint64
+	for account, totals := range t.totals {
+		if !labeled[account] {
+			otherDeposits += totals.deposits
+			otherBlocked += totals.blocked
+			continue
+		}
+		if totals.deposits > 0 {
+			ch <- prometheus.MustNewConstMetric(t.depositsDesc, prometheus.GaugeValue, float64(totals.deposits), account.Hex())
+		}
+		if totals.blocked > 0 {
+			ch <- prometheus.MustNewConstMetric(t.blockedDesc, prometheus.GaugeValue, float64(totals.blocked), account.Hex())
+		}
+	}
+	ch <- prometheus.MustNewConstMetric(t.depositsDesc, prometheus.GaugeValue, float64(otherDeposits), otherAccount)
+	ch <- prometheus.MustNewConstMetric(t.blockedDesc, prometheus.GaugeValue, float64(otherBlocked), otherAccount)
+}
+
+// byVolume returns all tracked accounts, highest volume first. The caller
+// must hold the lock.
+func (t *AccountTracker) byVolume() []common.Address {
+	accounts := make([]common.Address, 0, len(t.totals))
+	for account := range t.totals {
+		accounts = append(accounts, account)
+	}
+	sort.Slice(accounts, func(i, j int) bool {
+		if c := t.totals[accounts[i]].volume.Cmp(t.totals[accounts[j]].volume); c != 0 {
+			return c > 0
+		}
+		return accounts[i].Hex() < accounts[j].Hex()
+	})
+	return accounts
+}
+
+// stats builds the API view of an account. The caller must hold the lock.
+func (t *AccountTracker) stats(account common.Address) AccountStats {
+	totals := t.totals[account]
+	return AccountStats{
+		Account:   account,
+		Deposits:  totals.deposits,
+		Blocked:   totals.blocked,
+		VolumeWei: totals.volume.String(),
+		Watched:   t.watchlist[account],
+	}
+}
+
+// ServeHTTP serves the per-account breakdown. /accounts lists all accounts by
+// volume with limit and offset query parameters, /accounts/{address} returns
+// a single account.
+func (t *AccountTracker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if address := strings.Trim(strings.TrimPrefix(r.URL.Path, "/accounts"), "/"); address != "" {
+		if !common.IsHexAddress(address) {
+			http.Error(w, "Invalid address", http.StatusBadRequest)
+			return
+		}
+		stats, ok := t.Account(common.HexToAddress(address))
+		if !ok {
+			http.Error(w, "Account not found", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, stats)
+		return
+	}
+
+	limit, err := queryInt(r, "limit", defaultAccountsLimit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	offset, err := queryInt(r, "offset", 0)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	accounts := t.Accounts()
+	total := len(accounts)
+	if offset > total {
+		offset = total
+	}
+	accounts = accounts[offset:]
+	if limit < len(accounts) {
+		accounts = accounts[:limit]
+	}
+
+	writeJSON(w, struct {
+		Total    int            `json:"total"`
+		Accounts []AccountStats `json:"accounts"`
+	}{total, accounts})
+}
+
+// queryInt parses a non-negative integer query parameter
+func queryInt(r *http.Request, name string, def int) (int, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid %s: %q", name, value)
+	}
+	return n, nil
+}
+
+// writeJSON encodes a value as the JSON response body
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
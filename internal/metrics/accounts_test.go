@@ -0,0 +1,173 @@
+package metrics
+
+import (
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	watched = common.HexToAddress("0x00000000000000000000000000000000000000a0")
+	large   = common.HexToAddress("0x00000000000000000000000000000000000000a1")
+	medium  = common.HexToAddress("0x00000000000000000000000000000000000000a2")
+	small   = common.HexToAddress("0x00000000000000000000000000000000000000a3")
+	frozen  = common.HexToAddress("0x00000000000000000000000000000000000000a4")
+)
+
+// series gathers the account metrics of a tracker as name{account} -> value
+func series(t *testing.T, tracker *AccountTracker) map[string]float64 {
+	t.Helper()
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(tracker)
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := make(map[string]float64)
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			values[family.GetName()+"{"+metric.GetLabel()[0].GetValue()+"}"] = metric.GetGauge().GetValue()
+		}
+	}
+	return values
+}
+
+func TestAccountTracker(t *testing.T) {
+	tracker := NewAccountTracker([]common.Address{watched}, 2)
+	tracker.Observe(watched, big.NewInt(1), false)
+	tracker.Observe(large, big.NewInt(100), false)
+	tracker.Observe(medium, big.NewInt(50), false)
+	tracker.Observe(small, big.NewInt(10), false)
+	tracker.Observe(frozen, big.NewInt(20), true)
+
+	tests := []struct {
+		name    string
+		observe func()
+		want    map[string]float64
+	}{
+		{
+			// The watchlist and the top two accounts by volume get labels
+			name:    "bounded",
+			observe: func() {},
+			want: map[string]float64{
+				depositsByAccountName + "{" + watched.Hex() + "}": 1,
+				depositsByAccountName + "{" + large.Hex() + "}":   1,
+				depositsByAccountName + "{" + medium.Hex() + "}":  1,
+				depositsByAccountName + "{other}":                 1,
+				blockedDepositsName + "{other}":                   1,
+			},
+		},
+		{
+			// An account that moves into the top two takes the label of another
+			name:    "top_changed",
+			observe: func() { tracker.Observe(small, big.NewInt(90), false) },
+			want: map[string]float64{
+				depositsByAccountName + "{" + watched.Hex() + "}": 1,
+				depositsByAccountName + "{" + large.Hex() + "}":   1,
+				depositsByAccountName + "{" + small.Hex() + "}":   2,
+				depositsByAccountName + "{other}":                 1,
+				blockedDepositsName + "{other}":                   1,
+			},
+		},
+		{
+			// Reorged deposits are taken off the counts and the volume
+			name: "reverted",
+			observe: func() {
+				tracker.Revert(small, big.NewInt(90), false)
+				tracker.Revert(frozen, big.NewInt(20), true)
+			},
+			want: map[string]float64{
+				depositsByAccountName + "{" + watched.Hex() + "}": 1,
+				depositsByAccountName + "{" + large.Hex() + "}":   1,
+				depositsByAccountName + "{" + medium.Hex() + "}":  1,
+				depositsByAccountName + "{other}":                 1,
+				blockedDepositsName + "{other}":                   0,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.observe()
+			if got := series(t, tracker); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("series = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAccountsAPI(t *testing.T) {
+	tracker := NewAccountTracker([]common.Address{watched}, 0)
+	tracker.Observe(watched, big.NewInt(1), false)
+	tracker.Observe(large, big.NewInt(100), false)
+	tracker.Observe(medium, big.NewInt(50), false)
+	tracker.Observe(frozen, big.NewInt(20), true)
+
+	tests := []struct {
+		name         string
+		path         string
+		wantStatus   int
+		wantAccounts []common.Address // Listed accounts in order
+		wantTotal    int
+	}{
+		{name: "all", path: "/accounts", wantStatus: http.StatusOK, wantAccounts: []common.Address{large, medium, frozen, watched}, wantTotal: 4},
+		{name: "page", path: "/accounts?limit=2&offset=1", wantStatus: http.StatusOK, wantAccounts: []common.Address{medium, frozen}, wantTotal: 4},
+		{name: "beyond_last_page", path: "/accounts?offset=10", wantStatus: http.StatusOK, wantAccounts: []common.Address{}, wantTotal: 4},
+		{name: "invalid_limit", path: "/accounts?limit=-1", wantStatus: http.StatusBadRequest},
+		{name: "account", path: "/accounts/" + frozen.Hex(), wantStatus: http.StatusOK, wantAccounts: []common.Address{frozen}},
+		{name: "unknown_account", path: "/accounts/" + small.Hex(), wantStatus: http.StatusNotFound},
+		{name: "invalid_account", path: "/accounts/0x12", wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			tracker.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			var resp struct {
+				Total    int            `json:"total"`
+				Accounts []AccountStats `json:"accounts"`
+			}
+			if tt.wantTotal == 0 {
+				var stats AccountStats
+				json.Unmarshal(rec.Body.Bytes(), &stats)
+				resp.Accounts = []AccountStats{stats}
+			} else {
+				json.Unmarshal(rec.Body.Bytes(), &resp)
+			}
+			accounts := make([]common.Address, len(resp.Accounts))
+			for i, stats := range resp.Accounts {
+				accounts[i] = stats.Account
+			}
+			if !reflect.DeepEqual(accounts, tt.wantAccounts) {
+				t.Errorf("accounts = %v, want %v", accounts, tt.wantAccounts)
+			}
+			if resp.Total != tt.wantTotal {
+				t.Errorf("total = %d, want %d", resp.Total, tt.wantTotal)
+			}
+		})
+	}
+
+	t.Run("totals", func(t *testing.T) {
+		stats, _ := tracker.Account(frozen)
+		want := AccountStats{Account: frozen, Blocked: 1, VolumeWei: "20"}
+		if stats != want {
+			t.Errorf("stats = %+v, want %+v", stats, want)
+		}
+		if stats, _ := tracker.Account(watched); !stats.Watched {
+			t.Error("watchlist account not marked as watched")
+		}
+	})
+}
@@ -1,11 +1,16 @@
 package metrics
 
 import (
+	"context"
 	"fmt"
-	"log"
+	"log/slog"
+	"math/big"
 	"net/http"
 	"time"
 
+	"github.com/ddomeke/rpc_proxy/internal/config"
+	"github.com/ddomeke/rpc_proxy/pkg/utils"
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
@@ -14,48 +19,342 @@ import (
 // Collector holds all the Prometheus metrics
 type Collector struct {
 	// Current metrics
-	TotalDeposits         prometheus.Counter
-	BlockedDeposits       *prometheus.CounterVec
-	DepositsByAccount     *prometheus.CounterVec
+	TotalDeposits         prometheus.Gauge
 	DepositValueHistogram prometheus.Histogram
+	ReorgedDeposits       *prometheus.CounterVec
+	RejectedTransactions  *prometheus.CounterVec
+
+	// L2 deposit confirmations
+	L2DepositConfirmations  *prometheus.CounterVec
+	DepositConfirmationTime prometheus.Histogram
+
+	// Frozen accounts cache
+	FrozenAccounts     prometheus.Gauge
+	FrozenSetStaleness prometheus.Gauge
+
+	// JSON-RPC proxy
+	ProxyRequests            *prometheus.CounterVec
+	ProxyRequestDuration     *prometheus.HistogramVec
+	ProxyRequestsInFlight    *prometheus.GaugeVec
+	ProxyRequestSize         *prometheus.HistogramVec
+	ProxyResponseSize        *prometheus.HistogramVec
+	UpstreamRequestDuration  *prometheus.HistogramVec
+	UpstreamRequestsInFlight *prometheus.GaugeVec
+	FilteredLogs             *prometheus.CounterVec
+	PolicyRejections         *prometheus.CounterVec
+	CoalescedRequests        *prometheus.CounterVec
+
+	// API key usage
+	APIKeyRequests     *prometheus.CounterVec
+	APIKeyComputeUnits *prometheus.CounterVec
+	APIKeyDailyUsage   *prometheus.GaugeVec
+
+	// Rate limiting
+	RateLimitedCalls       *prometheus.CounterVec
+	RateLimitBackendErrors prometheus.Counter
+	RateLimitFallback      prometheus.Gauge
+
+	// Response cache
+	CacheLookups       *prometheus.CounterVec
+	CacheHitRatio      prometheus.Gauge
+	CacheEntries       prometheus.Gauge
+	CacheSizeBytes     prometheus.Gauge
+	CacheInvalidations *prometheus.CounterVec
+
+	// Configuration reloads
+	ConfigReloads           *prometheus.CounterVec
+	ConfigLastReloadSuccess prometheus.Gauge
+
+	// Per-account deposit totals, also served by the /accounts API
+	Accounts *AccountTracker
+
+	// Deposit counters with one label per sender account, only registered
+	// when account labels are not bounded
+	boundedAccounts   bool
+	blockedDeposits   *prometheus.GaugeVec
+	depositsByAccount *prometheus.GaugeVec
 }
 
 // NewCollector creates a new metrics collector with initialized metrics
-func NewCollector() *Collector {
+func NewCollector(cfg config.MetricsConfig) *Collector {
+	watchlist := make([]common.Address, len(cfg.AccountWatchlist))
+	for i, account := range cfg.AccountWatchlist {
+		watchlist[i] = common.HexToAddress(account)
+	}
+	accounts := NewAccountTracker(watchlist, cfg.AccountTopN)
+	accountFactory := promauto.With(prometheus.DefaultRegisterer)
+	if cfg.AccountLabels == "bounded" {
+		// The tracker exports the account series with bounded labels instead
+		prometheus.MustRegister(accounts)
+		accountFactory = promauto.With(nil)
+	}
+
 	return &Collector{
-		TotalDeposits: promauto.NewCounter(
-			prometheus.CounterOpts{
-				Name: "opstack_total_deposits",
-				Help: "Total number of deposits through OptimismPortal",
-			}),
+		Accounts:        accounts,
+		boundedAccounts: cfg.AccountLabels == "bounded",
 
-		BlockedDeposits: promauto.NewCounterVec(
-			prometheus.CounterOpts{
-				Name: "opstack_blocked_deposits",
-				Help: "Total number of blocked deposits from frozen accounts",
+		blockedDeposits: accountFactory.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: blockedDepositsName,
+				Help: blockedDepositsHelp,
 			},
 			[]string{"account"}),
 
-		DepositsByAccount: promauto.NewCounterVec(
-			prometheus.CounterOpts{
-				Name: "opstack_deposits_by_account",
-				Help: "Number of deposits grouped by sender account",
+		depositsByAccount: accountFactory.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: depositsByAccountName,
+				Help: depositsByAccountHelp,
 			},
 			[]string{"account"}),
 
+		TotalDeposits: promauto.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "opstack_total_deposits",
+				Help: "Total number of deposits through OptimismPortal, without deposits reorged out of L1",
+			}),
+
 		DepositValueHistogram: promauto.NewHistogram(
 			prometheus.HistogramOpts{
 				Name:    "opstack_deposit_value",
 				Help:    "Distribution of deposit values in ETH",
 				Buckets: prometheus.ExponentialBuckets(0.001, 10, 7), // 0.001 ETH to 1000 ETH
 			}),
+
+		ReorgedDeposits: promauto.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "opstack_reorged_deposits",
+				Help: "Number of counted deposits whose L1 block was reorged out, by status before the reorg",
+			},
+			[]string{"status"}),
+
+		RejectedTransactions: promauto.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "opstack_rejected_transactions",
+				Help: "Total number of raw transactions rejected because a frozen account is involved",
+			},
+			[]string{"route", "reason"}),
+
+		L2DepositConfirmations: promauto.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "opstack_l2_deposit_confirmations",
+				Help: "Number of deposits included on L2, grouped by receipt status",
+			},
+			[]string{"status"}),
+
+		DepositConfirmationTime: promauto.NewHistogram(
+			prometheus.HistogramOpts{
+				Name:    "opstack_deposit_confirmation_seconds",
+				Help:    "Time between the L1 deposit and its inclusion on L2 in seconds",
+				Buckets: prometheus.ExponentialBuckets(1, 2, 12), // 1s to ~1h
+			}),
+
+		FrozenAccounts: promauto.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "opstack_frozen_accounts",
+				Help: "Number of accounts in the in-memory frozen set",
+			}),
+
+		FrozenSetStaleness: promauto.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "opstack_frozen_set_staleness_seconds",
+				Help: "Seconds since the frozen set was last confirmed current against L1, or since startup until the first sync",
+			}),
+
+		ProxyRequests: promauto.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "opstack_proxy_requests_total",
+				Help: "Number of proxied JSON-RPC requests by route, method, upstream and outcome",
+			},
+			[]string{"route", "method", "upstream", "outcome"}),
+
+		ProxyRequestDuration: promauto.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "opstack_proxy_request_duration_seconds",
+				Help:    "Time to handle a proxied JSON-RPC request in seconds",
+				Buckets: prometheus.ExponentialBuckets(0.005, 2, 12), // 5ms to ~10s
+			},
+			[]string{"route", "method", "outcome"}),
+
+		ProxyRequestsInFlight: promauto.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "opstack_proxy_requests_in_flight",
+				Help: "Number of JSON-RPC requests currently being handled by route",
+			},
+			[]string{"route"}),
+
+		ProxyRequestSize: promauto.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "opstack_proxy_request_size_bytes",
+				Help:    "Size of proxied HTTP request bodies in bytes",
+				Buckets: prometheus.ExponentialBuckets(64, 4, 9), // 64B to 4MB
+			},
+			[]string{"route"}),
+
+		ProxyResponseSize: promauto.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "opstack_proxy_response_size_bytes",
+				Help:    "Size of proxied HTTP response bodies in bytes",
+				Buckets: prometheus.ExponentialBuckets(64, 4, 11), // 64B to 64MB
+			},
+			[]string{"route"}),
+
+		UpstreamRequestDuration: promauto.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "opstack_upstream_request_duration_seconds",
+				Help:    "Latency of proxied requests to the upstream nodes in seconds, including retries",
+				Buckets: prometheus.ExponentialBuckets(0.005, 2, 12), // 5ms to ~10s
+			},
+			[]string{"pool", "upstream"}),
+
+		UpstreamRequestsInFlight: promauto.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "opstack_upstream_requests_in_flight",
+				Help: "Number of proxied requests currently waiting for an upstream pool",
+			},
+			[]string{"pool"}),
+
+		FilteredLogs: promauto.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "opstack_proxy_filtered_logs_total",
+				Help: "Number of TransactionDeposited logs from frozen accounts removed from proxied responses",
+			},
+			[]string{"route", "source"}),
+
+		PolicyRejections: promauto.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "opstack_proxy_policy_rejections_total",
+				Help: "Number of JSON-RPC calls rejected by a route policy, by route and rule",
+			},
+			[]string{"route", "rule"}),
+
+		CoalescedRequests: promauto.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "opstack_proxy_coalesced_requests_total",
+				Help: "Number of JSON-RPC calls answered with the upstream response of an identical call in flight, by route and method",
+			},
+			[]string{"route", "method"}),
+
+		APIKeyRequests: promauto.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "opstack_api_key_requests_total",
+				Help: "Number of JSON-RPC calls by API key and outcome (allowed, unauthorized, forbidden, rate_limited, quota_exceeded)",
+			},
+			[]string{"key", "outcome"}),
+
+		APIKeyComputeUnits: promauto.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "opstack_api_key_compute_units_total",
+				Help: "Compute units charged by API key",
+			},
+			[]string{"key"}),
+
+		APIKeyDailyUsage: promauto.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "opstack_api_key_daily_usage",
+				Help: "Usage of the current UTC day by API key and quota (requests or compute_units)",
+			},
+			[]string{"key", "quota"}),
+
+		RateLimitedCalls: promauto.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "opstack_rate_limited_total",
+				Help: "Number of JSON-RPC calls rejected by a rate limit, by scope (ip or key) and method class (cheap or heavy)",
+			},
+			[]string{"scope", "class"}),
+
+		RateLimitBackendErrors: promauto.NewCounter(
+			prometheus.CounterOpts{
+				Name: "opstack_rate_limit_backend_errors_total",
+				Help: "Number of failed calls to the shared rate limit backend",
+			}),
+
+		RateLimitFallback: promauto.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "opstack_rate_limit_fallback",
+				Help: "Whether calls are rate limited in process because the shared backend failed (1) or not (0)",
+			}),
+
+		CacheLookups: promauto.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "opstack_cache_lookups_total",
+				Help: "Number of response cache lookups of cacheable calls, by method and result (hit or miss)",
+			},
+			[]string{"method", "result"}),
+
+		CacheHitRatio: promauto.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "opstack_cache_hit_ratio",
+				Help: "Share of response cache lookups that were hits since the start",
+			}),
+
+		CacheEntries: promauto.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "opstack_cache_entries",
+				Help: "Number of results in the in-memory response cache",
+			}),
+
+		CacheSizeBytes: promauto.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "opstack_cache_size_bytes",
+				Help: "Approximate memory of the results in the in-memory response cache",
+			}),
+
+		CacheInvalidations: promauto.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "opstack_cache_invalidations_total",
+				Help: "Number of cached results of recent blocks dropped on reorgs, by chain ID",
+			},
+			[]string{"chain"}),
+
+		ConfigReloads: promauto.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "opstack_config_reloads_total",
+				Help: "Number of configuration reloads by result (success or failure)",
+			},
+			[]string{"result"}),
+
+		ConfigLastReloadSuccess: promauto.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "opstack_config_last_reload_success_timestamp_seconds",
+				Help: "Unix time of the last successful configuration reload",
+			}),
+	}
+}
+
+// ObserveAccountDeposit counts a deposit of a sender account, as blocked if
+// the sender is frozen
+func (c *Collector) ObserveAccountDeposit(account common.Address, value *big.Int, blocked bool) {
+	c.Accounts.Observe(account, value, blocked)
+	if c.boundedAccounts {
+		return
+	}
+	if blocked {
+		c.blockedDeposits.WithLabelValues(account.Hex()).Inc()
+	} else {
+		c.depositsByAccount.WithLabelValues(account.Hex()).Inc()
 	}
 }
 
-// StartServer starts the /metrics endpoint for Prometheus
-func StartServer(metricsPort string) {
+// RevertAccountDeposit takes a deposit of a sender account that was reorged
+// out of L1 off its counts
+func (c *Collector) RevertAccountDeposit(account common.Address, value *big.Int, blocked bool) {
+	c.Accounts.Revert(account, value, blocked)
+	if c.boundedAccounts {
+		return
+	}
+	if blocked {
+		c.blockedDeposits.WithLabelValues(account.Hex()).Dec()
+	} else {
+		c.depositsByAccount.WithLabelValues(account.Hex()).Dec()
+	}
+}
+
+// StartServer serves the /metrics endpoint for Prometheus, the /accounts
+// per-account breakdown and the given operational handlers by pattern until
+// the context is cancelled
+func StartServer(ctx context.Context, metricsPort string, collector *Collector, handlers map[string]http.Handler, drainTimeout time.Duration) error {
 	if metricsPort == "" {
-		log.Println("[WARN] METRICS_PORT environment variable not set, using default port 9100")
+		slog.Warn("METRICS_PORT environment variable not set, using default port 9100")
 		metricsPort = "9100"
 	}
 
@@ -64,25 +363,31 @@ func StartServer(metricsPort string) {
 	// Create a separate mux for metrics to avoid conflicts with the main RPC handler
 	mux := http.NewServeMux()
 	mux.Handle("/metrics", promhttp.Handler())
+	mux.Handle("/accounts", collector.Accounts)
+	mux.Handle("/accounts/", collector.Accounts)
+	for pattern, handler := range handlers {
+		mux.Handle(pattern, handler)
+	}
 
-	log.Printf("[INFO] Starting Prometheus metrics server on %s\n", metricsAddr)
-
-	// Start the server in a goroutine
-	go func() {
-		err := http.ListenAndServe(metricsAddr, mux)
-		if err != nil {
-			log.Fatalf("[ERROR] Could not start Prometheus metrics server: %v", err)
-		}
-	}()
+	slog.Info("Starting Prometheus metrics server", "addr", metricsAddr)
 
 	// Add a quick check to verify the server is running
 	go func() {
-		time.Sleep(2 * time.Second) // Give the server time to start
+		if !utils.SleepContext(ctx, 2*time.Second) { // Give the server time to start
+			return
+		}
 		_, err := http.Get(fmt.Sprintf("http://localhost:%s/metrics", metricsPort))
 		if err != nil {
-			log.Printf("[WARN] Metrics server may not be running correctly: %v", err)
+			slog.Warn("Metrics server may not be running correctly", "error", err)
 		} else {
-			log.Printf("[INFO] Metrics server verified running on port %s", metricsPort)
+			slog.Info("Metrics server verified running", "port", metricsPort)
 		}
 	}()
+
+	srv := &http.Server{Addr: metricsAddr, Handler: mux}
+	if err := utils.Serve(ctx, srv, drainTimeout); err != nil {
+		return fmt.Errorf("metrics server: %v", err)
+	}
+	slog.Info("Prometheus metrics server stopped")
+	return nil
 }
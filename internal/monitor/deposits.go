@@ -0,0 +1,45 @@
+package monitor
+
+import (
+	"github.com/ddomeke/rpc_proxy/internal/eth"
+	"github.com/ddomeke/rpc_proxy/internal/metrics"
+	"github.com/ddomeke/rpc_proxy/internal/store"
+	"github.com/ddomeke/rpc_proxy/pkg/utils"
+)
+
+// recordDeposit stores a deposit with its frozen verdict and updates the
+// deposit metrics. Deposits that are already in the store are not counted
+// again, so a deposit seen again after a restart or backfill is counted once.
+// A deposit restored after a reorg is added back to the counts its revert
+// took it off, but its value is not observed again.
+func recordDeposit(depositStore store.DepositStore, metricsCollector *metrics.Collector, deposit *eth.DepositEvent, frozen bool) (bool, error) {
+	status := store.StatusObserved
+	if frozen {
+		status = store.StatusBlocked
+	}
+
+	// Only reorged deposits are saved again
+	_, err := depositStore.GetDeposit(deposit.SourceHash)
+	restored := err == nil
+
+	created, err := depositStore.SaveDeposit(&store.Deposit{
+		DepositEvent: *deposit,
+		Frozen:       frozen,
+		Status:       status,
+	})
+	if err != nil || !created {
+		return created, err
+	}
+
+	metricsCollector.ObserveAccountDeposit(deposit.From, deposit.Value, frozen)
+	if frozen {
+		return true, nil
+	}
+
+	// Update deposit metrics
+	metricsCollector.TotalDeposits.Inc()
+	if !restored {
+		metricsCollector.DepositValueHistogram.Observe(utils.WeiToEther(deposit.Value))
+	}
+	return true, nil
+}
@@ -2,14 +2,19 @@ package monitor
 
 import (
 	"context"
-	"log"
+	"errors"
+	"fmt"
+	"log/slog"
 	"math/big"
+	"sort"
 	"sync"
 	"time"
 
 	"github.com/ddomeke/rpc_proxy/internal/config"
 	"github.com/ddomeke/rpc_proxy/internal/eth"
 	"github.com/ddomeke/rpc_proxy/internal/metrics"
+	"github.com/ddomeke/rpc_proxy/internal/store"
+	"github.com/ddomeke/rpc_proxy/pkg/utils"
 	"github.com/ethereum/go-ethereum"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
@@ -18,115 +23,528 @@ import (
 
 const retryDelay = 5 * time.Second // Retry delay in case of errors
 
-// Global deposit tracking
-var (
-	pendingDeposits      = make(map[common.Hash]*eth.DepositEvent)
-	pendingDepositsMutex sync.RWMutex
-)
+// confirmationInterval is how often queued deposits are checked for enough
+// confirmations and the checkpoint is advanced
+const confirmationInterval = 2 * time.Second
+
+// L1CheckpointName is the store cursor of the last fully processed L1 block
+const L1CheckpointName = "l1_deposits"
+
+// wsRetryInterval is how long auto mode polls before trying the websocket again
+const wsRetryInterval = 5 * time.Minute
+
+// errReloaded ends the current event source after a configuration change
+// that needs it restarted
+var errReloaded = errors.New("configuration reloaded")
+
+// logKey identifies a log by its position in a specific L1 block
+type logKey struct {
+	blockHash common.Hash
+	index     uint
+}
+
+// L1Monitor listens for deposit events on L1
+type L1Monitor struct {
+	clients          *eth.Clients
+	frozenSet        *eth.FrozenSet
+	depositStore     store.DepositStore
+	cfg              *config.Config
+	metricsCollector *metrics.Collector
+	query            ethereum.FilterQuery
+
+	// Deposits waiting for the configured confirmation depth
+	queue map[logKey]types.Log
+
+	// Consecutive websocket failures and the end of the current polling
+	// fallback in auto mode
+	wsFailures    int
+	fallbackUntil time.Time
 
-// GetPendingDeposits returns a copy of the current pending deposits map
-func GetPendingDeposits() map[common.Hash]*eth.DepositEvent {
-	pendingDepositsMutex.RLock()
-	defer pendingDepositsMutex.RUnlock()
+	// Reloaded configuration, picked up by the listener goroutine
+	reloads chan *config.Config
 
-	// Create a copy to avoid concurrent access issues
-	result := make(map[common.Hash]*eth.DepositEvent)
-	for k, v := range pendingDeposits {
-		result[k] = v
+	// Event source state for health checks, read by other goroutines
+	statusMu     sync.RWMutex
+	status       L1Status
+	pollInterval time.Duration
+}
+
+// NewL1Monitor creates the L1 deposit listener; call Run to start it
+func NewL1Monitor(clients *eth.Clients, frozenSet *eth.FrozenSet, depositStore store.DepositStore, cfg *config.Config, metricsCollector *metrics.Collector) *L1Monitor {
+	return &L1Monitor{
+		clients:          clients,
+		frozenSet:        frozenSet,
+		depositStore:     depositStore,
+		cfg:              cfg,
+		metricsCollector: metricsCollector,
+		query:            depositQuery(cfg),
+		queue:            make(map[logKey]types.Log),
+		reloads:          make(chan *config.Config, 1),
+	}
+}
+
+// depositQuery filters TransactionDeposited events at the OptimismPortal address
+func depositQuery(cfg *config.Config) ethereum.FilterQuery {
+	return ethereum.FilterQuery{
+		Addresses: []common.Address{common.HexToAddress(cfg.OptimismPortalAddress)},
+		Topics:    [][]common.Hash{{eth.DepositEventTopic}},
 	}
-	return result
 }
 
-// UpdatePendingDeposits adds a deposit to pending deposits
-func UpdatePendingDeposits(deposit *eth.DepositEvent) {
-	pendingDepositsMutex.Lock()
-	defer pendingDepositsMutex.Unlock()
-	pendingDeposits[deposit.Hash] = deposit
+// PrepareReload returns the function that hands a new configuration to the
+// listener. The subscription is only restarted if the portal address, the
+// websocket URL or the ingest settings changed.
+func (m *L1Monitor) PrepareReload(cfg *config.Config) (func(), error) {
+	return func() {
+		// Only the latest configuration matters if the listener is busy
+		select {
+		case <-m.reloads:
+		default:
+		}
+		m.reloads <- cfg
+	}, nil
 }
 
-// RemovePendingDeposit removes a deposit from pending deposits
-func RemovePendingDeposit(hash common.Hash) {
-	pendingDepositsMutex.Lock()
-	defer pendingDepositsMutex.Unlock()
-	delete(pendingDeposits, hash)
+// Run listens for deposit events on L1 until the context is cancelled
+func (m *L1Monitor) Run(ctx context.Context) {
+	slog.Info("Starting L1 deposit event listener")
+	defer slog.Info("L1 deposit event listener stopped")
+
+	for ctx.Err() == nil {
+		m.checkReload()
+
+		// Reorgs that happened while disconnected never show up as removed logs
+		if err := m.checkReorgs(ctx); err != nil && ctx.Err() == nil {
+			slog.Error("L1 reorg check failed", "error", err)
+		}
+
+		// Catch up on deposits emitted while the listener was down
+		if err := m.backfill(ctx); err != nil {
+			if ctx.Err() == nil {
+				slog.Error("L1 deposit backfill failed", "error", err)
+			}
+			utils.SleepContext(ctx, retryDelay)
+			continue
+		}
+
+		var err error
+		switch mode := m.ingestMode(); mode {
+		case "ws":
+			if err = m.subscribe(ctx); err != nil && err != errReloaded && ctx.Err() == nil {
+				m.wsFailures++
+			}
+		case "filter":
+			err = m.pollFilter(ctx, m.fallbackUntil)
+		default:
+			err = m.poll(ctx, m.fallbackUntil)
+		}
+		if err == errReloaded || ctx.Err() != nil {
+			continue
+		}
+		if err != nil {
+			slog.Error("L1 event listening error", "error", err)
+		}
+		utils.SleepContext(ctx, retryDelay) // Reconnect
+	}
 }
 
-// ListenL1DepositEvents listens for deposit events on L1
-func ListenL1DepositEvents(clients *eth.Clients, cfg *config.Config, metricsCollector *metrics.Collector) {
-	log.Println("[INFO] Starting L1 Deposit event listener...")
+// checkReload applies a pending configuration change and reports whether the
+// current event source must be restarted for it to take effect
+func (m *L1Monitor) checkReload() bool {
+	var cfg *config.Config
+	select {
+	case cfg = <-m.reloads:
+	default:
+		return false
+	}
+
+	old := m.cfg
+	m.cfg = cfg
+	restart := old.L1RPCURLWs != cfg.L1RPCURLWs ||
+		old.Monitor.IngestMode != cfg.Monitor.IngestMode ||
+		old.Monitor.PollInterval != cfg.Monitor.PollInterval
+	if restart {
+		m.wsFailures = 0
+		m.fallbackUntil = time.Time{}
+	}
+
+	if query := depositQuery(cfg); query.Addresses[0] != m.query.Addresses[0] {
+		// Queued logs were emitted by the previous portal
+		slog.Info("OptimismPortal address changed, following the new portal", "address", query.Addresses[0].Hex())
+		m.query = query
+		m.queue = make(map[logKey]types.Log)
+		restart = true
+	}
+	return restart
+}
 
-	// Listen for TransactionDeposited events at the OptimismPortal address
-	portalAddress := common.HexToAddress(cfg.OptimismPortalAddress)
-	depositEventSignature := common.HexToHash("0x35d79ab81f2b2017e19afb5c5571778877782d7a8786f5907f93b0f4702f4f23")
+// ingestMode returns how L1 events are received next. Auto mode uses the
+// websocket when one is configured and falls back to eth_getLogs polling for
+// a while after repeated websocket failures.
+func (m *L1Monitor) ingestMode() string {
+	mode := m.cfg.Monitor.IngestMode
+	if mode != "auto" {
+		return mode
+	}
+	if m.cfg.L1RPCURLWs == "" {
+		return "poll"
+	}
 
-	query := ethereum.FilterQuery{
-		Addresses: []common.Address{portalAddress},
-		Topics:    [][]common.Hash{{depositEventSignature}},
+	if m.wsFailures >= m.cfg.Monitor.WSMaxFailures {
+		slog.Warn("L1 websocket keeps failing, polling over HTTP", "failures", m.wsFailures, "retry_in", wsRetryInterval)
+		m.wsFailures = 0
+		m.fallbackUntil = time.Now().Add(wsRetryInterval)
+	}
+	if time.Now().Before(m.fallbackUntil) {
+		return "poll"
 	}
+	m.fallbackUntil = time.Time{}
+	return "ws"
+}
 
+// subscribe processes live deposit events until the subscription fails or
+// the context is cancelled
+func (m *L1Monitor) subscribe(ctx context.Context) error {
 	// Connect to WebSocket for event subscription
-	l1Clientws, err := ethclient.Dial(cfg.L1RPCURLWs)
+	l1Clientws, err := ethclient.DialContext(ctx, m.cfg.L1RPCURLWs)
 	if err != nil {
-		log.Fatalf("[ERROR] Could not connect to L1 websocket: %v", err)
+		return fmt.Errorf("could not connect to L1 websocket: %v", err)
 	}
+	defer l1Clientws.Close()
 
 	logs := make(chan types.Log)
-	sub, err := l1Clientws.SubscribeFilterLogs(context.Background(), query, logs)
+	sub, err := l1Clientws.SubscribeFilterLogs(ctx, m.query, logs)
 	if err != nil {
-		log.Fatalf("[ERROR] L1 deposit event subscription failed: %v", err)
+		return fmt.Errorf("L1 deposit event subscription failed: %v", err)
 	}
+	defer sub.Unsubscribe()
+
+	slog.Info("Subscribed to L1 deposit events over websocket")
+	m.wsFailures = 0
+	m.setStatus("ws", true)
+	defer m.setStatus("ws", false)
+
+	ticker := time.NewTicker(confirmationInterval)
+	defer ticker.Stop()
 
 	for {
 		select {
+		case <-ctx.Done():
+			return ctx.Err()
 		case err := <-sub.Err():
-			log.Printf("[ERROR] L1 event listening error: %v", err)
-			time.Sleep(retryDelay)
-			go ListenL1DepositEvents(clients, cfg, metricsCollector) // Reconnect
-			return
+			return err
 		case logEntry := <-logs:
-			// Decode TransactionDeposited event
-			deposit, err := eth.DecodeDepositEvent(clients, logEntry)
-
-			log.Printf("[DEBUG] Event topic count: %d", len(logEntry.Topics))
-			for i, topic := range logEntry.Topics {
-				log.Printf("[DEBUG] Topic %d: %s", i, topic.Hex())
+			m.handleLog(ctx, logEntry)
+		case <-ticker.C:
+			if m.checkReload() {
+				return errReloaded
 			}
-			log.Printf("[DEBUG] Data length: %d", len(logEntry.Data))
+			m.sweep(ctx)
+			m.setStatus("ws", true)
+		}
+	}
+}
 
-			if err != nil {
-				log.Printf("[ERROR] Deposit event parsing error: %v", err)
+// sweep processes queued deposits that are now confirmed and scans the newly
+// confirmed blocks, so the checkpoint only covers fully processed blocks
+func (m *L1Monitor) sweep(ctx context.Context) {
+	m.processConfirmed(ctx)
+	if err := m.backfill(ctx); err != nil && ctx.Err() == nil {
+		slog.Error("L1 deposit sweep failed", "error", err)
+	}
+}
+
+// handleLog queues a new deposit log or reverts a removed one
+func (m *L1Monitor) handleLog(ctx context.Context, logEntry types.Log) {
+	if slog.Default().Enabled(ctx, slog.LevelDebug) {
+		topics := make([]string, len(logEntry.Topics))
+		for i, topic := range logEntry.Topics {
+			topics[i] = topic.Hex()
+		}
+		slog.Debug("L1 deposit event received", "block", logEntry.BlockNumber, "log_index", logEntry.Index,
+			"removed", logEntry.Removed, "topics", topics, "data_length", len(logEntry.Data))
+	}
+
+	key := logKey{blockHash: logEntry.BlockHash, index: logEntry.Index}
+
+	if logEntry.Removed {
+		// Not processed yet, so nothing was counted
+		if _, queued := m.queue[key]; queued {
+			delete(m.queue, key)
+			slog.Info("Unconfirmed deposit removed by L1 reorg", "block", logEntry.BlockNumber, "log_index", logEntry.Index)
+			return
+		}
+		m.revertDeposit(eth.DepositSourceHash(logEntry.BlockHash, logEntry.Index))
+		return
+	}
+
+	m.queue[key] = logEntry
+	if m.cfg.Monitor.ConfirmationDepth == 0 {
+		m.processConfirmed(ctx)
+	}
+}
+
+// processConfirmed processes queued deposits that reached the confirmation
+// depth, in L1 order. Deposits whose block is no longer canonical are dropped.
+func (m *L1Monitor) processConfirmed(ctx context.Context) {
+	if len(m.queue) == 0 {
+		return
+	}
+
+	depth := m.cfg.Monitor.ConfirmationDepth
+	var head uint64
+	if depth > 0 {
+		var err error
+		head, err = m.clients.L1Client.BlockNumber(ctx)
+		if err != nil {
+			slog.Error("Could not get L1 block number", "error", err)
+			return
+		}
+	}
+
+	var ready []types.Log
+	for _, logEntry := range m.queue {
+		if depth == 0 || logEntry.BlockNumber+depth <= head {
+			ready = append(ready, logEntry)
+		}
+	}
+	sort.Slice(ready, func(i, j int) bool {
+		if ready[i].BlockNumber != ready[j].BlockNumber {
+			return ready[i].BlockNumber < ready[j].BlockNumber
+		}
+		return ready[i].Index < ready[j].Index
+	})
+
+	canonical := make(map[uint64]common.Hash)
+	for _, logEntry := range ready {
+		if depth > 0 {
+			hash, ok := canonical[logEntry.BlockNumber]
+			if !ok {
+				header, err := m.clients.L1Client.HeaderByNumber(ctx, new(big.Int).SetUint64(logEntry.BlockNumber))
+				if err != nil {
+					// Keep it queued and retry on the next tick
+					slog.Error("Could not get L1 block", "block", logEntry.BlockNumber, "error", err)
+					continue
+				}
+				hash = header.Hash()
+				canonical[logEntry.BlockNumber] = hash
+			}
+			if hash != logEntry.BlockHash {
+				slog.Info("Unconfirmed deposit dropped, L1 block was reorged", "block", logEntry.BlockNumber)
+				delete(m.queue, logKey{blockHash: logEntry.BlockHash, index: logEntry.Index})
 				continue
 			}
+		}
 
-			// Check if address is frozen
-			frozen, err := eth.CheckIfAddressIsFrozen(cfg, deposit.From.Hex())
-			if err != nil {
-				log.Printf("[ERROR] Address check error: %v", err)
-			} else if frozen {
-				// Block deposit from frozen account
-				log.Printf("[INFO] Deposit from frozen account blocked: %s", deposit.From.Hex())
+		if err := m.processLog(ctx, logEntry); err != nil {
+			if ctx.Err() != nil {
+				return // Interrupted, stays queued
+			}
+			// Keep it queued and retry on the next tick
+			continue
+		}
+		delete(m.queue, logKey{blockHash: logEntry.BlockHash, index: logEntry.Index})
+	}
+}
 
-				metricsCollector.BlockedDeposits.WithLabelValues(deposit.From.Hex()).Inc()
-				continue
+// processLog decodes a confirmed deposit, checks the sender and records it.
+// Deposits that are already in the store are skipped, unless they were
+// reorged out and their log is canonical again. An error is returned if
+// the deposit was not recorded and must be processed again, because the
+// sender could not be checked, it could not be stored or the context was
+// cancelled.
+func (m *L1Monitor) processLog(ctx context.Context, logEntry types.Log) error {
+	// Decode TransactionDeposited event
+	deposit, err := eth.DecodeDepositLog(m.clients.PortalABI, logEntry)
+	if err != nil {
+		slog.Error("Deposit event parsing error", "error", err)
+		return nil
+	}
+	if existing, err := m.depositStore.GetDeposit(deposit.SourceHash); err == nil && existing.Status != store.StatusReorged {
+		return nil
+	}
+	deposit.Timestamp = eth.BlockTimestamp(m.clients, deposit.BlockNum)
+
+	// Check if address is frozen
+	frozen, err := m.frozenSet.IsFrozen(ctx, deposit.From)
+	if err != nil {
+		// A deposit that could not be checked must not be recorded as not frozen
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		slog.Error("Address check error, deposit will be retried", "address", deposit.From.Hex(), "source_hash", deposit.SourceHash.Hex(), "error", err)
+		return err
+	}
+
+	// Store the deposit; it stays pending until it is seen on L2
+	created, err := recordDeposit(m.depositStore, m.metricsCollector, deposit, frozen)
+	if err != nil {
+		slog.Error("Could not store deposit, deposit will be retried", "source_hash", deposit.SourceHash.Hex(), "error", err)
+		return err
+	}
+	if !created {
+		slog.Debug("Deposit already recorded", "source_hash", deposit.SourceHash.Hex())
+		return nil
+	}
+
+	if frozen {
+		// Block deposit from frozen account
+		slog.Info("Deposit from frozen account blocked", "from", deposit.From.Hex(), "source_hash", deposit.SourceHash.Hex())
+		return nil
+	}
+
+	slog.Info("New deposit recorded", "from", deposit.From.Hex(), "to", deposit.To.Hex(),
+		"value_eth", utils.WeiToEther(deposit.Value), "mint_wei", deposit.Mint.String(), "gas", deposit.GasLimit,
+		"l2_tx", deposit.L2TxHash.Hex())
+
+	// L2 may have included it before it was confirmed here, after the L2
+	// monitor checked that block; the periodic pending check retries errors
+	stored, err := m.depositStore.GetDeposit(deposit.SourceHash)
+	if err == nil {
+		_, err = confirmIfIncluded(ctx, m.clients, m.depositStore, m.metricsCollector, stored)
+	}
+	if err != nil && ctx.Err() == nil {
+		slog.Warn("Could not check L2 inclusion of new deposit", "l2_tx", deposit.L2TxHash.Hex(), "error", err)
+	}
+	return nil
+}
+
+// checkReorgs compares the L1 block hash of every recently recorded deposit
+// with the canonical chain and reverts the deposits of replaced blocks
+func (m *L1Monitor) checkReorgs(ctx context.Context) error {
+	head, err := m.clients.L1Client.BlockNumber(ctx)
+	if err != nil {
+		return fmt.Errorf("could not get L1 block number: %v", err)
+	}
+
+	var from uint64
+	if head > m.cfg.Monitor.ReorgWindow {
+		from = head - m.cfg.Monitor.ReorgWindow
+	}
+
+	deposits, err := m.depositStore.DepositsSinceL1Block(from)
+	if err != nil {
+		return err
+	}
+
+	canonical := make(map[uint64]common.Hash)
+	reorgedFrom := uint64(0)
+	for _, deposit := range deposits {
+		hash, ok := canonical[deposit.BlockNum]
+		if !ok {
+			header, err := m.clients.L1Client.HeaderByNumber(ctx, new(big.Int).SetUint64(deposit.BlockNum))
+			if err != nil {
+				return fmt.Errorf("could not get L1 block %d: %v", deposit.BlockNum, err)
 			}
+			hash = header.Hash()
+			canonical[deposit.BlockNum] = hash
+		}
+		if hash != deposit.L1BlockHash {
+			m.revertDeposit(deposit.SourceHash)
+			if reorgedFrom == 0 || deposit.BlockNum < reorgedFrom {
+				reorgedFrom = deposit.BlockNum
+			}
+		}
+	}
+
+	// Rewind the checkpoint so the replacement blocks are scanned again
+	if reorgedFrom > 0 {
+		checkpoint, err := m.depositStore.GetCheckpoint(L1CheckpointName)
+		if err == nil && checkpoint >= reorgedFrom {
+			slog.Warn("L1 reorg detected, rewinding checkpoint", "block", reorgedFrom, "checkpoint", checkpoint)
+			return m.depositStore.SetCheckpoint(L1CheckpointName, reorgedFrom-1)
+		}
+	}
+	return nil
+}
+
+// backfill processes all deposits between the checkpoint and the confirmed
+// head with chunked eth_getLogs calls, advancing the checkpoint per chunk.
+// Deposits already in the store are not counted again.
+func (m *L1Monitor) backfill(ctx context.Context) error {
+	head, err := m.clients.L1Client.BlockNumber(ctx)
+	if err != nil {
+		return fmt.Errorf("could not get L1 block number: %v", err)
+	}
+	depth := m.cfg.Monitor.ConfirmationDepth
+	if head < depth {
+		return nil
+	}
+	confirmed := head - depth
+
+	var from uint64
+	checkpoint, err := m.depositStore.GetCheckpoint(L1CheckpointName)
+	switch {
+	case err == nil:
+		from = checkpoint + 1
+	case err == store.ErrNotFound && m.cfg.Monitor.StartBlock > 0:
+		from = m.cfg.Monitor.StartBlock
+	case err == store.ErrNotFound:
+		// First run without a start block: only follow new deposits
+		slog.Info("No L1 checkpoint found, starting at the confirmed head", "block", confirmed)
+		return m.depositStore.SetCheckpoint(L1CheckpointName, confirmed)
+	default:
+		return err
+	}
+	if from > confirmed {
+		return nil
+	}
 
-			// Update deposit metrics
-			metricsCollector.TotalDeposits.Inc()
-			metricsCollector.DepositsByAccount.WithLabelValues(deposit.From.Hex()).Inc()
+	chunkSize := m.cfg.Monitor.BackfillChunkSize
+	if confirmed-from >= chunkSize {
+		slog.Info("Backfilling L1 deposits", "from", from, "to", confirmed)
+	}
 
-			// Add ETH value to histogram
-			ethValue := new(big.Float).Quo(
-				new(big.Float).SetInt(deposit.Value),
-				new(big.Float).SetInt64(1e18),
-			)
-			ethFloat, _ := ethValue.Float64()
-			metricsCollector.DepositValueHistogram.Observe(ethFloat)
+	for from <= confirmed {
+		to := from + chunkSize - 1
+		if to > confirmed {
+			to = confirmed
+		}
 
-			// Add to pending deposits and update counter
-			UpdatePendingDeposits(deposit)
+		query := m.query
+		query.FromBlock = new(big.Int).SetUint64(from)
+		query.ToBlock = new(big.Int).SetUint64(to)
+		logs, err := m.clients.L1Client.FilterLogs(ctx, query)
+		if err != nil {
+			return fmt.Errorf("could not fetch deposits in blocks %d-%d: %v", from, to, err)
+		}
 
-			log.Printf("[INFO] New deposit recorded: %s -> %s (%.6f ETH, gas: %d)",
-				deposit.From.Hex(), deposit.To.Hex(), ethFloat, deposit.GasLimit)
+		for _, logEntry := range logs {
+			if logEntry.Removed {
+				continue
+			}
+			// The checkpoint must not pass a deposit that was not processed
+			if err := m.processLog(ctx, logEntry); err != nil {
+				return err
+			}
+			delete(m.queue, logKey{blockHash: logEntry.BlockHash, index: logEntry.Index})
 		}
+
+		if err := m.depositStore.SetCheckpoint(L1CheckpointName, to); err != nil {
+			return err
+		}
+		from = to + 1
+	}
+	return nil
+}
+
+// revertDeposit marks a recorded deposit as reorged out, takes it off the
+// deposit counts and counts the revert
+func (m *L1Monitor) revertDeposit(sourceHash common.Hash) {
+	previous, err := m.depositStore.RevertDeposit(sourceHash)
+	if err == store.ErrNotFound {
+		return
+	}
+	if err != nil {
+		slog.Error("Could not revert deposit", "source_hash", sourceHash.Hex(), "error", err)
+		return
+	}
+
+	m.metricsCollector.RevertAccountDeposit(previous.From, previous.Value, previous.Frozen)
+	if !previous.Frozen {
+		m.metricsCollector.TotalDeposits.Dec()
 	}
+	m.metricsCollector.ReorgedDeposits.WithLabelValues(string(previous.Status)).Inc()
+	slog.Warn("Deposit reverted by L1 reorg", "from", previous.From.Hex(), "to", previous.To.Hex(),
+		"block", previous.BlockNum, "l2_tx", previous.L2TxHash.Hex(), "previous_status", previous.Status)
 }
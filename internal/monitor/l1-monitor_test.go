@@ -0,0 +1,220 @@
+package monitor
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/ddomeke/rpc_proxy/internal/store"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// failingStore is a deposit store whose writes of deposits fail
+type failingStore struct {
+	*store.MemoryStore
+	fail bool
+}
+
+func (s *failingStore) SaveDeposit(deposit *store.Deposit) (bool, error) {
+	if s.fail {
+		return false, errors.New("disk full")
+	}
+	return s.MemoryStore.SaveDeposit(deposit)
+}
+
+// serveLogs answers eth_blockNumber with the head and eth_getLogs with the
+// logs in the requested block range
+func serveLogs(l1 *fakeNode, head uint64, logs ...types.Log) {
+	l1.handle("eth_blockNumber", func([]json.RawMessage) (interface{}, error) {
+		return hexutil.EncodeUint64(head), nil
+	})
+	l1.handle("eth_getLogs", func(params []json.RawMessage) (interface{}, error) {
+		var filter struct {
+			FromBlock string `json:"fromBlock"`
+			ToBlock   string `json:"toBlock"`
+		}
+		json.Unmarshal(params[0], &filter)
+		from, _ := hexutil.DecodeUint64(filter.FromBlock)
+		to, _ := hexutil.DecodeUint64(filter.ToBlock)
+		matching := []types.Log{}
+		for _, logEntry := range logs {
+			if logEntry.BlockNumber >= from && logEntry.BlockNumber <= to {
+				matching = append(matching, logEntry)
+			}
+		}
+		return matching, nil
+	})
+}
+
+func TestBackfill(t *testing.T) {
+	ctx := context.Background()
+	l1, l1Srv := newFakeNode(t)
+	l2, l2Srv := newFakeNode(t)
+	clients := testClients(t, l1Srv, l2Srv)
+	l1Chain(l1)
+	l2Block(l2, 1)
+
+	logs := []types.Log{
+		depositLog(t, clients.PortalABI, 105, 0),
+		depositLog(t, clients.PortalABI, 112, 3),
+		depositLog(t, clients.PortalABI, 118, 1),
+	}
+
+	tests := []struct {
+		name           string
+		failStore      bool
+		wantErr        bool
+		wantCheckpoint uint64
+		wantDeposits   int
+	}{
+		{name: "records_all", wantCheckpoint: 120, wantDeposits: 3},
+		// The checkpoint must stay before the first deposit that was not stored
+		{name: "store_error", failStore: true, wantErr: true, wantCheckpoint: 100, wantDeposits: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			depositStore := &failingStore{MemoryStore: store.NewMemoryStore(), fail: tt.failStore}
+			depositStore.SetCheckpoint(L1CheckpointName, 100)
+
+			m := newTestL1Monitor(t, clients, depositStore)
+			m.cfg.Monitor.ConfirmationDepth = 5
+			m.cfg.Monitor.BackfillChunkSize = 10
+			serveLogs(l1, 125, logs...)
+
+			err := m.backfill(ctx)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("backfill error = %v, want error %v", err, tt.wantErr)
+			}
+
+			checkpoint, err := depositStore.GetCheckpoint(L1CheckpointName)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if checkpoint != tt.wantCheckpoint {
+				t.Errorf("checkpoint = %d, want %d", checkpoint, tt.wantCheckpoint)
+			}
+			deposits, _ := depositStore.DepositsSinceL1Block(0)
+			if len(deposits) != tt.wantDeposits {
+				t.Errorf("stored %d deposits, want %d", len(deposits), tt.wantDeposits)
+			}
+		})
+	}
+}
+
+// depositCounts returns the deposit total, the number of observed deposit
+// values and the deposits of an account from the test collector
+func depositCounts(t *testing.T, account common.Address) (total float64, observed uint64, accountDeposits uint64) {
+	t.Helper()
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, family := range families {
+		if family.GetName() == "opstack_deposit_value" {
+			observed = family.GetMetric()[0].GetHistogram().GetSampleCount()
+		}
+	}
+	stats, _ := testCollector().Accounts.Account(account)
+	return testutil.ToFloat64(testCollector().TotalDeposits), observed, stats.Deposits
+}
+
+func TestL1Reorg(t *testing.T) {
+	ctx := context.Background()
+	l1, l1Srv := newFakeNode(t)
+	l2, l2Srv := newFakeNode(t)
+	clients := testClients(t, l1Srv, l2Srv)
+	l1Chain(l1)
+	l2Block(l2, 1)
+
+	canonical := depositLog(t, clients.PortalABI, 105, 0)
+	removed := canonical
+	removed.Removed = true
+	// forked is the same deposit in a block that was replaced on L1
+	forked := canonical
+	forked.BlockHash = common.HexToHash("0xf0")
+
+	tests := []struct {
+		name           string
+		depth          uint64
+		head           uint64
+		logs           []types.Log // Subscription logs in order of arrival
+		checkReorgs    bool
+		wantStatus     store.DepositStatus // Status of the stored deposit, empty if none was stored
+		wantQueued     int
+		wantCheckpoint uint64
+		wantCounted    int    // Change of the deposit total and the sender's deposits
+		wantObserved   uint64 // Deposit values observed, once per deposit
+	}{
+		{name: "confirmed", depth: 5, head: 110, logs: []types.Log{canonical}, wantStatus: store.StatusObserved, wantCounted: 1, wantObserved: 1},
+		{name: "not_deep_enough", depth: 5, head: 109, logs: []types.Log{canonical}, wantQueued: 1},
+		{name: "removed_before_confirmation", depth: 5, head: 109, logs: []types.Log{canonical, removed}},
+		{name: "removed_after_recording", depth: 0, head: 105, logs: []types.Log{canonical, removed}, wantStatus: store.StatusReorged, wantObserved: 1},
+		// The block of a queued deposit was replaced without a removed log
+		{name: "block_replaced", depth: 5, head: 110, logs: []types.Log{forked}},
+		{name: "restored", depth: 0, head: 105, logs: []types.Log{canonical, removed, canonical}, wantStatus: store.StatusObserved, wantCounted: 1, wantObserved: 1},
+		// A deposit recorded from a replaced block is found when polling resumes
+		{name: "reorg_while_disconnected", depth: 0, head: 110, logs: []types.Log{forked}, checkReorgs: true, wantStatus: store.StatusReorged, wantCheckpoint: 104, wantObserved: 1},
+	}
+	sender := common.BytesToAddress(canonical.Topics[1].Bytes())
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			depositStore := store.NewMemoryStore()
+			depositStore.SetCheckpoint(L1CheckpointName, 110)
+			m := newTestL1Monitor(t, clients, depositStore)
+			m.cfg.Monitor.ConfirmationDepth = tt.depth
+			serveLogs(l1, tt.head)
+			total, observed, accountDeposits := depositCounts(t, sender)
+
+			for _, logEntry := range tt.logs {
+				m.handleLog(ctx, logEntry)
+			}
+			m.processConfirmed(ctx)
+			if tt.checkReorgs {
+				if err := m.checkReorgs(ctx); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			var status store.DepositStatus
+			deposits, _, _ := depositStore.QueryDeposits(store.DepositFilter{})
+			if len(deposits) > 1 {
+				t.Fatalf("stored %d deposits, want at most one", len(deposits))
+			}
+			if len(deposits) == 1 {
+				status = deposits[0].Status
+			}
+			if status != tt.wantStatus {
+				t.Errorf("deposit status = %q, want %q", status, tt.wantStatus)
+			}
+			if len(m.queue) != tt.wantQueued {
+				t.Errorf("%d deposits queued, want %d", len(m.queue), tt.wantQueued)
+			}
+
+			wantCheckpoint := tt.wantCheckpoint
+			if wantCheckpoint == 0 {
+				wantCheckpoint = 110
+			}
+			if checkpoint, _ := depositStore.GetCheckpoint(L1CheckpointName); checkpoint != wantCheckpoint {
+				t.Errorf("checkpoint = %d, want %d", checkpoint, wantCheckpoint)
+			}
+
+			newTotal, newObserved, newAccountDeposits := depositCounts(t, sender)
+			if got := int(newTotal - total); got != tt.wantCounted {
+				t.Errorf("deposit total changed by %d, want %d", got, tt.wantCounted)
+			}
+			if got := int(newAccountDeposits) - int(accountDeposits); got != tt.wantCounted {
+				t.Errorf("sender deposits changed by %d, want %d", got, tt.wantCounted)
+			}
+			if got := newObserved - observed; got != tt.wantObserved {
+				t.Errorf("%d deposit values observed, want %d", got, tt.wantObserved)
+			}
+		})
+	}
+}
@@ -0,0 +1,173 @@
+package monitor
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// poll follows new deposits with eth_getLogs over the confirmed block range
+// until an error occurs, the context is cancelled or the given time, if set.
+// Without removed logs reorgs are only detected by re-checking recent deposits
+// on every poll.
+func (m *L1Monitor) poll(ctx context.Context, until time.Time) error {
+	slog.Info("Polling L1 deposit events over HTTP", "interval", m.cfg.Monitor.PollInterval)
+
+	ticker := time.NewTicker(m.cfg.Monitor.PollInterval)
+	defer ticker.Stop()
+
+	// The backfill before polling started brought the listener up to date
+	m.setStatus("poll", true)
+	defer m.setStatus("poll", false)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+		if !until.IsZero() && time.Now().After(until) {
+			return nil
+		}
+		if m.checkReload() {
+			return errReloaded
+		}
+		if err := m.checkReorgs(ctx); err != nil {
+			return fmt.Errorf("L1 reorg check failed: %v", err)
+		}
+		if err := m.backfill(ctx); err != nil {
+			return err
+		}
+		m.setStatus("poll", true)
+	}
+}
+
+// pollFilter follows new deposits with an eth_newFilter log filter that is
+// polled with eth_getFilterChanges. Filters live on a single node, so the
+// filter is installed on the pool's primary node and every poll goes to that
+// node. A filter only returns logs of blocks after it was installed, so the
+// blocks between the checkpoint and the head at installation are scanned with
+// eth_getLogs on the same node first. A failover or an expired filter ends the
+// loop, and the backfill before the next filter covers the blocks in between.
+// While the filter works, the checkpoint advances without eth_getLogs.
+func (m *L1Monitor) pollFilter(ctx context.Context, until time.Time) error {
+	node := m.clients.L1Pool.Primary()
+	client, err := m.clients.L1Pool.DialNode(ctx, node)
+	if err != nil {
+		return fmt.Errorf("could not connect to L1 upstream %s: %v", node.Name, err)
+	}
+	defer client.Close()
+	rpcClient := client.Client()
+
+	var filterID string
+	err = rpcClient.CallContext(ctx, &filterID, "eth_newFilter", map[string]interface{}{
+		"address": m.query.Addresses,
+		"topics":  m.query.Topics,
+	})
+	if err != nil {
+		return fmt.Errorf("could not install L1 deposit filter on %s: %v", node.Name, err)
+	}
+	defer rpcClient.CallContext(context.Background(), nil, "eth_uninstallFilter", filterID)
+
+	// The filter covers the blocks after this head
+	installHead, err := client.BlockNumber(ctx)
+	if err != nil {
+		return fmt.Errorf("could not get L1 block number from %s: %v", node.Name, err)
+	}
+	checkpoint, err := m.depositStore.GetCheckpoint(L1CheckpointName)
+	if err != nil {
+		return err
+	}
+	chunkSize := m.cfg.Monitor.BackfillChunkSize
+	for from := checkpoint + 1; from <= installHead; from += chunkSize {
+		to := from + chunkSize - 1
+		if to > installHead {
+			to = installHead
+		}
+		query := m.query
+		query.FromBlock = new(big.Int).SetUint64(from)
+		query.ToBlock = new(big.Int).SetUint64(to)
+		logs, err := client.FilterLogs(ctx, query)
+		if err != nil {
+			return fmt.Errorf("could not fetch deposits in blocks %d-%d from %s: %v", from, to, node.Name, err)
+		}
+		// Queued like filter logs, so a log in both is handled once
+		for _, logEntry := range logs {
+			m.handleLog(ctx, logEntry)
+		}
+	}
+
+	slog.Info("Polling L1 deposit filter", "upstream", node.Name, "filter_id", filterID, "interval", m.cfg.Monitor.PollInterval)
+
+	ticker := time.NewTicker(m.cfg.Monitor.PollInterval)
+	defer ticker.Stop()
+
+	m.setStatus("filter", true)
+	defer m.setStatus("filter", false)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+		if !until.IsZero() && time.Now().After(until) {
+			return nil
+		}
+		if m.checkReload() {
+			return errReloaded
+		}
+		if primary := m.clients.L1Pool.Primary(); primary != node {
+			slog.Info("L1 upstream failed over, recreating deposit filter", "from", node.Name, "to", primary.Name)
+			return nil
+		}
+
+		// The changes include the logs of every block up to this head
+		head, err := client.BlockNumber(ctx)
+		if err != nil {
+			return fmt.Errorf("could not get L1 block number from %s: %v", node.Name, err)
+		}
+		var logs []types.Log
+		if err := rpcClient.CallContext(ctx, &logs, "eth_getFilterChanges", filterID); err != nil {
+			return fmt.Errorf("could not poll L1 deposit filter: %v", err)
+		}
+		for _, logEntry := range logs {
+			m.handleLog(ctx, logEntry)
+		}
+		m.processConfirmed(ctx)
+		if err := m.advanceCheckpoint(head); err != nil {
+			return err
+		}
+		m.setStatus("filter", true)
+	}
+}
+
+// advanceCheckpoint moves the checkpoint over the confirmed blocks up to a
+// head whose logs were all received. It stops before the first block with a
+// deposit that is still queued.
+func (m *L1Monitor) advanceCheckpoint(head uint64) error {
+	depth := m.cfg.Monitor.ConfirmationDepth
+	if head < depth {
+		return nil
+	}
+	to := head - depth
+	for _, logEntry := range m.queue {
+		if logEntry.BlockNumber <= to && logEntry.BlockNumber > 0 {
+			to = logEntry.BlockNumber - 1
+		}
+	}
+
+	// The backfill before the filter was installed set the checkpoint
+	checkpoint, err := m.depositStore.GetCheckpoint(L1CheckpointName)
+	if err != nil {
+		return err
+	}
+	if to <= checkpoint {
+		return nil
+	}
+	return m.depositStore.SetCheckpoint(L1CheckpointName, to)
+}
@@ -0,0 +1,152 @@
+package monitor
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ddomeke/rpc_proxy/internal/config"
+	"github.com/ddomeke/rpc_proxy/internal/store"
+	"github.com/ddomeke/rpc_proxy/internal/upstream"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// TestPollFilterPinsNode runs filter mode on a round-robin pool of two
+// nodes: the filter must be installed and polled on the same node, the blocks
+// before the filter was installed must be scanned before the checkpoint moves,
+// and afterwards the checkpoint must advance without eth_getLogs.
+func TestPollFilterPinsNode(t *testing.T) {
+	primary, primarySrv := newFakeNode(t)
+	other, otherSrv := newFakeNode(t)
+	l2, l2Srv := newFakeNode(t)
+	l2Block(l2, 1)
+
+	clients := testClients(t, primarySrv, l2Srv)
+	pool, err := upstream.NewPool("L1", []string{primarySrv.URL, otherSrv.URL}, config.UpstreamConfig{Strategy: upstream.StrategyRoundRobin})
+	if err != nil {
+		t.Fatal(err)
+	}
+	clients.L1Pool = pool
+	if clients.L1Client, err = pool.Dial(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	depositStore := store.NewMemoryStore()
+	depositStore.SetCheckpoint(L1CheckpointName, 110)
+
+	// The chain is at block 122 when the filter is installed and at 130 once
+	// it is polled. The filter only returns the deposit in block 127, the one
+	// in block 118 is only found by eth_getLogs.
+	gapDeposit := depositLog(t, clients.PortalABI, 118, 2)
+	filterDeposit := depositLog(t, clients.PortalABI, 127, 0)
+	var mu sync.Mutex
+	polled := false
+	head := func([]json.RawMessage) (interface{}, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if polled {
+			return hexutil.EncodeUint64(130), nil
+		}
+		return hexutil.EncodeUint64(122), nil
+	}
+	for _, node := range []*fakeNode{primary, other} {
+		l1Chain(node)
+		node.handle("eth_blockNumber", head)
+	}
+	serveLogs(primary, 122, gapDeposit)
+	primary.handle("eth_blockNumber", head)
+	var gapCheckpoints []uint64
+	getLogs := primary.handlers["eth_getLogs"]
+	primary.handle("eth_getLogs", func(params []json.RawMessage) (interface{}, error) {
+		checkpoint, _ := depositStore.GetCheckpoint(L1CheckpointName)
+		mu.Lock()
+		gapCheckpoints = append(gapCheckpoints, checkpoint)
+		mu.Unlock()
+		return getLogs(params)
+	})
+	primary.handle("eth_newFilter", func([]json.RawMessage) (interface{}, error) {
+		return "0x1", nil
+	})
+	primary.handle("eth_getFilterChanges", func([]json.RawMessage) (interface{}, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if polled {
+			return []types.Log{}, nil
+		}
+		polled = true
+		return []types.Log{filterDeposit}, nil
+	})
+	primary.handle("eth_uninstallFilter", func([]json.RawMessage) (interface{}, error) {
+		return true, nil
+	})
+
+	m := newTestL1Monitor(t, clients, depositStore)
+	m.cfg.Monitor.ConfirmationDepth = 5
+	m.cfg.Monitor.PollInterval = 10 * time.Millisecond
+
+	if err := m.pollFilter(context.Background(), time.Now().Add(200*time.Millisecond)); err != nil {
+		t.Fatalf("pollFilter: %v", err)
+	}
+
+	if n := other.count("eth_newFilter") + other.count("eth_getFilterChanges") + other.count("eth_getLogs"); n != 0 {
+		t.Errorf("%d filter calls went to the other node", n)
+	}
+	if n := primary.count("eth_getFilterChanges"); n < 2 {
+		t.Errorf("filter polled %d times, want at least 2", n)
+	}
+	// One scan of blocks 111-122 before the checkpoint moved, none afterwards
+	mu.Lock()
+	if len(gapCheckpoints) != 1 || gapCheckpoints[0] != 110 {
+		t.Errorf("eth_getLogs at checkpoints %v, want [110]", gapCheckpoints)
+	}
+	mu.Unlock()
+
+	// Block 127 is not confirmed at head 130
+	checkpoint, _ := depositStore.GetCheckpoint(L1CheckpointName)
+	if checkpoint != 125 {
+		t.Errorf("checkpoint = %d, want 125", checkpoint)
+	}
+	deposits, _ := depositStore.DepositsSinceL1Block(0)
+	if len(deposits) != 1 || deposits[0].BlockNum != 118 {
+		t.Errorf("stored deposits %+v, want the one in block 118", deposits)
+	}
+	if _, queued := m.queue[logKey{blockHash: filterDeposit.BlockHash, index: filterDeposit.Index}]; !queued {
+		t.Error("deposit of the filter is not queued")
+	}
+}
+
+func TestAdvanceCheckpoint(t *testing.T) {
+	tests := []struct {
+		name   string
+		head   uint64
+		queued []uint64 // Blocks of deposits still waiting for confirmations
+		want   uint64
+	}{
+		{name: "confirmed_head", head: 130, want: 125},
+		{name: "stops_before_queued", head: 130, queued: []uint64{128, 121}, want: 120},
+		{name: "queued_above_confirmed", head: 130, queued: []uint64{127}, want: 125},
+		{name: "never_rewinds", head: 112, want: 110},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			depositStore := store.NewMemoryStore()
+			depositStore.SetCheckpoint(L1CheckpointName, 110)
+			m := &L1Monitor{cfg: config.Default(), depositStore: depositStore, queue: make(map[logKey]types.Log)}
+			m.cfg.Monitor.ConfirmationDepth = 5
+			for i, block := range tt.queued {
+				m.queue[logKey{index: uint(i)}] = types.Log{BlockNumber: block, Index: uint(i)}
+			}
+
+			if err := m.advanceCheckpoint(tt.head); err != nil {
+				t.Fatal(err)
+			}
+			if got, _ := depositStore.GetCheckpoint(L1CheckpointName); got != tt.want {
+				t.Errorf("checkpoint = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,42 @@
+package monitor
+
+import (
+	"time"
+)
+
+// L1Status is a snapshot of how the L1 deposit listener receives events
+type L1Status struct {
+	Mode       string    `json:"mode"`       // Event source in use: ws, poll or filter
+	Connected  bool      `json:"connected"`  // Subscription established or last poll succeeded
+	LastUpdate time.Time `json:"lastUpdate"` // Last time the listener was confirmed current
+}
+
+// Status returns how the listener currently receives events
+func (m *L1Monitor) Status() L1Status {
+	m.statusMu.RLock()
+	defer m.statusMu.RUnlock()
+	return m.status
+}
+
+// Active reports whether the listener is receiving events. Polling listeners
+// count as inactive once they missed a few polls.
+func (m *L1Monitor) Active() bool {
+	m.statusMu.RLock()
+	defer m.statusMu.RUnlock()
+	if !m.status.Connected {
+		return false
+	}
+	return m.status.Mode == "ws" || time.Since(m.status.LastUpdate) < 3*m.pollInterval
+}
+
+// setStatus records the event source and whether it is working
+func (m *L1Monitor) setStatus(mode string, connected bool) {
+	m.statusMu.Lock()
+	defer m.statusMu.Unlock()
+	m.status.Mode = mode
+	m.status.Connected = connected
+	if connected {
+		m.status.LastUpdate = time.Now()
+	}
+	m.pollInterval = m.cfg.Monitor.PollInterval
+}
@@ -1,52 +1,98 @@
 package monitor
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
-	"net/http"
+	"log/slog"
 	"time"
 
 	"github.com/ddomeke/rpc_proxy/internal/config"
 	"github.com/ddomeke/rpc_proxy/internal/eth"
 	"github.com/ddomeke/rpc_proxy/internal/metrics"
+	"github.com/ddomeke/rpc_proxy/internal/store"
 	"github.com/ddomeke/rpc_proxy/pkg/utils"
+	"github.com/ethereum/go-ethereum"
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
 )
 
-// MonitorL2Deposits monitors transactions on L2 and matches deposits
-func MonitorL2Deposits(clients *eth.Clients, cfg *config.Config, metricsCollector *metrics.Collector) {
-	log.Println("[INFO] Starting L2 deposit confirmation monitor...")
+// depositTxTypeHex is the type of deposit transactions as reported by L2 RPC
+var depositTxTypeHex = fmt.Sprintf("0x%x", eth.DepositTxType)
 
-	// Last checked block
-	lastCheckedBlock := uint64(0)
+// L2CheckpointName is the store cursor of the last L2 block checked for deposits
+const L2CheckpointName = "l2_deposits"
+
+// pendingCheckInterval is how often the receipts of pending deposits are
+// looked up, for deposits included on L2 before they were recorded
+const pendingCheckInterval = time.Minute
+
+// MonitorL2Deposits monitors transactions on L2 and matches deposits until
+// the context is cancelled. It resumes after the last checked block. Deposits
+// are only recorded once they are confirmed on L1, so L2 may include them
+// before that; pending deposits are therefore also checked by receipt, on
+// start and periodically.
+func MonitorL2Deposits(ctx context.Context, clients *eth.Clients, depositStore store.DepositStore, cfg *config.Config, metricsCollector *metrics.Collector) {
+	slog.Info("Starting L2 deposit confirmation monitor")
+	defer slog.Info("L2 deposit confirmation monitor stopped")
+
+	lastCheckedBlock, err := depositStore.GetCheckpoint(L2CheckpointName)
+	started := err == nil
+	switch {
+	case started:
+		slog.Info("Resuming L2 deposit confirmation after checkpoint", "block", lastCheckedBlock)
+	case err != store.ErrNotFound:
+		slog.Error("Could not read L2 checkpoint, starting at the head", "error", err)
+	}
+
+	var lastPendingCheck time.Time
+	for ctx.Err() == nil {
+		if time.Since(lastPendingCheck) >= pendingCheckInterval {
+			if err := checkPendingDeposits(ctx, clients, depositStore, metricsCollector); err != nil && ctx.Err() == nil {
+				slog.Error("Pending deposit check failed", "error", err)
+			}
+			lastPendingCheck = time.Now()
+		}
 
-	for {
 		// Get L2 block number
-		currentBlock, err := clients.L2Client.BlockNumber(context.Background())
+		currentBlock, err := clients.L2Client.BlockNumber(ctx)
 		if err != nil {
-			log.Printf("[ERROR] Could not get L2 block number: %v", err)
-			time.Sleep(5 * time.Second)
+			if ctx.Err() == nil {
+				slog.Error("Could not get L2 block number", "error", err)
+			}
+			utils.SleepContext(ctx, 5*time.Second)
 			continue
 		}
 
-		// If there are new blocks
-		if currentBlock > lastCheckedBlock {
-			// Check blocks
-			for blockNum := lastCheckedBlock + 1; blockNum <= currentBlock; blockNum++ {
-				checkL2BlockViaRPC(blockNum, clients, cfg, metricsCollector)
+		if !started && currentBlock > 0 {
+			// First run: start at the current head instead of scanning the whole chain
+			lastCheckedBlock = currentBlock - 1
+			started = true
+		}
+
+		// Check new blocks; a block that could not be checked is retried
+		// and the checkpoint does not pass it
+		for blockNum := lastCheckedBlock + 1; started && blockNum <= currentBlock && ctx.Err() == nil; blockNum++ {
+			if err := checkL2BlockViaRPC(ctx, blockNum, clients, depositStore, cfg, metricsCollector); err != nil {
+				if ctx.Err() == nil {
+					slog.Error("L2 block check failed, retrying", "block", blockNum, "error", err)
+				}
+				break
+			}
+			lastCheckedBlock = blockNum
+			if err := depositStore.SetCheckpoint(L2CheckpointName, blockNum); err != nil {
+				slog.Error("Could not store L2 checkpoint", "block", blockNum, "error", err)
 			}
-			lastCheckedBlock = currentBlock
 		}
 
-		time.Sleep(2 * time.Second)
+		utils.SleepContext(ctx, 2*time.Second)
 	}
 }
 
-// checkL2BlockViaRPC checks L2 block (using HTTP RPC)
-func checkL2BlockViaRPC(blockNum uint64, clients *eth.Clients, cfg *config.Config, metricsCollector *metrics.Collector) {
+// checkL2BlockViaRPC checks L2 block (using HTTP RPC) and confirms the
+// recorded deposits it includes
+func checkL2BlockViaRPC(ctx context.Context, blockNum uint64, clients *eth.Clients, depositStore store.DepositStore, cfg *config.Config, metricsCollector *metrics.Collector) error {
 	// Create JSON-RPC request
 	blockNumHex := fmt.Sprintf("0x%x", blockNum)
 	rpcRequest := map[string]interface{}{
@@ -57,53 +103,138 @@ func checkL2BlockViaRPC(blockNum uint64, clients *eth.Clients, cfg *config.Confi
 	}
 
 	requestData, _ := json.Marshal(rpcRequest)
-	resp, err := http.Post(cfg.L2RPCURL, "application/json", bytes.NewBuffer(requestData))
+	respBody, _, err := clients.L2Pool.Forward(ctx, requestData)
 	if err != nil {
-		log.Printf("[ERROR] L2 RPC request failed: %v", err)
-		return
+		return fmt.Errorf("L2 RPC request failed: %v", err)
 	}
-	defer resp.Body.Close()
 
 	var response map[string]interface{}
-	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
-		log.Printf("[ERROR] RPC response parsing error: %v", err)
-		return
+	if err := json.Unmarshal(respBody, &response); err != nil {
+		return fmt.Errorf("RPC response parsing error: %v", err)
 	}
 
-	// Process response
-	if result, ok := response["result"].(map[string]interface{}); ok {
-		// Convert block timestamp from string
-		var blockTime time.Time
-		if timestampHex, ok := result["timestamp"].(string); ok {
-			timestamp, err := utils.HexToUint64(timestampHex)
-			if err == nil {
-				blockTime = time.Unix(int64(timestamp), 0)
-			} else {
-				log.Printf("[ERROR] Block timestamp conversion error: %v", err)
-				blockTime = time.Now() // Fallback
-			}
+	// Process response; a node behind the head may not have the block yet
+	result, ok := response["result"].(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("block not found")
+	}
+
+	// Convert block timestamp from string
+	var blockTime time.Time
+	if timestampHex, ok := result["timestamp"].(string); ok {
+		timestamp, err := utils.HexToUint64(timestampHex)
+		if err == nil {
+			blockTime = time.Unix(int64(timestamp), 0)
+		} else {
+			slog.Error("Block timestamp conversion error", "block", blockNum, "error", err)
+			blockTime = time.Now() // Fallback
 		}
+	}
 
-		if transactions, ok := result["transactions"].([]interface{}); ok {
-			for _, txData := range transactions {
-				tx := txData.(map[string]interface{})
-				if txHashStr, ok := tx["hash"].(string); ok {
-					txHash := common.HexToHash(txHashStr)
+	transactions, _ := result["transactions"].([]interface{})
+	for _, txData := range transactions {
+		tx, ok := txData.(map[string]interface{})
+		if !ok {
+			continue
+		}
 
-					// Get pending deposits
-					pendingMap := GetPendingDeposits()
+		// Only deposit transactions can match an L1 deposit
+		if txType, _ := tx["type"].(string); txType != depositTxTypeHex {
+			continue
+		}
 
-					// Check deposit hash
-					if deposit, exists := pendingMap[txHash]; exists {
-						// Deposit confirmed
-						confirmTime := blockTime.Sub(deposit.Timestamp)
-						log.Printf("[INFO] Deposit confirmed on L2: %s (%.2f seconds)", txHash.Hex(), confirmTime.Seconds())
+		if txHashStr, ok := tx["hash"].(string); ok {
+			txHash := common.HexToHash(txHashStr)
 
-						// Remove from pending list
-						RemovePendingDeposit(txHash)
-					}
-				}
+			// Check deposit hash
+			deposit, err := depositStore.GetDepositByL2TxHash(txHash)
+			if err == nil && deposit.Status == store.StatusObserved {
+				confirmDeposit(ctx, clients, depositStore, metricsCollector, deposit, blockNum, blockTime)
+			} else if err != nil && err != store.ErrNotFound {
+				return fmt.Errorf("could not look up deposit %s: %v", txHash.Hex(), err)
 			}
 		}
 	}
+	return nil
+}
+
+// checkPendingDeposits confirms the pending deposits that already have an L2
+// receipt
+func checkPendingDeposits(ctx context.Context, clients *eth.Clients, depositStore store.DepositStore, metricsCollector *metrics.Collector) error {
+	pending, err := depositStore.PendingDeposits()
+	if err != nil {
+		return fmt.Errorf("could not list pending deposits: %v", err)
+	}
+	for _, deposit := range pending {
+		if _, err := confirmIfIncluded(ctx, clients, depositStore, metricsCollector, deposit); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// confirmIfIncluded looks up the L2 receipt of a deposit and records its
+// outcome if it was included. It reports whether the deposit was included.
+func confirmIfIncluded(ctx context.Context, clients *eth.Clients, depositStore store.DepositStore, metricsCollector *metrics.Collector, deposit *store.Deposit) (bool, error) {
+	receipt, err := clients.L2Client.TransactionReceipt(ctx, deposit.L2TxHash)
+	if errors.Is(err, ethereum.NotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("could not get L2 receipt of deposit %s: %v", deposit.L2TxHash.Hex(), err)
+	}
+
+	header, err := clients.L2Client.HeaderByNumber(ctx, receipt.BlockNumber)
+	if err != nil {
+		return false, fmt.Errorf("could not get L2 block %s: %v", receipt.BlockNumber, err)
+	}
+	recordConfirmation(depositStore, metricsCollector, deposit, receipt, receipt.BlockNumber.Uint64(), time.Unix(int64(header.Time), 0))
+	return true, nil
+}
+
+// confirmDeposit fetches the L2 receipt of a matched deposit and records its outcome
+func confirmDeposit(ctx context.Context, clients *eth.Clients, depositStore store.DepositStore, metricsCollector *metrics.Collector, deposit *store.Deposit, blockNum uint64, blockTime time.Time) {
+	receipt, err := clients.L2Client.TransactionReceipt(ctx, deposit.L2TxHash)
+	if err != nil {
+		// The deposit is included, only its outcome is unknown
+		slog.Error("Could not get L2 receipt for deposit", "l2_tx", deposit.L2TxHash.Hex(), "error", err)
+		receipt = nil
+	}
+	recordConfirmation(depositStore, metricsCollector, deposit, receipt, blockNum, blockTime)
+}
+
+// recordConfirmation records the L2 outcome of an included deposit, which
+// removes it from the pending list. A nil receipt records an unknown outcome.
+// Deposits already confirmed by another check are not counted again.
+func recordConfirmation(depositStore store.DepositStore, metricsCollector *metrics.Collector, deposit *store.Deposit, receipt *types.Receipt, blockNum uint64, blockTime time.Time) {
+	confirmTime := blockTime.Sub(deposit.Timestamp)
+	status := "success"
+	switch {
+	case receipt == nil:
+		status = "unknown"
+	case receipt.Status == types.ReceiptStatusFailed:
+		// Failed deposits still mint on L2, but the call itself reverted
+		status = "failed"
+	}
+
+	err := depositStore.ConfirmDeposit(deposit.L2TxHash, blockNum, status, confirmTime)
+	if err == store.ErrNotFound {
+		slog.Debug("Deposit already confirmed", "l2_tx", deposit.L2TxHash.Hex())
+		return
+	}
+	if err != nil {
+		slog.Error("Could not store confirmation of deposit", "l2_tx", deposit.L2TxHash.Hex(), "error", err)
+		return
+	}
+
+	if status == "failed" {
+		slog.Warn("Deposit failed on L2", "l2_tx", deposit.L2TxHash.Hex(), "l1_tx", deposit.L1TxHash.Hex(),
+			"block", blockNum, "seconds", confirmTime.Seconds())
+	} else {
+		slog.Info("Deposit confirmed on L2", "l2_tx", deposit.L2TxHash.Hex(), "l1_tx", deposit.L1TxHash.Hex(),
+			"block", blockNum, "seconds", confirmTime.Seconds())
+	}
+
+	metricsCollector.L2DepositConfirmations.WithLabelValues(status).Inc()
+	metricsCollector.DepositConfirmationTime.Observe(confirmTime.Seconds())
 }
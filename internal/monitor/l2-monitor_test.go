@@ -0,0 +1,360 @@
+package monitor
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/ddomeke/rpc_proxy/internal/config"
+	"github.com/ddomeke/rpc_proxy/internal/eth"
+	"github.com/ddomeke/rpc_proxy/internal/metrics"
+	"github.com/ddomeke/rpc_proxy/internal/store"
+	"github.com/ddomeke/rpc_proxy/internal/upstream"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+)
+
+var (
+	collectorOnce sync.Once
+	collector     *metrics.Collector
+)
+
+// testCollector returns the metrics collector shared by all tests, as the
+// metrics can only be registered once
+func testCollector() *metrics.Collector {
+	collectorOnce.Do(func() {
+		collector = metrics.NewCollector(config.MetricsConfig{AccountLabels: "full"})
+	})
+	return collector
+}
+
+// fakeNode is a JSON-RPC node answering from per-method handlers. Methods
+// without a handler return method not found.
+type fakeNode struct {
+	mu       sync.Mutex
+	handlers map[string]func(params []json.RawMessage) (interface{}, error)
+	calls    map[string]int
+}
+
+func newFakeNode(t *testing.T) (*fakeNode, *httptest.Server) {
+	node := &fakeNode{
+		handlers: make(map[string]func([]json.RawMessage) (interface{}, error)),
+		calls:    make(map[string]int),
+	}
+	srv := httptest.NewServer(node)
+	t.Cleanup(srv.Close)
+	return node, srv
+}
+
+// handle sets the handler of a method
+func (n *fakeNode) handle(method string, handler func(params []json.RawMessage) (interface{}, error)) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.handlers[method] = handler
+}
+
+// count returns how often a method was called
+func (n *fakeNode) count(method string) int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.calls[method]
+}
+
+func (n *fakeNode) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ID     json.RawMessage   `json:"id"`
+		Method string            `json:"method"`
+		Params []json.RawMessage `json:"params"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	n.mu.Lock()
+	handler := n.handlers[req.Method]
+	n.calls[req.Method]++
+	n.mu.Unlock()
+
+	resp := map[string]interface{}{"jsonrpc": "2.0", "id": req.ID}
+	if handler == nil {
+		resp["error"] = map[string]interface{}{"code": -32601, "message": "method not found: " + req.Method}
+	} else if result, err := handler(req.Params); err != nil {
+		resp["error"] = map[string]interface{}{"code": -32000, "message": err.Error()}
+	} else {
+		resp["result"] = result
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// testClients connects L1 and L2 clients to fake nodes
+func testClients(t *testing.T, l1, l2 *httptest.Server) *eth.Clients {
+	t.Helper()
+	portalABI, err := abi.JSON(strings.NewReader(eth.OptimismPortalABI))
+	if err != nil {
+		t.Fatal(err)
+	}
+	l1Client, err := ethclient.Dial(l1.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l2Client, err := ethclient.Dial(l2.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.Default().Upstream
+	l1Pool, err := upstream.NewPool("L1", []string{l1.URL}, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l2Pool, err := upstream.NewPool("L2", []string{l2.URL}, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &eth.Clients{
+		L1Client:   l1Client,
+		L2Client:   l2Client,
+		L1Pool:     l1Pool,
+		L2Pool:     l2Pool,
+		HTTPClient: http.DefaultClient,
+		PortalABI:  portalABI,
+	}
+}
+
+// headerJSON is a block header as returned by eth_getBlockByNumber
+func headerJSON(number, timestamp uint64) map[string]interface{} {
+	return map[string]interface{}{
+		"parentHash":       common.Hash{}.Hex(),
+		"sha3Uncles":       types.EmptyUncleHash.Hex(),
+		"miner":            common.Address{}.Hex(),
+		"stateRoot":        common.Hash{}.Hex(),
+		"transactionsRoot": types.EmptyTxsHash.Hex(),
+		"receiptsRoot":     types.EmptyReceiptsHash.Hex(),
+		"logsBloom":        hexutil.Encode(make([]byte, types.BloomByteLength)),
+		"difficulty":       "0x0",
+		"number":           hexutil.EncodeUint64(number),
+		"gasLimit":         "0x1c9c380",
+		"gasUsed":          "0x0",
+		"timestamp":        hexutil.EncodeUint64(timestamp),
+		"extraData":        "0x",
+		"mixHash":          common.Hash{}.Hex(),
+		"nonce":            "0x0000000000000000",
+		"hash":             common.BigToHash(new(big.Int).SetUint64(number)).Hex(),
+		"transactions":     []interface{}{},
+	}
+}
+
+// l1Header is the header of an L1 block served by l1Chain
+func l1Header(number uint64) map[string]interface{} {
+	return headerJSON(number, 1700000000+number*12)
+}
+
+// l1BlockHash returns the hash of an L1 block served by l1Chain
+func l1BlockHash(number uint64) common.Hash {
+	encoded, _ := json.Marshal(l1Header(number))
+	var header types.Header
+	if err := json.Unmarshal(encoded, &header); err != nil {
+		panic(err)
+	}
+	return header.Hash()
+}
+
+// receiptJSON is the successful receipt of a deposit transaction
+func receiptJSON(txHash common.Hash, blockNumber uint64) map[string]interface{} {
+	return map[string]interface{}{
+		"type":              hexutil.EncodeUint64(eth.DepositTxType),
+		"status":            "0x1",
+		"cumulativeGasUsed": "0xc350",
+		"gasUsed":           "0xc350",
+		"logsBloom":         hexutil.Encode(make([]byte, types.BloomByteLength)),
+		"logs":              []interface{}{},
+		"transactionHash":   txHash.Hex(),
+		"transactionIndex":  "0x1",
+		"blockHash":         common.BigToHash(new(big.Int).SetUint64(blockNumber)).Hex(),
+		"blockNumber":       hexutil.EncodeUint64(blockNumber),
+		"contractAddress":   nil,
+	}
+}
+
+// depositLog builds a TransactionDeposited log of a plain ETH deposit
+func depositLog(t *testing.T, portalABI abi.ABI, blockNumber uint64, logIndex uint) types.Log {
+	t.Helper()
+	opaqueData := make([]byte, 73)
+	big.NewInt(1e16).FillBytes(opaqueData[0:32])
+	big.NewInt(1e16).FillBytes(opaqueData[32:64])
+	binary.BigEndian.PutUint64(opaqueData[64:72], 100000)
+	data, err := portalABI.Events["TransactionDeposited"].Inputs.NonIndexed().Pack(opaqueData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return types.Log{
+		Address: common.HexToAddress("0xbEb5Fc579115071764c7423A4f12eDde41f106Ed"),
+		Topics: []common.Hash{
+			eth.DepositEventTopic,
+			common.BytesToHash(common.HexToAddress("0x00000000000000000000000000000000000000a1").Bytes()),
+			common.BytesToHash(common.HexToAddress("0x00000000000000000000000000000000000000b2").Bytes()),
+			{},
+		},
+		Data:        data,
+		BlockNumber: blockNumber,
+		BlockHash:   l1BlockHash(blockNumber),
+		TxHash:      common.BigToHash(big.NewInt(int64(blockNumber)<<16 | int64(logIndex))),
+		Index:       logIndex,
+	}
+}
+
+// l2Block serves L2 blocks with the given deposit transactions and the
+// receipts of those transactions
+func l2Block(l2 *fakeNode, blockNumber uint64, txHashes ...common.Hash) {
+	l2.handle("eth_getBlockByNumber", func(params []json.RawMessage) (interface{}, error) {
+		var number string
+		json.Unmarshal(params[0], &number)
+		n, _ := hexutil.DecodeUint64(number)
+		block := headerJSON(n, 1700000000+n*2)
+		if n == blockNumber {
+			txs := make([]interface{}, len(txHashes))
+			for i, hash := range txHashes {
+				txs[i] = map[string]interface{}{"hash": hash.Hex(), "type": depositTxTypeHex}
+			}
+			block["transactions"] = txs
+		}
+		return block, nil
+	})
+	l2.handle("eth_getTransactionReceipt", func(params []json.RawMessage) (interface{}, error) {
+		var hash common.Hash
+		json.Unmarshal(params[0], &hash)
+		for _, included := range txHashes {
+			if hash == included {
+				return receiptJSON(hash, blockNumber), nil
+			}
+		}
+		return nil, nil
+	})
+}
+
+// l1Chain serves the L1 calls needed to record a deposit: block headers and
+// an isFrozen call that reports no account as frozen
+func l1Chain(l1 *fakeNode) {
+	l1.handle("eth_getBlockByNumber", func(params []json.RawMessage) (interface{}, error) {
+		var number string
+		json.Unmarshal(params[0], &number)
+		n, _ := hexutil.DecodeUint64(number)
+		return l1Header(n), nil
+	})
+	l1.handle("eth_call", func([]json.RawMessage) (interface{}, error) {
+		return hexutil.Encode(make([]byte, 32)), nil
+	})
+}
+
+// newTestL1Monitor creates an L1 monitor on fake nodes with an in-memory store
+func newTestL1Monitor(t *testing.T, clients *eth.Clients, depositStore store.DepositStore) *L1Monitor {
+	t.Helper()
+	cfg := config.Default()
+	cfg.OptimismPortalAddress = "0xbEb5Fc579115071764c7423A4f12eDde41f106Ed"
+	cfg.FrozenContractAddress = "0x00000000000000000000000000000000000000f0"
+	frozenSet, err := eth.NewFrozenSet(cfg, clients, testCollector())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewL1Monitor(clients, frozenSet, depositStore, cfg, testCollector())
+}
+
+// TestDepositIncludedBeforeRecorded covers a deposit that L2 includes before
+// the L1 side records it: the L2 monitor has already passed its block when
+// the deposit is stored, so it must be confirmed by receipt.
+func TestDepositIncludedBeforeRecorded(t *testing.T) {
+	ctx := context.Background()
+	l1, l1Srv := newFakeNode(t)
+	l2, l2Srv := newFakeNode(t)
+	clients := testClients(t, l1Srv, l2Srv)
+	l1Chain(l1)
+
+	depositStore := store.NewMemoryStore()
+	m := newTestL1Monitor(t, clients, depositStore)
+
+	logEntry := depositLog(t, clients.PortalABI, 100, 4)
+	event, err := eth.DecodeDepositLog(clients.PortalABI, logEntry)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// L2 includes the deposit in block 50, which the L2 monitor checks
+	// before the deposit has its L1 confirmations
+	l2Block(l2, 50, event.L2TxHash)
+	if err := checkL2BlockViaRPC(ctx, 50, clients, depositStore, m.cfg, testCollector()); err != nil {
+		t.Fatalf("checkL2BlockViaRPC: %v", err)
+	}
+	if _, err := depositStore.GetDeposit(event.SourceHash); err != store.ErrNotFound {
+		t.Fatalf("deposit stored before L1 processing: %v", err)
+	}
+
+	if err := m.processLog(ctx, logEntry); err != nil {
+		t.Fatalf("processLog: %v", err)
+	}
+
+	deposit, err := depositStore.GetDeposit(event.SourceHash)
+	if err != nil {
+		t.Fatalf("GetDeposit: %v", err)
+	}
+	if deposit.Status != store.StatusConfirmed || deposit.L2BlockNumber != 50 || deposit.L2Status != "success" {
+		t.Errorf("deposit = %s in L2 block %d (%s), want confirmed in block 50 (success)", deposit.Status, deposit.L2BlockNumber, deposit.L2Status)
+	}
+}
+
+func TestCheckPendingDeposits(t *testing.T) {
+	ctx := context.Background()
+	l1, l1Srv := newFakeNode(t)
+	l2, l2Srv := newFakeNode(t)
+	clients := testClients(t, l1Srv, l2Srv)
+	l1Chain(l1)
+
+	tests := []struct {
+		name       string
+		included   bool
+		wantStatus store.DepositStatus
+		wantBlock  uint64
+	}{
+		{name: "not_included", included: false, wantStatus: store.StatusObserved},
+		{name: "included", included: true, wantStatus: store.StatusConfirmed, wantBlock: 70},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			depositStore := store.NewMemoryStore()
+			event, err := eth.DecodeDepositLog(clients.PortalABI, depositLog(t, clients.PortalABI, uint64(200+i), 0))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := depositStore.SaveDeposit(&store.Deposit{DepositEvent: *event, Status: store.StatusObserved}); err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.included {
+				l2Block(l2, 70, event.L2TxHash)
+			} else {
+				l2Block(l2, 70)
+			}
+			if err := checkPendingDeposits(ctx, clients, depositStore, testCollector()); err != nil {
+				t.Fatalf("checkPendingDeposits: %v", err)
+			}
+
+			deposit, err := depositStore.GetDeposit(event.SourceHash)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if deposit.Status != tt.wantStatus || deposit.L2BlockNumber != tt.wantBlock {
+				t.Errorf("deposit = %s in L2 block %d, want %s in block %d", deposit.Status, deposit.L2BlockNumber, tt.wantStatus, tt.wantBlock)
+			}
+		})
+	}
+}
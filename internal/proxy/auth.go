@@ -0,0 +1,165 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ddomeke/rpc_proxy/internal/auth"
+)
+
+// apiKeyHeader carries the API key, alternatively it is the last path segment
+const apiKeyHeader = "X-API-Key"
+
+// JSON-RPC error codes of rejected API keys and exceeded quotas
+const (
+	errCodeUnauthorized  = -32000 // Missing, unknown or not permitted API key
+	errCodeLimitExceeded = -32005 // EIP-1474 "Limit exceeded"
+)
+
+// callerKey is the context key of the caller of a request
+type callerKey struct{}
+
+// caller is the client IP and the API key a request was made with, and the
+// keyring and rate limits of the table it was resolved from. The key is nil
+// for anonymous requests.
+type caller struct {
+	ip      string
+	key     *auth.Key
+	keyring *auth.Keyring
+	limits  *rateLimits
+}
+
+// withCaller returns a context carrying the caller of a request
+func withCaller(ctx context.Context, c *caller) context.Context {
+	return context.WithValue(ctx, callerKey{}, c)
+}
+
+// callerFrom returns the caller of a request, nil if it was not authenticated
+func callerFrom(ctx context.Context) *caller {
+	c, _ := ctx.Value(callerKey{}).(*caller)
+	return c
+}
+
+// keyError is an API key that was rejected before any call was made
+type keyError struct {
+	status  int
+	message string
+}
+
+// resolveKey looks up the API key of a request to a route. Without a key the
+// request is anonymous unless keys are required.
+func (s *Server) resolveKey(keyring *auth.Keyring, rt *route, secret string) (*auth.Key, *keyError) {
+	if secret == "" {
+		if keyring.Required() {
+			s.metricsCollector.APIKeyRequests.WithLabelValues("none", "unauthorized").Inc()
+			return nil, &keyError{http.StatusUnauthorized, "API key required"}
+		}
+		return nil, nil
+	}
+
+	key, ok := keyring.Lookup(secret)
+	if !ok {
+		s.metricsCollector.APIKeyRequests.WithLabelValues("invalid", "unauthorized").Inc()
+		return nil, &keyError{http.StatusUnauthorized, "invalid API key"}
+	}
+	if !key.RouteAllowed(rt.name) {
+		s.metricsCollector.APIKeyRequests.WithLabelValues(key.Name, "forbidden").Inc()
+		return nil, &keyError{http.StatusForbidden, fmt.Sprintf("API key %s may not use route %s", key.Name, rt.name)}
+	}
+	return key, nil
+}
+
+// authenticate resolves the API key of an HTTP request from the X-API-Key
+// header or the key path segment. A rejected request is answered with a
+// JSON-RPC error and a nil context is returned.
+func (s *Server) authenticate(w http.ResponseWriter, r *http.Request, table *routeTable, rt *route, pathKey string) context.Context {
+	secret := r.Header.Get(apiKeyHeader)
+	if secret == "" {
+		secret = pathKey
+	}
+
+	key, kerr := s.resolveKey(table.keyring, rt, secret)
+	if kerr != nil {
+		slog.InfoContext(r.Context(), "Request rejected", "route", rt.name, "reason", kerr.message, "remote_addr", r.RemoteAddr)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(kerr.status)
+		w.Write(newErrorResponse(nil, errCodeUnauthorized, kerr.message))
+		return nil
+	}
+	return withCaller(r.Context(), &caller{
+		ip:      table.rateLimits.clientIP(r),
+		key:     key,
+		keyring: table.keyring,
+		limits:  table.rateLimits,
+	})
+}
+
+// authorizeCall checks that the caller's key may call the method, applies the
+// rate limits and charges the call to the key's quotas. It returns the error
+// response of a rejected call.
+func (s *Server) authorizeCall(ctx context.Context, req *rpcRequest, out *requestOutcome) json.RawMessage {
+	c := callerFrom(ctx)
+	if c == nil {
+		return nil
+	}
+
+	if c.key != nil && !c.key.MethodAllowed(req.Method) {
+		s.metricsCollector.APIKeyRequests.WithLabelValues(c.key.Name, "forbidden").Inc()
+		out.filtered, out.unknownMethod = true, true
+		return newErrorResponse(req.ID, errCodeMethodNotFound,
+			fmt.Sprintf("the method %s is not available for this API key", req.Method))
+	}
+	if rejection := s.rateLimitCall(ctx, c, req, out); rejection != nil {
+		return rejection
+	}
+	if c.key == nil {
+		return nil
+	}
+
+	err := s.meter.Charge(c.key, c.keyring.Cost(req.Method))
+	var quotaErr *auth.QuotaError
+	if errors.As(err, &quotaErr) {
+		slog.InfoContext(ctx, "Call rejected, quota exceeded", "api_key", c.key.Name, "quota", quotaErr.Quota, "method", req.Method)
+		out.limited, out.retryAfter = true, quotaErr.RetryAfter
+		return newLimitResponse(req.ID, quotaErr.Error(), quotaErr.RetryAfter)
+	}
+	return nil
+}
+
+// limitData is the data of a limit exceeded error
+type limitData struct {
+	RetryAfter int `json:"retryAfter"` // Seconds until the call would be allowed
+}
+
+// newLimitResponse builds a limit exceeded error response
+func newLimitResponse(id json.RawMessage, message string, retryAfter time.Duration) json.RawMessage {
+	if len(id) == 0 {
+		id = json.RawMessage("null")
+	}
+	resp, _ := json.Marshal(rpcResponse{
+		JSONRPC: "2.0",
+		ID:      id,
+		Error: &rpcError{
+			Code:    errCodeLimitExceeded,
+			Message: message,
+			Data:    limitData{RetryAfter: int(math.Ceil(retryAfter.Seconds()))},
+		},
+	})
+	return resp
+}
+
+// writeLimited sets the 429 status and the Retry-After header of a request
+// whose calls were all limited, with the seconds until the last of them
+// would be allowed
+func writeLimited(w http.ResponseWriter, retryAfter time.Duration) {
+	seconds := max(1, int(math.Ceil(retryAfter.Seconds())))
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	w.WriteHeader(http.StatusTooManyRequests)
+}
@@ -0,0 +1,155 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"testing"
+
+	"github.com/ddomeke/rpc_proxy/internal/config"
+)
+
+func TestLimitedStatus(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+		wantCodes  []int
+	}{
+		{
+			// An upstream's own limit error is passed through, it is no 429
+			name:       "upstream_limit_error",
+			body:       `{"jsonrpc":"2.0","id":1,"method":"eth_getLogs","params":[{}]}`,
+			wantStatus: http.StatusOK,
+			wantCodes:  []int{errCodeLimitExceeded},
+		},
+		{
+			name:       "allowed",
+			body:       `{"jsonrpc":"2.0","id":1,"method":"eth_blockNumber"}`,
+			wantStatus: http.StatusOK,
+			wantCodes:  []int{0},
+		},
+		{
+			name:       "limited",
+			body:       `{"jsonrpc":"2.0","id":2,"method":"eth_blockNumber"}`,
+			wantStatus: http.StatusTooManyRequests,
+			wantCodes:  []int{errCodeLimitExceeded},
+		},
+		{
+			// Only a batch of limited calls is a 429
+			name:       "batch_partly_limited",
+			body:       `[{"jsonrpc":"2.0","id":3,"method":"eth_blockNumber"},{"jsonrpc":"2.0","id":4,"method":"eth_getLogs","params":[{}]}]`,
+			wantStatus: http.StatusOK,
+			wantCodes:  []int{errCodeLimitExceeded, errCodeLimitExceeded},
+		},
+		{
+			name:       "batch_limited",
+			body:       `[{"jsonrpc":"2.0","id":5,"method":"eth_blockNumber"},{"jsonrpc":"2.0","id":6,"method":"eth_chainId"}]`,
+			wantStatus: http.StatusTooManyRequests,
+			wantCodes:  []int{errCodeLimitExceeded, errCodeLimitExceeded},
+		},
+	}
+
+	l1, l1Srv := newFakeUpstream(t, "0x1")
+	_, l2Srv := newFakeUpstream(t, "0xa")
+	l1.result("eth_blockNumber", `"0x10"`)
+	l1.handle("eth_getLogs", func(req rpcRequest) rpcResponse {
+		return rpcResponse{Error: &rpcError{Code: errCodeLimitExceeded, Message: "query returned more than 10000 results", Data: map[string]interface{}{"from": "0x1", "to": "0x2"}}}
+	})
+
+	cfg := testConfig(l1Srv, l2Srv)
+	cfg.RateLimit.PerIP.Cheap = config.RateLimit{RPS: 0.001, Burst: 1}
+	s := newTestServer(t, cfg)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := post(s, "/l1", tt.body, nil)
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+
+			retryAfter := rec.Header().Get("Retry-After")
+			if tt.wantStatus == http.StatusTooManyRequests {
+				if seconds, err := strconv.Atoi(retryAfter); err != nil || seconds < 1 {
+					t.Errorf("Retry-After = %q, want a positive number of seconds", retryAfter)
+				}
+			} else if retryAfter != "" {
+				t.Errorf("Retry-After = %q, want none", retryAfter)
+			}
+
+			responses := decodeResponses(t, rec.Body.Bytes())
+			if len(responses) != len(tt.wantCodes) {
+				t.Fatalf("got %d responses, want %d", len(responses), len(tt.wantCodes))
+			}
+			for i, resp := range responses {
+				code := 0
+				if resp.Error != nil {
+					code = resp.Error.Code
+				}
+				if code != tt.wantCodes[i] {
+					body, _ := json.Marshal(resp)
+					t.Errorf("response %d = %s, want error code %d", i, body, tt.wantCodes[i])
+				}
+			}
+		})
+	}
+}
+
+func TestAPIKeys(t *testing.T) {
+	// Cases run in order, the reader key uses up its daily requests
+	tests := []struct {
+		name       string
+		path       string
+		key        string // X-API-Key header
+		method     string
+		wantStatus int
+		wantCode   int
+	}{
+		{name: "no_key", path: "/l1", method: "eth_blockNumber", wantStatus: http.StatusUnauthorized, wantCode: errCodeUnauthorized},
+		{name: "invalid_key", path: "/l1", key: "wrong", method: "eth_blockNumber", wantStatus: http.StatusUnauthorized, wantCode: errCodeUnauthorized},
+		{name: "header_key", path: "/l1", key: "secret-full", method: "eth_blockNumber", wantStatus: http.StatusOK},
+		{name: "path_key", path: "/l1/secret-full", method: "eth_blockNumber", wantStatus: http.StatusOK},
+		{name: "route_forbidden", path: "/l1", key: "secret-l2", method: "eth_blockNumber", wantStatus: http.StatusForbidden, wantCode: errCodeUnauthorized},
+		{name: "route_allowed", path: "/l2/secret-l2", method: "eth_blockNumber", wantStatus: http.StatusOK},
+		{name: "method_forbidden", path: "/l1", key: "secret-reader", method: "eth_chainId", wantStatus: http.StatusOK, wantCode: errCodeMethodNotFound},
+		{name: "quota_first", path: "/l1", key: "secret-reader", method: "eth_blockNumber", wantStatus: http.StatusOK},
+		{name: "quota_last", path: "/l1", key: "secret-reader", method: "eth_blockNumber", wantStatus: http.StatusOK},
+		{name: "quota_exceeded", path: "/l1", key: "secret-reader", method: "eth_blockNumber", wantStatus: http.StatusTooManyRequests, wantCode: errCodeLimitExceeded},
+	}
+
+	l1, l1Srv := newFakeUpstream(t, "0x1")
+	l2, l2Srv := newFakeUpstream(t, "0xa")
+	l1.result("eth_blockNumber", `"0x10"`)
+	l2.result("eth_blockNumber", `"0x20"`)
+
+	cfg := testConfig(l1Srv, l2Srv)
+	cfg.Auth.Required = true
+	cfg.Auth.Keys = []config.APIKeyConfig{
+		{Name: "full", Key: "secret-full"},
+		{Name: "l2only", Key: "secret-l2", Routes: []string{"l2"}},
+		{Name: "reader", Key: "secret-reader", Methods: []string{"eth_blockNumber"}, DailyRequests: 2},
+	}
+	s := newTestServer(t, cfg)
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{}
+			if tt.key != "" {
+				header.Set(apiKeyHeader, tt.key)
+			}
+			body := `{"jsonrpc":"2.0","id":` + strconv.Itoa(i) + `,"method":"` + tt.method + `"}`
+			rec := post(s, tt.path, body, header)
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			resp := decodeResponses(t, rec.Body.Bytes())[0]
+			code := 0
+			if resp.Error != nil {
+				code = resp.Error.Code
+			}
+			if code != tt.wantCode {
+				t.Errorf("error code = %d, want %d (%s)", code, tt.wantCode, rec.Body.String())
+			}
+		})
+	}
+}
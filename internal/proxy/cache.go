@@ -0,0 +1,414 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ddomeke/rpc_proxy/internal/cache"
+	"github.com/ddomeke/rpc_proxy/internal/upstream"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// cacheUpstream is the upstream label of calls answered from the cache
+const cacheUpstream = "cache"
+
+// cacheKind is how long the result of a method stays valid
+type cacheKind int
+
+const (
+	cacheStatic  cacheKind = iota // Never changes on a chain
+	cacheByHash                   // Identified by a block hash
+	cacheByBlock                  // Depends on the block parameter
+	cacheByTx                     // Valid as long as the including block is not reorged
+	cacheHead                     // Changes with every block
+	cacheLogs                     // Depends on the block range of the filter
+)
+
+// cacheMethod is the cache policy of a method. blockParam is the position of
+// the block parameter of cacheByBlock methods.
+type cacheMethod struct {
+	kind       cacheKind
+	blockParam int
+}
+
+// cacheableMethods are the methods whose results are cached
+var cacheableMethods = map[string]cacheMethod{
+	"eth_chainId":                             {kind: cacheStatic},
+	"net_version":                             {kind: cacheStatic},
+	"eth_getBlockByHash":                      {kind: cacheByHash},
+	"eth_getBlockTransactionCountByHash":      {kind: cacheByHash},
+	"eth_getTransactionByBlockHashAndIndex":   {kind: cacheByHash},
+	"eth_getBlockByNumber":                    {kind: cacheByBlock, blockParam: 0},
+	"eth_getBlockTransactionCountByNumber":    {kind: cacheByBlock, blockParam: 0},
+	"eth_getTransactionByBlockNumberAndIndex": {kind: cacheByBlock, blockParam: 0},
+	"eth_getBlockReceipts":                    {kind: cacheByBlock, blockParam: 0},
+	"eth_getBalance":                          {kind: cacheByBlock, blockParam: 1},
+	"eth_getCode":                             {kind: cacheByBlock, blockParam: 1},
+	"eth_getTransactionCount":                 {kind: cacheByBlock, blockParam: 1},
+	"eth_call":                                {kind: cacheByBlock, blockParam: 1},
+	"eth_getStorageAt":                        {kind: cacheByBlock, blockParam: 2},
+	"eth_getTransactionByHash":                {kind: cacheByTx},
+	"eth_getTransactionReceipt":               {kind: cacheByTx},
+	"eth_blockNumber":                         {kind: cacheHead},
+	"eth_getLogs":                             {kind: cacheLogs},
+}
+
+// chainHeads is the head and the finalized block of an upstream pool as seen
+// by the reorg checks, with the hashes of the recent blocks
+type chainHeads struct {
+	mu        sync.RWMutex
+	head      uint64
+	finalized uint64
+	hashes    map[uint64]common.Hash
+}
+
+// blocks returns the head and the finalized block, zero while unknown
+func (h *chainHeads) blocks() (uint64, uint64) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.head, h.finalized
+}
+
+// cacheLookup is a cacheable call
+type cacheLookup struct {
+	chain  string
+	key    string
+	method cacheMethod
+	params []json.RawMessage
+	heads  *chainHeads
+}
+
+// lookupCache returns the cached response of a call, or the lookup to store
+// its response with if it is cacheable and not cached. Both are nil for calls
+// that are not cached, such as notifications, which get no response.
+func (s *Server) lookupCache(rt *route, req *rpcRequest) (json.RawMessage, *cacheLookup) {
+	if s.cache == nil || len(req.ID) == 0 {
+		return nil, nil
+	}
+	method, ok := cacheableMethods[req.Method]
+	if !ok {
+		return nil, nil
+	}
+	chain, err := s.chainID(rt.pool)
+	if err != nil {
+		return nil, nil
+	}
+
+	lookup := &cacheLookup{
+		chain:  chain,
+		key:    cache.Key(chain, req.Method, req.Params),
+		method: method,
+		heads:  s.heads[rt.pool],
+	}
+	if len(req.Params) > 0 && json.Unmarshal(req.Params, &lookup.params) != nil {
+		return nil, nil
+	}
+
+	result, ok := s.cache.Get(lookup.key, req.Method)
+	if !ok {
+		return nil, lookup
+	}
+	resp, _ := json.Marshal(rpcResponse{JSONRPC: "2.0", ID: responseID(req.ID), Result: result})
+	return resp, nil
+}
+
+// storeCache caches the result of a successful response for as long as the
+// policy of its method allows
+func (s *Server) storeCache(lookup *cacheLookup, respBody []byte) {
+	var resp rpcResponse
+	if json.Unmarshal(respBody, &resp) != nil || resp.Error != nil || len(resp.Result) == 0 || string(resp.Result) == "null" {
+		return
+	}
+	if ttl, block := s.cacheTTL(lookup, resp.Result); ttl > 0 {
+		s.cache.Set(lookup.chain, lookup.key, block, resp.Result, ttl)
+	}
+}
+
+// cacheTTL returns how long a result stays valid, 0 if it must not be cached,
+// and the highest block it depends on
+func (s *Server) cacheTTL(lookup *cacheLookup, result json.RawMessage) (time.Duration, uint64) {
+	cfg := s.current().config.Cache
+	head, finalized := lookup.heads.blocks()
+
+	// blockTTL is the TTL and the block of the result of a block number or tag
+	blockTTL := func(block json.RawMessage) (time.Duration, uint64) {
+		number, tag := parseBlockRef(block)
+		switch {
+		case tag == "hash" || tag == "earliest":
+			return cache.Forever, 0
+		case tag == "latest" || tag == "safe" || tag == "finalized":
+			return cfg.LatestTTL, cache.HeadBlock
+		case tag != "":
+			return 0, 0 // pending or unknown
+		case finalized > 0 && number <= finalized:
+			return cache.Forever, number
+		case number <= head:
+			return cfg.RecentTTL, number
+		}
+		return 0, 0
+	}
+
+	switch lookup.method.kind {
+	case cacheStatic, cacheByHash:
+		return cache.Forever, 0
+
+	case cacheHead:
+		return cfg.LatestTTL, cache.HeadBlock
+
+	case cacheByBlock:
+		block := json.RawMessage(`"latest"`)
+		if lookup.method.blockParam < len(lookup.params) {
+			block = lookup.params[lookup.method.blockParam]
+		}
+		return blockTTL(block)
+
+	case cacheByTx:
+		// Pending transactions have no block yet
+		var tx struct {
+			BlockNumber *hexutil.Uint64 `json:"blockNumber"`
+		}
+		if json.Unmarshal(result, &tx) != nil || tx.BlockNumber == nil {
+			return 0, 0
+		}
+		return blockTTL(json.RawMessage(strconv.Quote(tx.BlockNumber.String())))
+
+	case cacheLogs:
+		var filter logFilter
+		if len(lookup.params) == 0 || json.Unmarshal(lookup.params[0], &filter) != nil {
+			return 0, 0
+		}
+		if filter.BlockHash != "" {
+			return cache.Forever, 0
+		}
+		// The range is as valid as its least valid end
+		fromTTL, fromBlock := blockTTL(quoteBlock(filter.FromBlock))
+		toTTL, toBlock := blockTTL(quoteBlock(filter.ToBlock))
+		return min(fromTTL, toTTL), max(fromBlock, toBlock)
+	}
+	return 0, 0
+}
+
+// quoteBlock encodes a block of a log filter, a missing block is the latest
+func quoteBlock(block string) json.RawMessage {
+	if block == "" {
+		block = "latest"
+	}
+	return json.RawMessage(strconv.Quote(block))
+}
+
+// parseBlockRef parses a block parameter: a number, a tag, a block hash or an
+// EIP-1898 object. It returns the number of a block number, "hash" for block
+// hashes and the tag otherwise.
+func parseBlockRef(raw json.RawMessage) (uint64, string) {
+	var ref struct {
+		BlockNumber string `json:"blockNumber"`
+		BlockHash   string `json:"blockHash"`
+	}
+	var block string
+	if json.Unmarshal(raw, &block) != nil {
+		if json.Unmarshal(raw, &ref) != nil {
+			return 0, "invalid"
+		}
+		if ref.BlockHash != "" {
+			return 0, "hash"
+		}
+		block = ref.BlockNumber
+	}
+
+	if len(block) == 66 && strings.HasPrefix(block, "0x") {
+		return 0, "hash"
+	}
+	if number, err := hexutil.DecodeUint64(block); err == nil {
+		return number, ""
+	}
+	if block == "" {
+		return 0, "invalid"
+	}
+	return 0, block
+}
+
+// responseID returns the ID of a response to a request, null if it has none
+func responseID(id json.RawMessage) json.RawMessage {
+	if len(id) == 0 {
+		return json.RawMessage("null")
+	}
+	return id
+}
+
+// headsWindow is the number of recent block hashes kept for reorg checks
+const headsWindow = 256
+
+// TrackHeads follows the head and the finalized block of both chains while
+// the cache is enabled, and drops the cached results of recent blocks when a
+// reorg is detected. It runs until the context is cancelled.
+func (s *Server) TrackHeads(ctx context.Context) {
+	if s.cache == nil {
+		return
+	}
+	interval := s.current().config.Cache.HeadInterval
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		for pool, heads := range s.heads {
+			s.checkHead(ctx, pool, heads)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// checkHead fetches the head and the finalized block of a pool. A block whose
+// hash differs from the hash seen before is a reorg: the recent blocks are
+// compared from the head down until one matches, and the cached results from
+// the lowest block that differs on are dropped. Nodes that lag behind return
+// older blocks of the same chain, which is no reorg.
+func (s *Server) checkHead(ctx context.Context, pool *upstream.Pool, heads *chainHeads) {
+	latest, err := fetchHeader(ctx, pool, "latest")
+	if err != nil {
+		slog.DebugContext(ctx, "Could not fetch head for reorg check", "pool", pool.Name(), "error", err)
+		return
+	}
+	finalized, err := fetchHeader(ctx, pool, "finalized")
+	if err != nil {
+		// Chains without finality cache no block forever
+		slog.DebugContext(ctx, "Could not fetch finalized block", "pool", pool.Name(), "error", err)
+	}
+
+	number := uint64(latest.Number)
+	canonical := map[uint64]common.Hash{number: latest.Hash}
+	if number > 0 {
+		canonical[number-1] = latest.ParentHash
+	}
+	from, reorged, err := s.findReorg(ctx, pool, heads, number, canonical)
+	if err != nil {
+		// Checked again with the next head
+		slog.DebugContext(ctx, "Could not fetch block for reorg check", "pool", pool.Name(), "error", err)
+		return
+	}
+
+	heads.mu.Lock()
+	if reorged {
+		// Hashes from the reorged block on belong to the old chain
+		for n := range heads.hashes {
+			if n >= from {
+				delete(heads.hashes, n)
+			}
+		}
+		heads.head = number
+	}
+	for n, hash := range canonical {
+		heads.hashes[n] = hash
+	}
+	heads.head = max(heads.head, number)
+	if finalized != nil {
+		heads.finalized = max(heads.finalized, uint64(finalized.Number))
+	}
+	for n := range heads.hashes {
+		if n+headsWindow < heads.head {
+			delete(heads.hashes, n)
+		}
+	}
+	heads.mu.Unlock()
+
+	if reorged {
+		chain, err := s.chainID(pool)
+		if err != nil {
+			return
+		}
+		dropped := s.cache.Invalidate(chain, from)
+		slog.WarnContext(ctx, "Reorg detected, cached results of recent blocks dropped", "pool", pool.Name(), "block", number, "from_block", from, "dropped", dropped)
+	}
+}
+
+// findReorg compares the known hashes of the recent blocks up to the head
+// with the canonical chain, from the head down until a block matches. It
+// returns the lowest block that differs and whether one does. canonical holds
+// the hashes of the canonical chain that are known, the ones fetched are added.
+// Blocks whose hash was never seen are skipped, below a block that differs they
+// count as reorged as well. If no block matches, every recent block is reorged.
+func (s *Server) findReorg(ctx context.Context, pool *upstream.Pool, heads *chainHeads, number uint64, canonical map[uint64]common.Hash) (uint64, bool, error) {
+	heads.mu.RLock()
+	known := make(map[uint64]common.Hash, len(heads.hashes))
+	lowest := number
+	for n, hash := range heads.hashes {
+		known[n] = hash
+		lowest = min(lowest, n)
+	}
+	heads.mu.RUnlock()
+
+	from, reorged := uint64(0), false
+	for n := number; n >= lowest; n-- {
+		hash, ok := known[n]
+		if !ok {
+			if reorged {
+				from = n
+			}
+		} else {
+			current, ok := canonical[n]
+			if !ok {
+				header, err := fetchHeader(ctx, pool, hexutil.EncodeUint64(n))
+				if err != nil && !reorged {
+					return 0, false, fmt.Errorf("block %d: %v", n, err)
+				}
+				if err != nil {
+					// The depth is unknown, so every recent block counts as reorged
+					return lowest, true, nil
+				}
+				current = header.Hash
+				canonical[n] = current
+				if n > 0 {
+					canonical[n-1] = header.ParentHash
+				}
+			}
+			if current == hash {
+				return from, reorged, nil
+			}
+			from, reorged = n, true
+		}
+		if n == 0 {
+			break
+		}
+	}
+	return 0, reorged, nil
+}
+
+// header is the part of a block the reorg checks need
+type header struct {
+	Number     hexutil.Uint64 `json:"number"`
+	Hash       common.Hash    `json:"hash"`
+	ParentHash common.Hash    `json:"parentHash"`
+}
+
+// fetchHeader fetches a block by tag from a pool
+func fetchHeader(ctx context.Context, pool *upstream.Pool, tag string) (*header, error) {
+	body := fmt.Sprintf(`{"jsonrpc":"2.0","id":1,"method":"eth_getBlockByNumber","params":[%q,false]}`, tag)
+	respBody, _, err := pool.Forward(ctx, []byte(body))
+	if err != nil {
+		return nil, err
+	}
+	var resp struct {
+		Result *header   `json:"result"`
+		Error  *rpcError `json:"error"`
+	}
+	if err := json.Unmarshal(respBody, &resp); err != nil {
+		return nil, err
+	}
+	if resp.Error != nil {
+		return nil, fmt.Errorf("%s", resp.Error.Message)
+	}
+	if resp.Result == nil {
+		return nil, fmt.Errorf("no %s block", tag)
+	}
+	return resp.Result, nil
+}
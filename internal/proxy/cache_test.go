@@ -0,0 +1,222 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"math/big"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ddomeke/rpc_proxy/internal/cache"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// testChain serves the blocks of a chain that can be reorged from a block on
+type testChain struct {
+	mu       sync.Mutex
+	head     uint64
+	forkFrom uint64 // Blocks from here on are of the fork, 0 for none
+}
+
+// hash returns the hash of a block of the chain
+func (c *testChain) hash(n uint64) common.Hash {
+	fork := int64(0)
+	if c.forkFrom > 0 && n >= c.forkFrom {
+		fork = 1
+	}
+	return common.BigToHash(big.NewInt(int64(n)*10 + fork))
+}
+
+// set moves the head and the fork of the chain
+func (c *testChain) set(head, forkFrom uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.head, c.forkFrom = head, forkFrom
+}
+
+// serve answers eth_getBlockByNumber from the chain
+func (c *testChain) serve(f *fakeUpstream) {
+	f.handle("eth_getBlockByNumber", func(req rpcRequest) rpcResponse {
+		var params []json.RawMessage
+		var tag string
+		json.Unmarshal(req.Params, &params)
+		json.Unmarshal(params[0], &tag)
+
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		var n uint64
+		switch tag {
+		case "latest":
+			n = c.head
+		case "finalized":
+			return rpcResponse{Result: json.RawMessage("null")}
+		default:
+			n, _ = hexutil.DecodeUint64(tag)
+		}
+		block, _ := json.Marshal(header{Number: hexutil.Uint64(n), Hash: c.hash(n), ParentHash: c.hash(n - 1)})
+		return rpcResponse{Result: block}
+	})
+}
+
+func TestCheckHeadReorg(t *testing.T) {
+	tests := []struct {
+		name     string
+		head     uint64
+		forkFrom uint64
+		wantKept []uint64
+	}{
+		{name: "next_block", head: 101, wantKept: []uint64{94, 96, 98, 100, cache.HeadBlock}},
+		{name: "lagging_node", head: 97, wantKept: []uint64{94, 96, 98, 100, cache.HeadBlock}},
+		{name: "head_replaced", head: 100, forkFrom: 100, wantKept: []uint64{94, 96, 98}},
+		// The parent of the new head matches, the walk goes on below it
+		{name: "deep_reorg", head: 102, forkFrom: 97, wantKept: []uint64{94, 96}},
+		{name: "beyond_window", head: 101, forkFrom: 50},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			l1, l1Srv := newFakeUpstream(t, "0x1")
+			_, l2Srv := newFakeUpstream(t, "0xa")
+			chain := &testChain{}
+			chain.serve(l1)
+
+			cfg := testConfig(l1Srv, l2Srv)
+			cfg.Cache.Enabled = true
+			s := newTestServer(t, cfg)
+			pool, heads := s.ethClients.L1Pool, s.heads[s.ethClients.L1Pool]
+
+			// Heads 95 to 100 were seen one at a time
+			for head := uint64(95); head <= 100; head++ {
+				chain.set(head, 0)
+				s.checkHead(ctx, pool, heads)
+			}
+			blocks := []uint64{94, 96, 98, 100, cache.HeadBlock}
+			for _, block := range blocks {
+				s.cache.Set("1", blockKey(block), block, json.RawMessage(`"0x1"`), time.Minute)
+			}
+			s.cache.Set("1", "final", 0, json.RawMessage(`"0x1"`), cache.Forever)
+
+			chain.set(tt.head, tt.forkFrom)
+			s.checkHead(ctx, pool, heads)
+
+			kept := map[uint64]bool{}
+			for _, block := range tt.wantKept {
+				kept[block] = true
+			}
+			for _, block := range blocks {
+				if _, ok := s.cache.Get(blockKey(block), "eth_getBalance"); ok != kept[block] {
+					t.Errorf("result of block %d kept = %v, want %v", block, ok, kept[block])
+				}
+			}
+			if _, ok := s.cache.Get("final", "eth_chainId"); !ok {
+				t.Error("result that never changes was dropped")
+			}
+
+			// The hashes of the new chain replace those of the old one
+			for n := tt.head - 1; n <= tt.head; n++ {
+				heads.mu.RLock()
+				hash := heads.hashes[n]
+				heads.mu.RUnlock()
+				if hash != chain.hash(n) {
+					t.Errorf("hash of block %d = %s, want %s", n, hash, chain.hash(n))
+				}
+			}
+		})
+	}
+}
+
+// blockKey is the cache key of a result of a block
+func blockKey(block uint64) string {
+	return "block:" + hexutil.EncodeUint64(block)
+}
+
+func TestCacheTTL(t *testing.T) {
+	_, l1Srv := newFakeUpstream(t, "0x1")
+	_, l2Srv := newFakeUpstream(t, "0xa")
+	cfg := testConfig(l1Srv, l2Srv)
+	cfg.Cache.Enabled = true
+	cfg.Cache.LatestTTL = time.Second
+	s := newTestServer(t, cfg)
+	heads := &chainHeads{head: 100, finalized: 90, hashes: make(map[uint64]common.Hash)}
+	recent := cfg.Cache.RecentTTL
+
+	tests := []struct {
+		name      string
+		method    string
+		params    string
+		result    string
+		wantTTL   time.Duration
+		wantBlock uint64
+	}{
+		{name: "static", method: "eth_chainId", params: `[]`, wantTTL: cache.Forever},
+		{name: "finalized_block", method: "eth_getBalance", params: `["0xa1","0x50"]`, wantTTL: cache.Forever, wantBlock: 80},
+		{name: "recent_block", method: "eth_getBalance", params: `["0xa1","0x5f"]`, wantTTL: recent, wantBlock: 95},
+		{name: "latest", method: "eth_getBalance", params: `["0xa1","latest"]`, wantTTL: time.Second, wantBlock: cache.HeadBlock},
+		{name: "default_latest", method: "eth_getBlockByNumber", params: `[]`, wantTTL: time.Second, wantBlock: cache.HeadBlock},
+		{name: "pending", method: "eth_getBalance", params: `["0xa1","pending"]`},
+		{name: "after_head", method: "eth_getBalance", params: `["0xa1","0x70"]`},
+		{name: "block_hash", method: "eth_call", params: `[{},{"blockHash":"0x` + common.Hash{1}.Hex()[2:] + `"}]`, wantTTL: cache.Forever},
+		{name: "head", method: "eth_blockNumber", params: `[]`, wantTTL: time.Second, wantBlock: cache.HeadBlock},
+		{name: "recent_tx", method: "eth_getTransactionReceipt", params: `["0x01"]`, result: `{"blockNumber":"0x5f"}`, wantTTL: recent, wantBlock: 95},
+		{name: "pending_tx", method: "eth_getTransactionByHash", params: `["0x01"]`, result: `{"blockNumber":null}`},
+		// A range is as valid as its least valid end and depends on its highest block
+		{name: "logs_range", method: "eth_getLogs", params: `[{"fromBlock":"0x50","toBlock":"0x60"}]`, wantTTL: recent, wantBlock: 96},
+		{name: "logs_to_latest", method: "eth_getLogs", params: `[{"fromBlock":"0x50"}]`, wantTTL: time.Second, wantBlock: cache.HeadBlock},
+		{name: "logs_block_hash", method: "eth_getLogs", params: `[{"blockHash":"0x01"}]`, wantTTL: cache.Forever},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lookup := &cacheLookup{method: cacheableMethods[tt.method], heads: heads}
+			if err := json.Unmarshal([]byte(tt.params), &lookup.params); err != nil {
+				t.Fatal(err)
+			}
+			ttl, block := s.cacheTTL(lookup, json.RawMessage(tt.result))
+			if ttl != tt.wantTTL {
+				t.Errorf("ttl = %v, want %v", ttl, tt.wantTTL)
+			}
+			if ttl > 0 && ttl != cache.Forever && block != tt.wantBlock {
+				t.Errorf("block = %d, want %d", block, tt.wantBlock)
+			}
+		})
+	}
+}
+
+func TestCachedResponses(t *testing.T) {
+	l1, l1Srv := newFakeUpstream(t, "0x1")
+	_, l2Srv := newFakeUpstream(t, "0xa")
+	l1.result("eth_getBlockByHash", `{"number":"0x1"}`)
+	cfg := testConfig(l1Srv, l2Srv)
+	cfg.Cache.Enabled = true
+	s := newTestServer(t, cfg)
+
+	call := `{"jsonrpc":"2.0","id":ID,"method":"eth_getBlockByHash","params":["0x01",false]}`
+	tests := []struct {
+		name         string
+		body         string
+		wantBody     string
+		wantUpstream int
+	}{
+		{name: "miss", body: strings.Replace(call, "ID", "1", 1), wantBody: `{"jsonrpc":"2.0","id":1,"result":{"number":"0x1"}}`, wantUpstream: 1},
+		{name: "hit", body: strings.Replace(call, "ID", `"two"`, 1), wantBody: `{"jsonrpc":"2.0","id":"two","result":{"number":"0x1"}}`, wantUpstream: 1},
+		// Parameters are normalized
+		{name: "hit_normalized", body: strings.Replace(strings.Replace(call, "ID", "3", 1), `["0x01",false]`, `[ "0X01", false ]`, 1), wantBody: `{"jsonrpc":"2.0","id":3,"result":{"number":"0x1"}}`, wantUpstream: 1},
+		{name: "notification", body: strings.Replace(call, `"id":ID,`, "", 1), wantUpstream: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := post(s, "/l1", tt.body, nil)
+			if got := strings.TrimSpace(rec.Body.String()); got != tt.wantBody {
+				t.Errorf("body = %s, want %s", got, tt.wantBody)
+			}
+			if got := l1.count("eth_getBlockByHash"); got != tt.wantUpstream {
+				t.Errorf("upstream got %d calls, want %d", got, tt.wantUpstream)
+			}
+		})
+	}
+}
@@ -0,0 +1,69 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/ddomeke/rpc_proxy/internal/cache"
+	"github.com/ddomeke/rpc_proxy/internal/upstream"
+)
+
+// flightResult is the upstream response shared by identical calls
+type flightResult struct {
+	body     []byte
+	upstream string // Node that answered
+}
+
+// coalesceRequest sends a request upstream once for all identical idempotent
+// requests in flight at the same time. Every caller gets the shared response
+// with its own JSON-RPC id. Notifications get no response, so they are never
+// shared.
+func (s *Server) coalesceRequest(ctx context.Context, rt *route, body []byte, req *rpcRequest, out *requestOutcome) ([]byte, error) {
+	if !s.current().config.Upstream.Coalesce || len(req.ID) == 0 || !upstream.IsIdempotent(body) {
+		return s.sendUpstream(ctx, rt, body, out)
+	}
+
+	// Responses of a pool do not depend on the route, which filters them
+	// afterwards, so identical calls on all routes of a pool are shared
+	key := cache.Key(rt.pool.Name(), req.Method, req.Params)
+	leader := false
+	flight := s.flights.DoChan(key, func() (interface{}, error) {
+		leader = true
+		// Callers that are still waiting get the response even if the
+		// caller that sent it went away
+		var sent requestOutcome
+		respBody, err := s.sendUpstream(context.WithoutCancel(ctx), rt, body, &sent)
+		return flightResult{respBody, sent.upstream}, err
+	})
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case res := <-flight:
+		result, _ := res.Val.(flightResult)
+		out.upstream = result.upstream
+		if res.Err != nil {
+			return nil, res.Err
+		}
+		if leader {
+			return result.body, nil
+		}
+		out.coalesced = true
+		return withID(result.body, req.ID), nil
+	}
+}
+
+// withID returns a response with the id of another request. Responses that
+// cannot be parsed are returned as they are.
+func withID(respBody []byte, id json.RawMessage) []byte {
+	var resp rpcResponse
+	if err := json.Unmarshal(respBody, &resp); err != nil {
+		return respBody
+	}
+	resp.ID = responseID(id)
+	rewritten, err := json.Marshal(resp)
+	if err != nil {
+		return respBody
+	}
+	return rewritten
+}
@@ -0,0 +1,127 @@
+package proxy
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCoalesce(t *testing.T) {
+	tests := []struct {
+		name          string
+		disabled      bool
+		bodies        []string
+		wantUpstream  int
+		wantResponses int // Responses with a result
+	}{
+		{
+			name:          "identical",
+			bodies:        []string{`{"jsonrpc":"2.0","id":ID,"method":"test_wait","params":["a"]}`},
+			wantUpstream:  1,
+			wantResponses: 4,
+		},
+		{
+			name:          "disabled",
+			disabled:      true,
+			bodies:        []string{`{"jsonrpc":"2.0","id":ID,"method":"test_wait","params":["a"]}`},
+			wantUpstream:  4,
+			wantResponses: 4,
+		},
+		{
+			name: "different_params",
+			bodies: []string{
+				`{"jsonrpc":"2.0","id":ID,"method":"test_wait","params":["a"]}`,
+				`{"jsonrpc":"2.0","id":ID,"method":"test_wait","params":["b"]}`,
+			},
+			wantUpstream:  2,
+			wantResponses: 4,
+		},
+		{
+			name:          "not_idempotent",
+			bodies:        []string{`{"jsonrpc":"2.0","id":ID,"method":"eth_sendRawTransaction","params":["0x00"]}`},
+			wantUpstream:  4,
+			wantResponses: 4,
+		},
+		{
+			// A notification neither takes nor hands out a response
+			name: "notification",
+			bodies: []string{
+				`{"jsonrpc":"2.0","method":"test_wait","params":["a"]}`,
+				`{"jsonrpc":"2.0","id":ID,"method":"test_wait","params":["a"]}`,
+			},
+			wantUpstream:  3,
+			wantResponses: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l1, l1Srv := newFakeUpstream(t, "0x1")
+			_, l2Srv := newFakeUpstream(t, "0xa")
+			release := make(chan struct{})
+			wait := func(req rpcRequest) rpcResponse {
+				<-release
+				return rpcResponse{Result: req.Params}
+			}
+			l1.handle("test_wait", wait)
+			l1.handle("eth_sendRawTransaction", wait)
+
+			cfg := testConfig(l1Srv, l2Srv)
+			cfg.Upstream.Coalesce = !tt.disabled
+			// Screening is not under test
+			cfg.Routes[0].ScreenTxs = false
+			s := newTestServer(t, cfg)
+
+			// Four calls, taking turns among the bodies
+			var wg sync.WaitGroup
+			bodies := make([]string, 4)
+			got := make([][]byte, 4)
+			for i := range bodies {
+				bodies[i] = strings.Replace(tt.bodies[i%len(tt.bodies)], "ID", strconv.Itoa(i+1), 1)
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					got[i] = post(s, "/l1", bodies[i], nil).Body.Bytes()
+				}(i)
+			}
+			// Give all calls time to reach the proxy before the upstream answers
+			time.Sleep(50 * time.Millisecond)
+			close(release)
+			wg.Wait()
+
+			upstream := l1.count("test_wait") + l1.count("eth_sendRawTransaction")
+			if upstream != tt.wantUpstream {
+				t.Errorf("upstream got %d calls, want %d", upstream, tt.wantUpstream)
+			}
+
+			responses := 0
+			for i, body := range got {
+				var req rpcRequest
+				json.Unmarshal([]byte(bodies[i]), &req)
+				if len(req.ID) == 0 {
+					if len(body) != 0 {
+						t.Errorf("notification got response %s", body)
+					}
+					continue
+				}
+				var resp rpcResponse
+				if err := json.Unmarshal(body, &resp); err != nil {
+					t.Fatalf("call %d got invalid response %q", i, body)
+				}
+				if string(resp.ID) != string(req.ID) {
+					t.Errorf("call %d got response for ID %s", i, resp.ID)
+				}
+				if string(resp.Result) != string(req.Params) {
+					t.Errorf("call %d with params %s got result %s", i, req.Params, resp.Result)
+				}
+				responses++
+			}
+			if responses != tt.wantResponses {
+				t.Errorf("%d calls got a response, want %d", responses, tt.wantResponses)
+			}
+		})
+	}
+}
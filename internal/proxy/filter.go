@@ -0,0 +1,49 @@
+package proxy
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+
+	"github.com/ddomeke/rpc_proxy/internal/eth"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// depositLogSender returns the sender of a TransactionDeposited log in its JSON form
+func depositLogSender(logMap map[string]interface{}) (common.Address, bool) {
+	topics, ok := logMap["topics"].([]interface{})
+	if !ok || len(topics) < 2 {
+		return common.Address{}, false
+	}
+	if topic, _ := topics[0].(string); !strings.EqualFold(topic, eth.DepositEventTopic.Hex()) {
+		return common.Address{}, false
+	}
+	fromAddrHex, ok := topics[1].(string)
+	if !ok {
+		return common.Address{}, false
+	}
+	return common.HexToAddress(fromAddrHex), true
+}
+
+// filterFrozenDepositLog reports whether a log must be dropped because it is a
+// TransactionDeposited event sent by a frozen account. Logs whose sender cannot
+// be checked are dropped as well. Deposits are recorded by the L1 monitor once
+// they are confirmed, not from proxied responses, which may include blocks
+// that are later reorged.
+func (s *Server) filterFrozenDepositLog(ctx context.Context, logMap map[string]interface{}) bool {
+	fromAddress, ok := depositLogSender(logMap)
+	if !ok {
+		return false
+	}
+
+	frozen, err := s.frozenSet.IsFrozen(ctx, fromAddress)
+	if err != nil {
+		slog.ErrorContext(ctx, "Frozen address check error", "address", fromAddress.Hex(), "error", err)
+		return true
+	}
+	if frozen {
+		slog.InfoContext(ctx, "Frozen account found", "address", fromAddress.Hex())
+		return true
+	}
+	return false
+}
@@ -0,0 +1,147 @@
+package proxy
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Outcomes of a proxied JSON-RPC request
+const (
+	outcomeSuccess       = "success"
+	outcomeUpstreamError = "upstream_error"
+	outcomeJSONRPCError  = "jsonrpc_error"
+	outcomeFiltered      = "filtered"
+	outcomeLimited       = "limited"
+)
+
+// otherMethod is the method label of requests for methods that are unknown or
+// not allowed on the route, which keeps arbitrary client input out of labels
+const otherMethod = "other"
+
+// Methods that are labeled by name, together with the names a route allows.
+// Every other method is labeled otherMethod.
+var labeledMethods = map[string]bool{
+	"web3_clientVersion":                      true,
+	"web3_sha3":                               true,
+	"net_version":                             true,
+	"net_listening":                           true,
+	"net_peerCount":                           true,
+	"eth_accounts":                            true,
+	"eth_blobBaseFee":                         true,
+	"eth_blockNumber":                         true,
+	"eth_call":                                true,
+	"eth_chainId":                             true,
+	"eth_coinbase":                            true,
+	"eth_createAccessList":                    true,
+	"eth_estimateGas":                         true,
+	"eth_feeHistory":                          true,
+	"eth_gasPrice":                            true,
+	"eth_getBalance":                          true,
+	"eth_getBlockByHash":                      true,
+	"eth_getBlockByNumber":                    true,
+	"eth_getBlockReceipts":                    true,
+	"eth_getBlockTransactionCountByHash":      true,
+	"eth_getBlockTransactionCountByNumber":    true,
+	"eth_getCode":                             true,
+	"eth_getFilterChanges":                    true,
+	"eth_getFilterLogs":                       true,
+	"eth_getLogs":                             true,
+	"eth_getProof":                            true,
+	"eth_getStorageAt":                        true,
+	"eth_getTransactionByBlockHashAndIndex":   true,
+	"eth_getTransactionByBlockNumberAndIndex": true,
+	"eth_getTransactionByHash":                true,
+	"eth_getTransactionCount":                 true,
+	"eth_getTransactionReceipt":               true,
+	"eth_getUncleCountByBlockHash":            true,
+	"eth_getUncleCountByBlockNumber":          true,
+	"eth_maxPriorityFeePerGas":                true,
+	"eth_newBlockFilter":                      true,
+	"eth_newFilter":                           true,
+	"eth_newPendingTransactionFilter":         true,
+	"eth_sendRawTransaction":                  true,
+	"eth_sendRawTransactionConditional":       true,
+	"eth_subscribe":                           true,
+	"eth_syncing":                             true,
+	"eth_uninstallFilter":                     true,
+	"eth_unsubscribe":                         true,
+	"debug_traceBlockByHash":                  true,
+	"debug_traceBlockByNumber":                true,
+	"debug_traceCall":                         true,
+	"debug_traceTransaction":                  true,
+	"trace_block":                             true,
+	"trace_filter":                            true,
+	"trace_replayBlockTransactions":           true,
+	"optimism_outputAtBlock":                  true,
+	"optimism_rollupConfig":                   true,
+	"optimism_syncStatus":                     true,
+}
+
+// methodLabel returns the method label of a call on the route
+func (rt *route) methodLabel(method string) string {
+	if labeledMethods[method] || (rt.allowed != nil && rt.allowed.names[method]) {
+		return method
+	}
+	return otherMethod
+}
+
+// noUpstream is the upstream label of requests that never reached a node
+const noUpstream = "none"
+
+// requestOutcome collects how a single JSON-RPC request was handled
+type requestOutcome struct {
+	upstream      string // Node that answered, empty if none was reached
+	filtered      bool   // Rejected by a route policy
+	unknownMethod bool   // Method not allowed on the route
+	limited       bool   // Rejected because a quota is exceeded
+	coalesced     bool   // Answered with the response of an identical call in flight
+
+	retryAfter time.Duration // Wait until a limited call would be allowed
+}
+
+// observeRequest records the metrics of a handled JSON-RPC request
+func (s *Server) observeRequest(rt *route, method string, out *requestOutcome, respBody []byte, err error, elapsed time.Duration) {
+	outcome := outcomeSuccess
+	switch {
+	case out.limited:
+		outcome = outcomeLimited
+	case out.filtered:
+		outcome = outcomeFiltered
+	case err != nil:
+		outcome = outcomeUpstreamError
+	default:
+		if code, ok := responseErrorCode(respBody); ok {
+			outcome = outcomeJSONRPCError
+			if code == errCodeMethodNotFound {
+				out.unknownMethod = true
+			}
+		}
+	}
+
+	if out.unknownMethod {
+		method = otherMethod
+	} else {
+		method = rt.methodLabel(method)
+	}
+	upstream := out.upstream
+	if upstream == "" {
+		upstream = noUpstream
+	}
+
+	s.metricsCollector.ProxyRequests.WithLabelValues(rt.name, method, upstream, outcome).Inc()
+	s.metricsCollector.ProxyRequestDuration.WithLabelValues(rt.name, method, outcome).Observe(elapsed.Seconds())
+	if out.coalesced {
+		s.metricsCollector.CoalescedRequests.WithLabelValues(rt.name, method).Inc()
+	}
+}
+
+// responseErrorCode returns the error code of an encoded JSON-RPC error response
+func responseErrorCode(body []byte) (int, bool) {
+	var resp struct {
+		Error *rpcError `json:"error"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil || resp.Error == nil {
+		return 0, false
+	}
+	return resp.Error.Code, true
+}
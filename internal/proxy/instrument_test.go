@@ -0,0 +1,65 @@
+package proxy
+
+import (
+	"testing"
+
+	"github.com/ddomeke/rpc_proxy/internal/config"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestMethodLabel(t *testing.T) {
+	rt := newRoute(config.RouteConfig{
+		Name:           "custom",
+		AllowedMethods: []string{"eth_*", "custom_method"},
+	}, nil)
+	open := newRoute(config.RouteConfig{Name: "open"}, nil)
+
+	tests := []struct {
+		name   string
+		route  *route
+		method string
+		want   string
+	}{
+		{name: "standard", route: open, method: "eth_getBalance", want: "eth_getBalance"},
+		{name: "allowed_name", route: rt, method: "custom_method", want: "custom_method"},
+		// A glob allows any number of names, only listed names are labeled
+		{name: "allowed_glob", route: rt, method: "eth_madeUp1", want: otherMethod},
+		{name: "unknown", route: open, method: "eth_madeUp2", want: otherMethod},
+		{name: "allowed_elsewhere", route: open, method: "custom_method", want: otherMethod},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.route.methodLabel(tt.method); got != tt.want {
+				t.Errorf("methodLabel(%q) = %q, want %q", tt.method, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequestMetricsMethodLabel(t *testing.T) {
+	l1, l1Srv := newFakeUpstream(t, "0x1")
+	_, l2Srv := newFakeUpstream(t, "0xa")
+	l1.result("eth_madeUpMethod", `"0x1"`)
+	s := newTestServer(t, testConfig(l1Srv, l2Srv))
+
+	// The upstream answers the call, its method is still no label
+	rec := post(s, "/l1", `{"jsonrpc":"2.0","id":1,"method":"eth_madeUpMethod"}`, nil)
+	if resp := decodeResponses(t, rec.Body.Bytes()); resp[0].Error != nil {
+		t.Fatalf("unexpected error response %+v", resp[0].Error)
+	}
+
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			for _, label := range metric.GetLabel() {
+				if label.GetName() == "method" && label.GetValue() == "eth_madeUpMethod" {
+					t.Errorf("%s has a series for the unknown method", family.GetName())
+				}
+			}
+		}
+	}
+}
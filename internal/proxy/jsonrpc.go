@@ -0,0 +1,63 @@
+package proxy
+
+import (
+	"encoding/json"
+)
+
+// Standard JSON-RPC 2.0 error codes
+const (
+	errCodeParseError     = -32700
+	errCodeInvalidRequest = -32600
+	errCodeInternalError  = -32603
+)
+
+// rpcRequest is a single JSON-RPC request object
+type rpcRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+// rpcError is a JSON-RPC error object
+type rpcError struct {
+	Code    int         `json:"code"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+// rpcResponse is a JSON-RPC response object
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+// newErrorResponse builds an encoded JSON-RPC error response for the given request id
+func newErrorResponse(id json.RawMessage, code int, message string) json.RawMessage {
+	if len(id) == 0 {
+		id = json.RawMessage("null")
+	}
+	resp, _ := json.Marshal(rpcResponse{
+		JSONRPC: "2.0",
+		ID:      id,
+		Error:   &rpcError{Code: code, Message: message},
+	})
+	return resp
+}
+
+// isBatch reports whether the request body is a JSON-RPC batch (a top-level array)
+func isBatch(body []byte) bool {
+	for _, c := range body {
+		switch c {
+		case ' ', '\t', '\r', '\n':
+			continue
+		case '[':
+			return true
+		default:
+			return false
+		}
+	}
+	return false
+}
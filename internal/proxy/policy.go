@@ -0,0 +1,203 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// Route policy rules, the rule label of the policy rejection metric
+const (
+	ruleMethodDenied     = "method_denied"
+	ruleMethodNotAllowed = "method_not_allowed"
+	ruleLogsBlockRange   = "logs_block_range"
+	ruleLogsAddresses    = "logs_addresses"
+	ruleLogsTopics       = "logs_topics"
+	ruleCallGas          = "call_gas"
+)
+
+// methodSet matches method names against names and globs such as debug_*
+type methodSet struct {
+	names map[string]bool
+	globs []string
+}
+
+// newMethodSet creates a set of validated names and globs
+func newMethodSet(patterns []string) *methodSet {
+	set := &methodSet{names: make(map[string]bool)}
+	for _, pattern := range patterns {
+		if strings.ContainsAny(pattern, `*?[\`) {
+			set.globs = append(set.globs, pattern)
+		} else {
+			set.names[pattern] = true
+		}
+	}
+	return set
+}
+
+// contains reports whether a method matches a name or a glob of the set
+func (m *methodSet) contains(method string) bool {
+	if m.names[method] {
+		return true
+	}
+	for _, glob := range m.globs {
+		if ok, _ := path.Match(glob, method); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// applyPolicy checks a call against the method policy and the parameter
+// guards of the route. It returns the error response of a rejected call.
+func (s *Server) applyPolicy(ctx context.Context, rt *route, req *rpcRequest, out *requestOutcome) json.RawMessage {
+	if rule := rt.methodRule(req.Method); rule != "" {
+		slog.InfoContext(ctx, "Method rejected", "method", req.Method, "route", rt.name, "rule", rule)
+		s.metricsCollector.PolicyRejections.WithLabelValues(rt.name, rule).Inc()
+		out.filtered, out.unknownMethod = true, true
+		return newErrorResponse(req.ID, errCodeMethodNotFound,
+			fmt.Sprintf("the method %s is not available on this route", req.Method))
+	}
+
+	if rule, reason := rt.checkParams(req); rule != "" {
+		slog.InfoContext(ctx, "Call rejected by parameter guard", "method", req.Method, "route", rt.name, "rule", rule, "reason", reason)
+		s.metricsCollector.PolicyRejections.WithLabelValues(rt.name, rule).Inc()
+		out.filtered = true
+		return newErrorResponse(req.ID, errCodeInvalidParams, reason)
+	}
+	return nil
+}
+
+// logFilter is the filter object of eth_getLogs, eth_newFilter and logs
+// subscriptions
+type logFilter struct {
+	FromBlock string            `json:"fromBlock"`
+	ToBlock   string            `json:"toBlock"`
+	BlockHash string            `json:"blockHash"`
+	Address   json.RawMessage   `json:"address"`
+	Topics    []json.RawMessage `json:"topics"`
+}
+
+// callObject is the transaction object of eth_call and eth_estimateGas
+type callObject struct {
+	Gas string `json:"gas"`
+}
+
+// checkParams applies the parameter guards of the route to a call. It returns
+// the violated rule and the reason, or empty strings if the call passes.
+// Malformed parameters are left to the upstream to reject.
+func (rt *route) checkParams(req *rpcRequest) (string, string) {
+	var params []json.RawMessage
+	if json.Unmarshal(req.Params, &params) != nil || len(params) == 0 {
+		return "", ""
+	}
+
+	switch req.Method {
+	case "eth_getLogs", "eth_newFilter":
+		var filter logFilter
+		if json.Unmarshal(params[0], &filter) != nil {
+			return "", ""
+		}
+		if rule, reason := rt.checkLogFilter(&filter); rule != "" {
+			return rule, reason
+		}
+		return rt.checkBlockRange(&filter)
+
+	case "eth_subscribe":
+		// Logs subscriptions only see new blocks, so there is no range
+		var kind string
+		var filter logFilter
+		if len(params) < 2 || json.Unmarshal(params[0], &kind) != nil || kind != "logs" || json.Unmarshal(params[1], &filter) != nil {
+			return "", ""
+		}
+		return rt.checkLogFilter(&filter)
+
+	case "eth_call", "eth_estimateGas":
+		var call callObject
+		if rt.guards.MaxCallGas == 0 || json.Unmarshal(params[0], &call) != nil || call.Gas == "" {
+			return "", ""
+		}
+		gas, err := strconv.ParseUint(strings.TrimPrefix(call.Gas, "0x"), 16, 64)
+		if err != nil || gas > rt.guards.MaxCallGas {
+			return ruleCallGas, fmt.Sprintf("gas exceeds the maximum of %d", rt.guards.MaxCallGas)
+		}
+	}
+	return "", ""
+}
+
+// checkLogFilter limits the addresses and topics of a log filter
+func (rt *route) checkLogFilter(filter *logFilter) (string, string) {
+	if limit := rt.guards.MaxLogsAddresses; limit > 0 && countValues(filter.Address) > limit {
+		return ruleLogsAddresses, fmt.Sprintf("log filter exceeds the maximum of %d addresses", limit)
+	}
+	if limit := rt.guards.MaxLogsTopics; limit > 0 {
+		topics := 0
+		for _, position := range filter.Topics {
+			topics += countValues(position)
+		}
+		if topics > limit {
+			return ruleLogsTopics, fmt.Sprintf("log filter exceeds the maximum of %d topics", limit)
+		}
+	}
+	return "", ""
+}
+
+// checkBlockRange limits the blocks a log filter spans. Block tags are
+// resolved with the head of the route's upstream and the range is not
+// checked while the head is unknown.
+func (rt *route) checkBlockRange(filter *logFilter) (string, string) {
+	limit := rt.guards.MaxLogsBlockRange
+	if limit == 0 || filter.BlockHash != "" {
+		return "", ""
+	}
+
+	head := rt.pool.Head()
+	from, ok := resolveBlock(filter.FromBlock, head)
+	if !ok {
+		return "", ""
+	}
+	to, ok := resolveBlock(filter.ToBlock, head)
+	if !ok || to < from {
+		return "", ""
+	}
+	if to-from >= limit {
+		return ruleLogsBlockRange, fmt.Sprintf("block range exceeds the maximum of %d blocks", limit)
+	}
+	return "", ""
+}
+
+// resolveBlock returns the number of a block number or tag. A missing block
+// is the latest one.
+func resolveBlock(block string, head uint64) (uint64, bool) {
+	switch block {
+	case "earliest":
+		return 0, true
+	case "", "latest", "pending", "safe", "finalized":
+		return head, head > 0
+	}
+	n, err := strconv.ParseUint(strings.TrimPrefix(block, "0x"), 16, 64)
+	return n, err == nil && strings.HasPrefix(block, "0x")
+}
+
+// countValues counts the values of a filter field that is null, a single
+// value or an array of values
+func countValues(field json.RawMessage) int {
+	var values []json.RawMessage
+	if json.Unmarshal(field, &values) == nil {
+		count := 0
+		for _, value := range values {
+			if string(value) != "null" {
+				count++
+			}
+		}
+		return count
+	}
+	if len(field) == 0 || string(field) == "null" {
+		return 0
+	}
+	return 1
+}
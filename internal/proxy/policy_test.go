@@ -0,0 +1,133 @@
+package proxy
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/ddomeke/rpc_proxy/internal/config"
+	"github.com/ddomeke/rpc_proxy/internal/upstream"
+)
+
+func TestMethodRule(t *testing.T) {
+	tests := []struct {
+		name    string
+		allowed []string
+		denied  []string
+		method  string
+		want    string
+	}{
+		{name: "open", method: "eth_call", want: ""},
+		{name: "denied_name", denied: []string{"eth_sign"}, method: "eth_sign", want: ruleMethodDenied},
+		{name: "denied_glob", denied: []string{"admin_*"}, method: "admin_addPeer", want: ruleMethodDenied},
+		{name: "glob_is_no_prefix", denied: []string{"eth_sign"}, method: "eth_signTypedData_v4", want: ""},
+		{name: "denied_class", denied: []string{"debug_trace[BC]*"}, method: "debug_traceCall", want: ruleMethodDenied},
+		{name: "allowed_glob", allowed: []string{"eth_*"}, method: "eth_getLogs", want: ""},
+		{name: "not_allowed", allowed: []string{"eth_*"}, method: "debug_traceCall", want: ruleMethodNotAllowed},
+		{name: "single_character", allowed: []string{"net_versio?"}, method: "net_version", want: ""},
+		{name: "default_debug", denied: config.DefaultDeniedMethods, method: "debug_setHead", want: ruleMethodDenied},
+		{name: "default_trace", denied: config.DefaultDeniedMethods, method: "debug_traceTransaction", want: ruleMethodDenied},
+		{name: "default_call", denied: config.DefaultDeniedMethods, method: "eth_call", want: ""},
+		// Denied methods take precedence over allowed ones
+		{name: "denied_and_allowed", allowed: []string{"eth_*"}, denied: []string{"eth_sendRawTransaction"}, method: "eth_sendRawTransaction", want: ruleMethodDenied},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rt := newRoute(config.RouteConfig{Name: "test", AllowedMethods: tt.allowed, DeniedMethods: tt.denied}, nil)
+			if got := rt.methodRule(tt.method); got != tt.want {
+				t.Errorf("methodRule(%q) = %q, want %q", tt.method, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckParams(t *testing.T) {
+	pool, err := upstream.NewPool("L1", []string{"http://127.0.0.1:1"}, config.Default().Upstream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rt := newRoute(config.RouteConfig{
+		Name: "test",
+		Guards: config.GuardConfig{
+			MaxLogsBlockRange: 100,
+			MaxLogsAddresses:  2,
+			MaxLogsTopics:     3,
+			MaxCallGas:        1000000,
+		},
+	}, pool)
+
+	tests := []struct {
+		name   string
+		method string
+		params string
+		want   string
+	}{
+		{name: "range_within", method: "eth_getLogs", params: `[{"fromBlock":"0x1","toBlock":"0x64"}]`, want: ""},
+		{name: "range_exceeded", method: "eth_getLogs", params: `[{"fromBlock":"0x1","toBlock":"0x65"}]`, want: ruleLogsBlockRange},
+		{name: "range_of_new_filter", method: "eth_newFilter", params: `[{"fromBlock":"earliest","toBlock":"0x100"}]`, want: ruleLogsBlockRange},
+		// Tags are not resolved while the head is unknown
+		{name: "range_to_latest", method: "eth_getLogs", params: `[{"fromBlock":"0x1"}]`, want: ""},
+		{name: "block_hash", method: "eth_getLogs", params: `[{"blockHash":"0x01","address":["0x1","0x2"]}]`, want: ""},
+		{name: "addresses_exceeded", method: "eth_getLogs", params: `[{"blockHash":"0x01","address":["0x1","0x2","0x3"]}]`, want: ruleLogsAddresses},
+		{name: "single_address", method: "eth_getLogs", params: `[{"blockHash":"0x01","address":"0x1"}]`, want: ""},
+		{name: "topics_within", method: "eth_getLogs", params: `[{"blockHash":"0x01","topics":["0x1",null,["0x2","0x3"]]}]`, want: ""},
+		{name: "topics_exceeded", method: "eth_getLogs", params: `[{"blockHash":"0x01","topics":[["0x1","0x2"],["0x3","0x4"]]}]`, want: ruleLogsTopics},
+		{name: "subscription_addresses", method: "eth_subscribe", params: `["logs",{"address":["0x1","0x2","0x3"]}]`, want: ruleLogsAddresses},
+		{name: "subscription_new_heads", method: "eth_subscribe", params: `["newHeads"]`, want: ""},
+		{name: "gas_within", method: "eth_call", params: `[{"gas":"0xf4240"},"latest"]`, want: ""},
+		{name: "gas_exceeded", method: "eth_estimateGas", params: `[{"gas":"0xf4241"}]`, want: ruleCallGas},
+		{name: "gas_invalid", method: "eth_call", params: `[{"gas":"0xzz"},"latest"]`, want: ruleCallGas},
+		{name: "no_gas", method: "eth_call", params: `[{"to":"0x1"},"latest"]`, want: ""},
+		// Malformed parameters are left to the upstream
+		{name: "malformed", method: "eth_getLogs", params: `{"fromBlock":"0x1"}`, want: ""},
+		{name: "other_method", method: "eth_getBalance", params: `["0x1","latest"]`, want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := &rpcRequest{Method: tt.method, Params: json.RawMessage(tt.params)}
+			if got, reason := rt.checkParams(req); got != tt.want {
+				t.Errorf("checkParams = %q (%s), want %q", got, reason, tt.want)
+			}
+		})
+	}
+}
+
+func TestPolicyRejection(t *testing.T) {
+	l1, l1Srv := newFakeUpstream(t, "0x1")
+	_, l2Srv := newFakeUpstream(t, "0xa")
+	l1.result("eth_getLogs", `[]`)
+	cfg := testConfig(l1Srv, l2Srv)
+	cfg.Routes[0].Guards.MaxLogsAddresses = 1
+	s := newTestServer(t, cfg)
+
+	tests := []struct {
+		name     string
+		body     string
+		wantCode int
+	}{
+		{name: "denied", body: `{"jsonrpc":"2.0","id":1,"method":"personal_unlockAccount","params":[]}`, wantCode: errCodeMethodNotFound},
+		{name: "guard", body: `{"jsonrpc":"2.0","id":1,"method":"eth_getLogs","params":[{"address":["0x1","0x2"]}]}`, wantCode: errCodeInvalidParams},
+		{name: "allowed", body: `{"jsonrpc":"2.0","id":1,"method":"eth_getLogs","params":[{"address":["0x1"]}]}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := decodeResponses(t, post(s, "/l1", tt.body, nil).Body.Bytes())[0]
+			code := 0
+			if resp.Error != nil {
+				code = resp.Error.Code
+			}
+			if code != tt.wantCode {
+				t.Errorf("error code = %d, want %d", code, tt.wantCode)
+			}
+		})
+	}
+	// Rejected calls never reach the upstream
+	if got := l1.count("eth_getLogs"); got != 1 {
+		t.Errorf("upstream got %d eth_getLogs calls, want 1", got)
+	}
+	if got := l1.count("personal_unlockAccount"); got != 0 {
+		t.Errorf("upstream got %d denied calls, want 0", got)
+	}
+}
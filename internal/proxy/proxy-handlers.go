@@ -2,149 +2,282 @@ package proxy
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"fmt"
 	"io"
-	"log"
-	"math/big"
+	"log/slog"
 	"net/http"
-
-	"github.com/ddomeke/rpc_proxy/internal/eth"
-	"github.com/ethereum/go-ethereum/common"
-	"github.com/ethereum/go-ethereum/common/hexutil"
+	"sync"
+	"time"
 )
 
-// proxyHandler handles JSON-RPC proxy requests
-func (s *Server) proxyHandler(w http.ResponseWriter, r *http.Request) {
-	log.Println("[INFO] JSON-RPC request received")
+// proxyHandler handles JSON-RPC proxy requests for a route. pathKey is the
+// API key given in the path, if any.
+func (s *Server) proxyHandler(w http.ResponseWriter, r *http.Request, table *routeTable, rt *route, pathKey string) {
+	ctx := s.authenticate(w, r, table, rt, pathKey)
+	if ctx == nil {
+		return
+	}
+	slog.InfoContext(ctx, "JSON-RPC request received", "route", rt.name)
 
 	// Read JSON-RPC request
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
 		http.Error(w, "Could not read request", http.StatusBadRequest)
-		log.Printf("[ERROR] Could not read RPC request: %v", err)
+		slog.ErrorContext(ctx, "Could not read RPC request", "error", err)
 		return
 	}
 	defer r.Body.Close()
+	s.metricsCollector.ProxyRequestSize.WithLabelValues(rt.name).Observe(float64(len(body)))
 
-	// Parse JSON-RPC request
-	var req map[string]interface{}
-	if err := json.Unmarshal(body, &req); err != nil {
-		http.Error(w, "Invalid JSON", http.StatusBadRequest)
-		log.Printf("[ERROR] JSON parse error: %v", err)
+	// Batch requests are processed element by element
+	if isBatch(body) {
+		s.batchHandler(ctx, w, body, rt)
 		return
 	}
 
-	// Special handling for eth_getBlockReceipts
-	if method, ok := req["method"].(string); ok && method == "eth_getBlockReceipts" {
-		s.blockReceiptsHandler(w, body)
+	// Parse JSON-RPC request
+	var req rpcRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		slog.ErrorContext(ctx, "JSON parse error", "error", err)
 		return
 	}
 
-	// Forward all other requests directly
-	resp, err := http.Post(s.config.L1RPCURL, "application/json", bytes.NewBuffer(body))
+	var out requestOutcome
+	respBody, err := s.processRequest(ctx, rt, body, &req, &out)
 	if err != nil {
-		http.Error(w, "Ethereum RPC request failed", http.StatusInternalServerError)
-		log.Printf("[ERROR] Ethereum RPC request failed: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	defer resp.Body.Close()
 
 	// Forward response to client
+	s.metricsCollector.ProxyResponseSize.WithLabelValues(rt.name).Observe(float64(len(respBody)))
 	w.Header().Set("Content-Type", "application/json")
-	io.Copy(w, resp.Body)
-	log.Println("[INFO] JSON-RPC request successfully forwarded")
+	if out.limited {
+		writeLimited(w, out.retryAfter)
+	}
+	w.Write(respBody)
+	slog.InfoContext(ctx, "JSON-RPC request successfully forwarded", "method", req.Method)
 }
 
-// blockReceiptsHandler handles eth_getBlockReceipts special processing
-func (s *Server) blockReceiptsHandler(w http.ResponseWriter, body []byte) {
-	log.Println("[INFO] Processing eth_getBlockReceipts request...")
+// batchHandler handles JSON-RPC batch requests
+func (s *Server) batchHandler(ctx context.Context, w http.ResponseWriter, body []byte, rt *route) {
+	respBody, retryAfter, limited := s.processBatch(ctx, rt, body)
 
-	// Forward request to L1 Ethereum RPC
-	resp, err := http.Post(s.config.L1RPCURL, "application/json", bytes.NewBuffer(body))
-	if err != nil {
-		http.Error(w, "Ethereum RPC request failed", http.StatusInternalServerError)
-		log.Printf("[ERROR] Ethereum RPC request failed: %v", err)
+	w.Header().Set("Content-Type", "application/json")
+	if respBody == nil {
+		// Batch contained only notifications
+		w.WriteHeader(http.StatusNoContent)
 		return
 	}
-	defer resp.Body.Close()
+	s.metricsCollector.ProxyResponseSize.WithLabelValues(rt.name).Observe(float64(len(respBody)))
+	if limited {
+		writeLimited(w, retryAfter)
+	}
+	w.Write(respBody)
+}
 
-	// Read response
-	respBody, err := io.ReadAll(resp.Body)
+// processBatch processes a JSON-RPC batch. Every element is routed and filtered
+// on its own, and the responses are returned in request order. A nil result
+// means the batch consisted only of notifications. If every answered call was
+// limited, it also returns the longest wait and true.
+func (s *Server) processBatch(ctx context.Context, rt *route, body []byte) ([]byte, time.Duration, bool) {
+	var batch []json.RawMessage
+	if err := json.Unmarshal(body, &batch); err != nil {
+		slog.ErrorContext(ctx, "JSON batch parse error", "error", err)
+		return newErrorResponse(nil, errCodeParseError, "Parse error"), 0, false
+	}
+
+	// An empty batch is an invalid request according to the JSON-RPC spec
+	if len(batch) == 0 {
+		return newErrorResponse(nil, errCodeInvalidRequest, "Invalid Request"), 0, false
+	}
+
+	slog.InfoContext(ctx, "Processing JSON-RPC batch", "requests", len(batch))
+
+	// Large batches are processed a bounded number of calls at a time
+	responses := make([]json.RawMessage, len(batch))
+	outcomes := make([]requestOutcome, len(batch))
+	workers := make(chan struct{}, s.current().config.Requests.BatchConcurrency)
+	var wg sync.WaitGroup
+	for i, elem := range batch {
+		workers <- struct{}{}
+		wg.Add(1)
+		go func(i int, elem json.RawMessage) {
+			defer func() {
+				<-workers
+				wg.Done()
+			}()
+			responses[i] = s.processBatchElement(ctx, rt, elem, &outcomes[i])
+		}(i, elem)
+	}
+	wg.Wait()
+
+	// Drop empty responses (notifications) while keeping the original order
+	results := make([]json.RawMessage, 0, len(responses))
+	limited, retryAfter := true, time.Duration(0)
+	for i, resp := range responses {
+		if len(bytes.TrimSpace(resp)) > 0 {
+			results = append(results, resp)
+			limited = limited && outcomes[i].limited
+			retryAfter = max(retryAfter, outcomes[i].retryAfter)
+		}
+	}
+	if len(results) == 0 {
+		return nil, 0, false
+	}
+
+	respBody, err := json.Marshal(results)
 	if err != nil {
-		http.Error(w, "Could not read response", http.StatusInternalServerError)
-		log.Printf("[ERROR] Could not read response: %v", err)
-		return
+		slog.ErrorContext(ctx, "Could not encode batch response", "error", err)
+		return newErrorResponse(nil, errCodeInternalError, "Internal error"), 0, false
+	}
+	slog.InfoContext(ctx, "JSON-RPC batch successfully forwarded", "requests", len(batch))
+	return respBody, retryAfter, limited
+}
+
+// processBatchElement processes a single element of a batch request and
+// converts any failure into a JSON-RPC error object
+func (s *Server) processBatchElement(ctx context.Context, rt *route, elem json.RawMessage, out *requestOutcome) json.RawMessage {
+	var req rpcRequest
+	if err := json.Unmarshal(elem, &req); err != nil || req.Method == "" {
+		return newErrorResponse(req.ID, errCodeInvalidRequest, "Invalid Request")
+	}
+
+	respBody, err := s.processRequest(ctx, rt, elem, &req, out)
+	if err != nil {
+		return newErrorResponse(req.ID, errCodeInternalError, err.Error())
+	}
+	// A response that is not JSON would break the encoding of the whole batch
+	if len(bytes.TrimSpace(respBody)) > 0 && !json.Valid(respBody) {
+		slog.ErrorContext(ctx, "Upstream response is not valid JSON", "method", req.Method)
+		return newErrorResponse(req.ID, errCodeInternalError, "Invalid upstream response")
+	}
+	return respBody
+}
+
+// processRequest handles a single JSON-RPC request on a route, returns the
+// response body and records how it was handled in out and in the metrics
+func (s *Server) processRequest(ctx context.Context, rt *route, body []byte, req *rpcRequest, out *requestOutcome) ([]byte, error) {
+	inFlight := s.metricsCollector.ProxyRequestsInFlight.WithLabelValues(rt.name)
+	inFlight.Inc()
+	defer inFlight.Dec()
+
+	start := time.Now()
+	respBody, err := s.dispatchRequest(ctx, rt, body, req, out)
+	s.observeRequest(rt, req.Method, out, respBody, err, time.Since(start))
+	return respBody, err
+}
+
+// dispatchRequest applies the route policies to a single JSON-RPC request and
+// returns the response body
+func (s *Server) dispatchRequest(ctx context.Context, rt *route, body []byte, req *rpcRequest, out *requestOutcome) ([]byte, error) {
+	if rejection := s.applyPolicy(ctx, rt, req, out); rejection != nil {
+		return rejection, nil
+	}
+	if rejection := s.authorizeCall(ctx, req, out); rejection != nil {
+		return rejection, nil
+	}
+
+	// Raw transactions involving frozen accounts never reach the upstream
+	if rawTransactionMethods[req.Method] && rt.screenTxs {
+		if rejection := s.screenRawTransaction(ctx, rt, req); rejection != nil {
+			code, _ := responseErrorCode(rejection)
+			out.filtered = code == errCodeTransactionRejected
+			return rejection, nil
+		}
+	}
+
+	// Special handling for eth_getBlockReceipts
+	if req.Method == "eth_getBlockReceipts" && rt.filterDeposits {
+		return s.blockReceiptsHandler(ctx, rt, body, req, out)
+	}
+
+	// Forward all other requests directly
+	return s.forwardRequest(ctx, rt, body, req, out)
+}
+
+// forwardRequest forwards a request to the route's upstream pool and returns
+// the raw response. Cacheable results are answered from the cache when they
+// are cached and cached otherwise.
+func (s *Server) forwardRequest(ctx context.Context, rt *route, body []byte, req *rpcRequest, out *requestOutcome) ([]byte, error) {
+	cached, lookup := s.lookupCache(rt, req)
+	if cached != nil {
+		out.upstream = cacheUpstream
+		return cached, nil
+	}
+
+	respBody, err := s.coalesceRequest(ctx, rt, body, req, out)
+	if err != nil {
+		return nil, err
+	}
+	// Only the call that went upstream caches the result
+	if lookup != nil && !out.coalesced {
+		s.storeCache(lookup, respBody)
+	}
+	return respBody, nil
+}
+
+// sendUpstream sends a request to the route's upstream pool and returns the
+// raw response
+func (s *Server) sendUpstream(ctx context.Context, rt *route, body []byte, out *requestOutcome) ([]byte, error) {
+	inFlight := s.metricsCollector.UpstreamRequestsInFlight.WithLabelValues(rt.pool.Name())
+	inFlight.Inc()
+	start := time.Now()
+	respBody, node, err := rt.pool.Forward(ctx, body)
+	inFlight.Dec()
+
+	if node != nil {
+		out.upstream = node.Name
+		s.metricsCollector.UpstreamRequestDuration.WithLabelValues(rt.pool.Name(), node.Name).Observe(time.Since(start).Seconds())
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "Ethereum RPC request failed", "route", rt.name, "error", err)
+		return nil, fmt.Errorf("Ethereum RPC request failed")
+	}
+	return respBody, nil
+}
+
+// blockReceiptsHandler handles eth_getBlockReceipts special processing
+func (s *Server) blockReceiptsHandler(ctx context.Context, rt *route, body []byte, req *rpcRequest, out *requestOutcome) ([]byte, error) {
+	slog.InfoContext(ctx, "Processing eth_getBlockReceipts request")
+
+	// Forward request to the route's upstream
+	respBody, err := s.forwardRequest(ctx, rt, body, req, out)
+	if err != nil {
+		return nil, err
 	}
 
 	// Parse response as JSON
 	var jsonResponse map[string]interface{}
 	if err := json.Unmarshal(respBody, &jsonResponse); err != nil {
-		http.Error(w, "Could not parse response as JSON", http.StatusInternalServerError)
-		log.Printf("[ERROR] JSON parse error: %v", err)
-		return
+		slog.ErrorContext(ctx, "JSON parse error", "error", err)
+		return nil, fmt.Errorf("Could not parse response as JSON")
 	}
 
 	// Check for TransactionDeposited events in logs
 	if result, ok := jsonResponse["result"].([]interface{}); ok {
 		for _, tx := range result {
-			txMap := tx.(map[string]interface{})
+			// Entries that are not receipt objects are passed through as they are
+			txMap, ok := tx.(map[string]interface{})
+			if !ok {
+				continue
+			}
 			if logs, ok := txMap["logs"].([]interface{}); ok {
 				filteredLogs := []interface{}{}
 				for _, logEntry := range logs {
-					logMap := logEntry.(map[string]interface{})
-
-					// If event is TransactionDeposited, check from address
-					if logMap["topics"] != nil {
-						topics := logMap["topics"].([]interface{})
-						if len(topics) > 0 && topics[0] == "0x35d79ab81f2b2017e19afb5c5571778877782d7a8786f5907f93b0f4702f4f23" {
-							// From address
-							if len(topics) > 1 {
-								fromAddrHex := topics[1].(string)
-								fromAddress := common.HexToAddress(fromAddrHex)
-
-								frozen, err := eth.CheckIfAddressIsFrozen(s.config, fromAddress.Hex())
-								if err != nil {
-									log.Printf("[ERROR] Frozen address check error: %v", err)
-									continue
-								}
-								if frozen {
-									log.Printf("[INFO] Frozen account found: %s", fromAddress.Hex())
-
-									s.metricsCollector.BlockedDeposits.WithLabelValues(fromAddress.Hex()).Inc()
-									continue // Filter out this log
-								}
-
-								// Decode TransactionDeposited event data
-								if blockNumberHex, ok := logMap["blockNumber"].(string); ok {
-									_, _ = hexutil.DecodeUint64(blockNumberHex)
-
-									// Decode log data
-									data, _ := hexutil.Decode(logMap["data"].(string))
-
-									var value *big.Int
-									var gasLimit uint64
-
-									// Simplified data decoding (full decode possible)
-									if len(data) >= 96 { // At least 3 32-byte parameters
-										value = new(big.Int).SetBytes(data[0:32])
-										gasLimitBytes := data[32:64]
-										gasLimit = new(big.Int).SetBytes(gasLimitBytes).Uint64()
-
-										// Update metrics
-										ethValue := new(big.Float).Quo(
-											new(big.Float).SetInt(value),
-											new(big.Float).SetInt64(1e18),
-										)
-										ethFloat, _ := ethValue.Float64()
-
-										log.Printf("[INFO] Deposit monitored: %s -> Value: %.6f ETH, Gas: %d",
-											fromAddress.Hex(), ethFloat, gasLimit)
-
-										s.metricsCollector.DepositValueHistogram.Observe(ethFloat)
-									}
-								}
-							}
-						}
+					logMap, ok := logEntry.(map[string]interface{})
+					if !ok {
+						filteredLogs = append(filteredLogs, logEntry)
+						continue
+					}
+
+					// Drop TransactionDeposited logs sent by frozen accounts
+					if s.filterFrozenDepositLog(ctx, logMap) {
+						s.metricsCollector.FilteredLogs.WithLabelValues(rt.name, "receipts").Inc()
+						continue // Filter out this log
 					}
 					filteredLogs = append(filteredLogs, logMap)
 				}
@@ -153,9 +286,12 @@ func (s *Server) blockReceiptsHandler(w http.ResponseWriter, body []byte) {
 		}
 	}
 
-	// Forward updated JSON to client
-	filteredResponse, _ := json.Marshal(jsonResponse)
-	w.Header().Set("Content-Type", "application/json")
-	w.Write(filteredResponse)
-	log.Println("[INFO] Frozen accounts filtered and response forwarded.")
+	// Return updated JSON to client
+	filteredResponse, err := json.Marshal(jsonResponse)
+	if err != nil {
+		slog.ErrorContext(ctx, "Could not encode filtered response", "error", err)
+		return nil, fmt.Errorf("Could not encode filtered response")
+	}
+	slog.InfoContext(ctx, "Frozen accounts filtered and response forwarded")
+	return filteredResponse, nil
 }
@@ -0,0 +1,149 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBatch(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+		wantIDs    []string // IDs of the responses in order
+		wantCodes  []int    // Error codes of the responses, 0 for results
+	}{
+		{
+			// The first call is answered last by the upstream
+			name:       "order",
+			body:       `[{"jsonrpc":"2.0","id":1,"method":"test_slow"},{"jsonrpc":"2.0","id":"b","method":"eth_blockNumber"},{"jsonrpc":"2.0","id":3,"method":"eth_blockNumber"}]`,
+			wantStatus: http.StatusOK,
+			wantIDs:    []string{`1`, `"b"`, `3`},
+			wantCodes:  []int{0, 0, 0},
+		},
+		{
+			name:       "notifications_dropped",
+			body:       `[{"jsonrpc":"2.0","id":1,"method":"eth_blockNumber"},{"jsonrpc":"2.0","method":"eth_blockNumber"},{"jsonrpc":"2.0","id":2,"method":"eth_blockNumber"}]`,
+			wantStatus: http.StatusOK,
+			wantIDs:    []string{`1`, `2`},
+			wantCodes:  []int{0, 0},
+		},
+		{
+			name:       "only_notifications",
+			body:       `[{"jsonrpc":"2.0","method":"eth_blockNumber"},{"jsonrpc":"2.0","method":"eth_blockNumber"}]`,
+			wantStatus: http.StatusNoContent,
+		},
+		{
+			// An empty batch is answered with a single error
+			name:       "empty",
+			body:       `[]`,
+			wantStatus: http.StatusOK,
+			wantIDs:    []string{`null`},
+			wantCodes:  []int{errCodeInvalidRequest},
+		},
+		{
+			name:       "invalid_element",
+			body:       `[1,{"jsonrpc":"2.0","id":2,"method":"eth_blockNumber"},{"jsonrpc":"2.0","id":3}]`,
+			wantStatus: http.StatusOK,
+			wantIDs:    []string{`null`, `2`, `3`},
+			wantCodes:  []int{errCodeInvalidRequest, 0, errCodeInvalidRequest},
+		},
+		{
+			// Each call is filtered on its own
+			name:       "denied_element",
+			body:       `[{"jsonrpc":"2.0","id":1,"method":"admin_peers"},{"jsonrpc":"2.0","id":2,"method":"eth_blockNumber"}]`,
+			wantStatus: http.StatusOK,
+			wantIDs:    []string{`1`, `2`},
+			wantCodes:  []int{errCodeMethodNotFound, 0},
+		},
+	}
+
+	l1, l1Srv := newFakeUpstream(t, "0x1")
+	_, l2Srv := newFakeUpstream(t, "0xa")
+	l1.result("eth_blockNumber", `"0x10"`)
+	l1.handle("test_slow", func(req rpcRequest) rpcResponse {
+		time.Sleep(50 * time.Millisecond)
+		return rpcResponse{Result: json.RawMessage(`"slow"`)}
+	})
+	s := newTestServer(t, testConfig(l1Srv, l2Srv))
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := post(s, "/l1", tt.body, nil)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if tt.wantStatus == http.StatusNoContent {
+				if rec.Body.Len() != 0 {
+					t.Errorf("body = %s, want none", rec.Body)
+				}
+				return
+			}
+
+			responses := decodeResponses(t, rec.Body.Bytes())
+			if len(responses) != len(tt.wantIDs) {
+				t.Fatalf("got %d responses, want %d: %s", len(responses), len(tt.wantIDs), rec.Body)
+			}
+			for i, resp := range responses {
+				if string(responseID(resp.ID)) != tt.wantIDs[i] {
+					t.Errorf("response %d has ID %s, want %s", i, resp.ID, tt.wantIDs[i])
+				}
+				code := 0
+				if resp.Error != nil {
+					code = resp.Error.Code
+				}
+				if code != tt.wantCodes[i] {
+					t.Errorf("response %d has error code %d, want %d", i, code, tt.wantCodes[i])
+				}
+			}
+		})
+	}
+}
+
+func TestBatchConcurrency(t *testing.T) {
+	l1, l1Srv := newFakeUpstream(t, "0x1")
+	_, l2Srv := newFakeUpstream(t, "0xa")
+
+	var mu sync.Mutex
+	inFlight, maxInFlight := 0, 0
+	l1.handle("test_slow", func(req rpcRequest) rpcResponse {
+		mu.Lock()
+		inFlight++
+		maxInFlight = max(maxInFlight, inFlight)
+		mu.Unlock()
+		time.Sleep(20 * time.Millisecond)
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+		return rpcResponse{Result: req.ID}
+	})
+
+	cfg := testConfig(l1Srv, l2Srv)
+	cfg.Requests.BatchConcurrency = 3
+	cfg.Upstream.Coalesce = false
+	s := newTestServer(t, cfg)
+
+	var batch []rpcRequest
+	for i := 0; i < 12; i++ {
+		batch = append(batch, rpcRequest{JSONRPC: "2.0", ID: json.RawMessage(strconv.Itoa(i)), Method: "test_slow"})
+	}
+	body, _ := json.Marshal(batch)
+	rec := post(s, "/l1", string(body), nil)
+
+	responses := decodeResponses(t, rec.Body.Bytes())
+	for i, resp := range responses {
+		if string(resp.Result) != strconv.Itoa(i) {
+			t.Errorf("response %d = %s, want %d", i, resp.Result, i)
+		}
+	}
+	if len(responses) != len(batch) {
+		t.Errorf("got %d responses, want %d", len(responses), len(batch))
+	}
+	if maxInFlight > cfg.Requests.BatchConcurrency || maxInFlight < 2 {
+		t.Errorf("%d calls were in flight at once, want 2 to %d", maxInFlight, cfg.Requests.BatchConcurrency)
+	}
+}
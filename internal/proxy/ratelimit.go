@@ -0,0 +1,146 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/ddomeke/rpc_proxy/internal/config"
+	"github.com/ddomeke/rpc_proxy/internal/ratelimit"
+)
+
+// Method classes with separate rate limits
+const (
+	classCheap = "cheap"
+	classHeavy = "heavy"
+)
+
+// rateLimits are the rate limits of a configuration
+type rateLimits struct {
+	limiter        ratelimit.Limiter          // Shared by the tables of the same backend settings
+	heavy          map[string]bool            // Methods of the heavy class
+	perIP          map[string]ratelimit.Limit // Limits of a client IP by class
+	perKey         map[string]ratelimit.Limit // Limits of an API key by class
+	trustedProxies []*net.IPNet
+}
+
+// newRateLimits creates the rate limits of a validated configuration
+func newRateLimits(cfg config.RateLimitConfig) *rateLimits {
+	rl := &rateLimits{
+		heavy: make(map[string]bool),
+		perIP: map[string]ratelimit.Limit{
+			classCheap: ratelimit.NewLimit(cfg.PerIP.Cheap),
+			classHeavy: ratelimit.NewLimit(cfg.PerIP.Heavy),
+		},
+		perKey: map[string]ratelimit.Limit{
+			classCheap: ratelimit.NewLimit(cfg.PerKey.Cheap),
+			classHeavy: ratelimit.NewLimit(cfg.PerKey.Heavy),
+		},
+	}
+	for _, method := range cfg.HeavyMethods {
+		rl.heavy[method] = true
+	}
+	for _, cidr := range cfg.TrustedProxies {
+		if _, network, err := net.ParseCIDR(cidr); err == nil {
+			rl.trustedProxies = append(rl.trustedProxies, network)
+		}
+	}
+	return rl
+}
+
+// class returns the method class of a method
+func (rl *rateLimits) class(method string) string {
+	if rl.heavy[method] {
+		return classHeavy
+	}
+	return classCheap
+}
+
+// trusted reports whether an address belongs to a trusted proxy
+func (rl *rateLimits) trusted(ip net.IP) bool {
+	for _, network := range rl.trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP returns the IP of the client of a request. Behind trusted proxies
+// it is the last X-Forwarded-For entry that was not added by one of them.
+func (rl *rateLimits) clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !rl.trusted(ip) {
+		return host
+	}
+
+	// Proxies append the address they received the request from
+	forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(forwarded[i]))
+		if hop == nil {
+			break
+		}
+		host = hop.String()
+		if !rl.trusted(hop) {
+			break
+		}
+	}
+	return host
+}
+
+// rateBucket is a token bucket a call is taken from
+type rateBucket struct {
+	scope string // ip, key or quota, the rps quota of the API key itself
+	ratelimit.Bucket
+}
+
+// rateLimitCall takes a token from the buckets of the caller's IP and API key
+// for the method class of a call and from the rps quota of the key. Tokens are
+// only taken if every bucket has one. It returns the error response of a
+// limited call.
+func (s *Server) rateLimitCall(ctx context.Context, c *caller, req *rpcRequest, out *requestOutcome) json.RawMessage {
+	class := c.limits.class(req.Method)
+	buckets := []rateBucket{{"ip", ratelimit.Bucket{Key: "ip:" + c.ip + ":" + class, Limit: c.limits.perIP[class]}}}
+	if c.key != nil {
+		buckets = append(buckets,
+			rateBucket{"key", ratelimit.Bucket{Key: "key:" + c.key.Name + ":" + class, Limit: c.limits.perKey[class]}},
+			rateBucket{"quota", ratelimit.Bucket{Key: "key:" + c.key.Name + ":quota", Limit: c.key.RateLimit()}},
+		)
+	}
+
+	limiterBuckets := make([]ratelimit.Bucket, len(buckets))
+	for i, b := range buckets {
+		limiterBuckets[i] = b.Bucket
+	}
+	// The fallback limiter handles redis errors itself, a failing limiter
+	// lets the call through
+	denied, retryAfter, err := c.limits.limiter.Allow(ctx, limiterBuckets)
+	if err != nil {
+		slog.ErrorContext(ctx, "Rate limiter failed, call not limited", "method", req.Method, "error", err)
+		s.metricsCollector.RateLimitBackendErrors.Inc()
+		return nil
+	}
+	if denied < 0 {
+		return nil
+	}
+
+	out.limited, out.retryAfter = true, retryAfter
+	b := buckets[denied]
+	if b.scope == "quota" {
+		slog.InfoContext(ctx, "Call rejected, quota exceeded", "api_key", c.key.Name, "quota", "rps", "method", req.Method)
+		s.metricsCollector.APIKeyRequests.WithLabelValues(c.key.Name, "rate_limited").Inc()
+		return newLimitResponse(req.ID, fmt.Sprintf("rate limit of API key %s exceeded", c.key.Name), retryAfter)
+	}
+	slog.InfoContext(ctx, "Call rejected, rate limit exceeded", "scope", b.scope, "class", class, "method", req.Method, "client_ip", c.ip)
+	s.metricsCollector.RateLimitedCalls.WithLabelValues(b.scope, class).Inc()
+	return newLimitResponse(req.ID, fmt.Sprintf("%s rate limit exceeded for %s calls", b.scope, class), retryAfter)
+}
@@ -0,0 +1,97 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/ddomeke/rpc_proxy/internal/ratelimit"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// brokenLimiter is a limiter whose calls fail
+type brokenLimiter struct {
+	closed bool
+}
+
+func (l *brokenLimiter) Allow(context.Context, []ratelimit.Bucket) (int, time.Duration, error) {
+	return -1, 0, errors.New("connection refused")
+}
+
+func (l *brokenLimiter) Close() error {
+	l.closed = true
+	return nil
+}
+
+func TestRateLimiterErrors(t *testing.T) {
+	l1, l1Srv := newFakeUpstream(t, "0x1")
+	_, l2Srv := newFakeUpstream(t, "0xa")
+	l1.result("eth_blockNumber", `"0x10"`)
+	cfg := testConfig(l1Srv, l2Srv)
+	cfg.RateLimit.PerIP.Cheap.RPS = 1
+	s := newTestServer(t, cfg)
+	s.current().rateLimits.limiter = &brokenLimiter{}
+
+	// A failing limiter lets calls through and counts the error
+	before := testutil.ToFloat64(s.metricsCollector.RateLimitBackendErrors)
+	rec := post(s, "/l1", `{"jsonrpc":"2.0","id":1,"method":"eth_blockNumber"}`, nil)
+	if rec.Code != http.StatusOK || l1.count("eth_blockNumber") != 1 {
+		t.Errorf("status = %d with %d upstream calls, want 200 with 1", rec.Code, l1.count("eth_blockNumber"))
+	}
+	if got := testutil.ToFloat64(s.metricsCollector.RateLimitBackendErrors) - before; got != 1 {
+		t.Errorf("counted %v limiter errors, want 1", got)
+	}
+}
+
+func TestReloadRateLimitBackend(t *testing.T) {
+	_, l1Srv := newFakeUpstream(t, "0x1")
+	_, l2Srv := newFakeUpstream(t, "0xa")
+	cfg := testConfig(l1Srv, l2Srv)
+	s := newTestServer(t, cfg)
+	old := &brokenLimiter{}
+	s.current().rateLimits.limiter = old
+
+	tests := []struct {
+		name        string
+		backend     string
+		redisURL    string
+		wantErr     bool
+		wantClosed  bool
+		wantReplace bool
+	}{
+		{name: "unchanged", backend: "memory"},
+		// A backend that cannot be created rejects the reload before anything applies
+		{name: "invalid_redis_url", backend: "redis", redisURL: "http://127.0.0.1:9", wantErr: true},
+		{name: "redis", backend: "redis", wantClosed: true, wantReplace: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			newCfg := testConfig(l1Srv, l2Srv)
+			newCfg.RateLimit.Backend = tt.backend
+			newCfg.RateLimit.RedisURL = "redis://127.0.0.1:9/0"
+			if tt.redisURL != "" {
+				newCfg.RateLimit.RedisURL = tt.redisURL
+			}
+			commit, err := s.PrepareReload(newCfg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("PrepareReload error = %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil {
+				commit()
+			}
+
+			if old.closed != tt.wantClosed {
+				t.Errorf("previous limiter closed = %v, want %v", old.closed, tt.wantClosed)
+			}
+			if replaced := s.current().rateLimits.limiter != old; replaced != tt.wantReplace {
+				t.Errorf("limiter replaced = %v, want %v", replaced, tt.wantReplace)
+			}
+		})
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,149 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/ddomeke/rpc_proxy/internal/config"
+	"github.com/ddomeke/rpc_proxy/internal/upstream"
+)
+
+// errCodeMethodNotFound is returned for methods a route does not allow
+const errCodeMethodNotFound = -32601
+
+// route is a proxy endpoint with its own upstream, method policies,
+// parameter guards and frozen-account filtering rules
+type route struct {
+	name           string
+	pool           *upstream.Pool
+	allowed        *methodSet // Nil if all methods are allowed
+	denied         *methodSet
+	guards         config.GuardConfig
+	filterDeposits bool
+	screenTxs      bool
+}
+
+// newRoute creates a route from its configuration
+func newRoute(cfg config.RouteConfig, pool *upstream.Pool) *route {
+	rt := &route{
+		name:           cfg.Name,
+		pool:           pool,
+		denied:         newMethodSet(cfg.DeniedMethods),
+		guards:         cfg.Guards,
+		filterDeposits: cfg.FilterDeposits,
+		screenTxs:      cfg.ScreenTxs,
+	}
+	if len(cfg.AllowedMethods) > 0 {
+		rt.allowed = newMethodSet(cfg.AllowedMethods)
+	}
+	return rt
+}
+
+// methodRule returns the policy rule that rejects a method on the route, or
+// an empty string if the route forwards it. Denied methods take precedence.
+func (rt *route) methodRule(method string) string {
+	if rt.denied.contains(method) {
+		return ruleMethodDenied
+	}
+	if rt.allowed != nil && !rt.allowed.contains(method) {
+		return ruleMethodNotAllowed
+	}
+	return ""
+}
+
+// buildRouteTable builds the routes of a configuration and maps them to
+// their upstream pools and chain IDs. The keyring is added when the table is
+// stored.
+func (s *Server) buildRouteTable(cfg *config.Config) (*routeTable, error) {
+	pools := map[string]*upstream.Pool{
+		"L1": s.ethClients.L1Pool,
+		"L2": s.ethClients.L2Pool,
+	}
+
+	table := &routeTable{
+		config:      cfg,
+		routes:      make(map[string]*route),
+		chainRoutes: make(map[string]*route),
+		rateLimits:  newRateLimits(cfg.RateLimit),
+	}
+	for _, rc := range cfg.Routes {
+		pool, ok := pools[strings.ToUpper(rc.Upstream)]
+		if !ok {
+			return nil, fmt.Errorf("route %s: unknown upstream %q", rc.Name, rc.Upstream)
+		}
+		table.routes[rc.Name] = newRoute(rc, pool)
+	}
+	if table.routes["l1"] == nil {
+		return nil, fmt.Errorf("no l1 route configured")
+	}
+
+	// Map chain IDs to routes for /chain/{chainId} requests. The first
+	// configured route of a chain serves it.
+	for _, rc := range cfg.Routes {
+		rt := table.routes[rc.Name]
+		chainID, err := s.chainID(rt.pool)
+		if err != nil {
+			slog.Warn("Could not resolve chain ID of route", "route", rt.name, "error", err)
+			continue
+		}
+		if table.chainRoutes[chainID] != nil {
+			continue
+		}
+		table.chainRoutes[chainID] = rt
+		slog.Info("Chain route registered", "path", "/chain/"+chainID, "route", rt.name)
+	}
+	return table, nil
+}
+
+// chainID returns the chain ID of an upstream pool. It is resolved once and
+// then cached, failed lookups are retried on the next reload.
+func (s *Server) chainID(pool *upstream.Pool) (string, error) {
+	s.chainMu.Lock()
+	defer s.chainMu.Unlock()
+
+	if chainID, ok := s.chainIDs[pool]; ok {
+		return chainID, nil
+	}
+
+	client := s.ethClients.L1Client
+	if pool == s.ethClients.L2Pool {
+		client = s.ethClients.L2Client
+	}
+	chainID, err := client.ChainID(context.Background())
+	if err != nil {
+		return "", err
+	}
+	s.chainIDs[pool] = chainID.String()
+	return chainID.String(), nil
+}
+
+// routeHandler handles requests to /{route} and /{route}/{apiKey}. Requests
+// to / go to L1 for backwards compatibility.
+func (s *Server) routeHandler(w http.ResponseWriter, r *http.Request) {
+	table := s.current()
+	name, key, _ := strings.Cut(strings.Trim(r.URL.Path, "/"), "/")
+	if name == "" {
+		name = "l1"
+	}
+	rt, ok := table.routes[name]
+	if !ok {
+		http.Error(w, fmt.Sprintf("Unknown route: %s", name), http.StatusNotFound)
+		return
+	}
+	s.proxyHandler(w, r, table, rt, key)
+}
+
+// chainHandler handles /chain/{chainId} and /chain/{chainId}/{apiKey} requests
+func (s *Server) chainHandler(w http.ResponseWriter, r *http.Request) {
+	table := s.current()
+	chainID, key, _ := strings.Cut(strings.Trim(strings.TrimPrefix(r.URL.Path, "/chain/"), "/"), "/")
+	rt, ok := table.chainRoutes[chainID]
+	if !ok {
+		http.Error(w, fmt.Sprintf("Unknown chain ID: %s", chainID), http.StatusNotFound)
+		return
+	}
+	s.proxyHandler(w, r, table, rt, key)
+}
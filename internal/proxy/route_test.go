@@ -0,0 +1,72 @@
+package proxy
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ddomeke/rpc_proxy/internal/config"
+)
+
+func TestRouting(t *testing.T) {
+	l1, l1Srv := newFakeUpstream(t, "0x1")
+	l2, l2Srv := newFakeUpstream(t, "0xa")
+	l1.result("web3_clientVersion", `"l1"`)
+	l2.result("web3_clientVersion", `"l2"`)
+
+	cfg := testConfig(l1Srv, l2Srv)
+	cfg.Routes = append(cfg.Routes, config.RouteConfig{Name: "archive", Upstream: "L1", AllowedMethods: []string{"eth_*"}})
+	s := newTestServer(t, cfg)
+
+	// The proxy's own mux
+	mux := http.NewServeMux()
+	mux.HandleFunc("/chain/", s.chainHandler)
+	mux.HandleFunc("/", s.routeHandler)
+	handler := withRequestID(mux)
+
+	tests := []struct {
+		name       string
+		path       string
+		wantStatus int
+		wantResult string
+		wantCode   int
+	}{
+		{name: "l1", path: "/l1", wantStatus: http.StatusOK, wantResult: `"l1"`},
+		{name: "l2", path: "/l2", wantStatus: http.StatusOK, wantResult: `"l2"`},
+		{name: "l2_trailing_slash", path: "/l2/", wantStatus: http.StatusOK, wantResult: `"l2"`},
+		{name: "root_is_l1", path: "/", wantStatus: http.StatusOK, wantResult: `"l1"`},
+		{name: "unknown_route", path: "/unknown", wantStatus: http.StatusNotFound},
+		{name: "unknown_route_with_key", path: "/unknown/key", wantStatus: http.StatusNotFound},
+		{name: "chain_l1", path: "/chain/1", wantStatus: http.StatusOK, wantResult: `"l1"`},
+		{name: "chain_l2", path: "/chain/10", wantStatus: http.StatusOK, wantResult: `"l2"`},
+		{name: "chain_unknown", path: "/chain/5", wantStatus: http.StatusNotFound},
+		// Each route has its own method policy
+		{name: "custom_route_policy", path: "/archive", wantStatus: http.StatusOK, wantCode: errCodeMethodNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := `{"jsonrpc":"2.0","id":1,"method":"web3_clientVersion","params":[]}`
+			req := httptest.NewRequest(http.MethodPost, tt.path, bytes.NewBufferString(body))
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			resp := decodeResponses(t, rec.Body.Bytes())[0]
+			switch {
+			case tt.wantCode != 0:
+				if resp.Error == nil || resp.Error.Code != tt.wantCode {
+					t.Errorf("response %s, want error code %d", rec.Body, tt.wantCode)
+				}
+			case string(resp.Result) != tt.wantResult:
+				t.Errorf("result = %s, want %s (%s)", resp.Result, tt.wantResult, rec.Body)
+			}
+		})
+	}
+}
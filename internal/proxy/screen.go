@@ -0,0 +1,82 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// JSON-RPC error codes used when screening transactions
+const (
+	errCodeInvalidParams       = -32602
+	errCodeTransactionRejected = -32003 // EIP-1474 "Transaction rejected"
+)
+
+// Methods that submit signed transactions
+var rawTransactionMethods = map[string]bool{
+	"eth_sendRawTransaction":            true,
+	"eth_sendRawTransactionConditional": true,
+}
+
+// frozenCheck is an address to screen and the rejection reason if it is frozen
+type frozenCheck struct {
+	address common.Address
+	reason  string
+}
+
+// screenRawTransaction decodes a submitted raw transaction and checks its
+// sender and recipient against the frozen accounts list. It returns an encoded
+// JSON-RPC error response if the transaction must be rejected, nil otherwise.
+func (s *Server) screenRawTransaction(ctx context.Context, rt *route, req *rpcRequest) []byte {
+	var params []json.RawMessage
+	if err := json.Unmarshal(req.Params, &params); err != nil || len(params) == 0 {
+		return newErrorResponse(req.ID, errCodeInvalidParams, "missing raw transaction parameter")
+	}
+
+	var rawHex string
+	if err := json.Unmarshal(params[0], &rawHex); err != nil {
+		return newErrorResponse(req.ID, errCodeInvalidParams, "raw transaction must be a hex string")
+	}
+	raw, err := hexutil.Decode(rawHex)
+	if err != nil {
+		return newErrorResponse(req.ID, errCodeInvalidParams, fmt.Sprintf("invalid raw transaction: %v", err))
+	}
+
+	tx := new(types.Transaction)
+	if err := tx.UnmarshalBinary(raw); err != nil {
+		return newErrorResponse(req.ID, errCodeInvalidParams, fmt.Sprintf("could not decode transaction: %v", err))
+	}
+
+	sender, err := types.Sender(types.LatestSignerForChainID(tx.ChainId()), tx)
+	if err != nil {
+		return newErrorResponse(req.ID, errCodeInvalidParams, fmt.Sprintf("could not recover sender: %v", err))
+	}
+
+	checks := []frozenCheck{{sender, "frozen_sender"}}
+	if tx.To() != nil {
+		checks = append(checks, frozenCheck{*tx.To(), "frozen_recipient"})
+	}
+
+	for _, check := range checks {
+		frozen, err := s.frozenSet.IsFrozen(ctx, check.address)
+		if err != nil {
+			// Fail closed like the deposit log filter
+			slog.ErrorContext(ctx, "Frozen address check error", "address", check.address.Hex(), "error", err)
+			return newErrorResponse(req.ID, errCodeInternalError, "could not screen transaction")
+		}
+		if frozen {
+			slog.InfoContext(ctx, "Transaction rejected", "tx", tx.Hash().Hex(), "route", rt.name,
+				"reason", check.reason, "address", check.address.Hex())
+
+			s.metricsCollector.RejectedTransactions.WithLabelValues(rt.name, check.reason).Inc()
+			return newErrorResponse(req.ID, errCodeTransactionRejected,
+				fmt.Sprintf("transaction rejected: account %s is frozen", check.address.Hex()))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package proxy
+
+import (
+	"encoding/json"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// serveFrozen answers isFrozen calls of the frozen contract for the accounts
+// given, or fails them all if fail is set
+func serveFrozen(l1 *fakeUpstream, fail bool, frozen ...common.Address) {
+	l1.handle("eth_call", func(req rpcRequest) rpcResponse {
+		if fail {
+			return rpcResponse{Error: &rpcError{Code: errCodeInternalError, Message: "node unavailable"}}
+		}
+		var params []struct {
+			Input hexutil.Bytes `json:"input"`
+		}
+		json.Unmarshal(req.Params, &params)
+		result := common.Hash{}
+		for _, account := range frozen {
+			// The account is the last argument of isFrozen(address)
+			if len(params[0].Input) == 36 && common.BytesToAddress(params[0].Input[4:]) == account {
+				result[31] = 1
+			}
+		}
+		return rpcResponse{Result: json.RawMessage(`"` + result.Hex() + `"`)}
+	})
+}
+
+func TestScreenRawTransaction(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	frozenKey, _ := crypto.GenerateKey()
+	frozenSender := crypto.PubkeyToAddress(frozenKey.PublicKey)
+	frozenRecipient := common.HexToAddress("0x00000000000000000000000000000000000000b0")
+	recipient := common.HexToAddress("0x00000000000000000000000000000000000000b1")
+
+	// rawTx signs a transfer on the L2 chain
+	rawTx := func(signer []byte, to *common.Address) string {
+		k, _ := crypto.ToECDSA(signer)
+		tx := types.NewTx(&types.DynamicFeeTx{ChainID: big.NewInt(10), Nonce: 1, Gas: 21000, GasFeeCap: big.NewInt(1), To: to})
+		signed, err := types.SignTx(tx, types.LatestSignerForChainID(big.NewInt(10)), k)
+		if err != nil {
+			t.Fatal(err)
+		}
+		raw, _ := signed.MarshalBinary()
+		return hexutil.Encode(raw)
+	}
+	clean := rawTx(crypto.FromECDSA(key), &recipient)
+
+	tests := []struct {
+		name         string
+		path         string
+		raw          string
+		failContract bool
+		wantCode     int
+		wantUpstream int
+	}{
+		{name: "clean", path: "/l2", raw: clean, wantUpstream: 1},
+		{name: "contract_creation", path: "/l2", raw: rawTx(crypto.FromECDSA(key), nil), wantUpstream: 1},
+		{name: "frozen_sender", path: "/l2", raw: rawTx(crypto.FromECDSA(frozenKey), &recipient), wantCode: errCodeTransactionRejected},
+		{name: "frozen_recipient", path: "/l2", raw: rawTx(crypto.FromECDSA(key), &frozenRecipient), wantCode: errCodeTransactionRejected},
+		{name: "invalid_hex", path: "/l2", raw: "0xzz", wantCode: errCodeInvalidParams},
+		{name: "invalid_transaction", path: "/l2", raw: "0x01", wantCode: errCodeInvalidParams},
+		// Transactions that cannot be screened are rejected
+		{name: "check_failed", path: "/l2", raw: clean, failContract: true, wantCode: errCodeInternalError},
+		// L1 does not screen by default
+		{name: "l1_not_screened", path: "/l1", raw: rawTx(crypto.FromECDSA(frozenKey), &recipient), wantUpstream: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l1, l1Srv := newFakeUpstream(t, "0x1")
+			l2, l2Srv := newFakeUpstream(t, "0xa")
+			serveFrozen(l1, tt.failContract, frozenSender, frozenRecipient)
+			l1.result("eth_sendRawTransaction", `"0x01"`)
+			l2.result("eth_sendRawTransaction", `"0x01"`)
+			s := newTestServer(t, testConfig(l1Srv, l2Srv))
+
+			body := `{"jsonrpc":"2.0","id":1,"method":"eth_sendRawTransaction","params":["` + tt.raw + `"]}`
+			resp := decodeResponses(t, post(s, tt.path, body, nil).Body.Bytes())[0]
+			code := 0
+			if resp.Error != nil {
+				code = resp.Error.Code
+			}
+			if code != tt.wantCode {
+				t.Errorf("error code = %d (%+v), want %d", code, resp.Error, tt.wantCode)
+			}
+			upstream := l1
+			if strings.HasPrefix(tt.path, "/l2") {
+				upstream = l2
+			}
+			if got := upstream.count("eth_sendRawTransaction"); got != tt.wantUpstream {
+				t.Errorf("upstream got %d transactions, want %d", got, tt.wantUpstream)
+			}
+		})
+	}
+}
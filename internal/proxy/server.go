@@ -1,35 +1,247 @@
 package proxy
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
+	"sync"
+	"sync/atomic"
 
+	"github.com/ddomeke/rpc_proxy/internal/auth"
+	"github.com/ddomeke/rpc_proxy/internal/cache"
 	"github.com/ddomeke/rpc_proxy/internal/config"
 	"github.com/ddomeke/rpc_proxy/internal/eth"
+	"github.com/ddomeke/rpc_proxy/internal/logging"
 	"github.com/ddomeke/rpc_proxy/internal/metrics"
+	"github.com/ddomeke/rpc_proxy/internal/ratelimit"
+	"github.com/ddomeke/rpc_proxy/internal/store"
+	"github.com/ddomeke/rpc_proxy/internal/upstream"
+	"github.com/ddomeke/rpc_proxy/pkg/utils"
+	"github.com/ethereum/go-ethereum/common"
+	"golang.org/x/sync/singleflight"
 )
 
 // Server holds the RPC proxy server configuration
 type Server struct {
-	config           *config.Config
 	ethClients       *eth.Clients
+	frozenSet        *eth.FrozenSet
+	keyStore         store.KeyStore
 	metricsCollector *metrics.Collector
+	meter            *auth.Meter
+	cache            *cache.Cache                   // Nil if caching is disabled
+	heads            map[*upstream.Pool]*chainHeads // Heads seen by the reorg checks of the cache
+	flights          singleflight.Group             // Upstream calls in flight by request
+	table            atomic.Pointer[routeTable]
+	wsEnabled        bool // Websocket listener started
+
+	tableMu    sync.Mutex      // Serializes table replacements
+	storedKeys []*store.APIKey // API keys of the store, added to every keyring
+
+	wsMu    sync.Mutex
+	wsConns map[*wsConn]bool // Open websocket client connections
+
+	chainMu  sync.Mutex
+	chainIDs map[*upstream.Pool]string // Chain ID of each upstream pool
+}
+
+// routeTable is the configuration and the routes the proxy serves. It is
+// replaced as a whole on reload, so every request sees either the old or the
+// new table.
+type routeTable struct {
+	config      *config.Config
+	routes      map[string]*route // Routes by name
+	chainRoutes map[string]*route // Routes by chain ID
+	keyring     *auth.Keyring     // API keys of the configuration and the store
+	rateLimits  *rateLimits
 }
 
 // NewServer creates a new RPC proxy server
-func NewServer(cfg *config.Config, clients *eth.Clients, collector *metrics.Collector) *Server {
-	return &Server{
-		config:           cfg,
+func NewServer(cfg *config.Config, clients *eth.Clients, frozenSet *eth.FrozenSet, st store.Store, responseCache *cache.Cache, collector *metrics.Collector) (*Server, error) {
+	s := &Server{
 		ethClients:       clients,
+		frozenSet:        frozenSet,
+		keyStore:         st,
 		metricsCollector: collector,
+		meter:            auth.NewMeter(collector),
+		cache:            responseCache,
+		heads: map[*upstream.Pool]*chainHeads{
+			clients.L1Pool: {hashes: make(map[uint64]common.Hash)},
+			clients.L2Pool: {hashes: make(map[uint64]common.Hash)},
+		},
+		chainIDs: make(map[*upstream.Pool]string),
+		wsConns:  make(map[*wsConn]bool),
+	}
+	table, err := s.buildRouteTable(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if s.storedKeys, err = s.keyStore.APIKeys(); err != nil {
+		return nil, fmt.Errorf("could not load API keys: %v", err)
+	}
+	if table.rateLimits.limiter, err = ratelimit.New(cfg.RateLimit, collector); err != nil {
+		return nil, err
+	}
+	s.storeTable(table)
+	if table.keyring.Required() && table.keyring.Len() == 0 {
+		slog.Warn("API keys are required but none is configured, all proxy requests are rejected")
+	}
+	slog.Info("Rate limiter initialized", "backend", cfg.RateLimit.Backend)
+	return s, nil
+}
+
+// ReloadKeys reloads the stored API keys after they were changed
+func (s *Server) ReloadKeys() error {
+	storedKeys, err := s.keyStore.APIKeys()
+	if err != nil {
+		return fmt.Errorf("could not load API keys: %v", err)
+	}
+
+	s.tableMu.Lock()
+	defer s.tableMu.Unlock()
+	s.storedKeys = storedKeys
+	table := *s.current()
+	table.keyring = auth.NewKeyring(table.config.Auth, storedKeys)
+	s.table.Store(&table)
+	return nil
+}
+
+// storeTable adds the keyring to a route table and switches to it
+func (s *Server) storeTable(table *routeTable) {
+	s.tableMu.Lock()
+	defer s.tableMu.Unlock()
+	table.keyring = auth.NewKeyring(table.config.Auth, s.storedKeys)
+	s.table.Store(table)
+}
+
+// PrepareReload builds the routes of a new configuration and returns the
+// function that switches to them. Requests in flight finish on the routes
+// they started with. A changed rate limit backend gets a new limiter and the
+// old one is closed, its buckets start over. A limiter that cannot be
+// created fails the reload.
+func (s *Server) PrepareReload(cfg *config.Config) (func(), error) {
+	table, err := s.buildRouteTable(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	// A new backend is created here, so one that cannot be built rejects the
+	// whole reload. Limiters connect on first use, so a reload that another
+	// component rejects leaves no connections open.
+	oldRL := s.current().config.RateLimit
+	rl := cfg.RateLimit
+	backendChanged := rl.Backend != oldRL.Backend ||
+		(rl.Backend == "redis" && (rl.RedisURL != oldRL.RedisURL || rl.RedisTimeout != oldRL.RedisTimeout))
+	var limiter ratelimit.Limiter
+	if backendChanged {
+		if limiter, err = ratelimit.New(rl, s.metricsCollector); err != nil {
+			return nil, err
+		}
 	}
+
+	return func() {
+		oldTable := s.current()
+		old := oldTable.config
+		table.rateLimits.limiter = oldTable.rateLimits.limiter
+		if backendChanged {
+			table.rateLimits.limiter = limiter
+		}
+
+		s.storeTable(table)
+		if !s.wsEnabled && cfg.L1RPCURLWs != "" {
+			slog.Warn("Websocket RPC Proxy stays disabled until restart")
+		}
+		if backendChanged {
+			if err := oldTable.rateLimits.limiter.Close(); err != nil {
+				slog.Error("Could not close previous rate limiter", "error", err)
+			}
+			slog.Info("Rate limiter replaced", "backend", rl.Backend)
+		}
+		if c := cfg.Cache; c.Enabled != old.Cache.Enabled || c.MaxMemoryMB != old.Cache.MaxMemoryMB || c.Path != old.Cache.Path ||
+			c.DiskMaxEntries != old.Cache.DiskMaxEntries || c.HeadInterval != old.Cache.HeadInterval {
+			slog.Warn("Cache changes apply after restart, the new TTLs apply now")
+		}
+	}, nil
+}
+
+// Close releases the connections of the rate limiter once the server stopped
+func (s *Server) Close() error {
+	return s.current().rateLimits.limiter.Close()
 }
 
-// Start starts the RPC proxy server
-func (s *Server) Start() error {
-	http.HandleFunc("/", s.proxyHandler)
-	proxyAddress := fmt.Sprintf(":%s", s.config.ProxyPort)
-	log.Printf("[INFO] RPC Proxy started. Port: %s\n", proxyAddress)
-	return http.ListenAndServe(proxyAddress, nil)
+// current returns the route table requests are served with
+func (s *Server) current() *routeTable {
+	return s.table.Load()
+}
+
+// Start serves the RPC proxy and its websocket listener until the context is
+// cancelled. In-flight requests are then drained within the shutdown timeout
+// and websocket clients are disconnected. If a listener fails, the other one
+// is stopped as well.
+func (s *Server) Start(ctx context.Context) error {
+	cfg := s.current().config
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var servers []*http.Server
+
+	// Websocket listener for subscriptions, which need an L1 websocket upstream
+	if cfg.L1RPCURLWs != "" {
+		s.wsEnabled = true
+		wsMux := http.NewServeMux()
+		wsMux.HandleFunc("/", s.wsHandler)
+		wsServer := &http.Server{Addr: fmt.Sprintf(":%s", cfg.ProxyWsPort), Handler: withRequestID(wsMux)}
+		wsServer.RegisterOnShutdown(s.closeWebsockets)
+		servers = append(servers, wsServer)
+		slog.Info("Websocket RPC Proxy started", "addr", wsServer.Addr)
+	} else {
+		slog.Warn("L1_RPC_URL_WS not set, websocket RPC Proxy disabled")
+	}
+
+	// Routes are looked up per request, so reloaded routes need no new handlers
+	mux := http.NewServeMux()
+	mux.HandleFunc("/chain/", s.chainHandler)
+	mux.HandleFunc("/", s.routeHandler)
+	servers = append(servers, &http.Server{Addr: fmt.Sprintf(":%s", cfg.ProxyPort), Handler: withRequestID(mux)})
+	slog.Info("RPC Proxy started", "addr", servers[len(servers)-1].Addr)
+
+	errCh := make(chan error, len(servers))
+	for _, srv := range servers {
+		go func(srv *http.Server) {
+			err := utils.Serve(ctx, srv, cfg.ShutdownTimeout)
+			if err != nil {
+				cancel()
+			}
+			errCh <- err
+		}(srv)
+	}
+
+	var errs []error
+	for range servers {
+		if err := <-errCh; err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("RPC proxy: %w", errors.Join(errs...))
+	}
+	slog.Info("RPC Proxy stopped")
+	return nil
+}
+
+// maxRequestIDLength caps client supplied request IDs
+const maxRequestIDLength = 128
+
+// withRequestID tags every request with the client's X-Request-ID, or a new
+// ID if there is none, so that all of its log lines can be correlated
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(logging.RequestIDHeader)
+		if id == "" || len(id) > maxRequestIDLength {
+			id = logging.NewRequestID()
+		}
+		w.Header().Set(logging.RequestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(logging.WithRequestID(r.Context(), id)))
+	})
 }
@@ -0,0 +1,260 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/ddomeke/rpc_proxy/internal/cache"
+	"github.com/ddomeke/rpc_proxy/internal/config"
+	"github.com/ddomeke/rpc_proxy/internal/eth"
+	"github.com/ddomeke/rpc_proxy/internal/logging"
+	"github.com/ddomeke/rpc_proxy/internal/metrics"
+	"github.com/ddomeke/rpc_proxy/internal/store"
+	"github.com/ddomeke/rpc_proxy/internal/upstream"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethclient"
+)
+
+var (
+	collectorOnce sync.Once
+	collector     *metrics.Collector
+)
+
+// testCollector returns the metrics collector shared by all tests, as the
+// metrics can only be registered once
+func testCollector() *metrics.Collector {
+	collectorOnce.Do(func() {
+		collector = metrics.NewCollector(config.MetricsConfig{AccountLabels: "full"})
+	})
+	return collector
+}
+
+// fakeUpstream is a JSON-RPC node answering single requests from per-method
+// handlers. Methods without a handler return method not found, notifications
+// get an empty response.
+type fakeUpstream struct {
+	mu       sync.Mutex
+	chainID  string
+	handlers map[string]func(req rpcRequest) rpcResponse
+	calls    map[string]int
+}
+
+func newFakeUpstream(t *testing.T, chainID string) (*fakeUpstream, *httptest.Server) {
+	f := &fakeUpstream{
+		chainID:  chainID,
+		handlers: make(map[string]func(rpcRequest) rpcResponse),
+		calls:    make(map[string]int),
+	}
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	return f, srv
+}
+
+// handle sets the handler of a method
+func (f *fakeUpstream) handle(method string, handler func(req rpcRequest) rpcResponse) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.handlers[method] = handler
+}
+
+// result answers a method with a fixed result
+func (f *fakeUpstream) result(method, result string) {
+	f.handle(method, func(req rpcRequest) rpcResponse {
+		return rpcResponse{Result: json.RawMessage(result)}
+	})
+}
+
+// count returns how often a method was called
+func (f *fakeUpstream) count(method string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls[method]
+}
+
+func (f *fakeUpstream) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req rpcRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	f.mu.Lock()
+	handler := f.handlers[req.Method]
+	f.calls[req.Method]++
+	f.mu.Unlock()
+
+	// Notifications get no response
+	if len(req.ID) == 0 {
+		if handler != nil {
+			handler(req)
+		}
+		return
+	}
+
+	var resp rpcResponse
+	switch {
+	case handler != nil:
+		resp = handler(req)
+	case req.Method == "eth_chainId":
+		resp = rpcResponse{Result: json.RawMessage(`"` + f.chainID + `"`)}
+	default:
+		resp = rpcResponse{Error: &rpcError{Code: errCodeMethodNotFound, Message: "the method " + req.Method + " does not exist"}}
+	}
+	resp.JSONRPC, resp.ID = "2.0", responseID(req.ID)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// testConfig is the default configuration with the fake upstreams
+func testConfig(l1, l2 *httptest.Server) *config.Config {
+	cfg := config.Default()
+	cfg.L1RPCURL, cfg.L2RPCURL = l1.URL, l2.URL
+	cfg.L1RPCURLs, cfg.L2RPCURLs = []string{l1.URL}, []string{l2.URL}
+	cfg.FrozenContractAddress = "0x00000000000000000000000000000000000000f0"
+	cfg.OptimismPortalAddress = "0xbEb5Fc579115071764c7423A4f12eDde41f106Ed"
+	return cfg
+}
+
+// newTestServer creates a proxy server for the upstreams of a configuration
+func newTestServer(t *testing.T, cfg *config.Config) *Server {
+	t.Helper()
+	portalABI, err := abi.JSON(strings.NewReader(eth.OptimismPortalABI))
+	if err != nil {
+		t.Fatal(err)
+	}
+	clients := &eth.Clients{HTTPClient: http.DefaultClient, PortalABI: portalABI}
+	if clients.L1Pool, err = upstream.NewPool("L1", cfg.L1RPCURLs, cfg.Upstream); err != nil {
+		t.Fatal(err)
+	}
+	if clients.L2Pool, err = upstream.NewPool("L2", cfg.L2RPCURLs, cfg.Upstream); err != nil {
+		t.Fatal(err)
+	}
+	if clients.L1Client, err = ethclient.Dial(cfg.L1RPCURLs[0]); err != nil {
+		t.Fatal(err)
+	}
+	if clients.L2Client, err = ethclient.Dial(cfg.L2RPCURLs[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	frozenSet, err := eth.NewFrozenSet(cfg, clients, testCollector())
+	if err != nil {
+		t.Fatal(err)
+	}
+	responseCache, err := cache.New(cfg.Cache, testCollector())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewServer(cfg, clients, frozenSet, store.NewMemoryStore(), responseCache, testCollector())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// post sends a JSON-RPC body to a path of the proxy
+func post(s *Server, path, body string, header http.Header) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, path, bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	rec := httptest.NewRecorder()
+	withRequestID(http.HandlerFunc(s.routeHandler)).ServeHTTP(rec, req)
+	return rec
+}
+
+// decodeResponses decodes a single or batch JSON-RPC response body
+func decodeResponses(t *testing.T, body []byte) []rpcResponse {
+	t.Helper()
+	var responses []rpcResponse
+	if isBatch(body) {
+		if err := json.Unmarshal(body, &responses); err != nil {
+			t.Fatalf("could not decode batch response %s: %v", body, err)
+		}
+		return responses
+	}
+	var resp rpcResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		t.Fatalf("could not decode response %s: %v", body, err)
+	}
+	return []rpcResponse{resp}
+}
+
+func TestRequestIDLogging(t *testing.T) {
+	frozen := common.HexToAddress("0x00000000000000000000000000000000000000a0")
+	l1, l1Srv := newFakeUpstream(t, "0x1")
+	_, l2Srv := newFakeUpstream(t, "0xa")
+	serveFrozen(l1, false, frozen)
+	l1.result("eth_getBlockReceipts", `[{"logs":[{"address":"0xbeb5fc579115071764c7423a4f12edde41f106ed","blockNumber":"0x10","logIndex":"0x0",`+
+		`"topics":["`+eth.DepositEventTopic.Hex()+`","`+common.BytesToHash(frozen.Bytes()).Hex()+`"]}]}]`)
+	s := newTestServer(t, testConfig(l1Srv, l2Srv))
+
+	defaultLogger := slog.Default()
+	t.Cleanup(func() { slog.SetDefault(defaultLogger) })
+	path := filepath.Join(t.TempDir(), "proxy.log")
+	logFile, err := logging.Init(config.LogConfig{Level: slog.LevelDebug, Format: "json", File: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logFile.Close()
+
+	tests := []struct {
+		name   string
+		header string // X-Request-ID sent by the client
+	}{
+		{name: "client_id", header: "client-request-1"},
+		{name: "generated_id"},
+		{name: "id_too_long", header: strings.Repeat("x", maxRequestIDLength+1)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{}
+			if tt.header != "" {
+				header.Set(logging.RequestIDHeader, tt.header)
+			}
+			rec := post(s, "/l1", `{"jsonrpc":"2.0","id":1,"method":"eth_getBlockReceipts","params":["0x10"]}`, header)
+			id := rec.Header().Get(logging.RequestIDHeader)
+			switch {
+			case tt.header != "" && len(tt.header) <= maxRequestIDLength && id != tt.header:
+				t.Errorf("request ID = %q, want the client's %q", id, tt.header)
+			case id == "" || id == tt.header && len(tt.header) > maxRequestIDLength:
+				t.Errorf("request ID = %q, want a generated one", id)
+			}
+
+			// The request and the frozen check of the filtered receipt log carry the ID
+			f, err := os.Open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			found := make(map[string]bool)
+			scanner := bufio.NewScanner(f)
+			for scanner.Scan() {
+				var line struct {
+					Msg       string `json:"msg"`
+					RequestID string `json:"request_id"`
+				}
+				json.Unmarshal(scanner.Bytes(), &line)
+				if line.RequestID == id {
+					found[line.Msg] = true
+				}
+			}
+			for _, msg := range []string{"JSON-RPC request received", "Frozen check"} {
+				if !found[msg] {
+					t.Errorf("no %q log line with request ID %s", msg, id)
+				}
+			}
+		})
+	}
+}
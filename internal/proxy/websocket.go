@@ -0,0 +1,230 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/ddomeke/rpc_proxy/internal/logging"
+	"github.com/gorilla/websocket"
+)
+
+// Methods that are proxied to the upstream websocket instead of the HTTP RPC
+var subscriptionMethods = map[string]bool{
+	"eth_subscribe":   true,
+	"eth_unsubscribe": true,
+}
+
+var upgrader = websocket.Upgrader{
+	// The proxy is an RPC endpoint, browser origins are not restricted
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// subscriptionNotification is an eth_subscription message sent by the upstream node
+type subscriptionNotification struct {
+	Method string `json:"method"`
+	Params struct {
+		Subscription string          `json:"subscription"`
+		Result       json.RawMessage `json:"result"`
+	} `json:"params"`
+}
+
+// wsConn serializes writes to a websocket connection
+type wsConn struct {
+	conn *websocket.Conn
+	mu   sync.Mutex
+}
+
+func (c *wsConn) write(msgType int, data []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.conn.WriteMessage(msgType, data)
+}
+
+// close sends a close message with the given code and closes the connection
+func (c *wsConn) close(code int, reason string) {
+	c.write(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason))
+	c.conn.Close()
+}
+
+// trackWebsocket adds or removes an open client connection
+func (s *Server) trackWebsocket(c *wsConn, open bool) {
+	s.wsMu.Lock()
+	defer s.wsMu.Unlock()
+	if open {
+		s.wsConns[c] = true
+	} else {
+		delete(s.wsConns, c)
+	}
+}
+
+// closeWebsockets disconnects all websocket clients on shutdown. Hijacked
+// connections are not drained by http.Server.Shutdown.
+func (s *Server) closeWebsockets() {
+	s.wsMu.Lock()
+	defer s.wsMu.Unlock()
+	if len(s.wsConns) > 0 {
+		slog.Info("Closing websocket connections", "connections", len(s.wsConns))
+	}
+	for c := range s.wsConns {
+		c.close(websocket.CloseGoingAway, "server shutting down")
+	}
+}
+
+// wsHandler handles JSON-RPC over websocket. Subscriptions are proxied to the
+// L1 websocket endpoint, all other requests go through the regular L1 route.
+// The API key is given in the X-API-Key header or as the path (/{apiKey}).
+func (s *Server) wsHandler(w http.ResponseWriter, r *http.Request) {
+	table := s.current()
+	pathKey := strings.Trim(r.URL.Path, "/")
+	ctx := s.authenticate(w, r, table, table.routes["l1"], pathKey)
+	if ctx == nil {
+		return
+	}
+	secret := r.Header.Get(apiKeyHeader)
+	if secret == "" {
+		secret = pathKey
+	}
+	connID := logging.RequestID(ctx)
+	clientIP := callerFrom(ctx).ip
+
+	clientConn, err := upgrader.Upgrade(w, r, http.Header{logging.RequestIDHeader: {connID}})
+	if err != nil {
+		slog.ErrorContext(ctx, "Websocket upgrade failed", "error", err)
+		return
+	}
+	defer clientConn.Close()
+
+	// Tracked so that shutdown can disconnect the client
+	client := &wsConn{conn: clientConn}
+	s.trackWebsocket(client, true)
+	defer s.trackWebsocket(client, false)
+
+	wsURL := s.current().config.L1RPCURLWs
+	if wsURL == "" {
+		slog.WarnContext(ctx, "Websocket connection refused, no L1 websocket configured")
+		client.close(websocket.CloseInternalServerErr, "upstream unavailable")
+		return
+	}
+	upstreamConn, _, err := websocket.DefaultDialer.DialContext(ctx, wsURL, nil)
+	if err != nil {
+		slog.ErrorContext(ctx, "Could not connect to L1 websocket", "error", err)
+		client.close(websocket.CloseInternalServerErr, "upstream unavailable")
+		return
+	}
+	defer upstreamConn.Close()
+
+	slog.InfoContext(ctx, "Websocket client connected", "remote_addr", r.RemoteAddr)
+
+	upstream := &wsConn{conn: upstreamConn}
+
+	// Relay upstream messages to the client until either side disconnects
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer clientConn.Close()
+		for {
+			msgType, msg, err := upstreamConn.ReadMessage()
+			if err != nil {
+				slog.InfoContext(ctx, "Upstream websocket closed", "error", err)
+				return
+			}
+			if s.filterNotification(ctx, msg) {
+				continue
+			}
+			if err := client.write(msgType, msg); err != nil {
+				return
+			}
+		}
+	}()
+
+	// Every message is a request of its own, numbered within the connection
+	for seq := 1; ; seq++ {
+		msgType, msg, err := clientConn.ReadMessage()
+		if err != nil {
+			break
+		}
+		msgCtx := logging.WithRequestID(ctx, fmt.Sprintf("%s-%d", connID, seq))
+		// The route and the key are looked up per message so that reloaded
+		// policies apply and revoked keys are disconnected
+		table := s.current()
+		rt := table.routes["l1"]
+		key, kerr := s.resolveKey(table.keyring, rt, secret)
+		if kerr != nil {
+			slog.InfoContext(msgCtx, "Websocket client disconnected", "reason", kerr.message)
+			client.close(websocket.ClosePolicyViolation, kerr.message)
+			break
+		}
+		msgCtx = withCaller(msgCtx, &caller{ip: clientIP, key: key, keyring: table.keyring, limits: table.rateLimits})
+		if err := s.handleWSMessage(msgCtx, rt, client, upstream, msgType, msg); err != nil {
+			slog.ErrorContext(msgCtx, "Websocket write failed", "error", err)
+			break
+		}
+	}
+
+	upstreamConn.Close()
+	<-done
+	slog.InfoContext(ctx, "Websocket client disconnected", "remote_addr", r.RemoteAddr)
+}
+
+// handleWSMessage routes a single client message
+func (s *Server) handleWSMessage(ctx context.Context, rt *route, client, upstream *wsConn, msgType int, msg []byte) error {
+	if isBatch(msg) {
+		if respBody, _, _ := s.processBatch(ctx, rt, msg); respBody != nil {
+			return client.write(websocket.TextMessage, respBody)
+		}
+		return nil
+	}
+
+	var req rpcRequest
+	if err := json.Unmarshal(msg, &req); err != nil {
+		return client.write(websocket.TextMessage, newErrorResponse(nil, errCodeParseError, "Parse error"))
+	}
+
+	// Subscriptions are bound to the upstream connection
+	if subscriptionMethods[req.Method] {
+		var out requestOutcome
+		if rejection := s.applyPolicy(ctx, rt, &req, &out); rejection != nil {
+			return client.write(websocket.TextMessage, rejection)
+		}
+		if rejection := s.authorizeCall(ctx, &req, &out); rejection != nil {
+			return client.write(websocket.TextMessage, rejection)
+		}
+		return upstream.write(msgType, msg)
+	}
+
+	var out requestOutcome
+	respBody, err := s.processRequest(ctx, rt, msg, &req, &out)
+	if err != nil {
+		return client.write(websocket.TextMessage, newErrorResponse(req.ID, errCodeInternalError, err.Error()))
+	}
+	if len(respBody) == 0 {
+		return nil
+	}
+	return client.write(websocket.TextMessage, respBody)
+}
+
+// filterNotification reports whether an upstream message is a log subscription
+// notification that must not reach the client
+func (s *Server) filterNotification(ctx context.Context, msg []byte) bool {
+	var notification subscriptionNotification
+	if err := json.Unmarshal(msg, &notification); err != nil || notification.Method != "eth_subscription" {
+		return false
+	}
+
+	// Only "logs" subscriptions carry log objects
+	var logMap map[string]interface{}
+	if err := json.Unmarshal(notification.Params.Result, &logMap); err != nil {
+		return false
+	}
+	if s.current().routes["l1"].filterDeposits && s.filterFrozenDepositLog(ctx, logMap) {
+		s.metricsCollector.FilteredLogs.WithLabelValues("l1", "subscription").Inc()
+		slog.InfoContext(ctx, "Subscription notification filtered", "subscription", notification.Params.Subscription)
+		return true
+	}
+	return false
+}
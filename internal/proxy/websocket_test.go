@@ -0,0 +1,141 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ddomeke/rpc_proxy/internal/eth"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/gorilla/websocket"
+)
+
+// serveSubscriptions starts an upstream websocket node that answers
+// eth_subscribe and then sends the given notifications
+func serveSubscriptions(t *testing.T, notifications ...string) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			_, msg, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			var req rpcRequest
+			json.Unmarshal(msg, &req)
+			if req.Method != "eth_subscribe" {
+				continue
+			}
+			conn.WriteJSON(rpcResponse{JSONRPC: "2.0", ID: responseID(req.ID), Result: json.RawMessage(`"0x5ub"`)})
+			for _, notification := range notifications {
+				conn.WriteMessage(websocket.TextMessage, []byte(notification))
+			}
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// depositNotification is a log subscription notification of a TransactionDeposited event
+func depositNotification(from common.Address) string {
+	return `{"jsonrpc":"2.0","method":"eth_subscription","params":{"subscription":"0x5ub","result":{` +
+		`"address":"0xbeb5fc579115071764c7423a4f12edde41f106ed","blockNumber":"0x10","logIndex":"0x0",` +
+		`"topics":["` + eth.DepositEventTopic.Hex() + `","` + common.BytesToHash(from.Bytes()).Hex() + `"]}}}`
+}
+
+func TestWebsocket(t *testing.T) {
+	frozen := common.HexToAddress("0x00000000000000000000000000000000000000a0")
+	clean := common.HexToAddress("0x00000000000000000000000000000000000000a1")
+
+	l1, l1Srv := newFakeUpstream(t, "0x1")
+	_, l2Srv := newFakeUpstream(t, "0xa")
+	l1.result("eth_blockNumber", `"0x10"`)
+	serveFrozen(l1, false, frozen)
+	wsSrv := serveSubscriptions(t, depositNotification(frozen), depositNotification(clean))
+
+	cfg := testConfig(l1Srv, l2Srv)
+	cfg.L1RPCURLWs = "ws" + strings.TrimPrefix(wsSrv.URL, "http")
+	s := newTestServer(t, cfg)
+	proxySrv := httptest.NewServer(withRequestID(http.HandlerFunc(s.wsHandler)))
+	t.Cleanup(proxySrv.Close)
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(proxySrv.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// read returns the next message sent to the client
+	read := func(t *testing.T) map[string]interface{} {
+		t.Helper()
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		var msg map[string]interface{}
+		if err := conn.ReadJSON(&msg); err != nil {
+			t.Fatalf("no message received: %v", err)
+		}
+		return msg
+	}
+
+	tests := []struct {
+		name    string
+		request string
+		// Fields of the messages the client receives, in order
+		want []map[string]interface{}
+	}{
+		{
+			name:    "regular_call",
+			request: `{"jsonrpc":"2.0","id":1,"method":"eth_blockNumber"}`,
+			want:    []map[string]interface{}{{"id": 1.0, "result": "0x10"}},
+		},
+		{
+			name:    "denied_method",
+			request: `{"jsonrpc":"2.0","id":2,"method":"admin_peers"}`,
+			want:    []map[string]interface{}{{"id": 2.0, "error": float64(errCodeMethodNotFound)}},
+		},
+		{
+			// The deposit of the frozen account is dropped, the clean one is relayed
+			name:    "subscription",
+			request: `{"jsonrpc":"2.0","id":3,"method":"eth_subscribe","params":["logs",{}]}`,
+			want: []map[string]interface{}{
+				{"id": 3.0, "result": "0x5ub"},
+				{"method": "eth_subscription", "sender": common.BytesToHash(clean.Bytes()).Hex()},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := conn.WriteMessage(websocket.TextMessage, []byte(tt.request)); err != nil {
+				t.Fatal(err)
+			}
+			for _, want := range tt.want {
+				msg := read(t)
+				for field, value := range want {
+					got := msg[field]
+					switch field {
+					case "error":
+						errObj, _ := got.(map[string]interface{})
+						got = errObj["code"]
+					case "sender":
+						params, _ := msg["params"].(map[string]interface{})
+						result, _ := params["result"].(map[string]interface{})
+						topics, _ := result["topics"].([]interface{})
+						if len(topics) > 1 {
+							got = topics[1]
+						}
+					}
+					if got != value {
+						body, _ := json.Marshal(msg)
+						t.Errorf("message %s: %s = %v, want %v", body, field, got, value)
+					}
+				}
+			}
+		})
+	}
+}
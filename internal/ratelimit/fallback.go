@@ -0,0 +1,95 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/ddomeke/rpc_proxy/internal/metrics"
+)
+
+// fallbackRetryInterval is how long calls are limited in process after the
+// shared backend failed, before it is tried again
+const fallbackRetryInterval = 5 * time.Second
+
+// Fallback limits calls with a shared limiter and falls back to an
+// in-process one while the shared limiter fails or is too slow. Each replica
+// then enforces the limits on its own.
+type Fallback struct {
+	shared    Limiter
+	local     Limiter
+	timeout   time.Duration
+	collector *metrics.Collector
+
+	mu          sync.Mutex
+	failedUntil time.Time // Calls go to the local limiter until then
+	closed      bool      // Calls in flight after Close go to the local limiter
+}
+
+// NewFallback creates a limiter that prefers the shared limiter
+func NewFallback(shared, local Limiter, timeout time.Duration, collector *metrics.Collector) *Fallback {
+	return &Fallback{
+		shared:    shared,
+		local:     local,
+		timeout:   timeout,
+		collector: collector,
+	}
+}
+
+// Allow implements Limiter, it never fails
+func (f *Fallback) Allow(ctx context.Context, buckets []Bucket) (int, time.Duration, error) {
+	if len(limited(buckets)) == 0 {
+		return -1, 0, nil
+	}
+
+	f.mu.Lock()
+	failing := f.closed || time.Now().Before(f.failedUntil)
+	f.mu.Unlock()
+	if failing {
+		return f.local.Allow(ctx, buckets)
+	}
+
+	sharedCtx, cancel := context.WithTimeout(ctx, f.timeout)
+	defer cancel()
+	denied, retryAfter, err := f.shared.Allow(sharedCtx, buckets)
+	if err != nil {
+		f.failed(ctx, err)
+		return f.local.Allow(ctx, buckets)
+	}
+	f.recovered(ctx)
+	return denied, retryAfter, nil
+}
+
+// Close implements Limiter, it closes both limiters
+func (f *Fallback) Close() error {
+	f.mu.Lock()
+	f.closed = true
+	f.mu.Unlock()
+	return errors.Join(f.shared.Close(), f.local.Close())
+}
+
+// failed switches to the local limiter for the retry interval
+func (f *Fallback) failed(ctx context.Context, err error) {
+	f.collector.RateLimitBackendErrors.Inc()
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.failedUntil.IsZero() {
+		slog.WarnContext(ctx, "Shared rate limiter failed, limiting in process", "error", err, "retry_in", fallbackRetryInterval)
+		f.collector.RateLimitFallback.Set(1)
+	}
+	f.failedUntil = time.Now().Add(fallbackRetryInterval)
+}
+
+// recovered switches back to the shared limiter after a successful call
+func (f *Fallback) recovered(ctx context.Context) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.failedUntil.IsZero() {
+		slog.InfoContext(ctx, "Shared rate limiter recovered")
+		f.collector.RateLimitFallback.Set(0)
+		f.failedUntil = time.Time{}
+	}
+}
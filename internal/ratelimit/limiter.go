@@ -0,0 +1,74 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/ddomeke/rpc_proxy/internal/config"
+	"github.com/ddomeke/rpc_proxy/internal/metrics"
+)
+
+// Limit is the rate and the burst of a token bucket
+type Limit struct {
+	RPS   float64
+	Burst int
+}
+
+// NewLimit creates a limit from its configuration. The burst defaults to the
+// rate rounded up.
+func NewLimit(cfg config.RateLimit) Limit {
+	limit := Limit{RPS: cfg.RPS, Burst: cfg.Burst}
+	if limit.Burst == 0 {
+		limit.Burst = int(math.Ceil(cfg.RPS))
+	}
+	return limit
+}
+
+// Unlimited reports whether the limit allows every call
+func (l Limit) Unlimited() bool {
+	return l.RPS <= 0
+}
+
+// Bucket is a named token bucket and its limit
+type Bucket struct {
+	Key   string
+	Limit Limit
+}
+
+// Limiter takes tokens from named token buckets
+type Limiter interface {
+	// Allow takes a token from each of the buckets if none of them is empty,
+	// and returns -1. Otherwise no token is taken and it returns the index of
+	// the first empty bucket and the time until it has a token again.
+	Allow(ctx context.Context, buckets []Bucket) (int, time.Duration, error)
+
+	// Close releases the connections of the limiter
+	Close() error
+}
+
+// limited returns the indexes of the buckets that are not unlimited
+func limited(buckets []Bucket) []int {
+	var indexes []int
+	for i, b := range buckets {
+		if !b.Limit.Unlimited() {
+			indexes = append(indexes, i)
+		}
+	}
+	return indexes
+}
+
+// New creates the limiter of the configured backend. The redis backend falls
+// back to in-process buckets while redis is unavailable.
+func New(cfg config.RateLimitConfig, collector *metrics.Collector) (Limiter, error) {
+	memory := NewMemory()
+	if cfg.Backend != "redis" {
+		return memory, nil
+	}
+	shared, err := NewRedis(cfg.RedisURL)
+	if err != nil {
+		return nil, fmt.Errorf("could not create redis rate limiter: %v", err)
+	}
+	return NewFallback(shared, memory, cfg.RedisTimeout, collector), nil
+}
@@ -0,0 +1,151 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ddomeke/rpc_proxy/internal/config"
+	"github.com/ddomeke/rpc_proxy/internal/metrics"
+)
+
+var (
+	collectorOnce sync.Once
+	collector     *metrics.Collector
+)
+
+// testCollector returns the metrics collector shared by all tests, as the
+// metrics can only be registered once
+func testCollector() *metrics.Collector {
+	collectorOnce.Do(func() {
+		collector = metrics.NewCollector(config.MetricsConfig{AccountLabels: "full"})
+	})
+	return collector
+}
+
+// slow is a limit that does not refill during a test
+var slow = Limit{RPS: 0.001, Burst: 2}
+
+// call is an Allow call and the result it must have
+type call struct {
+	keys       []string
+	wantDenied int
+}
+
+// limiterTests are token bucket cases every limiter must pass. Keys are
+// prefixed per case, so the cases can share a limiter.
+var limiterTests = []struct {
+	name  string
+	limit Limit
+	calls []call
+}{
+	{
+		name:  "burst",
+		limit: slow,
+		calls: []call{{[]string{"a"}, -1}, {[]string{"a"}, -1}, {[]string{"a"}, 0}},
+	},
+	{
+		// A denied call takes no token from the other buckets
+		name:  "all_or_nothing",
+		limit: slow,
+		calls: []call{{[]string{"a", "b"}, -1}, {[]string{"b"}, -1}, {[]string{"a", "b"}, 1}, {[]string{"a"}, -1}, {[]string{"a"}, 0}},
+	},
+	{
+		name:  "first_empty_bucket",
+		limit: Limit{RPS: 0.001, Burst: 1},
+		calls: []call{{[]string{"a", "b"}, -1}, {[]string{"a", "b"}, 0}, {[]string{"b", "a"}, 0}},
+	},
+	{
+		name:  "unlimited",
+		limit: Limit{},
+		calls: []call{{[]string{"a"}, -1}, {[]string{"a"}, -1}, {[]string{"a"}, -1}},
+	},
+}
+
+// testLimiter runs the token bucket cases against a limiter
+func testLimiter(t *testing.T, limiter Limiter, prefix string) {
+	ctx := context.Background()
+	for _, tt := range limiterTests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i, c := range tt.calls {
+				buckets := make([]Bucket, len(c.keys))
+				for j, key := range c.keys {
+					buckets[j] = Bucket{Key: prefix + tt.name + ":" + key, Limit: tt.limit}
+				}
+				denied, retryAfter, err := limiter.Allow(ctx, buckets)
+				if err != nil {
+					t.Fatalf("call %d: %v", i, err)
+				}
+				if denied != c.wantDenied {
+					t.Fatalf("call %d denied bucket %d, want %d", i, denied, c.wantDenied)
+				}
+				if denied >= 0 && retryAfter <= 0 {
+					t.Errorf("call %d: retry after %v, want a positive wait", i, retryAfter)
+				}
+			}
+		})
+	}
+}
+
+func TestMemory(t *testing.T) {
+	testLimiter(t, NewMemory(), "")
+}
+
+func TestMemoryRefill(t *testing.T) {
+	m := NewMemory()
+	buckets := []Bucket{{Key: "a", Limit: Limit{RPS: 1000, Burst: 1}}}
+	if denied, _, _ := m.Allow(context.Background(), buckets); denied != -1 {
+		t.Fatalf("first call denied")
+	}
+	denied, retryAfter, _ := m.Allow(context.Background(), buckets)
+	if denied == 0 && retryAfter > time.Millisecond {
+		t.Errorf("retry after %v, want at most 1ms", retryAfter)
+	}
+	time.Sleep(2 * time.Millisecond)
+	if denied, _, _ := m.Allow(context.Background(), buckets); denied != -1 {
+		t.Errorf("call after refill denied")
+	}
+}
+
+// failingLimiter is a shared limiter that fails every call
+type failingLimiter struct {
+	calls  int
+	closed bool
+}
+
+func (l *failingLimiter) Allow(context.Context, []Bucket) (int, time.Duration, error) {
+	l.calls++
+	return -1, 0, errors.New("connection refused")
+}
+
+func (l *failingLimiter) Close() error {
+	l.closed = true
+	return nil
+}
+
+func TestFallback(t *testing.T) {
+	ctx := context.Background()
+	shared := &failingLimiter{}
+	f := NewFallback(shared, NewMemory(), time.Second, testCollector())
+	buckets := []Bucket{{Key: "a", Limit: Limit{RPS: 0.001, Burst: 1}}}
+
+	// The local buckets limit calls while the shared limiter fails
+	if denied, _, err := f.Allow(ctx, buckets); denied != -1 || err != nil {
+		t.Fatalf("first call = %d, %v, want allowed", denied, err)
+	}
+	if denied, _, err := f.Allow(ctx, buckets); denied != 0 || err != nil {
+		t.Fatalf("second call = %d, %v, want denied", denied, err)
+	}
+	if shared.calls != 1 {
+		t.Errorf("shared limiter called %d times within the retry interval, want 1", shared.calls)
+	}
+
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !shared.closed {
+		t.Error("shared limiter not closed")
+	}
+}
@@ -0,0 +1,94 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// sweepInterval is how often full buckets are dropped from memory
+const sweepInterval = time.Minute
+
+// Memory keeps the token buckets in process
+type Memory struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	swept   time.Time
+}
+
+// bucket is the tokens left in a bucket when it was last refilled
+type bucket struct {
+	tokens   float64
+	refilled time.Time
+	limit    Limit
+}
+
+// NewMemory creates an in-process limiter without buckets
+func NewMemory() *Memory {
+	return &Memory{
+		buckets: make(map[string]*bucket),
+		swept:   time.Now(),
+	}
+}
+
+// Allow implements Limiter, it never fails
+func (m *Memory) Allow(ctx context.Context, buckets []Bucket) (int, time.Duration, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	m.sweep(now)
+
+	// Check every bucket before taking from any of them
+	taken := make([]*bucket, 0, len(buckets))
+	for i, want := range buckets {
+		if want.Limit.Unlimited() {
+			continue
+		}
+		b, ok := m.buckets[want.Key]
+		if !ok {
+			b = &bucket{tokens: float64(want.Limit.Burst), refilled: now}
+			m.buckets[want.Key] = b
+		}
+		b.refill(now, want.Limit)
+
+		if b.tokens < 1 {
+			return i, time.Duration((1 - b.tokens) / want.Limit.RPS * float64(time.Second)), nil
+		}
+		taken = append(taken, b)
+	}
+	for _, b := range taken {
+		b.tokens--
+	}
+	return -1, 0, nil
+}
+
+// Close implements Limiter, there is nothing to release
+func (m *Memory) Close() error {
+	return nil
+}
+
+// refill adds the tokens accrued since the last refill, a reload may have
+// lowered the burst
+func (b *bucket) refill(now time.Time, limit Limit) {
+	b.tokens += now.Sub(b.refilled).Seconds() * limit.RPS
+	if burst := float64(limit.Burst); b.tokens > burst {
+		b.tokens = burst
+	}
+	b.refilled = now
+	b.limit = limit
+}
+
+// sweep drops the buckets that refilled completely, they are recreated full
+// on the next call anyway. Without it every client IP ever seen would be kept.
+func (m *Memory) sweep(now time.Time) {
+	if now.Sub(m.swept) < sweepInterval {
+		return
+	}
+	m.swept = now
+	for key, b := range m.buckets {
+		if b.tokens+now.Sub(b.refilled).Seconds()*b.limit.RPS >= float64(b.limit.Burst) {
+			delete(m.buckets, key)
+		}
+	}
+}
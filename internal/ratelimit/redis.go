@@ -0,0 +1,88 @@
+package ratelimit
+
+import (
+	"context"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// redisKeyPrefix namespaces the buckets in a shared redis
+const redisKeyPrefix = "rpc_proxy:rl:"
+
+// tokenBucketScript refills the buckets of KEYS, with the rate and burst of
+// each as pairs in ARGV, and takes a token from each of them atomically. The
+// redis clock is used so that replicas with skewed clocks share the same
+// buckets. It returns -1 if the tokens were taken, otherwise nothing is taken
+// and it returns the zero-based index of the first empty bucket and the
+// milliseconds until it has a token again. Buckets expire once they would be
+// full again.
+var tokenBucketScript = redis.NewScript(`
+local time = redis.call('TIME')
+local now = tonumber(time[1]) + tonumber(time[2]) / 1000000
+
+local tokens = {}
+for i, key in ipairs(KEYS) do
+	local rps = tonumber(ARGV[2 * i - 1])
+	local burst = tonumber(ARGV[2 * i])
+	local state = redis.call('HMGET', key, 'tokens', 'refilled')
+	local left = tonumber(state[1]) or burst
+	local refilled = tonumber(state[2]) or now
+	left = math.min(burst, left + math.max(0, now - refilled) * rps)
+	if left < 1 then
+		return {i - 1, math.ceil((1 - left) / rps * 1000)}
+	end
+	tokens[i] = left
+end
+
+for i, key in ipairs(KEYS) do
+	local rps = tonumber(ARGV[2 * i - 1])
+	local burst = tonumber(ARGV[2 * i])
+	redis.call('HSET', key, 'tokens', tostring(tokens[i] - 1), 'refilled', tostring(now))
+	redis.call('PEXPIRE', key, math.ceil(burst / rps * 1000) + 1000)
+end
+return {-1, 0}
+`)
+
+// Redis keeps the token buckets in redis, shared by all proxy replicas
+type Redis struct {
+	client *redis.Client
+}
+
+// NewRedis creates a limiter for a redis:// or rediss:// URL. No connection
+// is made until the first call.
+func NewRedis(rawURL string) (*Redis, error) {
+	opts, err := redis.ParseURL(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	return &Redis{client: redis.NewClient(opts)}, nil
+}
+
+// Allow implements Limiter
+func (r *Redis) Allow(ctx context.Context, buckets []Bucket) (int, time.Duration, error) {
+	indexes := limited(buckets)
+	if len(indexes) == 0 {
+		return -1, 0, nil
+	}
+
+	keys := make([]string, 0, len(indexes))
+	args := make([]interface{}, 0, 2*len(indexes))
+	for _, i := range indexes {
+		keys = append(keys, redisKeyPrefix+buckets[i].Key)
+		args = append(args, buckets[i].Limit.RPS, buckets[i].Limit.Burst)
+	}
+	result, err := tokenBucketScript.Run(ctx, r.client, keys, args...).Int64Slice()
+	if err != nil {
+		return -1, 0, err
+	}
+	if result[0] < 0 {
+		return -1, 0, nil
+	}
+	return indexes[result[0]], time.Duration(result[1]) * time.Millisecond, nil
+}
+
+// Close implements Limiter, it closes the connections to redis
+func (r *Redis) Close() error {
+	return r.client.Close()
+}
@@ -0,0 +1,44 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+)
+
+// TestRedis runs the token bucket cases against the Lua script. It needs a
+// redis server, given by RATE_LIMIT_TEST_REDIS_URL.
+func TestRedis(t *testing.T) {
+	rawURL := os.Getenv("RATE_LIMIT_TEST_REDIS_URL")
+	if rawURL == "" {
+		t.Skip("RATE_LIMIT_TEST_REDIS_URL not set")
+	}
+	r, err := NewRedis(rawURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	testLimiter(t, r, fmt.Sprintf("test:%d:", time.Now().UnixNano()))
+}
+
+func TestRedisUnavailable(t *testing.T) {
+	// Nothing listens on the discard port
+	r, err := NewRedis("redis://127.0.0.1:9/0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, _, err := r.Allow(ctx, []Bucket{{Key: "a", Limit: slow}}); err == nil {
+		t.Error("Allow succeeded without redis")
+	}
+	// Unlimited buckets need no redis
+	if denied, _, err := r.Allow(ctx, []Bucket{{Key: "a"}}); denied != -1 || err != nil {
+		t.Errorf("unlimited call = %d, %v, want allowed", denied, err)
+	}
+}
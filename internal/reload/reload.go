@@ -0,0 +1,156 @@
+package reload
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/ddomeke/rpc_proxy/internal/config"
+	"github.com/ddomeke/rpc_proxy/internal/logging"
+	"github.com/ddomeke/rpc_proxy/internal/metrics"
+)
+
+// Settings that are only read at startup. Changes to them are reported but
+// not applied until the process is restarted.
+var restartOnly = []string{
+	"proxy_port",
+	"proxy_ws_port",
+	"metrics_port",
+	"shutdown_timeout",
+	"store.",
+	"log.format",
+	"log.file",
+	"metrics.",
+}
+
+// Reloadable is a component that can switch to a new configuration at runtime
+type Reloadable interface {
+	// PrepareReload checks that the configuration can be applied and returns
+	// the function that applies it. It must not change any state itself.
+	PrepareReload(cfg *config.Config) (func(), error)
+}
+
+// Result describes the outcome of a successful reload
+type Result struct {
+	Applied         []config.Change `json:"applied"`
+	RestartRequired []config.Change `json:"restartRequired"`
+}
+
+// Reloader reloads the configuration and applies it to all components. Either
+// every component switches to the new configuration or none does.
+type Reloader struct {
+	load       func() (*config.Config, error)
+	components []Reloadable
+	collector  *metrics.Collector
+
+	mu      sync.Mutex
+	current *config.Config
+}
+
+// NewReloader creates a reloader that builds new configurations with load
+func NewReloader(current *config.Config, load func() (*config.Config, error), collector *metrics.Collector, components ...Reloadable) *Reloader {
+	return &Reloader{
+		load:       load,
+		components: components,
+		collector:  collector,
+		current:    current,
+	}
+}
+
+// Reload loads the configuration again and applies the changes
+func (r *Reloader) Reload() (*Result, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	result, err := r.reload()
+	if err != nil {
+		r.collector.ConfigReloads.WithLabelValues("failure").Inc()
+		slog.Error("Configuration reload failed, keeping the current configuration", "error", err)
+		return nil, err
+	}
+
+	r.collector.ConfigReloads.WithLabelValues("success").Inc()
+	r.collector.ConfigLastReloadSuccess.SetToCurrentTime()
+	slog.Info("Configuration reloaded", "changes", len(result.Applied), "restart_required", len(result.RestartRequired))
+	return result, nil
+}
+
+// reload loads, checks and applies a new configuration. The caller must hold the lock.
+func (r *Reloader) reload() (*Result, error) {
+	cfg, err := r.load()
+	if err != nil {
+		return nil, err
+	}
+
+	result := &Result{}
+	for _, change := range config.Diff(r.current, cfg) {
+		if isRestartOnly(change.Key) {
+			result.RestartRequired = append(result.RestartRequired, change)
+		} else {
+			result.Applied = append(result.Applied, change)
+		}
+	}
+
+	// Keep the running values of startup-only settings so the configuration
+	// handed to the components matches what is actually in effect
+	cfg.ProxyPort, cfg.ProxyWsPort, cfg.MetricsPort = r.current.ProxyPort, r.current.ProxyWsPort, r.current.MetricsPort
+	cfg.Store = r.current.Store
+	cfg.Log.Format, cfg.Log.File = r.current.Log.Format, r.current.Log.File
+	cfg.Metrics = r.current.Metrics
+
+	for _, change := range result.RestartRequired {
+		slog.Warn("Configuration change requires a restart", "key", change.Key, "running", change.Old, "configured", change.New)
+	}
+	if len(result.Applied) == 0 {
+		return result, nil
+	}
+
+	// Prepare every component before applying any change
+	applies := make([]func(), 0, len(r.components))
+	for _, component := range r.components {
+		apply, err := component.PrepareReload(cfg)
+		if err != nil {
+			return nil, err
+		}
+		applies = append(applies, apply)
+	}
+	for _, apply := range applies {
+		apply()
+	}
+	logging.SetLevel(cfg.Log.Level)
+
+	for _, change := range result.Applied {
+		slog.Info("Configuration changed", "key", change.Key, "old", change.Old, "new", change.New)
+	}
+	r.current = cfg
+	return result, nil
+}
+
+// ServeHTTP reloads the configuration on POST and returns the changes
+func (r *Reloader) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	slog.InfoContext(req.Context(), "Configuration reload requested", "remote_addr", req.RemoteAddr)
+	result, err := r.Reload()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// isRestartOnly reports whether a setting is only read at startup
+func isRestartOnly(key string) bool {
+	for _, prefix := range restartOnly {
+		if key == prefix || (strings.HasSuffix(prefix, ".") && strings.HasPrefix(key, prefix)) {
+			return true
+		}
+	}
+	return false
+}
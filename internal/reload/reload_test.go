@@ -0,0 +1,129 @@
+package reload
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ddomeke/rpc_proxy/internal/config"
+	"github.com/ddomeke/rpc_proxy/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// component records the configurations applied to it and can refuse them
+type component struct {
+	refuse  bool
+	applied []*config.Config
+}
+
+func (c *component) PrepareReload(cfg *config.Config) (func(), error) {
+	if c.refuse {
+		return nil, errors.New("cannot apply")
+	}
+	return func() { c.applied = append(c.applied, cfg) }, nil
+}
+
+func TestReload(t *testing.T) {
+	collector := metrics.NewCollector(config.MetricsConfig{})
+	first, second := &component{}, &component{}
+	next := config.Default()
+	var loadErr error
+	r := NewReloader(config.Default(), func() (*config.Config, error) {
+		cfg := *next
+		return &cfg, loadErr
+	}, collector, first, second)
+
+	// Cases run in order, each one reloads once
+	tests := []struct {
+		name        string
+		change      func()
+		wantStatus  int
+		wantApplied int // Configurations applied to each component so far
+	}{
+		{name: "unchanged", change: func() {}, wantStatus: http.StatusOK},
+		{
+			// Ports are only read at startup
+			name:       "restart_only",
+			change:     func() { next.ProxyPort = "9999" },
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:        "applied",
+			change:      func() { next.Upstream.Strategy = "least-latency" },
+			wantStatus:  http.StatusOK,
+			wantApplied: 1,
+		},
+		{
+			// Either every component switches or none does
+			name: "refused",
+			change: func() {
+				next.Upstream.MaxRetries++
+				second.refuse = true
+			},
+			wantStatus:  http.StatusInternalServerError,
+			wantApplied: 1,
+		},
+		{
+			name: "invalid",
+			change: func() {
+				second.refuse = false
+				loadErr = errors.New("invalid configuration")
+			},
+			wantStatus:  http.StatusInternalServerError,
+			wantApplied: 1,
+		},
+		{
+			name:        "recovered",
+			change:      func() { loadErr = nil },
+			wantStatus:  http.StatusOK,
+			wantApplied: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.change()
+			successes := testutil.ToFloat64(collector.ConfigReloads.WithLabelValues("success"))
+			failures := testutil.ToFloat64(collector.ConfigReloads.WithLabelValues("failure"))
+
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/admin/reload", nil))
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			for i, c := range []*component{first, second} {
+				if len(c.applied) != tt.wantApplied {
+					t.Errorf("component %d applied %d configurations, want %d", i, len(c.applied), tt.wantApplied)
+				}
+			}
+			// Components keep running on the port they were started with
+			for _, c := range []*component{first, second} {
+				if n := len(c.applied); n > 0 && c.applied[n-1].ProxyPort != config.Default().ProxyPort {
+					t.Errorf("applied proxy port = %s, want the running %s", c.applied[n-1].ProxyPort, config.Default().ProxyPort)
+				}
+			}
+
+			wantSuccesses, wantFailures := successes, failures
+			if tt.wantStatus == http.StatusOK {
+				wantSuccesses++
+			} else {
+				wantFailures++
+			}
+			if got := testutil.ToFloat64(collector.ConfigReloads.WithLabelValues("success")); got != wantSuccesses {
+				t.Errorf("successful reloads = %v, want %v", got, wantSuccesses)
+			}
+			if got := testutil.ToFloat64(collector.ConfigReloads.WithLabelValues("failure")); got != wantFailures {
+				t.Errorf("failed reloads = %v, want %v", got, wantFailures)
+			}
+		})
+	}
+
+	t.Run("get", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/reload", nil))
+		if rec.Code != http.StatusMethodNotAllowed {
+			t.Errorf("status = %d, want %d", rec.Code, http.StatusMethodNotAllowed)
+		}
+	})
+}
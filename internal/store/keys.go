@@ -0,0 +1,40 @@
+package store
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrExists is returned when an API key name or key is already in the store
+var ErrExists = errors.New("already exists")
+
+// APIKey is a stored proxy API key. Only the SHA-256 hash of the key is kept.
+type APIKey struct {
+	Name              string    `json:"name"`
+	KeyHash           string    `json:"-"`
+	Routes            []string  `json:"routes"`
+	Methods           []string  `json:"methods"`
+	RPS               float64   `json:"rps"`
+	Burst             int       `json:"burst"`
+	DailyRequests     int64     `json:"dailyRequests"`
+	DailyComputeUnits int64     `json:"dailyComputeUnits"`
+	CreatedAt         time.Time `json:"createdAt"`
+}
+
+// KeyStore persists proxy API keys
+type KeyStore interface {
+	// APIKeys returns all stored keys by name
+	APIKeys() ([]*APIKey, error)
+
+	// SaveAPIKey adds a key. A name or key hash that is already used returns ErrExists.
+	SaveAPIKey(key *APIKey) error
+
+	// DeleteAPIKey removes the key with the given name
+	DeleteAPIKey(name string) error
+}
+
+// Store holds the deposits and the API keys
+type Store interface {
+	DepositStore
+	KeyStore
+}
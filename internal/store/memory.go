@@ -0,0 +1,275 @@
+package store
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// MemoryStore is a DepositStore that keeps deposits in memory only
+type MemoryStore struct {
+	mu       sync.RWMutex
+	deposits map[common.Hash]*Deposit // By source hash
+	byL2Tx   map[common.Hash]common.Hash
+
+	checkpoints map[string]uint64
+	apiKeys     map[string]*APIKey // By name
+}
+
+// NewMemoryStore creates an empty in-memory deposit store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		deposits: make(map[common.Hash]*Deposit),
+		byL2Tx:   make(map[common.Hash]common.Hash),
+
+		checkpoints: make(map[string]uint64),
+		apiKeys:     make(map[string]*APIKey),
+	}
+}
+
+// SaveDeposit implements DepositStore
+func (m *MemoryStore) SaveDeposit(deposit *Deposit) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	existing, exists := m.deposits[deposit.SourceHash]
+	if exists && existing.Status != StatusReorged {
+		return false, nil
+	}
+
+	stored := *deposit
+	now := time.Now()
+	switch {
+	case exists:
+		stored.ObservedAt = existing.ObservedAt
+	case stored.ObservedAt.IsZero():
+		stored.ObservedAt = now
+	}
+	stored.UpdatedAt = now
+
+	m.deposits[stored.SourceHash] = &stored
+	m.byL2Tx[stored.L2TxHash] = stored.SourceHash
+	return true, nil
+}
+
+// GetDeposit implements DepositStore
+func (m *MemoryStore) GetDeposit(sourceHash common.Hash) (*Deposit, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	deposit, ok := m.deposits[sourceHash]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	result := *deposit
+	return &result, nil
+}
+
+// GetDepositByL2TxHash implements DepositStore
+func (m *MemoryStore) GetDepositByL2TxHash(l2TxHash common.Hash) (*Deposit, error) {
+	m.mu.RLock()
+	sourceHash, ok := m.byL2Tx[l2TxHash]
+	m.mu.RUnlock()
+
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return m.GetDeposit(sourceHash)
+}
+
+// GetDepositsByL1TxHash implements DepositStore
+func (m *MemoryStore) GetDepositsByL1TxHash(l1TxHash common.Hash) ([]*Deposit, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var deposits []*Deposit
+	for _, deposit := range m.deposits {
+		if deposit.L1TxHash == l1TxHash {
+			result := *deposit
+			deposits = append(deposits, &result)
+		}
+	}
+	if len(deposits) == 0 {
+		return nil, ErrNotFound
+	}
+	sortByL1Position(deposits, true)
+	return deposits, nil
+}
+
+// PendingDeposits implements DepositStore
+func (m *MemoryStore) PendingDeposits() ([]*Deposit, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var pending []*Deposit
+	for _, deposit := range m.deposits {
+		if deposit.Status == StatusObserved {
+			result := *deposit
+			pending = append(pending, &result)
+		}
+	}
+	sort.Slice(pending, func(i, j int) bool {
+		return pending[i].ObservedAt.Before(pending[j].ObservedAt)
+	})
+	return pending, nil
+}
+
+// ConfirmDeposit implements DepositStore
+func (m *MemoryStore) ConfirmDeposit(l2TxHash common.Hash, l2BlockNumber uint64, l2Status string, latency time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	deposit, ok := m.deposits[m.byL2Tx[l2TxHash]]
+	if !ok || deposit.Status != StatusObserved {
+		return ErrNotFound
+	}
+	deposit.Status = statusFor(l2Status)
+	deposit.L2BlockNumber = l2BlockNumber
+	deposit.L2Status = l2Status
+	deposit.Latency = latency
+	deposit.UpdatedAt = time.Now()
+	return nil
+}
+
+// RevertDeposit implements DepositStore
+func (m *MemoryStore) RevertDeposit(sourceHash common.Hash) (*Deposit, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	deposit, ok := m.deposits[sourceHash]
+	if !ok || deposit.Status == StatusReorged {
+		return nil, ErrNotFound
+	}
+	previous := *deposit
+	deposit.Status = StatusReorged
+	deposit.UpdatedAt = time.Now()
+	return &previous, nil
+}
+
+// DepositsSinceL1Block implements DepositStore
+func (m *MemoryStore) DepositsSinceL1Block(blockNumber uint64) ([]*Deposit, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var deposits []*Deposit
+	for _, deposit := range m.deposits {
+		if deposit.BlockNum >= blockNumber && deposit.Status != StatusReorged {
+			result := *deposit
+			deposits = append(deposits, &result)
+		}
+	}
+	sortByL1Position(deposits, true)
+	return deposits, nil
+}
+
+// QueryDeposits implements DepositStore
+func (m *MemoryStore) QueryDeposits(filter DepositFilter) ([]*Deposit, int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var deposits []*Deposit
+	for _, deposit := range m.deposits {
+		if filter.matches(deposit) {
+			result := *deposit
+			deposits = append(deposits, &result)
+		}
+	}
+	sortByL1Position(deposits, filter.OldestFirst)
+
+	total := len(deposits)
+	if filter.Offset > total {
+		filter.Offset = total
+	}
+	deposits = deposits[filter.Offset:]
+	if filter.Limit > 0 && filter.Limit < len(deposits) {
+		deposits = deposits[:filter.Limit]
+	}
+	return deposits, total, nil
+}
+
+// GetCheckpoint implements DepositStore
+func (m *MemoryStore) GetCheckpoint(name string) (uint64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	blockNumber, ok := m.checkpoints[name]
+	if !ok {
+		return 0, ErrNotFound
+	}
+	return blockNumber, nil
+}
+
+// SetCheckpoint implements DepositStore
+func (m *MemoryStore) SetCheckpoint(name string, blockNumber uint64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.checkpoints[name] = blockNumber
+	return nil
+}
+
+// APIKeys implements KeyStore
+func (m *MemoryStore) APIKeys() ([]*APIKey, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	keys := make([]*APIKey, 0, len(m.apiKeys))
+	for _, key := range m.apiKeys {
+		result := *key
+		keys = append(keys, &result)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].Name < keys[j].Name
+	})
+	return keys, nil
+}
+
+// SaveAPIKey implements KeyStore
+func (m *MemoryStore) SaveAPIKey(key *APIKey) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, existing := range m.apiKeys {
+		if existing.Name == key.Name || existing.KeyHash == key.KeyHash {
+			return ErrExists
+		}
+	}
+	stored := *key
+	if stored.CreatedAt.IsZero() {
+		stored.CreatedAt = time.Now()
+	}
+	m.apiKeys[stored.Name] = &stored
+	return nil
+}
+
+// DeleteAPIKey implements KeyStore
+func (m *MemoryStore) DeleteAPIKey(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.apiKeys[name]; !ok {
+		return ErrNotFound
+	}
+	delete(m.apiKeys, name)
+	return nil
+}
+
+// Close implements DepositStore
+func (m *MemoryStore) Close() error {
+	return nil
+}
+
+// sortByL1Position sorts deposits by L1 block and log index
+func sortByL1Position(deposits []*Deposit, ascending bool) {
+	sort.Slice(deposits, func(i, j int) bool {
+		a, b := deposits[i], deposits[j]
+		if !ascending {
+			a, b = b, a
+		}
+		if a.BlockNum != b.BlockNum {
+			return a.BlockNum < b.BlockNum
+		}
+		return a.LogIndex < b.LogIndex
+	})
+}
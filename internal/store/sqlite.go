@@ -0,0 +1,439 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	_ "modernc.org/sqlite" // Pure-Go SQLite driver
+)
+
+// sqliteSchema creates the deposit and API key tables. Hashes and addresses
+// are stored as lower-case hex, amounts as decimal strings, lists as
+// comma-separated strings and times as unix milliseconds.
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS deposits (
+	source_hash     TEXT PRIMARY KEY,
+	l1_tx_hash      TEXT NOT NULL,
+	l1_block_hash   TEXT NOT NULL,
+	l1_block_number INTEGER NOT NULL,
+	l1_tx_index     INTEGER NOT NULL,
+	l1_log_index    INTEGER NOT NULL,
+	l1_timestamp    INTEGER NOT NULL,
+	sender          TEXT NOT NULL,
+	recipient       TEXT NOT NULL,
+	version         INTEGER NOT NULL,
+	mint            TEXT NOT NULL,
+	value           TEXT NOT NULL,
+	gas_limit       INTEGER NOT NULL,
+	is_creation     INTEGER NOT NULL,
+	data            BLOB,
+	l2_tx_hash      TEXT NOT NULL,
+	frozen          INTEGER NOT NULL,
+	status          TEXT NOT NULL,
+	l2_block_number INTEGER NOT NULL DEFAULT 0,
+	l2_status       TEXT NOT NULL DEFAULT '',
+	latency_ms      INTEGER NOT NULL DEFAULT 0,
+	observed_at     INTEGER NOT NULL,
+	updated_at      INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS deposits_l2_tx_hash ON deposits (l2_tx_hash);
+CREATE INDEX IF NOT EXISTS deposits_l1_tx_hash ON deposits (l1_tx_hash);
+CREATE INDEX IF NOT EXISTS deposits_status ON deposits (status, observed_at);
+CREATE INDEX IF NOT EXISTS deposits_l1_block ON deposits (l1_block_number, l1_log_index);
+CREATE INDEX IF NOT EXISTS deposits_sender ON deposits (sender);
+CREATE INDEX IF NOT EXISTS deposits_recipient ON deposits (recipient);
+CREATE TABLE IF NOT EXISTS checkpoints (
+	name         TEXT PRIMARY KEY,
+	block_number INTEGER NOT NULL,
+	updated_at   INTEGER NOT NULL
+);
+CREATE TABLE IF NOT EXISTS api_keys (
+	name                TEXT PRIMARY KEY,
+	key_hash            TEXT NOT NULL UNIQUE,
+	routes              TEXT NOT NULL,
+	methods             TEXT NOT NULL,
+	rps                 REAL NOT NULL,
+	burst               INTEGER NOT NULL,
+	daily_requests      INTEGER NOT NULL,
+	daily_compute_units INTEGER NOT NULL,
+	created_at          INTEGER NOT NULL
+);
+`
+
+// depositColumns is the column list used by every deposit query
+const depositColumns = `source_hash, l1_tx_hash, l1_block_hash, l1_block_number, l1_tx_index,
+	l1_log_index, l1_timestamp, sender, recipient, version, mint, value, gas_limit, is_creation,
+	data, l2_tx_hash, frozen, status, l2_block_number, l2_status, latency_ms, observed_at, updated_at`
+
+// SQLiteStore is a DepositStore backed by an embedded SQLite database
+type SQLiteStore struct {
+	db *sql.DB
+}
+
+// NewSQLiteStore opens (or creates) the SQLite database at the given path
+func NewSQLiteStore(path string) (*SQLiteStore, error) {
+	dsn := fmt.Sprintf("file:%s?_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)", url.PathEscape(path))
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("could not open deposit database: %v", err)
+	}
+	// SQLite allows a single writer, serialize access in the pool
+	db.SetMaxOpenConns(1)
+
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("could not create deposit schema: %v", err)
+	}
+	return &SQLiteStore{db: db}, nil
+}
+
+// SaveDeposit implements DepositStore
+func (s *SQLiteStore) SaveDeposit(deposit *Deposit) (bool, error) {
+	now := time.Now()
+	observedAt := deposit.ObservedAt
+	if observedAt.IsZero() {
+		observedAt = now
+	}
+
+	// A reorged deposit takes the block hash, verdict and status of the
+	// canonical log again, everything else is derived from the source hash
+	res, err := s.db.Exec(`INSERT INTO deposits (`+depositColumns+`)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (source_hash) DO UPDATE SET l1_block_hash = excluded.l1_block_hash,
+			l1_timestamp = excluded.l1_timestamp, frozen = excluded.frozen, status = excluded.status,
+			l2_block_number = excluded.l2_block_number, l2_status = excluded.l2_status,
+			latency_ms = excluded.latency_ms, updated_at = excluded.updated_at
+		WHERE deposits.status = '`+string(StatusReorged)+`'`,
+		hexString(deposit.SourceHash), hexString(deposit.L1TxHash), hexString(deposit.L1BlockHash),
+		deposit.BlockNum, deposit.TxIndex, deposit.LogIndex, deposit.Timestamp.UnixMilli(),
+		hexString(deposit.From), hexString(deposit.To), deposit.Version,
+		bigString(deposit.Mint), bigString(deposit.Value), deposit.GasLimit, deposit.IsCreation,
+		deposit.Data, hexString(deposit.L2TxHash), deposit.Frozen, string(deposit.Status),
+		deposit.L2BlockNumber, deposit.L2Status, deposit.Latency.Milliseconds(),
+		observedAt.UnixMilli(), now.UnixMilli())
+	if err != nil {
+		return false, fmt.Errorf("could not save deposit: %v", err)
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rows > 0, nil
+}
+
+// GetDeposit implements DepositStore
+func (s *SQLiteStore) GetDeposit(sourceHash common.Hash) (*Deposit, error) {
+	row := s.db.QueryRow(`SELECT `+depositColumns+` FROM deposits WHERE source_hash = ?`, hexString(sourceHash))
+	return scanDeposit(row)
+}
+
+// GetDepositByL2TxHash implements DepositStore
+func (s *SQLiteStore) GetDepositByL2TxHash(l2TxHash common.Hash) (*Deposit, error) {
+	row := s.db.QueryRow(`SELECT `+depositColumns+` FROM deposits WHERE l2_tx_hash = ?`, hexString(l2TxHash))
+	return scanDeposit(row)
+}
+
+// GetDepositsByL1TxHash implements DepositStore
+func (s *SQLiteStore) GetDepositsByL1TxHash(l1TxHash common.Hash) ([]*Deposit, error) {
+	deposits, err := s.queryDeposits(`SELECT `+depositColumns+` FROM deposits WHERE l1_tx_hash = ?
+		ORDER BY l1_log_index`, hexString(l1TxHash))
+	if err == nil && len(deposits) == 0 {
+		return nil, ErrNotFound
+	}
+	return deposits, err
+}
+
+// PendingDeposits implements DepositStore
+func (s *SQLiteStore) PendingDeposits() ([]*Deposit, error) {
+	return s.queryDeposits(`SELECT `+depositColumns+` FROM deposits WHERE status = ? ORDER BY observed_at`,
+		string(StatusObserved))
+}
+
+// ConfirmDeposit implements DepositStore
+func (s *SQLiteStore) ConfirmDeposit(l2TxHash common.Hash, l2BlockNumber uint64, l2Status string, latency time.Duration) error {
+	res, err := s.db.Exec(`UPDATE deposits SET status = ?, l2_block_number = ?, l2_status = ?, latency_ms = ?, updated_at = ?
+		WHERE l2_tx_hash = ? AND status = ?`,
+		string(statusFor(l2Status)), l2BlockNumber, l2Status, latency.Milliseconds(), time.Now().UnixMilli(),
+		hexString(l2TxHash), string(StatusObserved))
+	if err != nil {
+		return fmt.Errorf("could not confirm deposit: %v", err)
+	}
+	if rows, _ := res.RowsAffected(); rows == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// RevertDeposit implements DepositStore
+func (s *SQLiteStore) RevertDeposit(sourceHash common.Hash) (*Deposit, error) {
+	previous, err := s.GetDeposit(sourceHash)
+	if err != nil {
+		return nil, err
+	}
+	if previous.Status == StatusReorged {
+		return nil, ErrNotFound
+	}
+
+	_, err = s.db.Exec(`UPDATE deposits SET status = ?, updated_at = ? WHERE source_hash = ?`,
+		string(StatusReorged), time.Now().UnixMilli(), hexString(sourceHash))
+	if err != nil {
+		return nil, fmt.Errorf("could not revert deposit: %v", err)
+	}
+	return previous, nil
+}
+
+// DepositsSinceL1Block implements DepositStore
+func (s *SQLiteStore) DepositsSinceL1Block(blockNumber uint64) ([]*Deposit, error) {
+	return s.queryDeposits(`SELECT `+depositColumns+` FROM deposits
+		WHERE l1_block_number >= ? AND status != ? ORDER BY l1_block_number, l1_log_index`,
+		blockNumber, string(StatusReorged))
+}
+
+// QueryDeposits implements DepositStore
+func (s *SQLiteStore) QueryDeposits(filter DepositFilter) ([]*Deposit, int, error) {
+	var (
+		conditions []string
+		args       []interface{}
+	)
+	if len(filter.Statuses) > 0 {
+		placeholders := make([]string, len(filter.Statuses))
+		for i, status := range filter.Statuses {
+			placeholders[i] = "?"
+			args = append(args, string(status))
+		}
+		conditions = append(conditions, "status IN ("+strings.Join(placeholders, ", ")+")")
+	}
+	if filter.From != nil {
+		conditions = append(conditions, "sender = ?")
+		args = append(args, hexString(filter.From))
+	}
+	if filter.To != nil {
+		conditions = append(conditions, "recipient = ?")
+		args = append(args, hexString(filter.To))
+	}
+	// Amounts are decimal strings without leading zeros, so a longer string is
+	// the larger amount and strings of equal length compare like numbers
+	if filter.MinValue != nil {
+		minValue := bigString(filter.MinValue)
+		conditions = append(conditions, "(length(value) > ? OR (length(value) = ? AND value >= ?))")
+		args = append(args, len(minValue), len(minValue), minValue)
+	}
+	if filter.MaxValue != nil {
+		maxValue := bigString(filter.MaxValue)
+		conditions = append(conditions, "(length(value) < ? OR (length(value) = ? AND value <= ?))")
+		args = append(args, len(maxValue), len(maxValue), maxValue)
+	}
+	if !filter.L1After.IsZero() {
+		conditions = append(conditions, "l1_timestamp >= ?")
+		args = append(args, filter.L1After.UnixMilli())
+	}
+	if !filter.L1Before.IsZero() {
+		conditions = append(conditions, "l1_timestamp < ?")
+		args = append(args, filter.L1Before.UnixMilli())
+	}
+
+	where := ""
+	if len(conditions) > 0 {
+		where = " WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	var total int
+	if err := s.db.QueryRow(`SELECT COUNT(*) FROM deposits`+where, args...).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("could not count deposits: %v", err)
+	}
+
+	order := " ORDER BY l1_block_number DESC, l1_log_index DESC"
+	if filter.OldestFirst {
+		order = " ORDER BY l1_block_number, l1_log_index"
+	}
+	// SQLite reads a negative limit as no limit
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = -1
+	}
+	deposits, err := s.queryDeposits(`SELECT `+depositColumns+` FROM deposits`+where+order+` LIMIT ? OFFSET ?`,
+		append(args, limit, filter.Offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	return deposits, total, nil
+}
+
+// GetCheckpoint implements DepositStore
+func (s *SQLiteStore) GetCheckpoint(name string) (uint64, error) {
+	var blockNumber uint64
+	err := s.db.QueryRow(`SELECT block_number FROM checkpoints WHERE name = ?`, name).Scan(&blockNumber)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, ErrNotFound
+	}
+	if err != nil {
+		return 0, fmt.Errorf("could not read checkpoint %s: %v", name, err)
+	}
+	return blockNumber, nil
+}
+
+// SetCheckpoint implements DepositStore
+func (s *SQLiteStore) SetCheckpoint(name string, blockNumber uint64) error {
+	_, err := s.db.Exec(`INSERT INTO checkpoints (name, block_number, updated_at) VALUES (?, ?, ?)
+		ON CONFLICT (name) DO UPDATE SET block_number = excluded.block_number, updated_at = excluded.updated_at`,
+		name, blockNumber, time.Now().UnixMilli())
+	if err != nil {
+		return fmt.Errorf("could not write checkpoint %s: %v", name, err)
+	}
+	return nil
+}
+
+// APIKeys implements KeyStore
+func (s *SQLiteStore) APIKeys() ([]*APIKey, error) {
+	rows, err := s.db.Query(`SELECT name, key_hash, routes, methods, rps, burst, daily_requests, daily_compute_units, created_at
+		FROM api_keys ORDER BY name`)
+	if err != nil {
+		return nil, fmt.Errorf("could not query API keys: %v", err)
+	}
+	defer rows.Close()
+
+	var keys []*APIKey
+	for rows.Next() {
+		var (
+			key             APIKey
+			routes, methods string
+			createdAt       int64
+		)
+		err := rows.Scan(&key.Name, &key.KeyHash, &routes, &methods, &key.RPS, &key.Burst,
+			&key.DailyRequests, &key.DailyComputeUnits, &createdAt)
+		if err != nil {
+			return nil, fmt.Errorf("could not read API key: %v", err)
+		}
+		key.Routes, key.Methods = splitList(routes), splitList(methods)
+		key.CreatedAt = time.UnixMilli(createdAt)
+		keys = append(keys, &key)
+	}
+	return keys, rows.Err()
+}
+
+// SaveAPIKey implements KeyStore
+func (s *SQLiteStore) SaveAPIKey(key *APIKey) error {
+	createdAt := key.CreatedAt
+	if createdAt.IsZero() {
+		createdAt = time.Now()
+	}
+	res, err := s.db.Exec(`INSERT OR IGNORE INTO api_keys (name, key_hash, routes, methods, rps, burst, daily_requests,
+		daily_compute_units, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		key.Name, key.KeyHash, strings.Join(key.Routes, ","), strings.Join(key.Methods, ","), key.RPS, key.Burst,
+		key.DailyRequests, key.DailyComputeUnits, createdAt.UnixMilli())
+	if err != nil {
+		return fmt.Errorf("could not save API key: %v", err)
+	}
+	if rows, _ := res.RowsAffected(); rows == 0 {
+		return ErrExists
+	}
+	return nil
+}
+
+// DeleteAPIKey implements KeyStore
+func (s *SQLiteStore) DeleteAPIKey(name string) error {
+	res, err := s.db.Exec(`DELETE FROM api_keys WHERE name = ?`, name)
+	if err != nil {
+		return fmt.Errorf("could not delete API key: %v", err)
+	}
+	if rows, _ := res.RowsAffected(); rows == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// queryDeposits runs a query selecting depositColumns and reads all rows
+func (s *SQLiteStore) queryDeposits(query string, args ...interface{}) ([]*Deposit, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("could not query deposits: %v", err)
+	}
+	defer rows.Close()
+
+	var deposits []*Deposit
+	for rows.Next() {
+		deposit, err := scanDeposit(rows)
+		if err != nil {
+			return nil, err
+		}
+		deposits = append(deposits, deposit)
+	}
+	return deposits, rows.Err()
+}
+
+// Close implements DepositStore. The write-ahead log is checkpointed first so
+// that the database file is complete on its own.
+func (s *SQLiteStore) Close() error {
+	if _, err := s.db.Exec(`PRAGMA wal_checkpoint(TRUNCATE)`); err != nil {
+		s.db.Close()
+		return fmt.Errorf("could not checkpoint write-ahead log: %v", err)
+	}
+	return s.db.Close()
+}
+
+// rowScanner is implemented by *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanDeposit reads a deposit selected with depositColumns
+func scanDeposit(row rowScanner) (*Deposit, error) {
+	var (
+		d                                             Deposit
+		sourceHash, l1TxHash, l1BlockHash, l2TxHash   string
+		sender, recipient, mint, value, status        string
+		l1Timestamp, latencyMs, observedAt, updatedAt int64
+	)
+	err := row.Scan(&sourceHash, &l1TxHash, &l1BlockHash, &d.BlockNum, &d.TxIndex,
+		&d.LogIndex, &l1Timestamp, &sender, &recipient, &d.Version, &mint, &value, &d.GasLimit, &d.IsCreation,
+		&d.Data, &l2TxHash, &d.Frozen, &status, &d.L2BlockNumber, &d.L2Status, &latencyMs, &observedAt, &updatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read deposit: %v", err)
+	}
+
+	d.SourceHash = common.HexToHash(sourceHash)
+	d.L1TxHash = common.HexToHash(l1TxHash)
+	d.L1BlockHash = common.HexToHash(l1BlockHash)
+	d.L2TxHash = common.HexToHash(l2TxHash)
+	d.From = common.HexToAddress(sender)
+	d.To = common.HexToAddress(recipient)
+	d.Mint, _ = new(big.Int).SetString(mint, 10)
+	d.Value, _ = new(big.Int).SetString(value, 10)
+	d.Status = DepositStatus(status)
+	d.Timestamp = time.UnixMilli(l1Timestamp)
+	d.Latency = time.Duration(latencyMs) * time.Millisecond
+	d.ObservedAt = time.UnixMilli(observedAt)
+	d.UpdatedAt = time.UnixMilli(updatedAt)
+	return &d, nil
+}
+
+// hexString returns the lower-case hex form of a hash or address
+func hexString(v interface{ Hex() string }) string {
+	return strings.ToLower(v.Hex())
+}
+
+// splitList splits a comma-separated list, an empty string is an empty list
+func splitList(list string) []string {
+	if list == "" {
+		return nil
+	}
+	return strings.Split(list, ",")
+}
+
+// bigString returns the decimal form of an amount, treating nil as zero
+func bigString(v *big.Int) string {
+	if v == nil {
+		return "0"
+	}
+	return v.String()
+}
@@ -0,0 +1,147 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/ddomeke/rpc_proxy/internal/config"
+	"github.com/ddomeke/rpc_proxy/internal/eth"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// ErrNotFound is returned when a deposit or checkpoint is not in the store
+var ErrNotFound = errors.New("not found")
+
+// DepositStatus is the lifecycle state of a deposit
+type DepositStatus string
+
+const (
+	StatusObserved  DepositStatus = "observed"  // Seen on L1, waiting for L2 inclusion
+	StatusBlocked   DepositStatus = "blocked"   // Sent by a frozen account, filtered by the proxy
+	StatusConfirmed DepositStatus = "confirmed" // Included on L2
+	StatusFailed    DepositStatus = "failed"    // Included on L2, but the deposit call reverted
+	StatusReorged   DepositStatus = "reorged"   // The L1 block was reorged out
+)
+
+// Deposit is a stored deposit with its frozen verdict and L2 outcome
+type Deposit struct {
+	eth.DepositEvent
+
+	Frozen        bool
+	Status        DepositStatus
+	L2BlockNumber uint64
+	L2Status      string        // L2 receipt status: success, failed or unknown
+	Latency       time.Duration // Time between the L1 block and L2 inclusion
+	ObservedAt    time.Time
+	UpdatedAt     time.Time
+}
+
+// DepositFilter selects deposits in QueryDeposits. Zero fields match every deposit.
+type DepositFilter struct {
+	Statuses []DepositStatus // Any of these statuses
+	From     *common.Address // L1 sender
+	To       *common.Address // L2 recipient
+	MinValue *big.Int        // Minimum ETH value in wei
+	MaxValue *big.Int        // Maximum ETH value in wei
+	L1After  time.Time       // L1 block at or after this time
+	L1Before time.Time       // L1 block before this time
+
+	OldestFirst bool // Sort by L1 position ascending instead of newest first
+	Limit       int  // Maximum number of deposits returned, 0 for all
+	Offset      int  // Number of matching deposits skipped
+}
+
+// matches reports whether a deposit passes the filter
+func (f *DepositFilter) matches(d *Deposit) bool {
+	if len(f.Statuses) > 0 {
+		found := false
+		for _, status := range f.Statuses {
+			found = found || d.Status == status
+		}
+		if !found {
+			return false
+		}
+	}
+	value := d.Value
+	if value == nil {
+		value = new(big.Int)
+	}
+	switch {
+	case f.From != nil && d.From != *f.From,
+		f.To != nil && d.To != *f.To,
+		f.MinValue != nil && value.Cmp(f.MinValue) < 0,
+		f.MaxValue != nil && value.Cmp(f.MaxValue) > 0,
+		!f.L1After.IsZero() && d.Timestamp.Before(f.L1After),
+		!f.L1Before.IsZero() && !d.Timestamp.Before(f.L1Before):
+		return false
+	}
+	return true
+}
+
+// DepositStore persists observed deposits
+type DepositStore interface {
+	// SaveDeposit records a deposit unless its source hash is already known.
+	// A deposit that was reorged out is recorded again, its log is canonical
+	// again. It reports whether the deposit was new or restored.
+	SaveDeposit(deposit *Deposit) (bool, error)
+
+	// GetDeposit returns the deposit with the given source hash
+	GetDeposit(sourceHash common.Hash) (*Deposit, error)
+
+	// GetDepositByL2TxHash returns the deposit with the given L2 transaction hash
+	GetDepositByL2TxHash(l2TxHash common.Hash) (*Deposit, error)
+
+	// GetDepositsByL1TxHash returns the deposits emitted by an L1 transaction
+	// in log order. A transaction without deposits returns ErrNotFound.
+	GetDepositsByL1TxHash(l1TxHash common.Hash) ([]*Deposit, error)
+
+	// PendingDeposits returns all deposits waiting for L2 inclusion, oldest first
+	PendingDeposits() ([]*Deposit, error)
+
+	// QueryDeposits returns one page of the deposits matching the filter,
+	// ordered by L1 block and log index, and the total number of matches
+	QueryDeposits(filter DepositFilter) ([]*Deposit, int, error)
+
+	// ConfirmDeposit records the L2 inclusion of a deposit waiting for it.
+	// Deposits that are not waiting for L2 inclusion return ErrNotFound.
+	ConfirmDeposit(l2TxHash common.Hash, l2BlockNumber uint64, l2Status string, latency time.Duration) error
+
+	// RevertDeposit marks a deposit as reorged out of L1 and returns it as it
+	// was before. Unknown or already reorged deposits return ErrNotFound.
+	RevertDeposit(sourceHash common.Hash) (*Deposit, error)
+
+	// DepositsSinceL1Block returns the deposits that are not reorged and were
+	// emitted in the given L1 block or later
+	DepositsSinceL1Block(blockNumber uint64) ([]*Deposit, error)
+
+	// GetCheckpoint returns the last fully processed block of a named cursor
+	GetCheckpoint(name string) (uint64, error)
+
+	// SetCheckpoint records the last fully processed block of a named cursor
+	SetCheckpoint(name string, blockNumber uint64) error
+
+	// Close releases the resources of the store
+	Close() error
+}
+
+// Open opens the store selected in the configuration
+func Open(cfg config.StoreConfig) (Store, error) {
+	switch cfg.Backend {
+	case "memory":
+		return NewMemoryStore(), nil
+	case "sqlite":
+		return NewSQLiteStore(cfg.Path)
+	default:
+		return nil, fmt.Errorf("unknown deposit store backend %q", cfg.Backend)
+	}
+}
+
+// statusFor maps an L2 receipt status to the deposit lifecycle state
+func statusFor(l2Status string) DepositStatus {
+	if l2Status == "failed" {
+		return StatusFailed
+	}
+	return StatusConfirmed
+}
@@ -0,0 +1,129 @@
+package store
+
+import (
+	"math/big"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ddomeke/rpc_proxy/internal/eth"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// backends opens an empty store of every backend
+func backends(t *testing.T) map[string]DepositStore {
+	t.Helper()
+	sqlite, err := NewSQLiteStore(filepath.Join(t.TempDir(), "deposits.db"))
+	if err != nil {
+		t.Fatalf("NewSQLiteStore: %v", err)
+	}
+	t.Cleanup(func() { sqlite.Close() })
+	return map[string]DepositStore{
+		"memory": NewMemoryStore(),
+		"sqlite": sqlite,
+	}
+}
+
+// testDeposit builds an observed deposit emitted in the given L1 block
+func testDeposit(blockNum uint64, logIndex uint) *Deposit {
+	blockHash := common.BigToHash(new(big.Int).SetUint64(blockNum))
+	sourceHash := eth.DepositSourceHash(blockHash, logIndex)
+	return &Deposit{
+		DepositEvent: eth.DepositEvent{
+			SourceHash:  sourceHash,
+			L1TxHash:    common.BigToHash(big.NewInt(int64(blockNum*1000) + int64(logIndex))),
+			L1BlockHash: blockHash,
+			BlockNum:    blockNum,
+			LogIndex:    logIndex,
+			Timestamp:   time.Unix(1700000000+int64(blockNum)*12, 0),
+			From:        common.HexToAddress("0x00000000000000000000000000000000000000a1"),
+			To:          common.HexToAddress("0x00000000000000000000000000000000000000b2"),
+			Mint:        big.NewInt(1e15),
+			Value:       big.NewInt(1e15),
+			GasLimit:    100000,
+			L2TxHash:    common.BytesToHash(append([]byte{0x7e}, sourceHash[1:]...)),
+		},
+		Status: StatusObserved,
+	}
+}
+
+func TestSaveDeposit(t *testing.T) {
+	tests := []struct {
+		name    string
+		prepare func(s DepositStore, d *Deposit)
+		save    DepositStatus
+		created bool
+		want    DepositStatus
+	}{
+		{
+			name: "new",
+			save: StatusObserved, created: true, want: StatusObserved,
+		},
+		{
+			name:    "duplicate",
+			prepare: func(s DepositStore, d *Deposit) { s.SaveDeposit(d) },
+			save:    StatusObserved, created: false, want: StatusObserved,
+		},
+		{
+			name: "confirmed_is_kept",
+			prepare: func(s DepositStore, d *Deposit) {
+				s.SaveDeposit(d)
+				s.ConfirmDeposit(d.L2TxHash, 10, "success", time.Minute)
+			},
+			save: StatusObserved, created: false, want: StatusConfirmed,
+		},
+		{
+			name: "reorged_is_restored",
+			prepare: func(s DepositStore, d *Deposit) {
+				s.SaveDeposit(d)
+				s.ConfirmDeposit(d.L2TxHash, 10, "success", time.Minute)
+				s.RevertDeposit(d.SourceHash)
+			},
+			save: StatusObserved, created: true, want: StatusObserved,
+		},
+		{
+			name: "reorged_is_restored_blocked",
+			prepare: func(s DepositStore, d *Deposit) {
+				s.SaveDeposit(d)
+				s.RevertDeposit(d.SourceHash)
+			},
+			save: StatusBlocked, created: true, want: StatusBlocked,
+		},
+	}
+
+	for _, tt := range tests {
+		for backend, s := range backends(t) {
+			t.Run(tt.name+"/"+backend, func(t *testing.T) {
+				d := testDeposit(100, 3)
+				if tt.prepare != nil {
+					tt.prepare(s, d)
+				}
+
+				again := *d
+				again.Status = tt.save
+				again.Frozen = tt.save == StatusBlocked
+				created, err := s.SaveDeposit(&again)
+				if err != nil {
+					t.Fatalf("SaveDeposit: %v", err)
+				}
+				if created != tt.created {
+					t.Errorf("SaveDeposit created = %v, want %v", created, tt.created)
+				}
+
+				got, err := s.GetDeposit(d.SourceHash)
+				if err != nil {
+					t.Fatalf("GetDeposit: %v", err)
+				}
+				if got.Status != tt.want {
+					t.Errorf("status = %s, want %s", got.Status, tt.want)
+				}
+				if tt.want == StatusObserved && (got.L2BlockNumber != 0 || got.L2Status != "") {
+					t.Errorf("restored deposit kept L2 outcome %d/%q", got.L2BlockNumber, got.L2Status)
+				}
+				if got.L1BlockHash != d.L1BlockHash {
+					t.Errorf("L1 block hash = %s, want %s", got.L1BlockHash, d.L1BlockHash)
+				}
+			})
+		}
+	}
+}
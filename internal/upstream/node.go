@@ -0,0 +1,98 @@
+package upstream
+
+import (
+	"net/url"
+	"sync"
+	"time"
+)
+
+// Node is a single upstream RPC endpoint and its last known health
+type Node struct {
+	URL      string
+	Name     string // URL without path or query, safe to log
+	Priority int    // Position in the configured list, lower is preferred
+
+	mu          sync.RWMutex
+	healthy     bool
+	syncing     bool
+	blockNumber uint64
+	latency     time.Duration
+	lastError   string
+	lastCheck   time.Time
+}
+
+// NodeStatus is a snapshot of a node's health
+type NodeStatus struct {
+	Name        string        `json:"name"`
+	Priority    int           `json:"priority"`
+	Healthy     bool          `json:"healthy"`
+	Syncing     bool          `json:"syncing"`
+	BlockNumber uint64        `json:"blockNumber"`
+	Latency     time.Duration `json:"latency"`
+	LastError   string        `json:"lastError,omitempty"`
+	LastCheck   time.Time     `json:"lastCheck"`
+}
+
+// newNode creates a node that is considered healthy until the first probe
+func newNode(rawURL string, priority int) *Node {
+	name := rawURL
+	if u, err := url.Parse(rawURL); err == nil && u.Host != "" {
+		// Provider URLs often carry API keys in the path or query
+		name = u.Scheme + "://" + u.Host
+	}
+	return &Node{
+		URL:      rawURL,
+		Name:     name,
+		Priority: priority,
+		healthy:  true,
+	}
+}
+
+// Status returns a snapshot of the node's health
+func (n *Node) Status() NodeStatus {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	return NodeStatus{
+		Name:        n.Name,
+		Priority:    n.Priority,
+		Healthy:     n.healthy,
+		Syncing:     n.syncing,
+		BlockNumber: n.blockNumber,
+		Latency:     n.latency,
+		LastError:   n.lastError,
+		LastCheck:   n.lastCheck,
+	}
+}
+
+// Healthy reports whether the node may receive traffic
+func (n *Node) Healthy() bool {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	return n.healthy
+}
+
+// Latency returns the smoothed request latency of the node
+func (n *Node) Latency() time.Duration {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	return n.latency
+}
+
+// observeLatency folds a new sample into the moving average latency
+func (n *Node) observeLatency(d time.Duration) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.latency == 0 {
+		n.latency = d
+		return
+	}
+	n.latency = (n.latency*4 + d) / 5
+}
+
+// markFailed takes the node out of rotation until the next successful probe
+func (n *Node) markFailed(err error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.healthy = false
+	n.lastError = err.Error()
+}
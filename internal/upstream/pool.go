@@ -0,0 +1,395 @@
+package upstream
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ddomeke/rpc_proxy/internal/config"
+	"github.com/ddomeke/rpc_proxy/pkg/utils"
+)
+
+// Load balancing strategies
+const (
+	StrategyRoundRobin   = "round-robin"
+	StrategyLeastLatency = "least-latency"
+	StrategyPriority     = "priority"
+)
+
+// Pool is a set of upstream nodes for one chain with health checks and failover
+type Pool struct {
+	name       string
+	httpClient *http.Client
+	next       uint64 // Round-robin counter
+
+	// Nodes and settings, replaced on reload
+	mu         sync.RWMutex
+	nodes      []*Node
+	strategy   string
+	interval   time.Duration
+	maxLag     uint64
+	maxRetries int
+}
+
+// NewPool creates an upstream pool from a list of node URLs in priority order
+func NewPool(name string, urls []string, cfg config.UpstreamConfig) (*Pool, error) {
+	if len(urls) == 0 {
+		return nil, fmt.Errorf("no %s upstreams configured", name)
+	}
+
+	nodes := make([]*Node, 0, len(urls))
+	for i, u := range urls {
+		nodes = append(nodes, newNode(u, i))
+	}
+
+	return &Pool{
+		name:       name,
+		nodes:      nodes,
+		strategy:   cfg.Strategy,
+		interval:   cfg.HealthInterval,
+		maxLag:     cfg.MaxBlockLag,
+		maxRetries: cfg.MaxRetries,
+		httpClient: &http.Client{
+			Timeout: 10 * time.Second,
+		},
+	}, nil
+}
+
+// Name returns the pool name
+func (p *Pool) Name() string {
+	return p.name
+}
+
+// Reconfigure replaces the nodes and settings of the pool. Nodes that keep
+// their URL and position keep their health, new nodes are probed right away.
+func (p *Pool) Reconfigure(urls []string, cfg config.UpstreamConfig) {
+	p.mu.Lock()
+	existing := make(map[string]*Node, len(p.nodes))
+	for _, node := range p.nodes {
+		existing[node.URL] = node
+	}
+	nodes := make([]*Node, 0, len(urls))
+	for i, u := range urls {
+		if node, ok := existing[u]; ok && node.Priority == i {
+			nodes = append(nodes, node)
+			continue
+		}
+		nodes = append(nodes, newNode(u, i))
+	}
+	p.nodes = nodes
+	p.strategy = cfg.Strategy
+	p.interval = cfg.HealthInterval
+	p.maxLag = cfg.MaxBlockLag
+	p.maxRetries = cfg.MaxRetries
+	p.mu.Unlock()
+
+	go p.CheckHealth(context.Background())
+}
+
+// Status returns the health of every node in the pool
+func (p *Pool) Status() []NodeStatus {
+	nodes := p.currentNodes()
+	statuses := make([]NodeStatus, 0, len(nodes))
+	for _, node := range nodes {
+		statuses = append(statuses, node.Status())
+	}
+	return statuses
+}
+
+// Head returns the highest block reported by a healthy node, or by any node
+// if none is healthy
+func (p *Pool) Head() uint64 {
+	var head, healthyHead uint64
+	for _, status := range p.Status() {
+		if status.BlockNumber > head {
+			head = status.BlockNumber
+		}
+		if status.Healthy && status.BlockNumber > healthyHead {
+			healthyHead = status.BlockNumber
+		}
+	}
+	if healthyHead > 0 {
+		return healthyHead
+	}
+	return head
+}
+
+// Healthy reports whether at least one node of the pool may receive traffic
+func (p *Pool) Healthy() bool {
+	for _, node := range p.currentNodes() {
+		if node.Healthy() {
+			return true
+		}
+	}
+	return false
+}
+
+// Run probes the nodes periodically until the context is cancelled
+func (p *Pool) Run(ctx context.Context) {
+	p.mu.RLock()
+	interval := p.interval
+	p.mu.RUnlock()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p.CheckHealth(ctx)
+
+			// Pick up a reloaded health interval
+			p.mu.RLock()
+			if p.interval != interval {
+				interval = p.interval
+				ticker.Reset(interval)
+			}
+			p.mu.RUnlock()
+		}
+	}
+}
+
+// CheckHealth probes every node with eth_blockNumber and eth_syncing and takes
+// nodes that are down, syncing or lagging behind the head out of rotation
+func (p *Pool) CheckHealth(ctx context.Context) {
+	type probe struct {
+		blockNumber uint64
+		syncing     bool
+		latency     time.Duration
+		err         error
+	}
+
+	p.mu.RLock()
+	nodes, maxLag := p.nodes, p.maxLag
+	p.mu.RUnlock()
+
+	probes := make([]probe, len(nodes))
+	var wg sync.WaitGroup
+	for i, node := range nodes {
+		wg.Add(1)
+		go func(i int, node *Node) {
+			defer wg.Done()
+			start := time.Now()
+			blockNumber, err := p.probeBlockNumber(ctx, node)
+			if err != nil {
+				probes[i].err = err
+				return
+			}
+			probes[i].latency = time.Since(start)
+			probes[i].blockNumber = blockNumber
+			probes[i].syncing, probes[i].err = p.probeSyncing(ctx, node)
+		}(i, node)
+	}
+	wg.Wait()
+
+	// The head is the highest block reported by any reachable node
+	var head uint64
+	for _, pr := range probes {
+		if pr.err == nil && pr.blockNumber > head {
+			head = pr.blockNumber
+		}
+	}
+
+	for i, node := range nodes {
+		pr := probes[i]
+
+		var reason string
+		switch {
+		case pr.err != nil:
+			reason = pr.err.Error()
+		case pr.syncing:
+			reason = "node is syncing"
+		case head-pr.blockNumber > maxLag:
+			reason = fmt.Sprintf("node is %d blocks behind head %d", head-pr.blockNumber, head)
+		}
+
+		node.mu.Lock()
+		wasHealthy := node.healthy
+		node.healthy = reason == ""
+		node.lastError = reason
+		node.lastCheck = time.Now()
+		if pr.err == nil {
+			node.syncing = pr.syncing
+			node.blockNumber = pr.blockNumber
+		}
+		node.mu.Unlock()
+
+		if pr.err == nil {
+			node.observeLatency(pr.latency)
+		}
+
+		if wasHealthy && reason != "" {
+			slog.Warn("Upstream removed from rotation", "pool", p.name, "upstream", node.Name, "reason", reason)
+		} else if !wasHealthy && reason == "" {
+			slog.Info("Upstream back in rotation", "pool", p.name, "upstream", node.Name)
+		}
+	}
+}
+
+// probeBlockNumber fetches the latest block number of a node
+func (p *Pool) probeBlockNumber(ctx context.Context, node *Node) (uint64, error) {
+	result, err := p.call(ctx, node, "eth_blockNumber")
+	if err != nil {
+		return 0, err
+	}
+	var blockHex string
+	if err := json.Unmarshal(result, &blockHex); err != nil {
+		return 0, fmt.Errorf("invalid eth_blockNumber result: %v", err)
+	}
+	return utils.HexToUint64(blockHex)
+}
+
+// probeSyncing reports whether a node is still syncing
+func (p *Pool) probeSyncing(ctx context.Context, node *Node) (bool, error) {
+	result, err := p.call(ctx, node, "eth_syncing")
+	if err != nil {
+		return false, err
+	}
+	// eth_syncing returns false when the node is in sync, a progress object otherwise
+	return strings.TrimSpace(string(result)) != "false", nil
+}
+
+// call performs a parameterless JSON-RPC call against a single node
+func (p *Pool) call(ctx context.Context, node *Node, method string) (json.RawMessage, error) {
+	reqBody := fmt.Sprintf(`{"jsonrpc":"2.0","id":1,"method":"%s","params":[]}`, method)
+	resp, err := p.send(ctx, node, []byte(reqBody))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var rpcResp struct {
+		Result json.RawMessage `json:"result"`
+		Error  *struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&rpcResp); err != nil {
+		return nil, fmt.Errorf("invalid %s response: %v", method, err)
+	}
+	if rpcResp.Error != nil {
+		return nil, fmt.Errorf("%s failed: %s", method, rpcResp.Error.Message)
+	}
+	return rpcResp.Result, nil
+}
+
+// send posts a request body to a single node
+func (p *Pool) send(ctx context.Context, node *Node, body []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, node.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= http.StatusInternalServerError || resp.StatusCode == http.StatusTooManyRequests {
+		resp.Body.Close()
+		return nil, fmt.Errorf("upstream returned HTTP %d", resp.StatusCode)
+	}
+	return resp, nil
+}
+
+// candidates returns the nodes to try for a request, in the order of the
+// configured strategy, or in priority order starting at the primary node when
+// pinned. If no node is healthy, all nodes are tried as a last resort.
+func (p *Pool) candidates(pinned bool) []*Node {
+	p.mu.RLock()
+	all, strategy := p.nodes, p.strategy
+	p.mu.RUnlock()
+
+	nodes := make([]*Node, 0, len(all))
+	for _, node := range all {
+		if node.Healthy() {
+			nodes = append(nodes, node)
+		}
+	}
+	if len(nodes) == 0 {
+		nodes = append(nodes, all...)
+	}
+
+	if pinned {
+		return nodes
+	}
+
+	switch strategy {
+	case StrategyRoundRobin:
+		start := int(atomic.AddUint64(&p.next, 1) % uint64(len(nodes)))
+		nodes = append(nodes[start:], nodes[:start]...)
+	case StrategyLeastLatency:
+		sort.SliceStable(nodes, func(i, j int) bool {
+			return nodes[i].Latency() < nodes[j].Latency()
+		})
+	}
+	// StrategyPriority keeps the configured order
+	return nodes
+}
+
+// Do sends a JSON-RPC request body to the pool. Idempotent requests are
+// retried on other nodes when the selected node fails. Log and filter requests
+// go to the primary node first.
+func (p *Pool) Do(ctx context.Context, body []byte) (*http.Response, *Node, error) {
+	attempts := 1
+	if IsIdempotent(body) {
+		p.mu.RLock()
+		attempts += p.maxRetries
+		p.mu.RUnlock()
+	}
+
+	var lastErr error
+	for i, node := range p.candidates(pinsPrimary(body)) {
+		if i >= attempts {
+			break
+		}
+
+		start := time.Now()
+		resp, err := p.send(ctx, node, body)
+		if err == nil {
+			node.observeLatency(time.Since(start))
+			return resp, node, nil
+		}
+		if ctx.Err() != nil {
+			return nil, node, ctx.Err()
+		}
+
+		slog.WarnContext(ctx, "Upstream request failed", "pool", p.name, "upstream", node.Name, "error", err)
+		node.markFailed(err)
+		lastErr = err
+	}
+	return nil, nil, fmt.Errorf("%s upstream request failed: %v", p.name, lastErr)
+}
+
+// Forward sends a JSON-RPC request body to the pool and returns the raw response
+func (p *Pool) Forward(ctx context.Context, body []byte) ([]byte, *Node, error) {
+	resp, node, err := p.Do(ctx, body)
+	if err != nil {
+		return nil, node, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, node, fmt.Errorf("could not read response from %s: %v", node.Name, err)
+	}
+	return respBody, node, nil
+}
+
+// currentNodes returns the nodes of the pool in priority order
+func (p *Pool) currentNodes() []*Node {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.nodes
+}
@@ -0,0 +1,230 @@
+package upstream
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/ddomeke/rpc_proxy/internal/config"
+)
+
+// testNode is an upstream node answering health probes and counting the
+// other requests it received
+type testNode struct {
+	blockNumber string
+	syncing     bool
+	status      int // HTTP status of every response, 200 if zero
+	requests    atomic.Int32
+}
+
+func (n *testNode) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if n.status != 0 {
+		w.WriteHeader(n.status)
+		return
+	}
+	var req struct {
+		ID     json.RawMessage `json:"id"`
+		Method string          `json:"method"`
+	}
+	json.NewDecoder(r.Body).Decode(&req)
+
+	var result interface{} = "ok"
+	switch req.Method {
+	case "eth_blockNumber":
+		result = n.blockNumber
+	case "eth_syncing":
+		result = n.syncing
+		if n.syncing {
+			result = map[string]string{"currentBlock": n.blockNumber}
+		}
+	default:
+		n.requests.Add(1)
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"jsonrpc": "2.0", "id": req.ID, "result": result})
+}
+
+// testPool starts the nodes and creates a pool of them in order
+func testPool(t *testing.T, strategy string, nodes ...*testNode) *Pool {
+	t.Helper()
+	urls := make([]string, len(nodes))
+	for i, node := range nodes {
+		srv := httptest.NewServer(node)
+		t.Cleanup(srv.Close)
+		urls[i] = srv.URL
+	}
+	cfg := config.Default().Upstream
+	cfg.Strategy = strategy
+	pool, err := NewPool("L1", urls, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pool
+}
+
+func TestCheckHealth(t *testing.T) {
+	nodes := []*testNode{
+		{blockNumber: "0x64"},
+		{blockNumber: "0x64", syncing: true},
+		{blockNumber: "0x50"}, // 20 blocks behind, more than the default lag of 10
+		{blockNumber: "0x5a"}, // 10 blocks behind
+		{status: http.StatusBadGateway},
+	}
+	wantHealthy := []bool{true, false, false, true, false}
+
+	pool := testPool(t, StrategyPriority, nodes...)
+	pool.CheckHealth(context.Background())
+
+	for i, status := range pool.Status() {
+		if status.Healthy != wantHealthy[i] {
+			t.Errorf("node %d healthy = %v (%s), want %v", i, status.Healthy, status.LastError, wantHealthy[i])
+		}
+	}
+	if head := pool.Head(); head != 100 {
+		t.Errorf("head = %d, want 100", head)
+	}
+}
+
+func TestDo(t *testing.T) {
+	tests := []struct {
+		name         string
+		strategy     string
+		body         string
+		down         []bool // Nodes that fail
+		calls        int
+		wantErr      bool
+		wantRequests []int32
+	}{
+		{
+			name:         "priority",
+			strategy:     StrategyPriority,
+			body:         `{"jsonrpc":"2.0","id":1,"method":"eth_call"}`,
+			down:         []bool{false, false, false},
+			calls:        2,
+			wantRequests: []int32{2, 0, 0},
+		},
+		{
+			name:         "round_robin",
+			strategy:     StrategyRoundRobin,
+			body:         `{"jsonrpc":"2.0","id":1,"method":"eth_call"}`,
+			down:         []bool{false, false, false},
+			calls:        3,
+			wantRequests: []int32{1, 1, 1},
+		},
+		{
+			// Logs never come from a node behind the one of the last call
+			name:         "round_robin_logs",
+			strategy:     StrategyRoundRobin,
+			body:         `{"jsonrpc":"2.0","id":1,"method":"eth_getLogs"}`,
+			down:         []bool{false, false, false},
+			calls:        3,
+			wantRequests: []int32{3, 0, 0},
+		},
+		{
+			name:         "round_robin_filter",
+			strategy:     StrategyRoundRobin,
+			body:         `[{"jsonrpc":"2.0","id":1,"method":"eth_chainId"},{"jsonrpc":"2.0","id":2,"method":"eth_getFilterChanges"}]`,
+			down:         []bool{false, false, false},
+			calls:        3,
+			wantRequests: []int32{3, 0, 0},
+		},
+		{
+			name:         "logs_failover",
+			strategy:     StrategyLeastLatency,
+			body:         `{"jsonrpc":"2.0","id":1,"method":"eth_getLogs"}`,
+			down:         []bool{true, false, false},
+			calls:        2,
+			wantRequests: []int32{0, 2, 0},
+		},
+		{
+			// The failed node is taken out of rotation
+			name:         "failover",
+			strategy:     StrategyPriority,
+			body:         `{"jsonrpc":"2.0","id":1,"method":"eth_call"}`,
+			down:         []bool{true, false, false},
+			calls:        2,
+			wantRequests: []int32{0, 2, 0},
+		},
+		{
+			name:         "not_idempotent",
+			strategy:     StrategyPriority,
+			body:         `{"jsonrpc":"2.0","id":1,"method":"eth_sendRawTransaction"}`,
+			down:         []bool{true, false, false},
+			calls:        1,
+			wantErr:      true,
+			wantRequests: []int32{0, 0, 0},
+		},
+		{
+			name:         "all_down",
+			strategy:     StrategyPriority,
+			body:         `{"jsonrpc":"2.0","id":1,"method":"eth_call"}`,
+			down:         []bool{true, true, true},
+			calls:        1,
+			wantErr:      true,
+			wantRequests: []int32{0, 0, 0},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nodes := make([]*testNode, len(tt.down))
+			for i, down := range tt.down {
+				nodes[i] = &testNode{blockNumber: "0x64"}
+				if down {
+					nodes[i].status = http.StatusServiceUnavailable
+				}
+			}
+			pool := testPool(t, tt.strategy, nodes...)
+
+			for i := 0; i < tt.calls; i++ {
+				_, _, err := pool.Forward(context.Background(), []byte(tt.body))
+				if (err != nil) != tt.wantErr {
+					t.Fatalf("call %d error = %v, want error %v", i, err, tt.wantErr)
+				}
+			}
+			for i, node := range nodes {
+				if got := node.requests.Load(); got != tt.wantRequests[i] {
+					t.Errorf("node %d got %d requests, want %d", i, got, tt.wantRequests[i])
+				}
+			}
+		})
+	}
+}
+
+func TestIsIdempotent(t *testing.T) {
+	tests := []struct {
+		body string
+		want bool
+	}{
+		{`{"method":"eth_call"}`, true},
+		{`{"method":"eth_sendRawTransaction"}`, false},
+		{`{"method":"eth_getFilterChanges"}`, false},
+		{`[{"method":"eth_call"},{"method":"eth_blockNumber"}]`, true},
+		{`[{"method":"eth_call"},{"method":"personal_sign"}]`, false},
+		{`not json`, false},
+	}
+
+	for _, tt := range tests {
+		if got := IsIdempotent([]byte(tt.body)); got != tt.want {
+			t.Errorf("IsIdempotent(%s) = %v, want %v", tt.body, got, tt.want)
+		}
+	}
+}
+
+func TestReconfigureKeepsHealth(t *testing.T) {
+	pool := testPool(t, StrategyPriority, &testNode{status: http.StatusBadGateway}, &testNode{blockNumber: "0x1"})
+	pool.CheckHealth(context.Background())
+	kept := pool.currentNodes()[0]
+	if kept.Healthy() {
+		t.Fatal("failing node is healthy")
+	}
+
+	// The first node keeps its URL and position, the second is new
+	pool.Reconfigure([]string{kept.URL, "http://127.0.0.1:1"}, config.Default().Upstream)
+	nodes := pool.currentNodes()
+	if nodes[0] != kept || nodes[0].Healthy() {
+		t.Error("node that kept its URL and position lost its health state")
+	}
+}
@@ -0,0 +1,150 @@
+package upstream
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// Methods that change state or are bound to a single node and must never be
+// replayed on another node
+var nonIdempotentPrefixes = []string{
+	"eth_send",
+	"eth_sign",
+	"eth_newFilter",
+	"eth_newBlockFilter",
+	"eth_newPendingTransactionFilter",
+	"eth_getFilterChanges",
+	"eth_getFilterLogs",
+	"eth_uninstallFilter",
+	"personal_",
+	"admin_",
+	"miner_",
+}
+
+// Methods whose results depend on the node's view of the chain or on filters
+// it keeps. They are sent to the primary node whatever the strategy, so
+// consecutive calls never go backwards to a node that lags behind.
+var primaryPrefixes = []string{
+	"eth_getLogs",
+	"eth_newFilter",
+	"eth_newBlockFilter",
+	"eth_newPendingTransactionFilter",
+	"eth_getFilterChanges",
+	"eth_getFilterLogs",
+	"eth_uninstallFilter",
+}
+
+// requestMethods returns the methods of a JSON-RPC body (single or batch)
+func requestMethods(body []byte) ([]string, bool) {
+	var reqs []struct {
+		Method string `json:"method"`
+	}
+	if err := json.Unmarshal(body, &reqs); err != nil {
+		var req struct {
+			Method string `json:"method"`
+		}
+		if err := json.Unmarshal(body, &req); err != nil {
+			return nil, false
+		}
+		reqs = append(reqs, req)
+	}
+
+	methods := make([]string, len(reqs))
+	for i, req := range reqs {
+		methods[i] = req.Method
+	}
+	return methods, true
+}
+
+// hasPrefix reports whether any of the methods starts with one of the prefixes
+func hasPrefix(methods, prefixes []string) bool {
+	for _, method := range methods {
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(method, prefix) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// IsIdempotent reports whether every request in a JSON-RPC body (single or
+// batch) can safely be retried on another node
+func IsIdempotent(body []byte) bool {
+	methods, ok := requestMethods(body)
+	return ok && !hasPrefix(methods, nonIdempotentPrefixes)
+}
+
+// pinsPrimary reports whether a JSON-RPC body contains a request that must go
+// to the primary node
+func pinsPrimary(body []byte) bool {
+	methods, _ := requestMethods(body)
+	return hasPrefix(methods, primaryPrefixes)
+}
+
+// transport routes HTTP JSON-RPC requests through the pool
+type transport struct {
+	pool *Pool
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body == nil {
+		return nil, fmt.Errorf("empty request body")
+	}
+	body, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	resp, _, err := t.pool.Do(req.Context(), body)
+	return resp, err
+}
+
+// Dial creates an ethclient whose requests are load balanced over the pool
+func (p *Pool) Dial(ctx context.Context) (*ethclient.Client, error) {
+	// The pool transport only applies to HTTP endpoints
+	primary := p.currentNodes()[0]
+	if !strings.HasPrefix(primary.URL, "http") {
+		return nil, fmt.Errorf("%s upstream %s is not an HTTP endpoint", p.name, primary.Name)
+	}
+
+	// Requests are sent to the pool's current nodes, the URL only selects the HTTP transport
+	httpClient := &http.Client{Transport: &transport{pool: p}}
+	rpcClient, err := rpc.DialOptions(ctx, primary.URL, rpc.WithHTTPClient(httpClient))
+	if err != nil {
+		return nil, err
+	}
+	return ethclient.NewClient(rpcClient), nil
+}
+
+// Primary returns the healthy node with the highest priority, or the first
+// node if none is healthy. It is the node that node-bound state such as log
+// filters is kept on.
+func (p *Pool) Primary() *Node {
+	nodes := p.currentNodes()
+	for _, node := range nodes {
+		if node.Healthy() {
+			return node
+		}
+	}
+	return nodes[0]
+}
+
+// DialNode creates an ethclient bound to a single node of the pool, for
+// requests that depend on state kept by that node
+func (p *Pool) DialNode(ctx context.Context, node *Node) (*ethclient.Client, error) {
+	rpcClient, err := rpc.DialOptions(ctx, node.URL, rpc.WithHTTPClient(p.httpClient))
+	if err != nil {
+		return nil, err
+	}
+	return ethclient.NewClient(rpcClient), nil
+}
@@ -1,13 +1,15 @@
 package utils
 
 import (
+	"context"
 	"fmt"
-	"io"
-	"log"
-	"os"
+	"log/slog"
+	"math/big"
+	"net/http"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 )
@@ -21,27 +23,16 @@ func LoadEnvFile(envPath string) error {
 		return fmt.Errorf("could not resolve directory path: %v", err)
 	}
 
-	fmt.Printf("Loading .env file: %s\n", absPath)
+	slog.Info("Loading .env file", "path", absPath)
 	err = godotenv.Load(absPath)
 	if err != nil {
 		return fmt.Errorf("could not load .env file: %v", err)
 	}
 
-	fmt.Println(".env file successfully loaded")
+	slog.Info(".env file successfully loaded")
 	return nil
 }
 
-// InitLogger initializes the log file and returns it for later closing
-func InitLogger() *os.File {
-	logFile, err := os.OpenFile("proxy.log", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	if err != nil {
-		log.Fatalf("Could not open log file: %v", err)
-	}
-	log.SetOutput(io.MultiWriter(logFile, os.Stdout)) // Write to console and file
-	log.SetFlags(log.Ldate | log.Ltime | log.Lshortfile)
-	return logFile
-}
-
 // HexToUint64 is a helper function to convert hexadecimal string to number
 func HexToUint64(hexStr string) (uint64, error) {
 	// Remove "0x" prefix
@@ -50,3 +41,53 @@ func HexToUint64(hexStr string) (uint64, error) {
 	// Convert hex string to number
 	return strconv.ParseUint(hexStr, 16, 64)
 }
+
+// WeiToEther converts a wei amount to a float ETH value
+func WeiToEther(wei *big.Int) float64 {
+	if wei == nil {
+		return 0
+	}
+	ethValue := new(big.Float).Quo(
+		new(big.Float).SetInt(wei),
+		new(big.Float).SetInt64(1e18),
+	)
+	ethFloat, _ := ethValue.Float64()
+	return ethFloat
+}
+
+// SleepContext sleeps for the given duration and reports false if the context
+// was cancelled first
+func SleepContext(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// Serve runs an HTTP server until the context is cancelled and then stops
+// accepting connections and waits up to drainTimeout for in-flight requests.
+// Requests still running after the deadline are cut off and reported.
+func Serve(ctx context.Context, srv *http.Server, drainTimeout time.Duration) error {
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+	}
+
+	drainCtx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+	defer cancel()
+	if err := srv.Shutdown(drainCtx); err != nil {
+		srv.Close()
+		return fmt.Errorf("%s not drained within %s: %v", srv.Addr, drainTimeout, err)
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package utils
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestServe(t *testing.T) {
+	tests := []struct {
+		name         string
+		requestTime  time.Duration // Time the in-flight request takes
+		drainTimeout time.Duration
+		wantErr      bool
+		wantServed   bool // The in-flight request got its response
+	}{
+		{name: "drained", requestTime: 100 * time.Millisecond, drainTimeout: 5 * time.Second, wantServed: true},
+		{name: "drain_timeout", requestTime: 5 * time.Second, drainTimeout: 100 * time.Millisecond, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			started := make(chan struct{})
+			mux := http.NewServeMux()
+			mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+				close(started)
+				time.Sleep(tt.requestTime)
+				w.Write([]byte("ok"))
+			})
+			srv := &http.Server{Addr: freeAddr(t), Handler: mux}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			served := make(chan error, 1)
+			go func() { served <- Serve(ctx, srv, tt.drainTimeout) }()
+
+			// The request is in flight when the shutdown starts
+			responded := make(chan bool, 1)
+			go func() {
+				for {
+					resp, err := http.Get("http://" + srv.Addr)
+					if err == nil {
+						resp.Body.Close()
+						responded <- resp.StatusCode == http.StatusOK
+						return
+					}
+					select {
+					case <-started:
+						responded <- false
+						return
+					default:
+					}
+					time.Sleep(10 * time.Millisecond)
+				}
+			}()
+			<-started
+			cancel()
+
+			if err := <-served; (err != nil) != tt.wantErr {
+				t.Errorf("Serve() = %v, want error %v", err, tt.wantErr)
+			}
+			if ok := <-responded; ok != tt.wantServed {
+				t.Errorf("request served = %v, want %v", ok, tt.wantServed)
+			}
+		})
+	}
+
+	t.Run("listen_error", func(t *testing.T) {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer l.Close()
+		// Fails at once without waiting for the context
+		srv := &http.Server{Addr: l.Addr().String()}
+		if err := Serve(context.Background(), srv, time.Second); err == nil {
+			t.Error("Serve() on a port in use succeeded")
+		}
+	})
+}
+
+// freeAddr returns a local address with a port that is not in use
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}